@@ -0,0 +1,143 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+)
+
+// analysisCacheSize is the number of the files whose analyses are kept. An analysis is small unlike the PCM.
+const analysisCacheSize = 256
+
+// analysisCache keeps the analyses of the recently opened files, so that going back to a file in the playlist
+// shows its waveform at once without decoding it again.
+type analysisCache struct {
+	analyses map[pcmCacheKey]*analysis
+
+	// keys are the keys in the order they are added, to drop the oldest.
+	keys []pcmCacheKey
+
+	m sync.Mutex
+}
+
+func newAnalysisCache() *analysisCache {
+	return &analysisCache{
+		analyses: map[pcmCacheKey]*analysis{},
+	}
+}
+
+func (c *analysisCache) get(key pcmCacheKey) (*analysis, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	a, ok := c.analyses[key]
+	return a, ok
+}
+
+func (c *analysisCache) put(key pcmCacheKey, a *analysis) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if _, ok := c.analyses[key]; ok {
+		return
+	}
+	c.analyses[key] = a
+	c.keys = append(c.keys, key)
+	if len(c.keys) > analysisCacheSize {
+		delete(c.analyses, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+}
+
+// analysis is the result of analyzing the decoded PCM of a file.
+// Decoding the whole file takes time, so the analysis runs in background after a file is opened.
+// The PCM is analyzed as it is decoded, and only kept in the bounded cache, so that the memory doesn't grow with the length of the file.
+type analysis struct {
+	// loudness is the integrated loudness in LUFS.
+	loudness float64
+
+	// shortTerm is the short-term loudness in LUFS at every loudness step.
+	shortTerm []float64
+
+	waveform *waveform
+
+	// soundStart and soundEnd are the range of the frames without the leading and trailing silence.
+	// The range is empty when the file is silent.
+	soundStart int64
+	soundEnd   int64
+
+	channels channelStats
+	clips    clipStats
+
+	// tempo is the detected beat grid, or nil when the file has no clear beat.
+	tempo *beatGrid
+}
+
+// analyze analyzes the file at path of about frames samples per channel.
+// The decoded PCM is cached when it fits in the cache, so that the following operations don't decode the file again.
+func analyze(path string, frames int64, j *job) (*analysis, error) {
+	meter := newLoudnessMeter()
+	onsets := newOnsetDetector()
+	wf := newWaveform(frames, waveformBuckets)
+	a := &analysis{soundStart: -1}
+	var pos int64
+	add := func(pcm []int16) {
+		meter.add(pcm)
+		onsets.add(pcm)
+		wf.add(pos, pcm)
+		a.channels.add(pcm)
+		a.clips.add(pcm)
+		for i := 0; i < len(pcm)/2; i++ {
+			if isSilent(pcm[2*i], pcm[2*i+1]) {
+				continue
+			}
+			if a.soundStart < 0 {
+				a.soundStart = pos + int64(i)
+			}
+			a.soundEnd = pos + int64(i) + 1
+		}
+		pos += int64(len(pcm) / 2)
+	}
+
+	key, err := newPCMCacheKey(path)
+	if err != nil {
+		return nil, err
+	}
+	if pcm, ok := thePCMCache.get(key); ok {
+		add(pcm)
+	} else {
+		var all []int16
+		keep := thePCMCache.fits(frames * bytesPerSample)
+		if err := streamPCM(path, j, func(pcm []int16) {
+			add(pcm)
+			if keep {
+				all = append(all, pcm...)
+			}
+		}); err != nil {
+			return nil, err
+		}
+		if keep {
+			thePCMCache.put(key, all)
+		}
+	}
+
+	if a.soundStart < 0 {
+		a.soundStart = 0
+	}
+	a.loudness = integratedLoudness(meter.steps)
+	a.shortTerm = shortTermLoudness(meter.steps)
+	a.waveform = wf
+	a.clips.finish()
+	a.tempo = detectTempo(onsets.env)
+	return a, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+const (
+	// seamBandSamples is the length of the audio compared at each side of the seam.
+	seamBandSamples = sampleRate * 250 / 1000
+
+	// seamBandWarmUpSamples is the length of the audio fed to the filters before the compared range to settle them.
+	seamBandWarmUpSamples = sampleRate * 50 / 1000
+
+	lowBandCutoff  = 250
+	highBandCutoff = 4000
+
+	// seamBandFloor is the floor in dB of the band energy, to compare silent bands.
+	seamBandFloor = -60
+
+	// seamBandMismatch is the difference in dB of a band regarded as audible at the seam.
+	seamBandMismatch = 6
+)
+
+// bandEnergy is the energy in dB of the low, mid and high bands.
+type bandEnergy [3]float64
+
+var bandNames = [...]string{"Low", "Mid", "High"}
+
+// newBandFilters returns the filters of the low, mid and high bands.
+// The coefficients are from Robert Bristow-Johnson's Audio EQ Cookbook.
+func newBandFilters() [3]*biquad {
+	const q = math.Sqrt2 / 2
+	coeffs := func(fc float64) (cos, alpha float64) {
+		w := 2 * math.Pi * fc / sampleRate
+		return math.Cos(w), math.Sin(w) / (2 * q)
+	}
+	normalize := func(b0, b1, b2, a0, a1, a2 float64) *biquad {
+		return &biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+	}
+
+	cos, alpha := coeffs(lowBandCutoff)
+	low := normalize((1-cos)/2, 1-cos, (1-cos)/2, 1+alpha, -2*cos, 1-alpha)
+
+	cos, alpha = coeffs(highBandCutoff)
+	high := normalize((1+cos)/2, -(1 + cos), (1+cos)/2, 1+alpha, -2*cos, 1-alpha)
+
+	// The band pass with the constant 0 dB peak gain at the geometric center of the mid band.
+	center := math.Sqrt(lowBandCutoff * highBandCutoff)
+	w := 2 * math.Pi * center / sampleRate
+	bw := math.Log2(highBandCutoff / lowBandCutoff)
+	alpha = math.Sin(w) * math.Sinh(math.Ln2/2*bw*w/math.Sin(w))
+	mid := normalize(alpha, 0, -alpha, 1+alpha, -2*math.Cos(w), 1-alpha)
+
+	return [3]*biquad{low, mid, high}
+}
+
+// measureBandEnergy returns the band energy of the mono mix of the frames [from, to) in interleaved stereo PCM.
+func measureBandEnergy(pcm []int16, from, to int64) bandEnergy {
+	filters := newBandFilters()
+	start := from - seamBandWarmUpSamples
+	if start < 0 {
+		start = 0
+	}
+	var sums [3]float64
+	for i := start; i < to; i++ {
+		v := (float64(pcm[2*i]) + float64(pcm[2*i+1])) / 2 / 32768
+		for b, f := range filters {
+			y := f.process(v)
+			if i >= from {
+				sums[b] += y * y
+			}
+		}
+	}
+	var e bandEnergy
+	for b, s := range sums {
+		e[b] = 10 * math.Log10(s/float64(to-from))
+	}
+	return e
+}
+
+// seamBands is the band energy before the loop end and after the loop start.
+// A large mismatch is what listeners perceive as the loop jumping.
+type seamBands struct {
+	introSample int64
+	loopSample  int64
+	before      bandEnergy
+	after       bandEnergy
+
+	// err is the error reading the PCM at the seam, which is cached not to read the file again every frame.
+	err error
+}
+
+// SeamBands returns the band energy at the seam of the current loop.
+// SeamBands returns nil until the file is analyzed, or when the loop is too short.
+func (p *Player) SeamBands() *seamBands {
+	if p.analysis == nil || !p.isValidLoop(p.introSample, p.loopSample) || p.loopSample < seamBandSamples {
+		return nil
+	}
+	if s := p.seamBands; s != nil && s.introSample == p.introSample && s.loopSample == p.loopSample {
+		if s.err != nil {
+			return nil
+		}
+		return s
+	}
+	p.seamBands = &seamBands{
+		introSample: p.introSample,
+		loopSample:  p.loopSample,
+	}
+	// Only the both sides of the seam are decoded, as the analysis doesn't keep the PCM.
+	end := p.introSample + p.loopSample
+	if p.seamBands.before, p.seamBands.err = p.measureBandEnergyAt(end-seamBandSamples, end); p.seamBands.err == nil {
+		p.seamBands.after, p.seamBands.err = p.measureBandEnergyAt(p.introSample, p.introSample+seamBandSamples)
+	}
+	if p.seamBands.err != nil {
+		log.Printf("seam band error: %s, %v", p.path, p.seamBands.err)
+		return nil
+	}
+	return p.seamBands
+}
+
+// measureBandEnergyAt returns the band energy of the frames [from, to) of the file.
+func (p *Player) measureBandEnergyAt(from, to int64) (bandEnergy, error) {
+	start := from - seamBandWarmUpSamples
+	if start < 0 {
+		start = 0
+	}
+	pcm, err := readPCMRange(p.path, start, to)
+	if err != nil {
+		return bandEnergy{}, err
+	}
+	if int64(len(pcm)/2) < to-start {
+		return bandEnergy{}, fmt.Errorf("oggplayer: the file ends before the seam")
+	}
+	return measureBandEnergy(pcm, from-start, to-start), nil
+}
+
+// mismatch returns the band with the largest difference across the seam and the difference in dB.
+func (s *seamBands) mismatch() (band int, diff float64) {
+	for b := range s.before {
+		d := math.Max(s.after[b], seamBandFloor) - math.Max(s.before[b], seamBandFloor)
+		if math.Abs(diff) < math.Abs(d) {
+			band, diff = b, d
+		}
+	}
+	return
+}
+
+// seamBandsLine returns the line describing the band mismatch at the seam, or an empty string when it is not audible.
+func seamBandsLine(s *seamBands) string {
+	if s == nil {
+		return ""
+	}
+	band, diff := s.mismatch()
+	if math.Abs(diff) < seamBandMismatch {
+		return ""
+	}
+	return fmt.Sprintf("Seam: %s band jumps by %+.1f dB", bandNames[band], diff)
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+)
+
+// channelMode is which channels the channel stage plays.
+type channelMode int
+
+const (
+	channelStereo channelMode = iota
+
+	// channelLeft and channelRight play only the left or the right channel in both channels.
+	channelLeft
+	channelRight
+
+	// channelMono plays the downmix of the channels, as a mono speaker does.
+	channelMono
+)
+
+// channelStage solos a channel or downmixes the playback to mono.
+// A click at the loop seam can be in one channel only, and the mono downmix reveals the phase cancellation
+// which the stereo playback hides.
+type channelStage struct {
+	mode channelMode
+	m    sync.Mutex
+}
+
+func (s *channelStage) Name() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	switch s.mode {
+	case channelLeft:
+		return "Left only"
+	case channelRight:
+		return "Right only"
+	}
+	return "Mono"
+}
+
+func (s *channelStage) Active() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.mode != channelStereo
+}
+
+func (s *channelStage) Process(pcm []int16) {
+	s.m.Lock()
+	mode := s.mode
+	s.m.Unlock()
+	for i := 0; i+1 < len(pcm); i += 2 {
+		var v int16
+		switch mode {
+		case channelLeft:
+			v = pcm[i]
+		case channelRight:
+			v = pcm[i+1]
+		case channelMono:
+			v = int16((int32(pcm[i]) + int32(pcm[i+1])) / 2)
+		}
+		pcm[i] = v
+		pcm[i+1] = v
+	}
+}
+
+// Toggle switches to mode, or back to the stereo when mode is already selected.
+func (s *channelStage) Toggle(mode channelMode) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.mode == mode {
+		s.mode = channelStereo
+		return
+	}
+	s.mode = mode
+}
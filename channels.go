@@ -0,0 +1,94 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// monoSideLevel is the level in dB of the side (L-R) against the mid (L+R) below which a stereo file is regarded as mono.
+	monoSideLevel = -60
+
+	// channelImbalanceLimit is the level difference in dB between the channels regarded as an export mistake.
+	channelImbalanceLimit = 12
+)
+
+// channelStats are the statistics of the channels gathered while analyzing, for the sanity checks of common export mistakes.
+type channelStats struct {
+	// peak is the absolute peak of each channel.
+	peak [2]int
+
+	// energy is the sum of the squares of each channel.
+	energy [2]float64
+
+	// mid and side are the sums of the squares of L+R and L-R.
+	mid  float64
+	side float64
+}
+
+func (c *channelStats) add(pcm []int16) {
+	for i := 0; i < len(pcm)/2; i++ {
+		l, r := float64(pcm[2*i]), float64(pcm[2*i+1])
+		for ch, v := range [2]float64{l, r} {
+			if a := int(math.Abs(v)); a > c.peak[ch] {
+				c.peak[ch] = a
+			}
+			c.energy[ch] += v * v
+		}
+		c.mid += (l + r) * (l + r)
+		c.side += (l - r) * (l - r)
+	}
+}
+
+// warnings returns the problems found in the channels of a file of channels channels.
+// A mono file is decoded to the same two channels, so the stereo checks are only for stereo files.
+func (c *channelStats) warnings(channels int) []string {
+	silent := [2]bool{c.peak[0] < silenceThreshold, c.peak[1] < silenceThreshold}
+	if silent[0] && silent[1] {
+		return []string{"the file is silent"}
+	}
+	if channels != 2 {
+		return nil
+	}
+	switch {
+	case silent[0]:
+		return []string{"the left channel is silent"}
+	case silent[1]:
+		return []string{"the right channel is silent"}
+	}
+	if c.side <= c.mid*math.Pow(10, monoSideLevel/10.0) {
+		return []string{"the stereo file is mono: both channels are the same"}
+	}
+	if d := 10 * math.Log10(c.energy[0]/c.energy[1]); d > channelImbalanceLimit {
+		return []string{fmt.Sprintf("the left channel is %.1f dB louder than the right", d)}
+	} else if d < -channelImbalanceLimit {
+		return []string{fmt.Sprintf("the right channel is %.1f dB louder than the left", -d)}
+	}
+	return nil
+}
+
+// channelWarnings returns the problems found in the channels, or nil until the analysis finishes.
+func (p *Player) channelWarnings() []string {
+	if p.analysis == nil {
+		return nil
+	}
+	var channels int
+	if p.info != nil {
+		channels = p.info.channels
+	}
+	return p.analysis.channels.warnings(channels)
+}
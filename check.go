@@ -0,0 +1,117 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// checkResult is the result of checking a file's loop tags.
+type checkResult struct {
+	start    int64
+	length   int64
+	problems []string
+}
+
+// checkFile checks that the file at path has valid loop tags: both tags exist and the loop is within the file.
+// The validation profile and the custom rules are checked as well. The file is decoded only when the rules limit the loudness.
+func checkFile(path string) checkResult {
+	info, err := readFileInfoAt(path)
+	if err != nil {
+		return checkResult{problems: []string{err.Error()}}
+	}
+
+	var problems []string
+	if len(info.comments.Get(loopStartKey)) == 0 && len(info.comments.Get(loopLengthKey)) == 0 {
+		problems = append(problems, "no loop tags")
+	}
+	tags := parseLoopTags(info.comments, theTagMode)
+	tags.removePreSkip(info.preSkip)
+	total, ok := info.exactLength()
+	if ok {
+		// The tags are printed as written, so they are checked against the length at the file's sample rate.
+		tags.validateRange(info.toFileSamples(total))
+	} else {
+		problems = append(problems, "the length is unknown")
+	}
+	problems = append(problems, tags.warnings...)
+	problems = append(problems, theProfile.check(info)...)
+	loudness := math.NaN()
+	if theRules.limitsLoudness() {
+		l, err := measureLoudness(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("loudness not measured: %v", err))
+		} else {
+			loudness = l
+		}
+	}
+	problems = append(problems, theRules.check(path, info, samplesToDuration(total), loudness)...)
+	return checkResult{
+		start:    tags.start,
+		length:   tags.length,
+		problems: problems,
+	}
+}
+
+// measureLoudness decodes the file at path and returns its integrated loudness in LUFS, as the analysis of an opened file does.
+func measureLoudness(path string) (float64, error) {
+	meter := newLoudnessMeter()
+	if err := streamPCM(path, nil, meter.add); err != nil {
+		return 0, err
+	}
+	return integratedLoudness(meter.steps), nil
+}
+
+// runCheck checks the loop tags of every Ogg file under dir and writes a pass/fail table to w.
+// The files are checked by -jobs workers at once, and the results are written in order as soon as they are ready.
+// runCheck reports whether any file failed.
+func runCheck(dir string, w io.Writer) (bool, error) {
+	paths, err := scanDir(dir, ".ogg", ".opus")
+	if err != nil {
+		return false, err
+	}
+	if len(paths) == 0 {
+		return false, fmt.Errorf("oggplayer: no Ogg files in %s", dir)
+	}
+
+	fmt.Fprintf(w, "%-6s %10s %10s  %s\n", "RESULT", loopStartKey, loopLengthKey, "FILE")
+	results := make([]checkResult, len(paths))
+	var failed int
+	scanPool(len(paths), func(i int) {
+		results[i] = checkFile(paths[i])
+	}, func(i int) {
+		r := results[i]
+		result := "PASS"
+		if len(r.problems) > 0 {
+			result = "FAIL"
+			failed++
+		}
+		name, err := filepath.Rel(dir, paths[i])
+		if err != nil {
+			name = paths[i]
+		}
+		line := fmt.Sprintf("%-6s %10d %10d  %s", result, r.start, r.length, filepath.ToSlash(name))
+		if len(r.problems) > 0 {
+			line += ": " + strings.Join(r.problems, "; ")
+		}
+		fmt.Fprintln(w, line)
+	})
+	fmt.Fprintf(w, "\n%d files checked, %d failed\n", len(paths), failed)
+	return failed > 0, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckLoudnessRule(t *testing.T) {
+	dir := t.TempDir()
+	dat, err := os.ReadFile(filepath.Join("testdata", "test_mono.ogg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bgm.ogg"), dat, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules := theRules
+	defer func() {
+		theRules = rules
+	}()
+
+	for _, tc := range []struct {
+		name    string
+		rules   *checkRules
+		problem string
+	}{
+		{
+			name:    "max",
+			rules:   &checkRules{MaxLoudness: loudnessLimit(-40)},
+			problem: "LUFS is above -40.0 LUFS",
+		},
+		{
+			name:    "min",
+			rules:   &checkRules{MinLoudness: loudnessLimit(0)},
+			problem: "LUFS is below 0.0 LUFS",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			theRules = tc.rules
+			var out bytes.Buffer
+			failed, err := runCheck(dir, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !failed {
+				t.Errorf("runCheck reported no failure:\n%s", out.String())
+			}
+			if !strings.Contains(out.String(), tc.problem) {
+				t.Errorf("the output doesn't contain %q:\n%s", tc.problem, out.String())
+			}
+			if strings.Contains(out.String(), "loudness not measured") {
+				t.Errorf("the loudness is not measured:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func loudnessLimit(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardCommands are the commands copying the standard input to the clipboard, tried in order.
+var clipboardCommands = [][]string{
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+}
+
+// copyToClipboard copies text to the clipboard with pbcopy on macOS, or with the first available clipboard tool on Linux.
+func copyToClipboard(text string) error {
+	commands := clipboardCommands
+	if runtime.GOOS == "darwin" {
+		commands = [][]string{{"pbcopy"}}
+	}
+	for _, c := range commands {
+		if _, err := exec.LookPath(c[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return fmt.Errorf("oggplayer: no clipboard command is found")
+}
+
+// pasteCommands are the commands writing the clipboard to the standard output, tried in order.
+var pasteCommands = [][]string{
+	{"wl-paste", "--no-newline"},
+	{"xclip", "-selection", "clipboard", "-out"},
+	{"xsel", "--clipboard", "--output"},
+}
+
+// readClipboard returns the text in the clipboard with pbpaste on macOS, or with the first available clipboard tool on Linux.
+func readClipboard() (string, error) {
+	commands := pasteCommands
+	if runtime.GOOS == "darwin" {
+		commands = [][]string{{"pbpaste"}}
+	}
+	for _, c := range commands {
+		if _, err := exec.LookPath(c[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(c[0], c[1:]...).Output()
+		return string(out), err
+	}
+	return "", fmt.Errorf("oggplayer: no clipboard command is found")
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// copyToClipboard copies text to the clipboard with clip, which comes with Windows.
+func copyToClipboard(text string) error {
+	cmd := exec.Command("clip")
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
+
+// readClipboard returns the text in the clipboard with PowerShell's Get-Clipboard.
+func readClipboard() (string, error) {
+	out, err := exec.Command("powershell", "-NoProfile", "-Command", "Get-Clipboard").Output()
+	return string(out), err
+}
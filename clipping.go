@@ -0,0 +1,183 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// clipSample is the absolute sample value at or above which a sample is counted as clipping.
+	// A decoder clamps the overshooting samples to the full scale, and a lossy encode leaves them just below it.
+	clipSample = 32700
+
+	// clipRegion is the number of frames the clipped samples are counted in, to find the worst positions.
+	clipRegion = sampleRate / 10
+
+	// clipMarks is the maximum number of the worst positions marked on the waveform.
+	clipMarks = 8
+
+	// truePeakOversample is how many times the PCM is oversampled to find the inter-sample peaks.
+	truePeakOversample = 4
+
+	// truePeakTaps is the number of the samples the interpolation filter uses.
+	truePeakTaps = 16
+
+	// truePeakGate is the absolute sample value below which two neighboring samples are not interpolated.
+	// The peaks between quieter samples can't reach the full scale in practice, and skipping them keeps the analysis fast.
+	truePeakGate = 16384
+)
+
+// truePeakFilter is the windowed sinc filter interpolating the samples at each oversampled phase but the first.
+var truePeakFilter = func() [truePeakOversample][truePeakTaps]float64 {
+	var f [truePeakOversample][truePeakTaps]float64
+	for ph := 1; ph < truePeakOversample; ph++ {
+		for k := 0; k < truePeakTaps; k++ {
+			// The interpolated point is between the taps truePeakTaps/2-1 and truePeakTaps/2.
+			t := float64(k-truePeakTaps/2+1) - float64(ph)/truePeakOversample
+			w := 0.5 * (1 + math.Cos(math.Pi*t/(truePeakTaps/2)))
+			s := 1.0
+			if t != 0 {
+				s = math.Sin(math.Pi*t) / (math.Pi * t)
+			}
+			f[ph][k] = s * w
+		}
+	}
+	return f
+}()
+
+// clipStats are the clipped samples and the true peak gathered while analyzing.
+// Low-quality lossy encodes sometimes overshoot between the samples, which distorts on cheap DACs.
+type clipStats struct {
+	// count is the number of the samples at or near the full scale.
+	count int64
+
+	// regions is the number of the clipped samples in each clipRegion frames, keyed by the region's index.
+	regions map[int64]int
+
+	// worst is the first frames of the regions with the most clipped samples in time order, set by finish.
+	worst []int64
+
+	// truePeak is the highest level between and at the samples, where 1 is the full scale.
+	truePeak float64
+
+	// history is the last truePeakTaps samples of each channel, written twice to be read as one slice.
+	history [2][2 * truePeakTaps]float64
+	pos     int64
+}
+
+func (c *clipStats) add(pcm []int16) {
+	for i := 0; i < len(pcm)/2; i++ {
+		h := int(c.pos % truePeakTaps)
+		for ch := 0; ch < 2; ch++ {
+			v := pcm[2*i+ch]
+			if v >= clipSample || v <= -clipSample {
+				if c.regions == nil {
+					c.regions = map[int64]int{}
+				}
+				c.count++
+				c.regions[c.pos/clipRegion]++
+			}
+			if a := math.Abs(float64(v)) / 32768; a > c.truePeak {
+				c.truePeak = a
+			}
+
+			hist := &c.history[ch]
+			hist[h] = float64(v)
+			hist[h+truePeakTaps] = float64(v)
+			taps := hist[h+1 : h+1+truePeakTaps]
+			if math.Abs(taps[truePeakTaps/2-1]) < truePeakGate && math.Abs(taps[truePeakTaps/2]) < truePeakGate {
+				continue
+			}
+			for ph := 1; ph < truePeakOversample; ph++ {
+				var y float64
+				for k, t := range taps {
+					y += t * truePeakFilter[ph][k]
+				}
+				if a := math.Abs(y) / 32768; a > c.truePeak {
+					c.truePeak = a
+				}
+			}
+		}
+		c.pos++
+	}
+}
+
+// finish finds the regions with the most clipped samples, at most clipMarks, after all the PCM is added.
+func (c *clipStats) finish() {
+	regions := make([]int64, 0, len(c.regions))
+	for r := range c.regions {
+		regions = append(regions, r)
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		if c.regions[regions[i]] != c.regions[regions[j]] {
+			return c.regions[regions[i]] > c.regions[regions[j]]
+		}
+		return regions[i] < regions[j]
+	})
+	if len(regions) > clipMarks {
+		regions = regions[:clipMarks]
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i] < regions[j] })
+	c.worst = make([]int64, len(regions))
+	for i, r := range regions {
+		c.worst[i] = r * clipRegion
+	}
+}
+
+// truePeakDB returns the true peak in dBTP.
+func (c *clipStats) truePeakDB() float64 {
+	return 20 * math.Log10(c.truePeak)
+}
+
+// warnings returns the clipping and the inter-sample peaks over the full scale.
+func (c *clipStats) warnings() []string {
+	var warnings []string
+	if c.count > 0 {
+		var at []string
+		for _, f := range c.worst {
+			at = append(at, formatTime(samplesToDuration(f)))
+		}
+		warnings = append(warnings, fmt.Sprintf("%d samples clip at or near the full scale, the worst at %s", c.count, strings.Join(at, ", ")))
+	}
+	if c.truePeak > 1 {
+		warnings = append(warnings, fmt.Sprintf("inter-sample peaks reach %+.1f dBTP", c.truePeakDB()))
+	}
+	return warnings
+}
+
+// clipWarnings returns the clipping found in the file, or nil until the analysis finishes.
+func (p *Player) clipWarnings() []string {
+	if p.analysis == nil {
+		return nil
+	}
+	return p.analysis.clips.warnings()
+}
+
+// drawClips draws the worst clipping positions as marks at the top of the waveform.
+func (p *Player) drawClips(b *shapeBatch) {
+	if p.analysis == nil {
+		return
+	}
+	_, wy, _, _ := waveformRect()
+	for _, f := range p.analysis.clips.worst {
+		if cx, ok := p.timelineX(f); ok {
+			b.Rect(float64(cx), float64(wy), 1, 4, meterClipColor)
+		}
+	}
+}
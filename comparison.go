@@ -0,0 +1,124 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+)
+
+// abComparison auditions a file and its encoded version alternately at the same position.
+type abComparison struct {
+	source        *Player
+	encoded       *Player
+	result        encodeResult
+	encodedActive bool
+
+	// compensated reports whether the encoded file's loop tags are shifted by the encoder's delay.
+	compensated bool
+}
+
+func newABComparison(source *Player, r encodeResult) (*abComparison, error) {
+	e, err := NewPlayer(source.audioContext, r.path)
+	if err != nil {
+		return nil, err
+	}
+	e.Pause()
+	return &abComparison{
+		source:  source,
+		encoded: e,
+		result:  r,
+	}, nil
+}
+
+// active returns the player being auditioned.
+func (c *abComparison) active() *Player {
+	if c.encodedActive {
+		return c.encoded
+	}
+	return c.source
+}
+
+// Toggle switches the auditioned file keeping the position and the playing state.
+func (c *abComparison) Toggle() {
+	from, to := c.source, c.encoded
+	if c.encodedActive {
+		from, to = to, from
+	}
+	playing := from.IsPlaying()
+	from.Pause()
+	to.Seek(from.current)
+	if playing {
+		to.Resume()
+	}
+	c.encodedActive = !c.encodedActive
+}
+
+// Close closes the encoded file's player and returns the source's player.
+func (c *abComparison) Close() *Player {
+	if c.encodedActive {
+		c.Toggle()
+	}
+	c.encoded.Close()
+	return c.source
+}
+
+// CompensateDelay shifts the loop tags of the encoded file by the encoder's delay so that the loop plays
+// the same samples as the source, and reopens the file.
+func (c *abComparison) CompensateDelay() error {
+	r := c.result
+	if c.compensated || r.delay == 0 || !r.validLoop {
+		return nil
+	}
+	if err := writeLoopTags(r.path, r.introSample+r.delay, r.loopSample); err != nil {
+		return err
+	}
+
+	active := c.encodedActive
+	if active {
+		c.Toggle()
+	}
+	e, err := NewPlayer(c.source.audioContext, r.path)
+	if err != nil {
+		return err
+	}
+	e.Pause()
+	c.encoded.Close()
+	c.encoded = e
+	c.compensated = true
+	if active {
+		c.Toggle()
+	}
+	return nil
+}
+
+func (c *abComparison) lines() []string {
+	name := c.result.preset.name
+	a := "source"
+	if c.encodedActive {
+		a = name
+	}
+	lines := []string{fmt.Sprintf("A/B: playing %s (Tab: switch to the other)", a)}
+	if c.compensated {
+		lines = append(lines, fmt.Sprintf("%s: the loop tags are shifted by %d samples", name, c.result.delay))
+	} else {
+		for _, l := range c.result.delayLines() {
+			lines = append(lines, fmt.Sprintf("%s: %s", name, l))
+		}
+	}
+	for _, w := range c.result.warnings {
+		lines = append(lines, fmt.Sprintf("%s: %s", name, w))
+	}
+	return lines
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+)
+
+// loopChoice is the loop given by one of the sources of the loop, offered when the sources disagree.
+type loopChoice struct {
+	// label is the name of the choice shown to the user.
+	label string
+
+	// source is the loop source of the player after the choice.
+	source string
+
+	// The loop is of the decoded stream.
+	introSample int64
+	loopSample  int64
+}
+
+// loopFromOrigins returns the loop of the decoded stream the values in origins give, read as the tags are,
+// and reports false when they don't make a valid loop.
+func (p *Player) loopFromOrigins(origins []loopOrigin) (int64, int64, bool) {
+	if p.info == nil || len(origins) == 0 {
+		return 0, 0, false
+	}
+	c := &vorbisComments{}
+	for _, o := range origins {
+		c.Set(o.key, o.value)
+	}
+	tags := parseLoopTags(c, theTagMode)
+	tags.removePreSkip(p.info.preSkip)
+	tags.toStreamRate(p.info)
+	tags.validateRange(p.totalSample)
+	if !p.isValidLoop(tags.start, tags.length) {
+		return 0, 0, false
+	}
+	return tags.start, tags.length, true
+}
+
+// loopChoices returns the loops of the embedded tags, the sidecars, both of them merged and project, which can be nil.
+// A loop the same as an earlier one is left out, so the sources disagree when more than one loop is returned.
+func (p *Player) loopChoices(project *projectLoop) []loopChoice {
+	var embedded, sidecar []loopOrigin
+	if p.info != nil {
+		for _, o := range p.info.loopOrigins {
+			if o.sidecar {
+				sidecar = append(sidecar, o)
+			} else {
+				embedded = append(embedded, o)
+			}
+		}
+	}
+
+	var choices []loopChoice
+	add := func(label, source string, introSample, loopSample int64) {
+		for _, c := range choices {
+			if c.introSample == introSample && c.loopSample == loopSample {
+				return
+			}
+		}
+		choices = append(choices, loopChoice{
+			label:       label,
+			source:      source,
+			introSample: introSample,
+			loopSample:  loopSample,
+		})
+	}
+	if start, length, ok := p.loopFromOrigins(embedded); ok {
+		add("Keep the embedded tags", "embedded tags", start, length)
+	}
+	if start, length, ok := p.loopFromOrigins(sidecar); ok {
+		add("Keep the sidecar", "sidecar", start, length)
+	}
+	// The sidecar overrides the embedded tags value by value, e.g. only LOOPLENGTH.
+	if len(embedded) > 0 && len(sidecar) > 0 {
+		if start, length, ok := p.loopFromOrigins(p.info.loopOrigins); ok {
+			add("Merge the sidecar into the embedded tags", "merged tags", start, length)
+		}
+	}
+	if project != nil && p.isValidLoop(project.Start, project.Length) {
+		add("Keep the project", "project", project.Start, project.Length)
+	}
+	return choices
+}
+
+// checkLoopConflict asks which loop to use when the sources of the loop disagree, with project being the project's loop or nil.
+// Until it is answered, the loop that overrides the others is played.
+func (p *Player) checkLoopConflict(project *projectLoop) {
+	p.loopConflict = nil
+	if choices := p.loopChoices(project); len(choices) > 1 {
+		p.loopConflict = choices
+	}
+}
+
+// ResolveLoopConflict uses the i-th loop of the conflict. The loop can be saved to the file with Ctrl+S so that the sources agree.
+func (p *Player) ResolveLoopConflict(i int) error {
+	if i < 0 || i >= len(p.loopConflict) {
+		return nil
+	}
+	c := p.loopConflict[i]
+	p.loopConflict = nil
+	if err := p.SetLoop(c.introSample, c.loopSample); err != nil {
+		return err
+	}
+	p.loopSource = c.source
+	return nil
+}
+
+// DismissLoopConflict keeps the current loop without asking again.
+func (p *Player) DismissLoopConflict() {
+	p.loopConflict = nil
+}
+
+// loopConflictLines returns the lines asking which loop to use, or nil when the sources agree.
+// The choices are numbered from 1.
+func (p *Player) loopConflictLines() []string {
+	if len(p.loopConflict) == 0 {
+		return nil
+	}
+	lines := []string{"The loop sources disagree. Which loop to use?"}
+	for i, c := range p.loopConflict {
+		lines = append(lines, fmt.Sprintf("%d: %s (%s - %s, %s %d, %s %d)", i+1, c.label,
+			formatTimeMillis(samplesToDuration(c.introSample)), formatTimeMillis(samplesToDuration(c.introSample+c.loopSample)),
+			loopStartKey, p.fileSample(c.introSample), loopLengthKey, p.fileSample(c.introSample+c.loopSample)-p.fileSample(c.introSample)))
+	}
+	return lines
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"time"
+)
+
+// crossfadeDuration returns the duration of the crossfade between tracks configured by -crossfade.
+func crossfadeDuration() time.Duration {
+	return time.Duration(*flagXfade * float64(time.Second))
+}
+
+// crossfader keeps the players of the previous tracks playing while they fade out after the next track is opened.
+// The zero value is ready to use.
+type crossfader struct {
+	players []*Player
+}
+
+// Release fades out p instead of closing it at once when the crossfade is enabled and p is playing,
+// and reports whether p is taken over. The caller closes p otherwise.
+func (c *crossfader) Release(p *Player) bool {
+	d := crossfadeDuration()
+	if d <= 0 || !p.IsPlaying() {
+		return false
+	}
+	p.shuttle = 0
+	p.startFade(p.gain(), 0, d, fadeEndPause)
+	c.players = append(c.players, p)
+	return true
+}
+
+// FadeIn fades in p, the player of the track opened after a player is released.
+func (c *crossfader) FadeIn(p *Player) {
+	if p.IsPlaying() {
+		p.startFade(0, 1, crossfadeDuration(), fadeEndNone)
+	}
+}
+
+// Update ramps the gains of the fading players, and closes the players which have faded out.
+func (c *crossfader) Update() {
+	now := time.Now()
+	players := c.players[:0]
+	for _, p := range c.players {
+		p.updateFade(now)
+		if p.IsPlaying() {
+			players = append(players, p)
+			continue
+		}
+		p.Close()
+	}
+	c.players = players
+}
+
+// Close closes all the fading players.
+func (c *crossfader) Close() {
+	for _, p := range c.players {
+		p.Close()
+	}
+	c.players = nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// cueFramesPerSecond is the number of frames in a second in CUE sheets' mm:ss:ff notation.
+const cueFramesPerSecond = 75
+
+// cueFile is a FILE entry of a CUE sheet with its tracks' start positions as markers.
+type cueFile struct {
+	path    string
+	markers []Marker
+}
+
+// parseCUE parses a CUE sheet. Only FILE, TRACK, TITLE and INDEX 01 are used.
+func parseCUE(r io.Reader) ([]cueFile, error) {
+	var files []cueFile
+	var track, title string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := splitCUELine(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("cue: FILE without a file name")
+			}
+			files = append(files, cueFile{path: fields[1]})
+			track = ""
+			title = ""
+		case "TRACK":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("cue: TRACK without a number")
+			}
+			track = fields[1]
+			title = ""
+		case "TITLE":
+			// TITLE before the first TRACK is the album title.
+			if track != "" && len(fields) >= 2 {
+				title = fields[1]
+			}
+		case "INDEX":
+			if len(fields) < 3 || fields[1] != "01" {
+				continue
+			}
+			if len(files) == 0 {
+				return nil, fmt.Errorf("cue: INDEX before FILE")
+			}
+			sample, err := parseCUETime(fields[2])
+			if err != nil {
+				return nil, err
+			}
+			label := track
+			if title != "" {
+				label += " " + title
+			}
+			f := &files[len(files)-1]
+			f.markers = append(f.markers, Marker{
+				Sample: sample,
+				Label:  label,
+			})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// splitCUELine splits a line into fields. A quoted field can contain spaces.
+func splitCUELine(line string) []string {
+	var fields []string
+	line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff"))
+	for line != "" {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				fields = append(fields, line[1:])
+				break
+			}
+			fields = append(fields, line[1:end+1])
+			line = strings.TrimSpace(line[end+2:])
+			continue
+		}
+		end := strings.IndexAny(line, " \t")
+		if end < 0 {
+			fields = append(fields, line)
+			break
+		}
+		fields = append(fields, line[:end])
+		line = strings.TrimSpace(line[end:])
+	}
+	return fields
+}
+
+// parseCUETime parses mm:ss:ff and returns the position in samples.
+func parseCUETime(str string) (int64, error) {
+	parts := strings.Split(str, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("cue: invalid time: %s", str)
+	}
+	var vals [3]int64
+	for i, p := range parts {
+		v, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("cue: invalid time: %s", str)
+		}
+		vals[i] = v
+	}
+	frames := (vals[0]*60+vals[1])*cueFramesPerSecond + vals[2]
+	return frames * sampleRate / cueFramesPerSecond, nil
+}
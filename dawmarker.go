@@ -0,0 +1,122 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dawRegion is a region or a marker (when start equals end) to export to a DAW.
+type dawRegion struct {
+	name       string
+	start, end int64
+}
+
+func (r dawRegion) isMarker() bool {
+	return r.start == r.end
+}
+
+// dawRegions returns the intro and the loop of p as regions, followed by the markers and the notes taken at moments.
+func dawRegions(p *Player, moments []timedNote) []dawRegion {
+	var rs []dawRegion
+	if p.loopSample > 0 {
+		if p.introSample > 0 {
+			rs = append(rs, dawRegion{name: "Intro", start: 0, end: p.introSample})
+		}
+		rs = append(rs, dawRegion{name: "Loop", start: p.introSample, end: p.introSample + p.loopSample})
+	}
+	for _, m := range p.markers {
+		rs = append(rs, dawRegion{name: m.Label, start: m.Sample, end: m.Sample})
+	}
+	for _, n := range moments {
+		name := n.Text
+		if name == "" {
+			name = "Note"
+		}
+		rs = append(rs, dawRegion{name: name, start: n.Sample, end: n.Sample})
+	}
+	return rs
+}
+
+// samplesToSeconds formats the samples as seconds with enough digits to keep the exact sample.
+func samplesToSeconds(samples int64) string {
+	return fmt.Sprintf("%.6f", float64(samples)/sampleRate)
+}
+
+// reaperRegionCSV returns the regions as a CSV of REAPER's Region/Marker Manager. The times are in seconds.
+func reaperRegionCSV(regions []dawRegion) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if err := w.Write([]string{"#", "Name", "Start", "End", "Length"}); err != nil {
+		return nil, err
+	}
+	var nr, nm int
+	for _, r := range regions {
+		if r.isMarker() {
+			nm++
+			if err := w.Write([]string{fmt.Sprintf("M%d", nm), r.name, samplesToSeconds(r.start), "", ""}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		nr++
+		if err := w.Write([]string{fmt.Sprintf("R%d", nr), r.name, samplesToSeconds(r.start), samplesToSeconds(r.end), samplesToSeconds(r.end - r.start)}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// audacityLabels returns the regions as an Audacity label track, which has a tab-separated line of the start, the end and the label per region.
+func audacityLabels(regions []dawRegion) []byte {
+	var b bytes.Buffer
+	for _, r := range regions {
+		name := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(r.name)
+		fmt.Fprintf(&b, "%s\t%s\t%s\n", samplesToSeconds(r.start), samplesToSeconds(r.end), name)
+	}
+	return b.Bytes()
+}
+
+// writeDAWMarkers writes the loop and the markers of p, and the notes taken at moments, to dir as a REAPER region CSV (.csv) and an Audacity label file (.txt),
+// so that the fixes found in the player can be applied precisely in the DAW.
+// writeDAWMarkers returns the paths of the written files.
+func writeDAWMarkers(dir string, p *Player, moments []timedNote) ([]string, error) {
+	regions := dawRegions(p, moments)
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("oggplayer: no loop or markers to export")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	base := filepath.Join(dir, strings.TrimSuffix(filepath.Base(p.path), filepath.Ext(p.path)))
+	reaper, err := reaperRegionCSV(regions)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{base + "_regions.csv", base + "_labels.txt"}
+	for i, dat := range [][]byte{reaper, audacityLabels(regions)} {
+		if err := os.WriteFile(paths[i], dat, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,490 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// This is a minimal D-Bus client for MPRIS, which only encodes the types MPRIS needs.
+// See https://dbus.freedesktop.org/doc/dbus-specification.html.
+
+// Types of D-Bus messages.
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+	dbusSignal       = 4
+)
+
+// dbusNoReplyExpected is the flag of a message not to be replied.
+const dbusNoReplyExpected = 0x1
+
+// Codes of the header fields.
+const (
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+)
+
+// dbusObjectPath is a value of the type o.
+type dbusObjectPath string
+
+// dbusSig is a value of the type g.
+type dbusSig string
+
+// dbusVariant is a value of the type v.
+type dbusVariant struct {
+	value interface{}
+}
+
+// dbusEntry is an entry of a{sv}, e.g. of properties.
+type dbusEntry struct {
+	key   string
+	value interface{}
+}
+
+// dbusSignature returns the type signature of v.
+func dbusSignature(v interface{}) string {
+	switch v.(type) {
+	case byte:
+		return "y"
+	case bool:
+		return "b"
+	case int64:
+		return "x"
+	case uint32:
+		return "u"
+	case float64:
+		return "d"
+	case string:
+		return "s"
+	case dbusObjectPath:
+		return "o"
+	case dbusSig:
+		return "g"
+	case dbusVariant:
+		return "v"
+	case []string:
+		return "as"
+	case []dbusEntry:
+		return "a{sv}"
+	}
+	panic(fmt.Sprintf("oggplayer: unexpected D-Bus value: %T", v))
+}
+
+// dbusEncoder encodes values in little endian. The alignment is counted from the start of buf.
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *dbusEncoder) uint32(v uint32) {
+	e.align(4)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *dbusEncoder) uint64(v uint64) {
+	e.align(8)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *dbusEncoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+func (e *dbusEncoder) signature(s string) {
+	e.buf = append(e.buf, byte(len(s)))
+	e.buf = append(e.buf, s...)
+	e.buf = append(e.buf, 0)
+}
+
+// array encodes an array whose elements are aligned to align by calling elems.
+func (e *dbusEncoder) array(align int, elems func()) {
+	e.uint32(0)
+	pos := len(e.buf) - 4
+	e.align(align)
+	start := len(e.buf)
+	elems()
+	binary.LittleEndian.PutUint32(e.buf[pos:], uint32(len(e.buf)-start))
+}
+
+func (e *dbusEncoder) variant(v interface{}) {
+	e.signature(dbusSignature(v))
+	e.value(v)
+}
+
+func (e *dbusEncoder) value(v interface{}) {
+	switch v := v.(type) {
+	case byte:
+		e.buf = append(e.buf, v)
+	case bool:
+		var b uint32
+		if v {
+			b = 1
+		}
+		e.uint32(b)
+	case int64:
+		e.uint64(uint64(v))
+	case uint32:
+		e.uint32(v)
+	case float64:
+		e.uint64(math.Float64bits(v))
+	case string:
+		e.string(v)
+	case dbusObjectPath:
+		e.string(string(v))
+	case dbusSig:
+		e.signature(string(v))
+	case dbusVariant:
+		e.variant(v.value)
+	case []string:
+		e.array(4, func() {
+			for _, s := range v {
+				e.string(s)
+			}
+		})
+	case []dbusEntry:
+		e.array(8, func() {
+			for _, entry := range v {
+				e.align(8)
+				e.string(entry.key)
+				e.variant(entry.value)
+			}
+		})
+	default:
+		panic(fmt.Sprintf("oggplayer: unexpected D-Bus value: %T", v))
+	}
+}
+
+// dbusMessage is a D-Bus message. The body of a received message is kept as bytes, and decoded by the receiver.
+type dbusMessage struct {
+	typ         byte
+	flags       byte
+	serial      uint32
+	path        string
+	iface       string
+	member      string
+	errorName   string
+	replySerial uint32
+	destination string
+	sender      string
+	signature   string
+	body        []interface{}
+
+	order   binary.ByteOrder
+	rawBody []byte
+}
+
+func (m *dbusMessage) marshal() []byte {
+	var body dbusEncoder
+	var sig strings.Builder
+	for _, v := range m.body {
+		body.value(v)
+		sig.WriteString(dbusSignature(v))
+	}
+
+	e := &dbusEncoder{}
+	e.buf = append(e.buf, 'l', m.typ, m.flags, 1)
+	e.uint32(uint32(len(body.buf)))
+	e.uint32(m.serial)
+	e.array(8, func() {
+		field := func(code byte, v interface{}) {
+			e.align(8)
+			e.buf = append(e.buf, code)
+			e.variant(v)
+		}
+		if m.path != "" {
+			field(dbusFieldPath, dbusObjectPath(m.path))
+		}
+		if m.iface != "" {
+			field(dbusFieldInterface, m.iface)
+		}
+		if m.member != "" {
+			field(dbusFieldMember, m.member)
+		}
+		if m.errorName != "" {
+			field(dbusFieldErrorName, m.errorName)
+		}
+		if m.replySerial != 0 {
+			field(dbusFieldReplySerial, m.replySerial)
+		}
+		if m.destination != "" {
+			field(dbusFieldDestination, m.destination)
+		}
+		if sig.Len() > 0 {
+			field(dbusFieldSignature, dbusSig(sig.String()))
+		}
+	})
+	// The body starts at a multiple of 8, so the body's alignment counted from its start is the same.
+	e.align(8)
+	return append(e.buf, body.buf...)
+}
+
+// dbusDecoder decodes values. The alignment is counted from the start of buf.
+type dbusDecoder struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+var errDBusShort = fmt.Errorf("oggplayer: D-Bus message is too short")
+
+func (d *dbusDecoder) align(n int) {
+	d.pos = (d.pos + n - 1) / n * n
+}
+
+func (d *dbusDecoder) next(n int) ([]byte, error) {
+	if d.pos+n > len(d.buf) {
+		return nil, errDBusShort
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *dbusDecoder) uint32() (uint32, error) {
+	d.align(4)
+	b, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(b), nil
+}
+
+func (d *dbusDecoder) string() (string, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(int(n) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n]), nil
+}
+
+func (d *dbusDecoder) signature() (string, error) {
+	n, err := d.next(1)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(int(n[0]) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(b[:n[0]]), nil
+}
+
+// basic decodes a value of a basic type. The values of the other types are not needed for MPRIS.
+func (d *dbusDecoder) basic(sig string) (interface{}, error) {
+	switch sig {
+	case "y":
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case "b", "u", "i":
+		return d.uint32()
+	case "n", "q":
+		d.align(2)
+		return d.next(2)
+	case "x", "t", "d":
+		d.align(8)
+		return d.next(8)
+	case "s", "o":
+		return d.string()
+	case "g":
+		return d.signature()
+	}
+	return nil, fmt.Errorf("oggplayer: unsupported D-Bus type: %q", sig)
+}
+
+// stringArgs decodes the leading string arguments of the body, e.g. the interface and the property name of Get.
+func (m *dbusMessage) stringArgs() []string {
+	d := &dbusDecoder{buf: m.rawBody, order: m.order}
+	var ss []string
+	for _, c := range m.signature {
+		if c != 's' {
+			break
+		}
+		s, err := d.string()
+		if err != nil {
+			break
+		}
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// readDBusMessage reads a message from r.
+func readDBusMessage(r io.Reader) (*dbusMessage, error) {
+	// The fixed part of the header, and the length of the header fields' array.
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder
+	switch head[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("oggplayer: invalid D-Bus endianness: %q", head[0])
+	}
+	bodyLen := order.Uint32(head[4:8])
+	fieldsLen := order.Uint32(head[12:16])
+	headerLen := (16 + int(fieldsLen) + 7) / 8 * 8
+	buf := make([]byte, headerLen+int(bodyLen))
+	copy(buf, head)
+	if _, err := io.ReadFull(r, buf[16:]); err != nil {
+		return nil, err
+	}
+
+	m := &dbusMessage{
+		typ:     head[1],
+		flags:   head[2],
+		serial:  order.Uint32(head[8:12]),
+		order:   order,
+		rawBody: buf[headerLen:],
+	}
+	d := &dbusDecoder{buf: buf[:16+int(fieldsLen)], pos: 16, order: order}
+	for d.pos < len(d.buf) {
+		d.align(8)
+		code, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := d.signature()
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.basic(sig)
+		if err != nil {
+			return nil, err
+		}
+		s, _ := v.(string)
+		switch code[0] {
+		case dbusFieldPath:
+			m.path = s
+		case dbusFieldInterface:
+			m.iface = s
+		case dbusFieldMember:
+			m.member = s
+		case dbusFieldErrorName:
+			m.errorName = s
+		case dbusFieldReplySerial:
+			m.replySerial, _ = v.(uint32)
+		case dbusFieldDestination:
+			m.destination = s
+		case dbusFieldSender:
+			m.sender = s
+		case dbusFieldSignature:
+			m.signature = s
+		}
+	}
+	return m, nil
+}
+
+// dialSessionBus connects to the session bus and authenticates with the user ID.
+func dialSessionBus() (net.Conn, *bufio.Reader, error) {
+	addr := os.Getenv("DBUS_SESSION_BUS_ADDRESS")
+	if addr == "" {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			addr = "unix:path=" + filepath.Join(dir, "bus")
+		}
+	}
+	// The address can list several transports separated by semicolons.
+	var conn net.Conn
+	for _, a := range strings.Split(addr, ";") {
+		if !strings.HasPrefix(a, "unix:") {
+			continue
+		}
+		for _, kv := range strings.Split(strings.TrimPrefix(a, "unix:"), ",") {
+			var path string
+			switch k, v, _ := strings.Cut(kv, "="); k {
+			case "path":
+				path = v
+			case "abstract":
+				path = "@" + v
+			default:
+				continue
+			}
+			c, err := net.Dial("unix", path)
+			if err != nil {
+				continue
+			}
+			conn = c
+			break
+		}
+		if conn != nil {
+			break
+		}
+	}
+	if conn == nil {
+		return nil, nil, fmt.Errorf("oggplayer: D-Bus session bus is not found")
+	}
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("oggplayer: D-Bus authentication failed: %s", strings.TrimSpace(line))
+	}
+	if _, err := io.WriteString(conn, "BEGIN\r\n"); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, r, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Opcodes of Discord's IPC frames.
+const (
+	discordOpHandshake = 0
+	discordOpFrame     = 1
+)
+
+type discordActivity struct {
+	Details string `json:"details,omitempty"`
+	State   string `json:"state,omitempty"`
+}
+
+// discordPresence publishes the current track to Discord Rich Presence.
+//
+// A nil *discordPresence is valid and does nothing, so callers don't have to check whether the feature is enabled.
+type discordPresence struct {
+	clientID string
+	last     discordActivity
+	ch       chan discordActivity
+}
+
+func newDiscordPresence(clientID string) *discordPresence {
+	if clientID == "" {
+		return nil
+	}
+	d := &discordPresence{
+		clientID: clientID,
+		ch:       make(chan discordActivity, 1),
+	}
+	go d.loop()
+	return d
+}
+
+// Update publishes the state of p. p can be nil when no file is open.
+// Update is cheap when nothing changes, so it can be called every frame.
+func (d *discordPresence) Update(p *Player) {
+	if d == nil {
+		return
+	}
+
+	var a discordActivity
+	if p != nil {
+		a.Details = filepath.Base(p.path)
+		if p.loopSample > 0 {
+			a.State = fmt.Sprintf("Loop %s - %s", formatTime(samplesToDuration(p.introSample)), formatTime(samplesToDuration(p.introSample+p.loopSample)))
+		} else {
+			a.State = "No loop"
+		}
+		if !p.IsPlaying() {
+			a.State += " (Paused)"
+		}
+	}
+	if a == d.last {
+		return
+	}
+	d.last = a
+
+	// Only the latest activity matters. Drop the pending one if the connection is slow.
+	select {
+	case <-d.ch:
+	default:
+	}
+	d.ch <- a
+}
+
+func (d *discordPresence) loop() {
+	var conn io.ReadWriteCloser
+	for a := range d.ch {
+		if conn == nil {
+			c, err := d.connect()
+			if err != nil {
+				log.Printf("discord: %v", err)
+				continue
+			}
+			conn = c
+		}
+		if err := d.setActivity(conn, a); err != nil {
+			log.Printf("discord: %v", err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func (d *discordPresence) connect() (io.ReadWriteCloser, error) {
+	conn, err := dialDiscord()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeDiscordFrame(conn, discordOpHandshake, map[string]interface{}{
+		"v":         1,
+		"client_id": d.clientID,
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := readDiscordFrame(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *discordPresence) setActivity(conn io.ReadWriter, a discordActivity) error {
+	args := map[string]interface{}{
+		"pid": os.Getpid(),
+	}
+	// An empty activity clears the presence.
+	if a != (discordActivity{}) {
+		args["activity"] = a
+	}
+	if err := writeDiscordFrame(conn, discordOpFrame, map[string]interface{}{
+		"cmd":   "SET_ACTIVITY",
+		"args":  args,
+		"nonce": strconv.FormatInt(time.Now().UnixNano(), 10),
+	}); err != nil {
+		return err
+	}
+	return readDiscordFrame(conn)
+}
+
+func writeDiscordFrame(w io.Writer, op uint32, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 8, 8+len(body))
+	binary.LittleEndian.PutUint32(buf[0:4], op)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(body)))
+	buf = append(buf, body...)
+	_, err = w.Write(buf)
+	return err
+}
+
+// readDiscordFrame reads and discards one response frame.
+func readDiscordFrame(r io.Reader) error {
+	var header [8]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+	n := binary.LittleEndian.Uint32(header[4:8])
+	_, err := io.CopyN(io.Discard, r, int64(n))
+	return err
+}
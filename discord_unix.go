@@ -0,0 +1,44 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+func dialDiscord() (io.ReadWriteCloser, error) {
+	dir := os.TempDir()
+	for _, env := range []string{"XDG_RUNTIME_DIR", "TMPDIR", "TMP", "TEMP"} {
+		if v := os.Getenv(env); v != "" {
+			dir = v
+			break
+		}
+	}
+	// Discord listens on discord-ipc-0 to discord-ipc-9.
+	for i := 0; i < 10; i++ {
+		conn, err := net.Dial("unix", filepath.Join(dir, fmt.Sprintf("discord-ipc-%d", i)))
+		if err != nil {
+			continue
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("discord: Discord is not running")
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+func dialDiscord() (io.ReadWriteCloser, error) {
+	// Discord listens on discord-ipc-0 to discord-ipc-9.
+	for i := 0; i < 10; i++ {
+		f, err := os.OpenFile(fmt.Sprintf(`\\.\pipe\discord-ipc-%d`, i), os.O_RDWR, 0)
+		if err != nil {
+			continue
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("discord: Discord is not running")
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+)
+
+// openInEditor opens the file at path in the external editor given by -editor, e.g. Audacity or Reaper.
+// The editor runs independently, and the file is reloaded by the auto-reload when the editor saves it.
+func openInEditor(path string) error {
+	if *flagEditor == "" {
+		return fmt.Errorf("oggplayer: no editor is given by -editor")
+	}
+	cmd := exec.Command(*flagEditor, path)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("editor error: %s, %v", path, err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultVorbisQuality is the oggenc quality used when no preset is chosen.
+const defaultVorbisQuality = 6
+
+// encoderPreset is a codec and its quality to re-encode files with.
+type encoderPreset struct {
+	name  string
+	codec string
+
+	// quality is the oggenc quality for Vorbis.
+	quality float64
+
+	// bitrate is the bitrate in kbps for Opus.
+	bitrate int
+}
+
+const (
+	codecVorbis = "vorbis"
+	codecOpus   = "opus"
+)
+
+// encoderPresets are the presets to compare, as loop seams sometimes click only after lossy encoding.
+var encoderPresets = []encoderPreset{
+	{name: "Vorbis q3", codec: codecVorbis, quality: 3},
+	{name: "Vorbis q6", codec: codecVorbis, quality: 6},
+	{name: "Vorbis q10", codec: codecVorbis, quality: 10},
+	{name: "Opus 96 kbps", codec: codecOpus, bitrate: 96},
+	{name: "Opus 160 kbps", codec: codecOpus, bitrate: 160},
+}
+
+// outputPath returns the path the file at path is encoded to with the preset.
+func (e encoderPreset) outputPath(path string) string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if e.codec == codecOpus {
+		return fmt.Sprintf("%s_opus%d.opus", base, e.bitrate)
+	}
+	return fmt.Sprintf("%s_q%s.ogg", base, strconv.FormatFloat(e.quality, 'f', -1, 64))
+}
+
+func (e encoderPreset) encode(path string, pcm []int16, comments []string, j *job) error {
+	if e.codec == codecOpus {
+		return encodeOpus(path, pcm, e.bitrate, comments, j)
+	}
+	return encodeVorbis(path, pcm, e.quality, comments, j)
+}
+
+// encodeResult is the result of encoding the file at source with preset in background.
+type encodeResult struct {
+	source string
+	preset encoderPreset
+	path   string
+	err    error
+
+	// frames is the number of the samples per channel of the source.
+	frames int64
+
+	// introSample and loopSample are the loop of the source. validLoop reports whether the loop is valid.
+	introSample int64
+	loopSample  int64
+	validLoop   bool
+
+	// delay is the number of the samples the encoder added at the beginning, e.g. by priming.
+	// padding is the number of the samples the encoder added at the end.
+	delay   int64
+	padding int64
+
+	// preSkip is the samples of an Opus file the decoders discard at the beginning.
+	preSkip int64
+
+	// opusIntroSample and opusLoopSample are the loop tags of an Opus file converted to its granule domain.
+	opusIntroSample int64
+	opusLoopSample  int64
+
+	// warnings are the problems of the seam the encoder introduced.
+	warnings []string
+}
+
+// EncodeJob returns the function encoding the file with preset next to it, keeping the tags.
+// An encoded Vorbis file is decoded again to measure the encoder's delay and padding,
+// and to check that the encoder didn't break the seam.
+// The function can be called on another goroutine.
+func (p *Player) EncodeJob(preset encoderPreset) func(j *job) encodeResult {
+	var comments []string
+	if p.info != nil {
+		c := &vorbisComments{comments: append([]string(nil), p.info.comments.comments...)}
+		// The loop tags of an Opus file include the pre-skip, which the decoded PCM doesn't,
+		// and the decoded PCM is encoded at sampleRate, which can differ from the file's sample rate.
+		if (p.info.preSkip > 0 || p.info.rate() != sampleRate) && p.isValidLoop(p.fileIntroSample, p.fileLoopSample) {
+			c.Set(loopStartKey, strconv.FormatInt(p.fileIntroSample, 10))
+			c.Set(loopLengthKey, strconv.FormatInt(p.fileLoopSample, 10))
+		}
+		comments = c.comments
+	}
+	r := encodeResult{
+		source:      p.path,
+		preset:      preset,
+		path:        preset.outputPath(p.path),
+		introSample: p.fileIntroSample,
+		loopSample:  p.fileLoopSample,
+		validLoop:   p.isValidLoop(p.fileIntroSample, p.fileLoopSample),
+	}
+	return func(j *job) encodeResult {
+		if err := checkWritable(); err != nil {
+			r.err = err
+			return r
+		}
+		pcm, err := decodePCM(r.source, j)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		r.frames = int64(len(pcm) / 2)
+		if err := preset.encode(r.path, pcm, comments, j); err != nil {
+			r.err = err
+			return r
+		}
+		if preset.codec == codecOpus {
+			dat, err := os.ReadFile(r.path)
+			if err != nil {
+				r.err = err
+				return r
+			}
+			if r.preSkip, err = readOpusPreSkip(dat); err != nil {
+				r.err = err
+				return r
+			}
+			// Keep the loop working in engines, which read the loop tags in the granule domain of Opus.
+			if r.validLoop {
+				start, length := opusLoop(r.introSample, r.loopSample, sampleRate, r.preSkip)
+				if err := writeLoopTags(r.path, start, length); err != nil {
+					r.err = err
+					return r
+				}
+				r.opusIntroSample, r.opusLoopSample = start, length
+			}
+			return r
+		}
+
+		encoded, err := decodePCM(r.path, j)
+		if err != nil {
+			r.err = err
+			return r
+		}
+		if r.validLoop {
+			s := checkSeamIntegrity(pcm, encoded, r.introSample, r.loopSample)
+			r.delay = s.shift
+			r.warnings = s.warnings()
+		} else {
+			r.delay = findShift(pcm, encoded, 0)
+		}
+		r.padding = int64(len(encoded)/2) - int64(len(pcm)/2) - r.delay
+		return r
+	}
+}
+
+// delayLines returns the lines describing how the encoder's delay and padding affect the loop.
+func (r *encodeResult) delayLines() []string {
+	if r.preset.codec == codecOpus {
+		lines := []string{fmt.Sprintf("Opus pre-skip: %d samples", r.preSkip)}
+		if r.validLoop {
+			lines = append(lines, fmt.Sprintf("The loop tags are converted to 48 kHz with the pre-skip: %s=%d, %s=%d",
+				loopStartKey, r.opusIntroSample, loopLengthKey, r.opusLoopSample))
+		}
+		return lines
+	}
+	lines := []string{fmt.Sprintf("Encoder delay: %d samples, padding: %d samples", r.delay, r.padding)}
+	if r.delay != 0 && r.validLoop {
+		lines = append(lines, fmt.Sprintf("The loop plays %d samples off. Press U to shift the encoded file's loop tags", r.delay))
+	}
+	// The padding is negative when the encoder trimmed the end.
+	if r.validLoop && r.introSample+r.loopSample > r.frames+r.padding {
+		lines = append(lines, "The encoder trimmed the end, which cuts the loop")
+	}
+	return lines
+}
+
+// writeTempWAV writes PCM to a temporary WAV file for the encoders, and returns its path.
+func writeTempWAV(pcm []int16) (string, error) {
+	tmp, err := os.CreateTemp("", "oggplayer-*.wav")
+	if err != nil {
+		return "", err
+	}
+	if err := writeWAV(tmp, pcm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// encodeOpus encodes interleaved stereo PCM at sampleRate to an Ogg/Opus file at path with opusenc.
+// opusenc is killed when j is canceled.
+func encodeOpus(path string, pcm []int16, bitrate int, comments []string, j *job) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	wav, err := writeTempWAV(pcm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(wav)
+
+	args := []string{"--quiet", "--bitrate", strconv.Itoa(bitrate)}
+	for _, c := range comments {
+		args = append(args, "--comment", c)
+	}
+	args = append(args, wav, path)
+	if out, err := exec.CommandContext(j.Context(), *flagOpusenc, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("oggplayer: %s failed: %w: %s", *flagOpusenc, err, out)
+	}
+	return nil
+}
+
+// encodeVorbis encodes interleaved stereo PCM at sampleRate to an Ogg/Vorbis file at path with oggenc,
+// and writes comments to it. oggenc is killed when j is canceled.
+func encodeVorbis(path string, pcm []int16, quality float64, comments []string, j *job) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	wav, err := writeTempWAV(pcm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(wav)
+
+	encoded := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	defer os.Remove(encoded)
+	cmd := exec.CommandContext(j.Context(), *flagOggenc, "--quiet", "-q", strconv.FormatFloat(quality, 'f', -1, 64), "-o", encoded, wav)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("oggplayer: %s failed: %w: %s", *flagOggenc, err, out)
+	}
+
+	// Write the comments by ourselves instead of oggenc's options so that any tags are kept as they are.
+	dat, err := os.ReadFile(encoded)
+	if err != nil {
+		return err
+	}
+	c, err := readOggVorbisComments(dat)
+	if err != nil {
+		return err
+	}
+	c.comments = comments
+	dat, err = replaceOggVorbisComments(dat, c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, dat, 0644)
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// externalDecodes are the WAV files decoded from the files of a format without a decoder in Go, e.g. Opus, by an external command.
+// A WAV file is reused until the source file is changed, and is streamed like other files so that the memory stays flat.
+type externalDecodes struct {
+	// decode decodes the file at path to the WAV file at wav. decode must stop when ctx is canceled.
+	decode func(ctx context.Context, path, wav string) error
+
+	files map[pcmCacheKey]string
+
+	// decoding are the decodes in progress. A decode of the same file waits for the one in progress instead of decoding it again.
+	decoding map[pcmCacheKey]*externalDecode
+
+	m sync.Mutex
+}
+
+// externalDecode is a decode in progress. wav and err are set before done is closed.
+type externalDecode struct {
+	done chan struct{}
+	wav  string
+	err  error
+}
+
+func newExternalDecodes(decode func(ctx context.Context, path, wav string) error) *externalDecodes {
+	return &externalDecodes{
+		decode:   decode,
+		files:    map[pcmCacheKey]string{},
+		decoding: map[pcmCacheKey]*externalDecode{},
+	}
+}
+
+// ready reports whether the WAV file of the file at path is decoded and can be opened at once.
+func (d *externalDecodes) ready(path string) bool {
+	key, err := newPCMCacheKey(path)
+	if err != nil {
+		return false
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	wav, ok := d.files[key]
+	if !ok {
+		return false
+	}
+	_, err = os.Stat(wav)
+	return err == nil
+}
+
+// wavPath returns the WAV file decoded from the file at path. The decoding stops when ctx is canceled.
+// Different files are decoded at once, while the same file is decoded only once.
+func (d *externalDecodes) wavPath(ctx context.Context, path string) (string, error) {
+	key, err := newPCMCacheKey(path)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		d.m.Lock()
+		if wav, ok := d.files[key]; ok {
+			if _, err := os.Stat(wav); err == nil {
+				d.m.Unlock()
+				return wav, nil
+			}
+		}
+		dec, ok := d.decoding[key]
+		if !ok {
+			dec = &externalDecode{done: make(chan struct{})}
+			d.decoding[key] = dec
+			d.m.Unlock()
+			return d.run(ctx, key, dec)
+		}
+		d.m.Unlock()
+
+		select {
+		case <-dec.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if dec.err == nil {
+			return dec.wav, nil
+		}
+		// The decode might have been canceled by its own caller. Decode again unless this one is canceled too.
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if !errors.Is(dec.err, context.Canceled) {
+			return "", dec.err
+		}
+	}
+}
+
+// run decodes the file of key as dec. The WAV file decoded from an older version of the file is removed,
+// so that re-exporting a file many times doesn't leave its WAV files behind.
+func (d *externalDecodes) run(ctx context.Context, key pcmCacheKey, dec *externalDecode) (string, error) {
+	dec.wav, dec.err = d.decodeToTemp(ctx, key.path)
+
+	d.m.Lock()
+	defer d.m.Unlock()
+	delete(d.decoding, key)
+	close(dec.done)
+	if dec.err != nil {
+		return "", dec.err
+	}
+	for k, wav := range d.files {
+		if k.path == key.path && k != key {
+			os.Remove(wav)
+			delete(d.files, k)
+		}
+	}
+	d.files[key] = dec.wav
+	return dec.wav, nil
+}
+
+// decodeToTemp decodes the file at path to a new temporary WAV file and returns its path.
+func (d *externalDecodes) decodeToTemp(ctx context.Context, path string) (string, error) {
+	tmp, err := os.CreateTemp("", "oggplayer-*.wav")
+	if err != nil {
+		return "", err
+	}
+	wav := tmp.Name()
+	tmp.Close()
+	if err := d.decode(ctx, path, wav); err != nil {
+		os.Remove(wav)
+		return "", err
+	}
+	return wav, nil
+}
+
+// decodeJob decodes a file to play in theJobs, so that the UI keeps responding while an external command decodes it.
+type decodeJob struct {
+	path string
+	job  *job
+	done chan struct{}
+	err  error
+}
+
+// startDecode starts decoding the file at path when it needs an external decoder and isn't decoded yet.
+// startDecode returns nil when the file can be opened at once.
+func startDecode(path string) *decodeJob {
+	decodes, err := externalDecodesOf(path)
+	if err != nil || decodes == nil || decodes.ready(path) {
+		// The error is reported when the file is opened.
+		return nil
+	}
+	d := &decodeJob{
+		path: path,
+		done: make(chan struct{}),
+	}
+	d.job = theJobs.Go("Decode "+filepath.Base(path), func(j *job) {
+		defer close(d.done)
+		_, d.err = decodes.wavPath(j.Context(), path)
+	})
+	return d
+}
+
+// result reports whether the decode is over, and its error.
+// A decode canceled in the job list is over with the cancellation error, even when it was still queued.
+func (d *decodeJob) result() (bool, error) {
+	select {
+	case <-d.done:
+		return true, d.err
+	default:
+	}
+	if err := d.job.Err(); err != nil {
+		return true, err
+	}
+	return false, nil
+}
+
+// Cancel stops the decode, e.g. when another file is selected before it finishes.
+func (d *decodeJob) Cancel() {
+	d.job.Cancel()
+}
+
+// pendingLoad is a file to open when its decode finishes.
+type pendingLoad struct {
+	decode *decodeJob
+
+	// crossfading reports whether the player is faded in as the previous one is still fading out.
+	crossfading bool
+
+	// then is called with the opened player unless it is nil.
+	then func(p *Player) error
+}
+
+// removeAll removes the decoded WAV files.
+func (d *externalDecodes) removeAll() {
+	d.m.Lock()
+	defer d.m.Unlock()
+	for key, wav := range d.files {
+		os.Remove(wav)
+		delete(d.files, key)
+	}
+}
+
+// removeDecodedWAVs removes the WAV files decoded by the external commands. It must be called before the app exits.
+func removeDecodedWAVs() {
+	theOpusDecodes.removeAll()
+	theFLACDecodes.removeAll()
+}
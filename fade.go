@@ -0,0 +1,161 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// fadeEnd is what the playback does when a fade finishes.
+type fadeEnd int
+
+const (
+	// fadeEndNone goes on playing, e.g. after a fade-in.
+	fadeEndNone fadeEnd = iota
+
+	// fadeEndPause pauses at the current position.
+	fadeEndPause
+
+	// fadeEndStop pauses and goes back to the beginning, as a game stops the BGM between scenes.
+	fadeEndStop
+)
+
+// fade is a linear gain envelope from 'from' to 'to' over d since start.
+//
+// The envelope is applied to the audio player's volume rather than to the decoded stream.
+// The audio player reads the stream ahead into its buffer, so a gain applied to the stream would be heard late.
+type fade struct {
+	from  float64
+	to    float64
+	start time.Time
+	d     time.Duration
+	end   fadeEnd
+}
+
+// gain returns the gain of the envelope at now.
+func (f *fade) gain(now time.Time) float64 {
+	t := float64(now.Sub(f.start)) / float64(f.d)
+	if t >= 1 {
+		return f.to
+	}
+	if t < 0 {
+		t = 0
+	}
+	return f.from + (f.to-f.from)*t
+}
+
+// done reports whether the envelope has reached its end at now.
+func (f *fade) done(now time.Time) bool {
+	return now.Sub(f.start) >= f.d
+}
+
+// playFadeIn, pauseFadeOut and stopFadeOut return the durations of the fades configured by the flags.
+func playFadeIn() time.Duration {
+	return time.Duration(*flagFadeIn * float64(time.Millisecond))
+}
+
+func pauseFadeOut() time.Duration {
+	return time.Duration(*flagFadeOff * float64(time.Millisecond))
+}
+
+func stopFadeOut() time.Duration {
+	return time.Duration(*flagFadeStop * float64(time.Second))
+}
+
+// gain returns the current gain of the fade, which is 1 without a fade.
+func (p *Player) gain() float64 {
+	if p.fade == nil {
+		return 1
+	}
+	return p.fade.gain(time.Now())
+}
+
+// applyVolume sets the volume, the gain of the loudness matching and the gain of the fade to the audio player.
+func (p *Player) applyVolume() {
+	p.audioPlayer.SetVolume(p.baseVolume() * p.gain())
+}
+
+// baseVolume returns the volume with the gain of the loudness matching to the reference track.
+func (p *Player) baseVolume() float64 {
+	return float64(p.volume128) / 128 * math.Pow(10, p.matchDB/20)
+}
+
+// startFade starts a fade from the gain from to the gain to over d.
+// The fade finishes immediately when d is not positive.
+func (p *Player) startFade(from, to float64, d time.Duration, end fadeEnd) {
+	p.fade = &fade{
+		from:  from,
+		to:    to,
+		start: time.Now(),
+		d:     d,
+		end:   end,
+	}
+	p.updateFade(time.Now())
+}
+
+// cancelFade drops the fade and restores the volume.
+func (p *Player) cancelFade() {
+	p.fade = nil
+	p.applyVolume()
+}
+
+// FadingOut reports whether the playback is fading out to pause or to stop.
+func (p *Player) FadingOut() bool {
+	return p.fade != nil && p.fade.end != fadeEndNone
+}
+
+// FadeOutAndStop fades out over -stop-fade, and then pauses and goes back to the beginning.
+// The playback stops immediately when it is paused.
+func (p *Player) FadeOutAndStop() {
+	p.shuttle = 0
+	if !p.IsPlaying() {
+		p.cancelFade()
+		p.Seek(0)
+		return
+	}
+	p.startFade(p.gain(), 0, stopFadeOut(), fadeEndStop)
+}
+
+// fadeLine returns the state of the fade-out to show, or an empty string.
+func (p *Player) fadeLine() string {
+	if !p.FadingOut() {
+		return ""
+	}
+	if p.fade.end == fadeEndStop {
+		return "Fading out to stop..."
+	}
+	return "Fading out to pause..."
+}
+
+// updateFade applies the gain of the fade at now, and pauses or stops the playback at the end of a fade-out.
+func (p *Player) updateFade(now time.Time) {
+	if p.fade == nil {
+		return
+	}
+	if !p.fade.done(now) {
+		p.audioPlayer.SetVolume(p.baseVolume() * p.fade.gain(now))
+		return
+	}
+	switch p.fade.end {
+	case fadeEndPause:
+		p.audioPlayer.Pause()
+	case fadeEndStop:
+		p.audioPlayer.Pause()
+		// Seeking also drops the silent audio buffered by the audio player.
+		p.Seek(0)
+	}
+	p.cancelFade()
+}
@@ -0,0 +1,196 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const vorbisIdentificationMagic = "\x01vorbis"
+
+// fileInfo is the format information of an audio file.
+type fileInfo struct {
+	sampleRate int
+	channels   int
+
+	// nominalBitrate is in bits per second. 0 means unknown.
+	nominalBitrate int
+
+	comments *vorbisComments
+
+	// granulePosition is the granule position of the last page, which is the exact number of the samples
+	// per channel at sampleRate. granulePosition is -1 when unknown.
+	granulePosition int64
+
+	// preSkip is the number of the samples an Opus decoder discards at the beginning.
+	// The loop tags of an Opus file are in the granule domain, which includes the pre-skip.
+	preSkip int64
+
+	// loopOrigins are the values of the loop tags in the order they were read. A later value overrides an earlier one.
+	loopOrigins []loopOrigin
+}
+
+// exactLength returns the exact number of the samples per channel of the stream resampled to the player's sample rate.
+// exactLength reports false when the length is unknown.
+func (i *fileInfo) exactLength() (int64, bool) {
+	if i == nil || i.granulePosition < 0 || i.sampleRate <= 0 {
+		return 0, false
+	}
+	return i.granulePosition * sampleRate / int64(i.sampleRate), true
+}
+
+// rate returns the sample rate of the file, or sampleRate when unknown.
+func (i *fileInfo) rate() int64 {
+	if i == nil || i.sampleRate <= 0 {
+		return sampleRate
+	}
+	return int64(i.sampleRate)
+}
+
+// resampled reports whether the file is resampled to sampleRate to be played.
+func (i *fileInfo) resampled() bool {
+	return i.rate() != sampleRate
+}
+
+// toStreamSamples converts n samples at the file's sample rate, e.g. of the loop tags, to the samples of
+// the decoded stream, which is always resampled to sampleRate.
+func (i *fileInfo) toStreamSamples(n int64) int64 {
+	if !i.resampled() {
+		return n
+	}
+	r := i.rate()
+	return (n*sampleRate + r/2) / r
+}
+
+// toFileSamples converts n samples of the decoded stream to the samples at the file's sample rate.
+func (i *fileInfo) toFileSamples(n int64) int64 {
+	if !i.resampled() {
+		return n
+	}
+	r := i.rate()
+	return (n*r + sampleRate/2) / sampleRate
+}
+
+// readFileInfo reads the format information of an Ogg/Vorbis, Ogg/Opus, WAV, MP3 or FLAC file.
+func readFileInfo(r io.ReadSeeker) (*fileInfo, error) {
+	wav, err := isWAVStream(r)
+	if err != nil {
+		return nil, err
+	}
+	if wav {
+		return readWAVInfo(r)
+	}
+	opus, err := isOggOpusStream(r)
+	if err != nil {
+		return nil, err
+	}
+	if opus {
+		return readOggOpusInfo(r)
+	}
+	mp3, err := isMP3Stream(r)
+	if err != nil {
+		return nil, err
+	}
+	if mp3 {
+		return readMP3Info(r)
+	}
+	flac, err := isFLACStream(r)
+	if err != nil {
+		return nil, err
+	}
+	if flac {
+		return readFLACInfo(r)
+	}
+	return readOggVorbisInfo(r)
+}
+
+// readFileInfoAt is readFileInfo reading the file at path.
+// The loop of an MP3 file is also read from its sidecars.
+func readFileInfoAt(path string) (*fileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := readFileInfo(f)
+	if err != nil {
+		return nil, err
+	}
+	source, err := tagSourceName(f)
+	if err != nil {
+		return nil, err
+	}
+	info.loopOrigins = loopOriginsOf(info.comments, source)
+	mp3, err := isMP3Stream(f)
+	if err != nil {
+		return nil, err
+	}
+	if mp3 {
+		origins, err := readLoopSidecars(path, info.comments)
+		if err != nil {
+			return nil, err
+		}
+		// The ID3 values of the keys cleared by the sidecar are absent.
+		var kept []loopOrigin
+		for _, o := range info.loopOrigins {
+			if len(info.comments.Get(o.key)) > 0 {
+				kept = append(kept, o)
+			}
+		}
+		info.loopOrigins = append(kept, origins...)
+	}
+	return info, nil
+}
+
+// readOggVorbisInfo reads the format information from the header packets of an Ogg/Vorbis file.
+// Only the headers and the end of r are read.
+func readOggVorbisInfo(r io.ReadSeeker) (*fileInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h, err := readOggHeadersFrom(bufio.NewReader(r), vorbisHeaderCount)
+	if err != nil {
+		return nil, err
+	}
+
+	// https://xiph.org/vorbis/doc/Vorbis_I_spec.html#x1-630004.2.2
+	id := h.packets[0]
+	if !strings.HasPrefix(string(id), vorbisIdentificationMagic) || len(id) < 30 {
+		return nil, fmt.Errorf("vorbis: invalid identification header")
+	}
+	info := &fileInfo{
+		channels:       int(id[11]),
+		sampleRate:     int(binary.LittleEndian.Uint32(id[12:16])),
+		nominalBitrate: int(int32(binary.LittleEndian.Uint32(id[20:24]))),
+	}
+	if info.nominalBitrate < 0 {
+		info.nominalBitrate = 0
+	}
+	if info.granulePosition, err = h.readLastGranulePosition(r); err != nil {
+		return nil, err
+	}
+
+	c, err := parseVorbisCommentPacket(h.packets[1])
+	if err != nil {
+		return nil, err
+	}
+	info.comments = c
+	return info, nil
+}
@@ -0,0 +1,229 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+const flacMagic = "fLaC"
+
+// isFLACStream reports whether r starts with the FLAC stream marker. The position of r is restored to the start.
+func isFLACStream(r io.ReadSeeker) (bool, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	var magic [4]byte
+	_, err := io.ReadFull(r, magic[:])
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(magic[:]) == flacMagic, nil
+}
+
+// flacStreamInfo is the STREAMINFO metadata block of a FLAC file.
+type flacStreamInfo struct {
+	sampleRate    int
+	channels      int
+	bitsPerSample int
+
+	// totalSamples is the number of the samples per channel. 0 means unknown.
+	totalSamples int64
+}
+
+// readFLACMetadata reads the STREAMINFO and the VORBIS_COMMENT metadata blocks of a FLAC file.
+// https://xiph.org/flac/format.html#metadata_block
+func readFLACMetadata(r io.Reader) (*flacStreamInfo, *vorbisComments, error) {
+	br := bufio.NewReader(r)
+	var magic [4]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, nil, fmt.Errorf("flac: %w", err)
+	}
+	if string(magic[:]) != flacMagic {
+		return nil, nil, fmt.Errorf("flac: invalid stream marker")
+	}
+
+	var si *flacStreamInfo
+	c := &vorbisComments{}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return nil, nil, fmt.Errorf("flac: %w", err)
+		}
+		last := header[0]&0x80 != 0
+		typ := header[0] & 0x7f
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		switch typ {
+		case 0, 4:
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return nil, nil, fmt.Errorf("flac: %w", err)
+			}
+			if typ == 0 {
+				if len(buf) < 18 {
+					return nil, nil, fmt.Errorf("flac: invalid STREAMINFO")
+				}
+				// 20 bits of the sample rate, 3 bits of the channels - 1, 5 bits of the bits per sample - 1 and 36 bits of the total samples.
+				v := binary.BigEndian.Uint64(buf[10:18])
+				si = &flacStreamInfo{
+					sampleRate:    int(v >> 44),
+					channels:      int((v>>41)&0x7) + 1,
+					bitsPerSample: int((v>>36)&0x1f) + 1,
+					totalSamples:  int64(v & (1<<36 - 1)),
+				}
+			} else {
+				comments, err := parseVorbisComments(buf)
+				if err != nil {
+					return nil, nil, err
+				}
+				c = comments
+			}
+		default:
+			if _, err := br.Discard(size); err != nil {
+				return nil, nil, fmt.Errorf("flac: %w", err)
+			}
+		}
+		if last {
+			break
+		}
+	}
+	if si == nil {
+		return nil, nil, fmt.Errorf("flac: STREAMINFO is missing")
+	}
+	return si, c, nil
+}
+
+// readFLACInfo reads the format information from the metadata blocks of a FLAC file.
+// The loop tags are read from the Vorbis comments like an Ogg/Vorbis file.
+func readFLACInfo(r io.ReadSeeker) (*fileInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	si, c, err := readFLACMetadata(r)
+	if err != nil {
+		return nil, err
+	}
+	// The bitrate is left unknown, as the bitrate limits of the profiles are for the lossy files.
+	info := &fileInfo{
+		sampleRate:      si.sampleRate,
+		channels:        si.channels,
+		comments:        c,
+		granulePosition: -1,
+	}
+	if si.totalSamples > 0 {
+		info.granulePosition = si.totalSamples
+	}
+	return info, nil
+}
+
+// decodeFLACToWAV decodes the FLAC file at path to the WAV file at wav with the flac command, as there is no FLAC decoder in Go.
+// flac writes the raw samples at the file's bit depth, which are converted to the stereo 16-bit WAV the player can read.
+// flac is killed when ctx is canceled.
+func decodeFLACToWAV(ctx context.Context, path, wav string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	si, _, err := readFLACMetadata(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if si.channels > 2 {
+		return fmt.Errorf("oggplayer: only mono and stereo FLAC files are supported: %d channels", si.channels)
+	}
+
+	raw := wav + ".raw"
+	defer os.Remove(raw)
+	cmd := exec.CommandContext(ctx, *flagFLAC, "--decode", "--silent", "--force", "--force-raw-format", "--endian=little", "--sign=signed", "--output-name="+raw, path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("oggplayer: %s failed: %w: %s", *flagFLAC, err, out)
+	}
+	return convertRawToWAV(raw, wav, si)
+}
+
+// convertRawToWAV converts the raw signed little-endian samples at src in the format of si to a stereo 16-bit WAV file at dst.
+func convertRawToWAV(src, dst string, si *flacStreamInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	bytesPerRawSample := (si.bitsPerSample + 7) / 8
+	frames := fi.Size() / int64(si.channels*bytesPerRawSample)
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	bw := bufio.NewWriter(out)
+	if err := writeWAVHeader(bw, si.sampleRate, frames); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(in)
+	frame := make([]byte, si.channels*bytesPerRawSample)
+	var vals [2]int16
+	var buf [4]byte
+	for i := int64(0); i < frames; i++ {
+		if _, err := io.ReadFull(br, frame); err != nil {
+			return err
+		}
+		for ch := 0; ch < si.channels; ch++ {
+			sample := frame[ch*bytesPerRawSample : (ch+1)*bytesPerRawSample]
+			// Sign-extend the sample, and scale it to 16 bits.
+			var v int32
+			for j := bytesPerRawSample - 1; j >= 0; j-- {
+				v = v<<8 | int32(sample[j])
+			}
+			shift := 32 - 8*bytesPerRawSample
+			v = v << shift >> shift
+			if si.bitsPerSample > 16 {
+				v >>= si.bitsPerSample - 16
+			} else {
+				v <<= 16 - si.bitsPerSample
+			}
+			vals[ch] = int16(v)
+		}
+		if si.channels == 1 {
+			vals[1] = vals[0]
+		}
+		binary.LittleEndian.PutUint16(buf[0:], uint16(vals[0]))
+		binary.LittleEndian.PutUint16(buf[2:], uint16(vals[1]))
+		// bufio.Writer keeps the first error, which is returned by Flush.
+		bw.Write(buf[:])
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,1960 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/sqweek/dialog"
+)
+
+const (
+	screenWidth  = 320
+	screenHeight = 240
+)
+
+var (
+	playerBarColor     = color.RGBA{0x80, 0x80, 0x80, 0xff}
+	playerCurrentColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	loopCursorColor    = color.RGBA{0xff, 0xff, 0x80, 0xff}
+	markerColor        = color.RGBA{0x80, 0xc0, 0xff, 0xff}
+	selectionColor     = color.RGBA{0x20, 0x40, 0x80, 0x80}
+	waveformColor      = color.RGBA{0x40, 0x80, 0x40, 0xff}
+	loudnessLaneColor  = color.RGBA{0xc0, 0x80, 0x40, 0xff}
+	seamBeforeColor    = color.RGBA{0xff, 0xff, 0x80, 0xff}
+	seamAfterColor     = color.RGBA{0x80, 0xc0, 0xff, 0xff}
+	ghostWaveformColor = color.RGBA{0x80, 0x40, 0x40, 0x80}
+	momentColor        = color.RGBA{0xff, 0x80, 0x80, 0xff}
+)
+
+const (
+	// waveformHeight is the height of the waveform drawn on the bar as the timeline.
+	waveformHeight = 24
+
+	// loudnessLaneHeight is the height of the short-term loudness lane above the waveform.
+	loudnessLaneHeight = 12
+
+	// loudnessLaneFloor is the loudness in LUFS at the bottom of the lane.
+	loudnessLaneFloor = -60
+)
+
+// loudnessLine returns the line showing the loudness, or an empty string when it is not measured.
+func loudnessLine(loudness float64) string {
+	if math.IsNaN(loudness) {
+		return ""
+	}
+	return fmt.Sprintf("Loudness: %.1f LUFS\n", loudness)
+}
+
+// debugCharWidth and debugLineHeight are the size of a character of ebitenutil.DebugPrint.
+const (
+	debugCharWidth  = 6
+	debugLineHeight = 16
+)
+
+// wrapText wraps str so that each line has at most width characters.
+func wrapText(str string, width int) string {
+	var lines []string
+	for len(str) > width {
+		lines = append(lines, str[:width])
+		str = str[width:]
+	}
+	return strings.Join(append(lines, str), "\n")
+}
+
+func playerBarRect() (x, y, w, h int) {
+	w, h = 300, 4
+	x = (screenWidth - w) / 2
+	y = screenHeight - h - 16
+	return
+}
+
+// waveformRect returns the rectangle of the waveform standing on the bar, which makes the bar a timeline.
+func waveformRect() (x, y, w, h int) {
+	x, by, w, _ := playerBarRect()
+	return x, by - 1 - waveformHeight, w, waveformHeight
+}
+
+// waveformLaneRect returns the center line and the half height of the i-th of n lanes stacked in the waveform.
+func waveformLaneRect(i, n int) (cy, half float64) {
+	_, y, _, h := waveformRect()
+	lh := float64(h) / float64(n)
+	return float64(y) + lh*(float64(i)+0.5), lh / 2
+}
+
+// drawWaveform draws wf on the bar in the view of the timeline in the lanes of theWaveform.
+// wf is scaled with its own length so that waveforms of files with different lengths are aligned in time.
+func (p *Player) drawWaveform(b *shapeBatch, wf *waveform, clr color.Color) {
+	x, _, w, _ := waveformRect()
+	start, span := p.viewRange()
+	lanes := theWaveform.lanes()
+	for li, lane := range lanes {
+		cy, half := waveformLaneRect(li, len(lanes))
+		for i := 0; i < w; i++ {
+			from := start + int64(i)*span/int64(w)
+			if from >= wf.frames {
+				break
+			}
+			min, max := wf.rangePeaks(lane, from, start+int64(i+1)*span/int64(w))
+			top := cy - float64(max)*half
+			bottom := cy - float64(min)*half
+			b.Rect(float64(x+i), top, 1, bottom-top+1, clr)
+		}
+	}
+}
+
+// drawSamples draws the PCM starting at the frame from in the zoomed view of the timeline.
+// When a column has at most one sample, the sample is drawn as a stem from the center line.
+func (p *Player) drawSamples(b *shapeBatch, pcm []int16, from int64, clr color.Color) {
+	x, _, w, _ := waveformRect()
+	start, span := p.viewRange()
+	frames := int64(len(pcm) / 2)
+	lanes := theWaveform.lanes()
+	for li, lane := range lanes {
+		cy, half := waveformLaneRect(li, len(lanes))
+		for i := 0; i < w; i++ {
+			s0 := start + int64(i)*span/int64(w) - from
+			s1 := start + int64(i+1)*span/int64(w) - from
+			if s1 <= s0 {
+				s1 = s0 + 1
+			}
+			if s0 < 0 || s0 >= frames {
+				continue
+			}
+			if s1 > frames {
+				s1 = frames
+			}
+			var min, max float32
+			if s1-s0 > 1 {
+				min, max = 1, -1
+			}
+			for j := s0; j < s1; j++ {
+				v := lane.value(pcm[2*j], pcm[2*j+1])
+				if v < min {
+					min = v
+				}
+				if v > max {
+					max = v
+				}
+			}
+			top := cy - float64(max)*half
+			bottom := cy - float64(min)*half
+			b.Rect(float64(x+i), top, 1, bottom-top+1, clr)
+		}
+	}
+}
+
+// drawLoudnessLane draws the short-term loudness above the waveform.
+// Each column shows the peak in its range so that short surges are not missed.
+func (p *Player) drawLoudnessLane(b *shapeBatch, shortTerm []float64) {
+	if len(shortTerm) == 0 {
+		return
+	}
+	x, y, w, _ := waveformRect()
+	bottom := y - 2
+	start, span := p.viewRange()
+	for i := 0; i < w; i++ {
+		from := int((start + int64(i)*span/int64(w)) / loudnessStepSamples)
+		to := int((start + int64(i+1)*span/int64(w)) / loudnessStepSamples)
+		if to <= from {
+			to = from + 1
+		}
+		if from >= len(shortTerm) {
+			break
+		}
+		if to > len(shortTerm) {
+			to = len(shortTerm)
+		}
+		peak := math.Inf(-1)
+		for _, l := range shortTerm[from:to] {
+			if peak < l {
+				peak = l
+			}
+		}
+		h := (peak - loudnessLaneFloor) / -loudnessLaneFloor * loudnessLaneHeight
+		if h <= 0 {
+			continue
+		}
+		if h > loudnessLaneHeight {
+			h = loudnessLaneHeight
+		}
+		b.Rect(float64(x+i), float64(bottom)-h, 1, h, loudnessLaneColor)
+	}
+}
+
+// drawSeamBands draws the band energy before the loop end (yellow) and after the loop start (blue) at the top right.
+func (p *Player) drawSeamBands(screen *ebiten.Image, b *shapeBatch) {
+	s := p.SeamBands()
+	if s == nil {
+		return
+	}
+	const (
+		barWidth    = 4
+		groupWidth  = 2*barWidth + 4
+		chartHeight = 24
+	)
+	x0 := screenWidth - len(bandNames)*groupWidth - 4
+	bottom := 4 + chartHeight
+	height := func(db float64) float64 {
+		h := (db - seamBandFloor) / -seamBandFloor * chartHeight
+		if h < 0 {
+			return 0
+		}
+		if h > chartHeight {
+			return chartHeight
+		}
+		return h
+	}
+	for band := range bandNames {
+		x := x0 + band*groupWidth
+		hb := height(s.before[band])
+		ha := height(s.after[band])
+		b.Rect(float64(x), float64(bottom)-hb, barWidth, hb, seamBeforeColor)
+		b.Rect(float64(x+barWidth), float64(bottom)-ha, barWidth, ha, seamAfterColor)
+	}
+	ebitenutil.DebugPrintAt(screen, "L M H", x0-1, bottom)
+}
+
+func (p *Player) update() error {
+	p.updateCurrent()
+	if inpututil.IsKeyJustPressed(ebiten.KeyG) && !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		p.ghost = nil
+	}
+	dragging, err := p.dragLoopIfNeeded()
+	if err != nil {
+		return err
+	}
+	if !dragging {
+		p.seekBarIfNeeded()
+	}
+	p.zoomIfNeeded()
+	p.switchPlayStateIfNeeded()
+	p.shuttleIfNeeded()
+	p.updateVolumeIfNeeded()
+	if err := p.auditionSegmentIfNeeded(); err != nil {
+		return err
+	}
+	if err := p.previewSelectionIfNeeded(); err != nil {
+		return err
+	}
+	if err := p.abLoopIfNeeded(); err != nil {
+		return err
+	}
+	if err := p.setLoopAtPlayheadIfNeeded(); err != nil {
+		return err
+	}
+	if err := p.adjustLoopIfNeeded(); err != nil {
+		return err
+	}
+	if err := p.previewSeamIfNeeded(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// auditionSegmentIfNeeded loops the segment between the markers with the number keys.
+// 0 restores the file's loop.
+func (p *Player) auditionSegmentIfNeeded() error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyDigit0) {
+		return p.ResetLoop()
+	}
+	for i := 0; i < 9; i++ {
+		if inpututil.IsKeyJustPressed(ebiten.KeyDigit1 + ebiten.Key(i)) {
+			return p.AuditionSegment(i)
+		}
+	}
+	return nil
+}
+
+func (p *Player) updateVolumeIfNeeded() {
+	// Shift+Z switches the snap mode.
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return
+	}
+	if theSettings.Keys.pressed(actionVolumeDown) {
+		p.AddVolume(-1)
+	}
+	if theSettings.Keys.pressed(actionVolumeUp) {
+		p.AddVolume(1)
+	}
+}
+
+func (p *Player) switchPlayStateIfNeeded() {
+	if !theSettings.Keys.justPressed(actionPlay) {
+		return
+	}
+	p.TogglePlay()
+}
+
+// transportHelp returns the help of the transport scheme's keys following Space.
+func transportHelp() string {
+	if theTransport == transportJKL {
+		return ", J/K/L to reverse/stop/forward"
+	}
+	return ""
+}
+
+// shuttleIfNeeded controls the playback with J, K and L in the JKL transport scheme.
+func (p *Player) shuttleIfNeeded() {
+	if theTransport != transportJKL || isControlPressed() || ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyJ) {
+		p.ShuttleReverse()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyK) {
+		p.StopShuttle()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyL) {
+		p.ShuttleForward()
+	}
+}
+
+// loopDragInterval is the interval to apply the dragged loop to the playback.
+// Rebuilding the loop every frame would make the sound stutter.
+const loopDragInterval = 100 * time.Millisecond
+
+// dragLoopIfNeeded drags the loop start or end on the bar, and reports whether a handle is being dragged.
+// The loop is applied while dragging so that the new seam can be heard at once.
+func (p *Player) dragLoopIfNeeded() (bool, error) {
+	x, y := ebiten.CursorPosition()
+	_, by, _, bh := playerBarRect()
+
+	if p.draggingLoop != loopHandleNone {
+		p.dragSample = p.timelineSample(x)
+		released := !ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft)
+		h := p.draggingLoop
+		if released {
+			p.draggingLoop = loopHandleNone
+		}
+		if !released && time.Since(p.loopDragApplied) < loopDragInterval {
+			return true, nil
+		}
+		p.loopDragApplied = time.Now()
+		return true, p.MoveLoopHandle(h, p.dragSample)
+	}
+
+	if p.loopSample <= 0 || !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return false, nil
+	}
+	const padding = 4
+	_, wy, _, _ := waveformRect()
+	if y < wy || by+bh+padding <= y {
+		return false, nil
+	}
+	// Grab the nearer handle when both are close to the cursor.
+	const grabDistance = 3
+	best := grabDistance + 1
+	for _, c := range []struct {
+		handle loopHandle
+		sample int64
+	}{
+		{loopHandleStart, p.introSample},
+		{loopHandleEnd, p.introSample + p.loopSample},
+	} {
+		hx, ok := p.timelineX(c.sample)
+		if !ok {
+			continue
+		}
+		d := x - hx
+		if d < 0 {
+			d = -d
+		}
+		if d < best {
+			best = d
+			p.draggingLoop = c.handle
+		}
+	}
+	if p.draggingLoop == loopHandleNone {
+		return false, nil
+	}
+	p.dragSample = p.timelineSample(x)
+	p.loopDragApplied = time.Time{}
+	return true, nil
+}
+
+func (p *Player) seekBarIfNeeded() {
+	x, y := ebiten.CursorPosition()
+	bx, by, bw, bh := playerBarRect()
+
+	// Dragging on the bar selects a range.
+	if p.selecting {
+		if !ebiten.IsMouseButtonPressed(ebiten.MouseButtonLeft) {
+			p.selecting = false
+			return
+		}
+		if x < bx {
+			x = bx
+		}
+		if x > bx+bw {
+			x = bx + bw
+		}
+		const dragThreshold = 2
+		if x-p.selAnchorX < dragThreshold && p.selAnchorX-x < dragThreshold {
+			return
+		}
+		p.SetSelection(p.selAnchor, p.timelineSample(x))
+		return
+	}
+
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return
+	}
+
+	// Calculate the next seeking position from the current cursor position.
+	// The waveform on the bar is a part of the timeline.
+	const padding = 4
+	_, wy, _, _ := waveformRect()
+	if y < wy || by+bh+padding <= y {
+		return
+	}
+	if x < bx || bx+bw <= x {
+		return
+	}
+	sample := p.timelineSample(x)
+	p.Seek(samplesToDuration(sample))
+
+	p.ClearSelection()
+	p.selecting = true
+	p.selAnchor = sample
+	p.selAnchorX = x
+}
+
+// setLoopAtPlayheadIfNeeded sets the loop start (I) or the loop end (O) at the current position.
+func (p *Player) setLoopAtPlayheadIfNeeded() error {
+	// Shift+I toggles the input monitor.
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return nil
+	}
+	if theSettings.Keys.justPressed(actionLoopStart) {
+		return p.MoveLoopHandle(loopHandleStart, p.currentSample())
+	}
+	if theSettings.Keys.justPressed(actionLoopEnd) {
+		return p.MoveLoopHandle(loopHandleEnd, p.currentSample())
+	}
+	return nil
+}
+
+// previewSeamIfNeeded seeks to before the loop end with E to audition the seam,
+// or to before the loop start with Shift+E to audition the end of the intro. Ctrl+E opens the editor.
+func (p *Player) previewSeamIfNeeded() error {
+	if !theSettings.Keys.justPressed(actionSeam) || isControlPressed() {
+		return nil
+	}
+	d := time.Duration(*flagPreview * float64(time.Second))
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return p.PreviewIntroEnd(d)
+	}
+	return p.PreviewSeam(d)
+}
+
+// adjustLoopIfNeeded halves (H) or doubles (D) the loop length, or shifts the loop by a bar (comma and period).
+func (p *Player) adjustLoopIfNeeded() error {
+	if theSettings.Keys.justPressed(actionHalveLoop) {
+		return p.ScaleLoopLength(1, 2)
+	}
+	// Ctrl+D exports the markers.
+	// Shift+D downmixes the playback to mono.
+	if theSettings.Keys.justPressed(actionDoubleLoop) && !isControlPressed() && !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return p.ScaleLoopLength(2, 1)
+	}
+	// Shift+, and Shift+. (< and >) pan the playback.
+	if theSettings.Keys.justPressed(actionShiftBack) && !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return p.ShiftLoop(-1, int64(*flagLoopBars))
+	}
+	if theSettings.Keys.justPressed(actionShiftFwd) && !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return p.ShiftLoop(1, int64(*flagLoopBars))
+	}
+	return nil
+}
+
+// abLoopIfNeeded sets the points of the A-B loop with Shift+A and Shift+B. A and B without Shift trim and report a bug.
+func (p *Player) abLoopIfNeeded() error {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyA) {
+		return p.SetPointA()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyB) {
+		return p.SetPointB()
+	}
+	return nil
+}
+
+// previewSelectionIfNeeded loops the selection with Enter, or plays it once with Shift+Enter. Escape clears the selection and restores the file's loop.
+// Escape is left to the proposed trim while it is shown, and does nothing without a selection so that an edited loop is kept.
+func (p *Player) previewSelectionIfNeeded() error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		if ebiten.IsKeyPressed(ebiten.KeyShift) {
+			return p.PlaySelection()
+		}
+		return p.PreviewSelection()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) && p.trim == nil && p.HasSelection() {
+		p.ClearSelection()
+		return p.ResetLoop()
+	}
+	return nil
+}
+
+// draw draws the shapes of the player to b. The texts are drawn to screen directly.
+func (p *Player) draw(screen *ebiten.Image, b *shapeBatch) {
+	// Draw the waveform, and the previous one ghosted over it after the file is reloaded.
+	// The zoomed-in view is drawn from the PCM itself, as the waveform overview is too coarse for it.
+	if p.analysis != nil {
+		if pcm, from := p.viewPCM(); pcm != nil {
+			p.drawSamples(b, pcm, from, waveformColor)
+		} else {
+			p.drawWaveform(b, p.analysis.waveform, waveformColor)
+		}
+		p.drawLoudnessLane(b, p.analysis.shortTerm)
+		p.drawBeatGrid(b)
+		p.drawClips(b)
+	}
+	p.drawSeamBands(screen, b)
+	if p.ghost != nil {
+		p.drawWaveform(b, p.ghost, ghostWaveformColor)
+	}
+
+	// Draw the bar.
+	x, y, w, h := playerBarRect()
+	b.Rect(float64(x), float64(y), float64(w), float64(h), playerBarColor)
+
+	// Draw which part of the file the zoomed timeline shows under the bar.
+	if start, span := p.viewRange(); span < p.totalSample {
+		vx := int64(x) + int64(w)*start/p.totalSample
+		vw := int64(w) * span / p.totalSample
+		if vw < 1 {
+			vw = 1
+		}
+		b.Rect(float64(vx), float64(y+h+6), float64(vw), 2, playerBarColor)
+	}
+
+	// Draw the selection over the bar and the waveform.
+	_, wy, _, _ := waveformRect()
+	if p.HasSelection() {
+		sx0 := p.timelineXClamped(p.selStart)
+		sx1 := p.timelineXClamped(p.selEnd)
+		b.Rect(float64(sx0), float64(wy), float64(sx1-sx0), float64(y+h+8-wy), selectionColor)
+	}
+
+	// Draw the loop start and end across the waveform to see whether they land on musical boundaries.
+	if p.analysis != nil && p.loopSample > 0 {
+		for _, s := range []int64{p.introSample, p.introSample + p.loopSample} {
+			if lx, ok := p.timelineX(s); ok {
+				b.Rect(float64(lx), float64(wy), 1, float64(y-wy), loopCursorColor)
+			}
+		}
+	}
+	// Draw where the loop handle is being dragged to, which is applied to the playback at intervals.
+	if p.draggingLoop != loopHandleNone {
+		if lx, ok := p.timelineX(p.dragSample); ok {
+			b.Rect(float64(lx), float64(wy), 1, float64(y+h-wy), playerCurrentColor)
+		}
+	}
+
+	cw, ch := 4, 10
+	cy := y - (ch-h)/2
+
+	// Draw the loop start and end on the bar.
+	for _, s := range []int64{p.introSample, p.introSample + p.loopSample} {
+		if cx, ok := p.timelineX(s); ok {
+			b.Rect(float64(cx-cw/2), float64(cy), float64(cw), float64(ch), loopCursorColor)
+		}
+	}
+
+	// Draw the cursor on the bar.
+	if cx, ok := p.timelineX(p.currentSample()); ok {
+		b.Rect(float64(cx-cw/2), float64(cy), float64(cw), float64(ch), playerCurrentColor)
+	}
+
+	// Draw the markers above the bar.
+	for _, m := range p.markers {
+		if mx, ok := p.timelineX(m.Sample); ok {
+			b.Rect(float64(mx), float64(cy-4), 1, 4, markerColor)
+		}
+	}
+}
+
+// message returns the debug message of the player with the file's state.
+func (p *Player) message() string {
+	// The state comes first so that it is always on the screen. The keys are in the help shown with F1.
+	msg := fmt.Sprintf(`Current Time: %s
+Loop Start: %s
+Loop End: %s
+Length: %s (%d at %d Hz)
+Volume: %d/128
+F1: keys, Shift+X: time display (%s)
+%s`, p.timeReadout(p.currentSample()), p.timeReadout(p.introSample), p.timeReadout(p.introSample+p.loopSample), formatTime(p.total), p.fileSample(p.totalSample), p.info.rate(), p.volume128, theTimeDisplay.label(), loudnessLine(p.Loudness()))
+	if l := p.zoomLine(); l != "" {
+		msg += l + "\n"
+	}
+	if l := p.abLine(); l != "" {
+		msg += l + "\n"
+	}
+	if l := p.shuttleLabel(); l != "" {
+		msg += l + " (K: stop)\n"
+	}
+	if l := p.fadeLine(); l != "" {
+		msg += l + "\n"
+	}
+	if l := p.tempoLine(); l != "" {
+		msg += l + " (Shift+G: grid)\n"
+	}
+	if l := p.snapLine(); l != "" {
+		msg += l + " (Shift+Z)\n"
+	}
+	if l := seamBandsLine(p.SeamBands()); l != "" {
+		msg += l + "\n"
+	}
+	if l := seamQualityLine(p.SeamQuality()); l != "" {
+		msg += wrapText(l, screenWidth/debugCharWidth) + "\n"
+	}
+	for _, w := range p.tagWarnings {
+		msg += "Warning: " + w + "\n"
+	}
+	for _, w := range p.channelWarnings() {
+		msg += "Warning: " + w + "\n"
+	}
+	for _, w := range p.silenceWarnings() {
+		msg += "Warning: " + w + "\n"
+	}
+	for _, w := range p.clipWarnings() {
+		msg += wrapText("Warning: "+w, screenWidth/debugCharWidth) + "\n"
+	}
+	for _, w := range p.profileWarnings {
+		msg += fmt.Sprintf("%s: %s\n", theProfile.Name, w)
+	}
+	for _, w := range p.ruleWarnings {
+		msg += "Rule: " + w + "\n"
+	}
+	if len(p.badTagKeys) > 0 && !*flagReadOnly {
+		msg += "Press C to clear the bad loop tags\n"
+	}
+	if p.HasSelection() {
+		msg += fmt.Sprintf("Selection: %s - %s\nEnter: loop it, Shift+Enter: play it once, Esc: clear\n", formatTime(samplesToDuration(p.selStart)), formatTime(samplesToDuration(p.selEnd)))
+	}
+	if len(p.markers) > 0 {
+		msg += "Press 1-9 to loop a segment between the markers\n"
+	}
+	for _, l := range p.loopProvenance() {
+		msg += wrapText(l, screenWidth/debugCharWidth) + "\n"
+	}
+	if p.loopSource != "" {
+		msg += fmt.Sprintf("Loop: %s, overriding the tags (Press 0 to restore, Ctrl+S to save)\n", p.loopSource)
+	}
+	if p.parkedAtLoopEnd() && p.playOnce {
+		msg += "Played the selection. Play to replay it\n"
+	} else if p.parkedAtLoopEnd() {
+		msg += "Paused at the loop end. Play to go on\n"
+	}
+	if p.ghost != nil {
+		msg += "Reloaded. Press G to hide the previous waveform\n"
+	}
+	if l := p.trimLine(); l != "" {
+		msg += wrapText(l+" (A: write, Esc: cancel)", screenWidth/debugCharWidth) + "\n"
+	}
+	return msg
+}
+
+type Game struct {
+	audioContext  *audio.Context
+	musicPlayer   *Player
+	musicPlayerCh chan *Player
+	fileCh        chan string
+	errCh         chan error
+	presence      *discordPresence
+	mediaKeys     *mediaKeys
+	monitor       *inputMonitor
+	history       *listeningHistory
+	playlist      *Playlist
+	loadErr       error
+
+	// loading is the file being decoded before it is opened, or nil.
+	loading *pendingLoad
+
+	// crossfade keeps the previous tracks fading out after the next track is opened.
+	crossfade crossfader
+
+	// reference is the reference track compared with the current file. reference is nil until it is opened.
+	reference *referenceAB
+
+	// watcher is the folder watched by -startup watch:DIR, or nil.
+	watcher *folderWatcher
+
+	// comparison is the audition of the current file and its encoded version. comparison is nil when not comparing.
+	comparison  *abComparison
+	presetIndex int
+	encodedCh   chan encodeResult
+
+	// editingNote reports whether the notes of the current file are being edited.
+	// momentIndex is the index of the note taken at a moment being edited, or -1 for the review's notes.
+	editingNote bool
+	note        []rune
+	momentIndex int
+
+	// renameCh receives the new path of the current file chosen in the dialog.
+	renameCh chan string
+
+	// showPlaylist reports whether the playlist pane is shown instead of the player's message.
+	showPlaylist bool
+
+	// thumbnails is the waveform thumbnails of the entries in the playlist pane.
+	thumbnails *thumbnails
+
+	// meters is the peak meters of the playback.
+	meters *peakMeters
+
+	// showRecent reports whether the recent files pane is shown. The other keys are disabled then.
+	showRecent bool
+
+	// helpPage is the page of the key help shown from 1, or 0 when the help is hidden. The other keys are disabled then.
+	helpPage int
+
+	// mini reports whether the mini mode is on. windowWidth and windowHeight are the window size to restore.
+	mini         bool
+	windowWidth  int
+	windowHeight int
+
+	// presenting reports whether the presentation mode is on. windowX and windowY are the window position to restore.
+	// timecode is the offscreen image the timecode is drawn to before it is scaled up.
+	presenting bool
+	windowX    int
+	windowY    int
+	timecode   *ebiten.Image
+
+	// shapes batches the shapes drawn in a frame.
+	shapes shapeBatch
+
+	perf perfOverlay
+}
+
+func NewGame() (*Game, error) {
+	audioContext := audio.NewContext(sampleRate)
+
+	ebiten.SetRunnableOnUnfocused(true)
+
+	return &Game{
+		audioContext:  audioContext,
+		musicPlayer:   nil,
+		musicPlayerCh: make(chan *Player),
+		errCh:         make(chan error),
+		playlist:      NewPlaylist(nil),
+		encodedCh:     make(chan encodeResult),
+		thumbnails:    newThumbnails(),
+		meters:        newPeakMeters(),
+	}, nil
+}
+
+func (g *Game) Update() error {
+	select {
+	case p := <-g.musicPlayerCh:
+		g.musicPlayer = p
+	case err := <-g.errCh:
+		return err
+	default:
+	}
+
+	g.rememberWindow()
+	g.crossfade.Update()
+	if err := g.loadingIfNeeded(); err != nil {
+		return err
+	}
+	g.reference.Update(g.musicPlayer)
+
+	// The other keys are disabled while the notes are edited.
+	if g.editingNote {
+		if g.musicPlayer != nil {
+			g.musicPlayer.updateCurrent()
+		}
+		g.editNote()
+		return nil
+	}
+
+	if shown, err := g.recentIfNeeded(); err != nil {
+		return err
+	} else if shown {
+		if g.musicPlayer != nil {
+			g.musicPlayer.updateCurrent()
+		}
+		return nil
+	}
+
+	if g.helpIfNeeded() {
+		if g.musicPlayer != nil {
+			g.musicPlayer.updateCurrent()
+		}
+		return nil
+	}
+
+	if g.loopConflictIfNeeded() {
+		g.musicPlayer.updateCurrent()
+		return nil
+	}
+
+	if g.musicPlayer != nil {
+		if err := g.musicPlayer.update(); err != nil {
+			return err
+		}
+		left, right := g.musicPlayer.Peaks()
+		g.meters.Update(time.Now(), left, right)
+	}
+	g.reviewIfNeeded()
+	g.exportIssueIfNeeded()
+	g.exportDAWMarkersIfNeeded()
+	g.copyStateIfNeeded()
+	if err := g.shareIfNeeded(); err != nil {
+		return err
+	}
+	g.trimSilenceIfNeeded()
+	g.renderLoopsIfNeeded()
+	g.exportStingerIfNeeded()
+	if inpututil.IsKeyJustPressed(ebiten.KeyDelete) {
+		theJobs.CancelLast()
+	}
+	g.encodeIfNeeded()
+
+	if err := g.openFileIfNeeded(); err != nil {
+		return err
+	}
+	if err := g.playlistIfNeeded(); err != nil {
+		return err
+	}
+	if err := g.mediaKeysIfNeeded(); err != nil {
+		return err
+	}
+	if err := g.watchIfNeeded(); err != nil {
+		return err
+	}
+	g.exportPlaylistIfNeeded()
+	g.renameIfNeeded()
+	g.toggleMiniIfNeeded()
+	g.togglePresentationIfNeeded()
+	g.openNewWindowIfNeeded()
+	g.openEditorIfNeeded()
+	g.saveLoopIfNeeded()
+	if err := g.clearBadTagsIfNeeded(); err != nil {
+		return err
+	}
+	g.reloadIfNeeded()
+	g.bypassPreviewIfNeeded()
+	g.transposeIfNeeded()
+	g.stretchIfNeeded()
+	g.midSideIfNeeded()
+	g.channelsIfNeeded()
+	g.panIfNeeded()
+	g.limiterIfNeeded()
+	g.referenceIfNeeded()
+	g.fadeIfNeeded()
+	g.waveformModeIfNeeded()
+	g.beatGridIfNeeded()
+	g.snapIfNeeded()
+	g.timeDisplayIfNeeded()
+	g.monitorIfNeeded()
+	g.perf.updateIfNeeded(g.musicPlayer)
+
+	g.presence.Update(g.musicPlayer)
+	g.mediaKeys.Update(g.musicPlayer)
+
+	return nil
+}
+
+func (g *Game) openFile(dir string) {
+	filename, err := dialog.File().SetStartDir(dir).Filter("Audio file", "ogg", "opus", "wav", "mp3", "flac").Filter("Playlist", "m3u", "m3u8", "cue").Filter("Project", projectExt[1:]).Load()
+	if err != dialog.Cancelled {
+		g.fileCh <- filename
+	}
+	g.fileCh <- ""
+}
+
+func (g *Game) openFileIfNeeded() error {
+	select {
+	case filename := <-g.fileCh:
+		if filename != "" {
+			fmt.Println("open ogg file", filename)
+			theSettings.LastDir = filepath.Dir(filename)
+			index, err := g.playlist.AddFile(filename)
+			if err != nil {
+				return err
+			}
+			if err := g.load(index); err != nil {
+				return err
+			}
+		}
+	default:
+	}
+
+	if !theSettings.Keys.justPressed(actionOpen) {
+		return nil
+	}
+	if g.musicPlayer != nil {
+		g.musicPlayer.Pause()
+	}
+
+	g.fileCh = make(chan string)
+	go g.openFile(theSettings.LastDir)
+
+	return nil
+}
+
+// mediaKeysIfNeeded applies the actions of the media keys pressed since the last frame.
+func (g *Game) mediaKeysIfNeeded() error {
+	for {
+		select {
+		case a := <-g.mediaKeys.C():
+			var err error
+			applyMediaAction(a, g.musicPlayer, func() {
+				err = g.load(g.playlist.Index() + 1)
+			}, func() {
+				err = g.load(g.playlist.Index() - 1)
+			})
+			if err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// playlistPaneLines is the number of the entries shown at once in the playlist pane.
+const playlistPaneLines = 9
+
+// playlistPaneRange returns the range of the entries shown in the playlist pane, which scrolls with the current entry.
+func (g *Game) playlistPaneRange() (first, last int) {
+	n := g.playlist.Len()
+	first = g.playlist.Index() - playlistPaneLines/2
+	if first > n-playlistPaneLines {
+		first = n - playlistPaneLines
+	}
+	if first < 0 {
+		first = 0
+	}
+	last = first + playlistPaneLines
+	if last > n {
+		last = n
+	}
+	return first, last
+}
+
+// playlistPaneKey returns the key toggling the playlist pane.
+func playlistPaneKey() string {
+	if theTransport == transportJKL {
+		return "Ctrl+L"
+	}
+	return "L"
+}
+
+// playlistIfNeeded moves in the playlist with N and P, and toggles the playlist pane with L.
+// In the JKL transport scheme, L plays forward and Ctrl+L toggles the pane instead.
+// A click on an entry in the pane opens it.
+func (g *Game) playlistIfNeeded() error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyL) && (theTransport != transportJKL || isControlPressed()) && !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		g.showPlaylist = !g.showPlaylist
+	}
+	// Ctrl+N opens a new window.
+	if theSettings.Keys.justPressed(actionNext) && !isControlPressed() {
+		return g.load(g.playlist.Index() + 1)
+	}
+	if theSettings.Keys.justPressed(actionPrev) {
+		return g.load(g.playlist.Index() - 1)
+	}
+	if !g.showPlaylist || g.mini || !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return nil
+	}
+	// The first line is the title.
+	_, y := ebiten.CursorPosition()
+	first, last := g.playlistPaneRange()
+	if i := first + y/debugLineHeight - 1; y >= debugLineHeight && i < last && i != g.playlist.Index() {
+		return g.load(i)
+	}
+	return nil
+}
+
+// drawThumbnails draws the waveform thumbnails with the loops at the right of the entries in the playlist pane.
+// The thumbnails are generated in background as the entries are shown.
+func (g *Game) drawThumbnails(b *shapeBatch) {
+	first, last := g.playlistPaneRange()
+	x := screenWidth - thumbnailWidth - 4
+	for i := first; i < last; i++ {
+		path := g.playlist.paths[i]
+		th := g.thumbnails.get(path)
+		if th == nil {
+			continue
+		}
+		// The first line is the title.
+		y := (i-first+1)*debugLineHeight + (debugLineHeight-thumbnailHeight)/2
+		l := g.playlist.loops[path]
+		th.draw(b, x, y, l.Start, l.Length)
+	}
+}
+
+// playlistMessage returns the playlist pane with the entries around the current one.
+func (g *Game) playlistMessage() string {
+	lines := []string{fmt.Sprintf("Playlist %d/%d (N/P: next/prev, %s: close)", g.playlist.Index()+1, g.playlist.Len(), playlistPaneKey())}
+	first, last := g.playlistPaneRange()
+	for i := first; i < last; i++ {
+		path := g.playlist.paths[i]
+		mark := "  "
+		if i == g.playlist.Index() {
+			mark = "> "
+		}
+		line := fmt.Sprintf("%s%d. %s", mark, i+1, filepath.Base(path))
+		if r := g.playlist.Review(path); r.Status != reviewNone {
+			line += fmt.Sprintf(" [%s]", r.Status)
+		}
+		// The thumbnail is drawn at the right.
+		if runes := []rune(line); len(runes) > (screenWidth-thumbnailWidth-8)/debugCharWidth {
+			line = string(runes[:(screenWidth-thumbnailWidth-8)/debugCharWidth])
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// load opens the index-th file in the playlist.
+func (g *Game) load(index int) error {
+	return g.loadThen(index, nil)
+}
+
+// loadThen is like load, and calls then with the opened player unless then is nil.
+// A file needing an external decoder, e.g. Opus, is decoded in a job first, and is opened by loadingIfNeeded when the decode finishes.
+func (g *Game) loadThen(index int, then func(p *Player) error) error {
+	if !g.playlist.SetIndex(index) {
+		return nil
+	}
+	g.closeComparison()
+	if g.loading != nil {
+		g.loading.decode.Cancel()
+		g.loading = nil
+	}
+	crossfading := false
+	if g.musicPlayer != nil {
+		if err := g.history.Record(g.musicPlayer); err != nil {
+			log.Printf("history error: %v", err)
+		}
+		crossfading = g.crossfade.Release(g.musicPlayer)
+		if !crossfading {
+			g.musicPlayer.Close()
+		}
+		g.musicPlayer = nil
+	}
+
+	if d := startDecode(g.playlist.Current()); d != nil {
+		g.loadErr = nil
+		g.loading = &pendingLoad{
+			decode:      d,
+			crossfading: crossfading,
+			then:        then,
+		}
+		return nil
+	}
+	return g.open(crossfading, then)
+}
+
+// open opens the current file in the playlist, and calls then with the opened player unless then is nil.
+func (g *Game) open(crossfading bool, then func(p *Player) error) error {
+	m, err := NewPlayer(g.audioContext, g.playlist.Current())
+	if err != nil {
+		// Show the error instead of quitting, e.g. for malformed tags in the strict mode.
+		log.Printf("open error: %v", err)
+		g.loadErr = err
+		return nil
+	}
+	g.loadErr = nil
+	g.musicPlayer = m
+	if crossfading {
+		g.crossfade.FadeIn(m)
+	}
+	theSettings.addRecent(m.path)
+	if err := g.playlist.prepare(m); err != nil {
+		return err
+	}
+	if then == nil {
+		return nil
+	}
+	return then(m)
+}
+
+// loadingIfNeeded opens the file being loaded when its decode finishes.
+func (g *Game) loadingIfNeeded() error {
+	if g.loading == nil {
+		return nil
+	}
+	over, err := g.loading.decode.result()
+	if !over {
+		return nil
+	}
+	l := g.loading
+	g.loading = nil
+	if err != nil {
+		log.Printf("open error: %v", err)
+		g.loadErr = err
+		return nil
+	}
+	return g.open(l.crossfading, l.then)
+}
+
+// openFiles adds paths to the playlist and opens the first one, e.g. for the files given on the command line.
+func (g *Game) openFiles(paths []string) error {
+	first := -1
+	for _, path := range paths {
+		index, err := g.playlist.AddFile(path)
+		if err != nil {
+			// Show the error instead of quitting, as the app can be opened by the OS's file association.
+			log.Printf("open error: %v", err)
+			g.loadErr = err
+			continue
+		}
+		if first < 0 {
+			first = index
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	return g.load(first)
+}
+
+// start starts as s tells when neither files nor a snapshot are given.
+func (g *Game) start(s startup) error {
+	switch s.mode {
+	case startupResume:
+		snap, err := loadLastSession()
+		if err != nil {
+			// A broken session shouldn't prevent the app from starting.
+			log.Printf("session error: %v", err)
+			return nil
+		}
+		if snap != nil {
+			return g.restore(snap)
+		}
+	case startupProject:
+		return g.openFiles([]string{s.path})
+	case startupWatch:
+		g.watcher = newFolderWatcher(s.path)
+	case startupDialog:
+		g.fileCh = make(chan string)
+		go g.openFile(theSettings.LastDir)
+	}
+	return nil
+}
+
+// watchIfNeeded adds the audio files exported to the watched folder to the playlist, and opens the latest one.
+func (g *Game) watchIfNeeded() error {
+	paths, err := g.watcher.Poll(time.Now())
+	if err != nil {
+		log.Printf("watch error: %s, %v", g.watcher.dir, err)
+		return nil
+	}
+	index := -1
+	for _, path := range paths {
+		i, err := g.playlist.AddFile(path)
+		if err != nil {
+			log.Printf("open error: %v", err)
+			continue
+		}
+		index = i
+	}
+	if index < 0 {
+		return nil
+	}
+	return g.load(index)
+}
+
+// restore restores the playlist and the current file's state from s.
+func (g *Game) restore(s *snapshot) error {
+	theInserts.restore(s.Inserts)
+	g.playlist = s.playlist()
+	// The snapshot is applied when the file is opened, which can be after it is decoded.
+	return g.loadThen(s.Index, s.apply)
+}
+
+// clearBadTagsIfNeeded removes the ignored loop tags from the file with C, and reopens it.
+func (g *Game) clearBadTagsIfNeeded() error {
+	if !inpututil.IsKeyJustPressed(ebiten.KeyC) || ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return nil
+	}
+	// Reloading throws away the edited loop, so reload only after the tags are written.
+	if g.musicPlayer == nil || len(g.musicPlayer.badTagKeys) == 0 || *flagReadOnly {
+		return nil
+	}
+	g.closeComparison()
+	if err := g.musicPlayer.ClearBadTags(); err != nil {
+		log.Printf("clearing tags error: %s, %v", g.musicPlayer.path, err)
+		return nil
+	}
+	return g.load(g.playlist.Index())
+}
+
+// reviewIfNeeded cycles the review status of the current file with R, and starts editing its notes with T.
+// Shift+T takes a note at the current moment.
+func (g *Game) reviewIfNeeded() {
+	if g.musicPlayer == nil {
+		return
+	}
+	path := g.playlist.Current()
+	// Ctrl+R renders the loops.
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) && !isControlPressed() && !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		r := g.playlist.Review(path)
+		r.Status = r.Status.next()
+		if err := g.playlist.SetReview(path, r); err != nil {
+			log.Printf("review error: %s, %v", path, err)
+		}
+	}
+	// Ctrl+T exports the stinger.
+	if inpututil.IsKeyJustPressed(ebiten.KeyT) && !isControlPressed() {
+		// Shift+T takes a note at the current moment at once, and the text can be written while listening.
+		if ebiten.IsKeyPressed(ebiten.KeyShift) {
+			i, err := g.playlist.TakeNote(path, g.musicPlayer.currentSample())
+			if err != nil {
+				log.Printf("review error: %s, %v", path, err)
+				return
+			}
+			g.editingNote = true
+			g.note = nil
+			g.momentIndex = i
+			return
+		}
+		g.editingNote = true
+		g.note = []rune(g.playlist.Review(path).Notes)
+		g.momentIndex = -1
+	}
+}
+
+// exportIssueIfNeeded writes a bug report of the current moment with B.
+func (g *Game) exportIssueIfNeeded() {
+	// Shift+B sets the point B of the A-B loop, and Ctrl+B bypasses the preview processing.
+	if g.musicPlayer == nil || !inpututil.IsKeyJustPressed(ebiten.KeyB) || ebiten.IsKeyPressed(ebiten.KeyShift) || isControlPressed() {
+		return
+	}
+	path, err := writeIssueReport(*flagIssues, g.musicPlayer, g.playlist.Review(g.playlist.Current()))
+	if err != nil {
+		log.Printf("issue export error: %s, %v", g.musicPlayer.path, err)
+		return
+	}
+	log.Printf("exported the bug report to %s", path)
+}
+
+// exportDAWMarkersIfNeeded exports the loop and the markers for DAWs with Ctrl+D.
+func (g *Game) exportDAWMarkersIfNeeded() {
+	if g.musicPlayer == nil || !isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyD) {
+		return
+	}
+	paths, err := writeDAWMarkers(*flagDAW, g.musicPlayer, g.playlist.Review(g.playlist.Current()).Moments)
+	if err != nil {
+		log.Printf("marker export error: %s, %v", g.musicPlayer.path, err)
+		return
+	}
+	log.Printf("exported the markers to %s", strings.Join(paths, ", "))
+}
+
+// copyStateIfNeeded copies the state of the current file as JSON to the clipboard with Y.
+// The state is written to stdout instead when the clipboard is not available.
+func (g *Game) copyStateIfNeeded() {
+	// Ctrl+Y copies the share token.
+	if g.musicPlayer == nil || !inpututil.IsKeyJustPressed(ebiten.KeyY) || isControlPressed() {
+		return
+	}
+	state, err := g.musicPlayer.stateJSON()
+	if err != nil {
+		log.Printf("state error: %s, %v", g.musicPlayer.path, err)
+		return
+	}
+	if err := copyToClipboard(state); err != nil {
+		log.Printf("clipboard error: %v", err)
+		fmt.Print(state)
+		return
+	}
+	log.Printf("copied the state of %s to the clipboard", g.musicPlayer.path)
+}
+
+// shareIfNeeded copies the share token of the current position to the clipboard with Ctrl+Y,
+// and jumps to the position of the token in the clipboard with Ctrl+V.
+func (g *Game) shareIfNeeded() error {
+	if !isControlPressed() {
+		return nil
+	}
+	if g.musicPlayer != nil && inpututil.IsKeyJustPressed(ebiten.KeyY) {
+		s := g.musicPlayer.ShareToken().String()
+		if err := copyToClipboard(s); err != nil {
+			log.Printf("clipboard error: %v", err)
+			fmt.Println(s)
+			return nil
+		}
+		log.Printf("copied %s to the clipboard", s)
+		return nil
+	}
+	if !inpututil.IsKeyJustPressed(ebiten.KeyV) {
+		return nil
+	}
+	s, index, err := readShareToken(g.playlist)
+	if err != nil {
+		log.Printf("share token error: %v", err)
+		return nil
+	}
+	if g.musicPlayer == nil || index != g.playlist.Index() {
+		if err := g.load(index); err != nil {
+			return err
+		}
+	}
+	if g.musicPlayer == nil {
+		return nil
+	}
+	if err := g.musicPlayer.JumpTo(s); err != nil {
+		log.Printf("seek error: %s, %v", g.musicPlayer.path, err)
+	}
+	return nil
+}
+
+// trimSilenceIfNeeded proposes trimming the silence with A, and writes the trimmed file with A again.
+func (g *Game) trimSilenceIfNeeded() {
+	p := g.musicPlayer
+	if p == nil {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) && p.trim != nil {
+		p.trim = nil
+		return
+	}
+	// Shift+A sets the point A of the A-B loop.
+	if !inpututil.IsKeyJustPressed(ebiten.KeyA) || ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return
+	}
+	if p.trim == nil {
+		if !p.ProposeTrim() {
+			log.Printf("no silence to trim: %s", p.path)
+		}
+		return
+	}
+	trim := p.TrimJob()
+	theJobs.Go("Trim "+filepath.Base(p.path), func(j *job) {
+		path, err := trim(j)
+		if j.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("trim error: %s, %v", p.path, err)
+			return
+		}
+		log.Printf("wrote the trimmed file to %s", path)
+	})
+}
+
+// bypassPreviewIfNeeded bypasses the preview processing with Ctrl+B, or stops bypassing it.
+func (g *Game) bypassPreviewIfNeeded() {
+	if !isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyB) {
+		return
+	}
+	thePreview.ToggleBypass()
+}
+
+// transposeIfNeeded transposes the preview a semitone up with Page Up and down with Page Down.
+// S switches between the transpose changing the tempo and the pitch shift keeping it.
+func (g *Game) transposeIfNeeded() {
+	if inpututil.IsKeyJustPressed(ebiten.KeyS) && !isControlPressed() && !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		theTranspose.ToggleKeepTempo()
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageUp) {
+		theTranspose.Add(1)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPageDown) {
+		theTranspose.Add(-1)
+	}
+}
+
+// midSideIfNeeded switches the playback to the mid only, the side only and back to the stereo with Shift+M.
+func (g *Game) midSideIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		return
+	}
+	theMidSide.Next()
+}
+
+// channelsIfNeeded solos the left channel with Shift+L, the right channel with Shift+R,
+// and downmixes the playback to mono with Shift+D. The same key again goes back to the stereo.
+func (g *Game) channelsIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || isControlPressed() {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyL) {
+		theChannels.Toggle(channelLeft)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		theChannels.Toggle(channelRight)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyD) {
+		theChannels.Toggle(channelMono)
+	}
+}
+
+// panIfNeeded pans the playback to the left with Shift+, (<) and to the right with Shift+. (>).
+func (g *Game) panIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyComma) {
+		thePan.Add(-1)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyPeriod) {
+		thePan.Add(1)
+	}
+}
+
+// limiterIfNeeded toggles the master limiter with Shift+C.
+func (g *Game) limiterIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || !inpututil.IsKeyJustPressed(ebiten.KeyC) {
+		return
+	}
+	theLimiter.Toggle()
+}
+
+// referenceIfNeeded switches between the current file and the reference track given by -reference with Shift+Tab.
+func (g *Game) referenceIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || !inpututil.IsKeyJustPressed(ebiten.KeyTab) {
+		return
+	}
+	if g.musicPlayer == nil || *flagRefTrack == "" {
+		return
+	}
+	if g.reference == nil {
+		r, err := newReferenceAB(g.audioContext, *flagRefTrack)
+		if err != nil {
+			log.Printf("reference error: %v", err)
+			return
+		}
+		g.reference = r
+	}
+	if g.reference.Active() {
+		g.musicPlayer = g.reference.Restore(g.musicPlayer)
+		return
+	}
+	// The encoded file is not compared with the reference.
+	g.closeComparison()
+	g.musicPlayer = g.reference.Toggle(g.musicPlayer)
+}
+
+// fadeIfNeeded fades out and stops the playback with Shift+S.
+func (g *Game) fadeIfNeeded() {
+	if g.musicPlayer == nil || !ebiten.IsKeyPressed(ebiten.KeyShift) || isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyS) {
+		return
+	}
+	g.musicPlayer.FadeOutAndStop()
+}
+
+// waveformModeIfNeeded switches the waveform between the sum, L/R and mid/side with Shift+W.
+func (g *Game) waveformModeIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || !inpututil.IsKeyJustPressed(ebiten.KeyW) {
+		return
+	}
+	theWaveform = theWaveform.next()
+}
+
+// timeDisplayIfNeeded switches how the times are shown with Shift+X.
+func (g *Game) timeDisplayIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || !inpututil.IsKeyJustPressed(ebiten.KeyX) {
+		return
+	}
+	theTimeDisplay = theTimeDisplay.next()
+}
+
+// snapIfNeeded switches where the loop markers snap to with Shift+Z.
+func (g *Game) snapIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || !inpututil.IsKeyJustPressed(ebiten.KeyZ) {
+		return
+	}
+	theSnap = theSnap.next()
+}
+
+// loopConflictIfNeeded asks which loop to use when the sources of the loop disagree. 1-4 choose a loop and Esc keeps the current one.
+// loopConflictIfNeeded reports whether it is asking, when the other keys are disabled.
+func (g *Game) loopConflictIfNeeded() bool {
+	p := g.musicPlayer
+	if p == nil || p.loopConflict == nil {
+		return false
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		p.DismissLoopConflict()
+		return true
+	}
+	for i := range p.loopConflict {
+		if !inpututil.IsKeyJustPressed(ebiten.KeyDigit1 + ebiten.Key(i)) {
+			continue
+		}
+		if err := p.ResolveLoopConflict(i); err != nil {
+			log.Printf("loop error: %s, %v", p.path, err)
+		}
+		return true
+	}
+	return true
+}
+
+// beatGridIfNeeded shows or hides the beat grid over the waveform with Shift+G.
+func (g *Game) beatGridIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || !inpututil.IsKeyJustPressed(ebiten.KeyG) {
+		return
+	}
+	theGridShown = !theGridShown
+}
+
+// monitorIfNeeded toggles the input monitor with Shift+I.
+func (g *Game) monitorIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) || !inpututil.IsKeyJustPressed(ebiten.KeyI) {
+		return
+	}
+	m, err := toggleInputMonitor(g.audioContext, g.monitor)
+	g.monitor = m
+	if err != nil {
+		log.Printf("input monitor error: %v", err)
+	}
+}
+
+// stretchIfNeeded slows down the preview keeping the pitch with Shift+[ and speeds it up with Shift+].
+func (g *Game) stretchIfNeeded() {
+	if !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketLeft) {
+		theStretch.Add(-1)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketRight) {
+		theStretch.Add(1)
+	}
+}
+
+// renderLoopsIfNeeded writes the intro and the loop iterations to a file with Ctrl+R.
+func (g *Game) renderLoopsIfNeeded() {
+	p := g.musicPlayer
+	if p == nil || !isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyR) {
+		return
+	}
+	render := p.RenderJob()
+	theJobs.Go("Render "+filepath.Base(p.path), func(j *job) {
+		path, err := render(j)
+		if j.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("render error: %s, %v", p.path, err)
+			return
+		}
+		log.Printf("rendered the loops to %s", path)
+	})
+}
+
+// exportStingerIfNeeded writes the current file trimmed tightly to its sound with Ctrl+T, for stingers and jingles.
+func (g *Game) exportStingerIfNeeded() {
+	p := g.musicPlayer
+	if p == nil || !isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyT) {
+		return
+	}
+	export, err := p.StingerJob()
+	if err != nil {
+		log.Printf("stinger error: %s, %v", p.path, err)
+		return
+	}
+	theJobs.Go("Stinger "+filepath.Base(p.path), func(j *job) {
+		path, err := export(j)
+		if j.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("stinger error: %s, %v", p.path, err)
+			return
+		}
+		log.Printf("exported the stinger to %s", path)
+	})
+}
+
+// encodeIfNeeded encodes the current file with the next preset with V, and starts comparing it with the source.
+// Tab switches between the source and the encoded file.
+func (g *Game) encodeIfNeeded() {
+	select {
+	case r := <-g.encodedCh:
+		g.compareEncoded(r)
+	default:
+	}
+
+	if g.comparison != nil && inpututil.IsKeyJustPressed(ebiten.KeyTab) && !ebiten.IsKeyPressed(ebiten.KeyShift) {
+		g.comparison.Toggle()
+		g.musicPlayer = g.comparison.active()
+	}
+	if g.comparison != nil && inpututil.IsKeyJustPressed(ebiten.KeyU) {
+		if err := g.comparison.CompensateDelay(); err != nil {
+			log.Printf("compensation error: %s, %v", g.comparison.result.path, err)
+		}
+		g.musicPlayer = g.comparison.active()
+	}
+
+	if g.musicPlayer == nil || !inpututil.IsKeyJustPressed(ebiten.KeyV) || isControlPressed() {
+		return
+	}
+	g.closeComparison()
+	preset := encoderPresets[g.presetIndex]
+	g.presetIndex = (g.presetIndex + 1) % len(encoderPresets)
+	encode := g.musicPlayer.EncodeJob(preset)
+	theJobs.Go(fmt.Sprintf("Encode %s with %s", filepath.Base(g.musicPlayer.path), preset.name), func(j *job) {
+		r := encode(j)
+		if j.Err() != nil {
+			return
+		}
+		g.encodedCh <- r
+	})
+}
+
+// compareEncoded starts comparing the current file with the encoded one.
+func (g *Game) compareEncoded(r encodeResult) {
+	if r.err != nil {
+		log.Printf("encode error: %s, %v", r.source, r.err)
+		return
+	}
+	log.Printf("wrote the encoded file to %s", r.path)
+	for _, w := range r.warnings {
+		log.Printf("encode warning: %s, %s", r.path, w)
+	}
+	if g.musicPlayer == nil || g.musicPlayer.path != r.source {
+		return
+	}
+	g.closeComparison()
+	c, err := newABComparison(g.musicPlayer, r)
+	if err != nil {
+		log.Printf("open error: %s, %v", r.path, err)
+		return
+	}
+	g.comparison = c
+}
+
+// closeComparison stops comparing, and makes the source the current player.
+// The auditioned reference track is switched back to the file as well.
+func (g *Game) closeComparison() {
+	g.musicPlayer = g.reference.Restore(g.musicPlayer)
+	if g.comparison == nil {
+		return
+	}
+	g.musicPlayer = g.comparison.Close()
+	g.comparison = nil
+}
+
+// editNote edits the notes with the typed characters. Enter saves the notes and Escape cancels the edit.
+// A note taken at a moment is already saved, and Escape leaves it without text.
+func (g *Game) editNote() {
+	g.note = ebiten.AppendInputChars(g.note)
+	if inpututil.IsKeyJustPressed(ebiten.KeyBackspace) && len(g.note) > 0 {
+		g.note = g.note[:len(g.note)-1]
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.editingNote = false
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEnter) {
+		g.editingNote = false
+		path := g.playlist.Current()
+		text := strings.TrimSpace(string(g.note))
+		if g.momentIndex >= 0 {
+			if err := g.playlist.SetNoteText(path, g.momentIndex, text); err != nil {
+				log.Printf("review error: %s, %v", path, err)
+			}
+			return
+		}
+		r := g.playlist.Review(path)
+		r.Notes = text
+		if err := g.playlist.SetReview(path, r); err != nil {
+			log.Printf("review error: %s, %v", path, err)
+		}
+	}
+}
+
+// reloadIfNeeded reopens the file when it is rewritten, e.g. re-exported by a DAW.
+func (g *Game) reloadIfNeeded() {
+	// The comparison would be broken by replacing the player.
+	if g.musicPlayer == nil || g.comparison != nil || g.reference.Active() || !g.musicPlayer.FileChanged() {
+		return
+	}
+	m, err := g.musicPlayer.Reload()
+	if err != nil {
+		log.Printf("reload error: %s, %v", g.musicPlayer.path, err)
+		return
+	}
+	g.musicPlayer = m
+}
+
+func (g *Game) exportPlaylistIfNeeded() {
+	if !inpututil.IsKeyJustPressed(ebiten.KeyW) || ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return
+	}
+	if g.playlist.Len() == 0 {
+		return
+	}
+
+	// Copy the playlist as the dialog runs on another goroutine.
+	playlist := g.playlist.clone()
+	go func() {
+		filename, err := dialog.File().Filter("M3U playlist", "m3u8", "m3u").Filter("Project", projectExt[1:]).Title("Export playlist").Save()
+		if err != nil {
+			if err != dialog.Cancelled {
+				log.Printf("dialog error: %v", err)
+			}
+			return
+		}
+		if filepath.Ext(filename) == "" {
+			filename += ".m3u8"
+		}
+		if err := playlist.Write(filename); err != nil {
+			log.Printf("playlist export error: %s, %v", filename, err)
+		}
+	}()
+}
+
+// isControlPressed reports whether Control, or Command on macOS, is pressed.
+func isControlPressed() bool {
+	return ebiten.IsKeyPressed(ebiten.KeyControl) || ebiten.IsKeyPressed(ebiten.KeyMeta)
+}
+
+// openEditorIfNeeded opens the current file in the external editor with Ctrl+E.
+func (g *Game) openEditorIfNeeded() {
+	if g.musicPlayer == nil || !isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyE) {
+		return
+	}
+	if err := openInEditor(g.musicPlayer.path); err != nil {
+		log.Printf("editor error: %s, %v", g.musicPlayer.path, err)
+	}
+}
+
+// saveLoopIfNeeded writes the current loop to the file's loop tags with Ctrl+S.
+func (g *Game) saveLoopIfNeeded() {
+	if g.musicPlayer == nil || !isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyS) {
+		return
+	}
+	// The comparison would be broken by replacing the player.
+	g.closeComparison()
+	p := g.musicPlayer
+	m, err := p.SaveLoop()
+	if err != nil {
+		log.Printf("saving loop error: %s, %v", p.path, err)
+		return
+	}
+	g.playlist.loopSaved(p.path, p.introSample, p.loopSample)
+	g.musicPlayer = m
+}
+
+// openNewWindowIfNeeded asks for a file with Ctrl+N and opens it in a new window.
+func (g *Game) openNewWindowIfNeeded() {
+	if !isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyN) {
+		return
+	}
+	dir := theSettings.LastDir
+	go func() {
+		filename, err := dialog.File().SetStartDir(dir).Filter("Audio file", "ogg", "opus", "wav", "mp3", "flac").Title("Open in a new window").Load()
+		if err != nil {
+			if err != dialog.Cancelled {
+				log.Printf("dialog error: %v", err)
+			}
+			return
+		}
+		if err := openNewWindow(filename); err != nil {
+			log.Printf("window error: %s, %v", filename, err)
+		}
+	}()
+}
+
+// renameIfNeeded renames or moves the current file with its sidecars with M.
+func (g *Game) renameIfNeeded() {
+	select {
+	case path := <-g.renameCh:
+		g.renameCh = nil
+		if g.musicPlayer == nil || path == "" {
+			return
+		}
+		g.closeComparison()
+		old := g.musicPlayer.path
+		m, err := renamePlayer(g.playlist, g.musicPlayer, path)
+		if err != nil {
+			log.Printf("rename error: %s, %v", old, err)
+		}
+		g.musicPlayer = m
+		return
+	default:
+	}
+
+	// Ctrl+M switches the mini mode.
+	if g.musicPlayer == nil || g.renameCh != nil || !inpututil.IsKeyJustPressed(ebiten.KeyM) || isControlPressed() || ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return
+	}
+	if err := checkWritable(); err != nil {
+		log.Printf("rename error: %s, %v", g.musicPlayer.path, err)
+		return
+	}
+	ch := make(chan string, 1)
+	g.renameCh = ch
+	path := g.musicPlayer.path
+	go func() {
+		filename, err := dialog.File().Title("Rename or move").SetStartDir(filepath.Dir(path)).SetStartFile(filepath.Base(path)).Save()
+		if err != nil && err != dialog.Cancelled {
+			log.Printf("dialog error: %v", err)
+		}
+		ch <- filename
+	}()
+}
+
+func (g *Game) Draw(screen *ebiten.Image) {
+	defer g.perf.recordDraw(time.Now())
+
+	if g.mini {
+		g.drawMini(screen)
+		return
+	}
+	if g.presenting {
+		g.drawPresentation(screen)
+		return
+	}
+	if g.showRecent {
+		ebitenutil.DebugPrint(screen, g.recentMessage())
+		return
+	}
+	if g.helpPage > 0 {
+		ebitenutil.DebugPrint(screen, g.helpMessage())
+		return
+	}
+	if g.musicPlayer != nil && g.musicPlayer.loopConflict != nil {
+		var msg string
+		for _, l := range append(g.musicPlayer.loopConflictLines(), "Esc: keep the current loop") {
+			msg += wrapText(l, screenWidth/debugCharWidth) + "\n"
+		}
+		ebitenutil.DebugPrint(screen, msg)
+		return
+	}
+	if g.musicPlayer == nil && g.loading != nil {
+		ebitenutil.DebugPrint(screen, fmt.Sprintf("Decoding %s...", filepath.Base(g.loading.decode.path)))
+		return
+	}
+	if g.musicPlayer == nil {
+		msg := fmt.Sprintf("Press %s to load an ogg file", theSettings.Keys.label(actionOpen))
+		if g.watcher != nil {
+			msg += fmt.Sprintf("\nWatching %s for exported files", g.watcher.dir)
+		}
+		if len(theSettings.Recent) > 0 {
+			msg += "\nPress Ctrl+O for the recent files"
+		}
+		if g.playlist.Len() > 1 {
+			msg += fmt.Sprintf("\nPress %s/%s to move in the playlist", theSettings.Keys.label(actionNext), theSettings.Keys.label(actionPrev))
+		}
+		if g.loadErr != nil {
+			msg += "\n\n" + wrapText(g.loadErr.Error(), screenWidth/debugCharWidth)
+		}
+		ebitenutil.DebugPrint(screen, msg)
+		return
+	}
+	// Draw all the shapes with one draw call before the texts over them.
+	g.shapes.begin(screen)
+	g.musicPlayer.draw(screen, &g.shapes)
+	g.drawMoments(&g.shapes)
+	// The meters are at the right edge under the seam bands.
+	g.meters.draw(&g.shapes, screenWidth-2*meterWidth-5, 48+meterHeight, meterWidth, meterHeight)
+	g.shapes.Flush()
+	if g.showPlaylist {
+		ebitenutil.DebugPrint(screen, g.playlistMessage())
+		g.shapes.begin(screen)
+		g.drawThumbnails(&g.shapes)
+		g.shapes.Flush()
+	} else {
+		ebitenutil.DebugPrint(screen, g.musicPlayer.message())
+	}
+	g.drawReview(screen)
+	g.perf.draw(screen)
+
+	var lines []string
+	// The badge is always shown not to judge the sound through forgotten preview processing.
+	if b := thePreview.badge(); b != "" {
+		lines = append(lines, b)
+	}
+	if g.monitor != nil {
+		lines = append(lines, g.monitor.line())
+	}
+	if g.reference != nil {
+		lines = append(lines, g.reference.line(g.musicPlayer))
+	}
+	if g.comparison != nil {
+		lines = append(lines, g.comparison.lines()...)
+	}
+	if jobs := theJobs.jobLines(); len(jobs) > 0 {
+		lines = append(lines, jobs...)
+		lines = append(lines, "Press Delete to cancel the last job")
+	}
+	if len(lines) > 0 {
+		for i := range lines {
+			lines[i] = wrapText(lines[i], screenWidth/debugCharWidth)
+		}
+		msg := strings.Join(lines, "\n")
+		ebitenutil.DebugPrintAt(screen, msg, 0, screenHeight-14-debugLineHeight*(strings.Count(msg, "\n")+1))
+	}
+}
+
+// drawMoments draws the notes taken at moments of the current file under the bar.
+func (g *Game) drawMoments(b *shapeBatch) {
+	p := g.musicPlayer
+	if p == nil {
+		return
+	}
+	_, y, _, h := playerBarRect()
+	for _, n := range g.playlist.Review(g.playlist.Current()).Moments {
+		if nx, ok := p.timelineX(n.Sample); ok {
+			b.Rect(float64(nx), float64(y+h), 1, 4, momentColor)
+		}
+	}
+}
+
+// drawReview draws the review of the current file below the bar.
+func (g *Game) drawReview(screen *ebiten.Image) {
+	r := g.playlist.Review(g.playlist.Current())
+	line := fmt.Sprintf("R: %s  T: ", r.Status)
+	if g.editingNote && g.momentIndex >= 0 && g.momentIndex < len(r.Moments) {
+		t := formatTimeMillis(samplesToDuration(r.Moments[g.momentIndex].Sample))
+		line = fmt.Sprintf("Note at %s (Enter: save, Esc: no text): %s_", t, string(g.note))
+	} else if g.editingNote {
+		line = "Note (Enter: save, Esc: cancel): " + string(g.note) + "_"
+	} else if r.Notes != "" {
+		line += r.Notes
+	} else {
+		line += "Add a note"
+	}
+	if runes, n := []rune(line), screenWidth/debugCharWidth; len(runes) > n {
+		if g.editingNote {
+			// Show the end, where the note is being typed.
+			line = string(runes[len(runes)-n:])
+		} else {
+			line = string(runes[:n])
+		}
+	}
+	ebitenutil.DebugPrintAt(screen, line, 0, screenHeight-14)
+}
+
+func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
+	if g.mini {
+		return screenWidth, miniScreenHeight
+	}
+	return screenWidth, screenHeight
+}
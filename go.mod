@@ -4,14 +4,16 @@ go 1.18
 
 require (
 	github.com/hajimehoshi/ebiten/v2 v2.6.2
-	github.com/hajimehoshi/oggloop v0.0.0-20180730010327-c7cf68761483
 	github.com/sqweek/dialog v0.0.0-20220809060634-e981b270ebbf
+	golang.org/x/term v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/TheTitanrain/w32 v0.0.0-20180517000239-4f5cfb03fabf // indirect
 	github.com/ebitengine/oto/v3 v3.1.0 // indirect
 	github.com/ebitengine/purego v0.5.0 // indirect
+	github.com/hajimehoshi/go-mp3 v0.3.4 // indirect
 	github.com/jezek/xgb v1.1.0 // indirect
 	github.com/jfreymuth/oggvorbis v1.0.5 // indirect
 	github.com/jfreymuth/vorbis v1.0.2 // indirect
@@ -0,0 +1,100 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+// audioGraph is the audio graph of the playback: the decoded source, the loop, the preview stages and the gain.
+// The player builds it for ebiten's audio player in real time, and the same graph is rendered offline for the exports and the tests,
+// so that they read exactly the samples the player plays. The graph has no clock, so the offline rendering is deterministic.
+type audioGraph struct {
+	// introSample and loopSample are the loop the source wraps in.
+	introSample int64
+	loopSample  int64
+
+	// stopAtLoopEnd reports whether the output ends at the loop end instead of wrapping to the loop start.
+	stopAtLoopEnd bool
+
+	// chain is the preview stages processing the loop, or nil for none.
+	chain *previewChain
+
+	// gainDB is the gain of the output in dB. The player leaves it at 0 and applies its volume at mixing instead,
+	// so that a volume change is heard at once rather than after the buffered audio.
+	gainDB float64
+}
+
+// build builds the graph reading src from its current position.
+// loopEnd is the node ending the output at the loop end when stopAtLoopEnd is set, which is seeked to start another pass.
+func (g audioGraph) build(src io.ReadSeeker) (out io.ReadSeeker, loopEnd *loopEndStop) {
+	out = audio.NewInfiniteLoopWithIntro(src, g.introSample*bytesPerSample, g.loopSample*bytesPerSample)
+	if g.stopAtLoopEnd {
+		loopEnd = newLoopEndStop(out, g.introSample*bytesPerSample, g.loopSample*bytesPerSample)
+		out = loopEnd
+	}
+	if g.chain != nil {
+		out = newPreviewStream(newRateStream(newStretchStream(out, g.chain), g.chain), g.chain)
+	}
+	if g.gainDB != 0 {
+		out = &gainStream{src: out, gain: math.Pow(10, g.gainDB/20)}
+	}
+	return out, loopEnd
+}
+
+// render renders the first frames frames of the graph reading pcm offline.
+// The output is shorter when the graph ends before, e.g. at the loop end.
+func (g audioGraph) render(pcm []int16, frames int64) ([]int16, error) {
+	src := make([]byte, len(pcm)*2)
+	for i, v := range pcm {
+		binary.LittleEndian.PutUint16(src[2*i:], uint16(v))
+	}
+	s, _ := g.build(bytes.NewReader(src))
+
+	buf := make([]byte, frames*bytesPerSample)
+	n, err := io.ReadFull(s, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	out := make([]int16, n/bytesPerSample*2)
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(buf[2*i:]))
+	}
+	return out, nil
+}
+
+// gainStream is a stream amplified by a linear gain, clipped to the 16-bit range.
+type gainStream struct {
+	src  io.ReadSeeker
+	gain float64
+}
+
+func (s *gainStream) Read(buf []byte) (int, error) {
+	n, err := s.src.Read(buf)
+	for i := 0; i+1 < n; i += 2 {
+		v := clampSample(float64(int16(binary.LittleEndian.Uint16(buf[i:]))) * s.gain)
+		binary.LittleEndian.PutUint16(buf[i:], uint16(v))
+	}
+	return n, err
+}
+
+func (s *gainStream) Seek(offset int64, whence int) (int64, error) {
+	return s.src.Seek(offset, whence)
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+)
+
+// headlessFile is a file opened without an audio context, for the modes running without a window.
+type headlessFile struct {
+	path string
+	info *fileInfo
+	tags *loopTags
+
+	// totalSample is the length of the file, and streamSample is the decoder's length, which can be an estimate.
+	totalSample  int64
+	streamSample int64
+}
+
+// openHeadless reads the format, the loop and the length of the file at path as newPlayer does.
+// The loop is in the samples of the decoded stream at sampleRate.
+func openHeadless(path string) (*headlessFile, error) {
+	info, err := readFileInfoAt(path)
+	if err != nil {
+		return nil, err
+	}
+	tags := parseLoopTags(info.comments, theTagMode)
+	tags.removePreSkip(info.preSkip)
+	tags.toStreamRate(info)
+
+	f, err := openAudioFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s, err := decodeStream(f, info)
+	if err != nil {
+		return nil, err
+	}
+	// As in newPlayer, the granule position is preferred to the decoder's estimate.
+	streamSample := s.Length() / bytesPerSample
+	totalSample := streamSample
+	if n, ok := info.exactLength(); ok && n < totalSample {
+		totalSample = n
+	}
+	tags.validateRange(totalSample)
+	if err := tags.err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &headlessFile{
+		path:         path,
+		info:         info,
+		tags:         tags,
+		totalSample:  totalSample,
+		streamSample: streamSample,
+	}, nil
+}
+
+// analyze analyzes the decoded PCM of the file.
+func (h *headlessFile) analyze() (*analysis, error) {
+	return analyze(h.path, h.streamSample, nil)
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// helpLines returns the lines of the key help, each of which fits in the width of the screen.
+func helpLines() []string {
+	keys := theSettings.Keys
+	lines := []string{
+		fmt.Sprintf("%s: play/pause, %s/%s: volume", keys.label(actionPlay), keys.label(actionVolumeDown), keys.label(actionVolumeUp)),
+	}
+	if theTransport == transportJKL {
+		lines = append(lines, "J/K/L: reverse/stop/forward")
+	}
+	lines = append(lines,
+		fmt.Sprintf("%s/%s: next/previous file, %s: playlist", keys.label(actionNext), keys.label(actionPrev), playlistPaneKey()),
+		"W: export the playlist, B: report a bug here",
+		"V: encode and compare, Shift+Tab: reference",
+		fmt.Sprintf("%s/%s: set the loop start/end here", keys.label(actionLoopStart), keys.label(actionLoopEnd)),
+		fmt.Sprintf("%s: hear the seam, Shift+%s: the intro's end", keys.label(actionSeam), keys.label(actionSeam)),
+		fmt.Sprintf("%s/%s: halve/double the loop, %s/%s: shift it", keys.label(actionHalveLoop), keys.label(actionDoubleLoop), keys.label(actionShiftBack), keys.label(actionShiftFwd)),
+		"Ctrl+Y/Ctrl+V: copy/paste the position",
+		"Shift+I: monitor the input",
+		"Shift+[/Shift+]: slower/faster keeping the pitch",
+		"PgUp/PgDn: transpose, S: keep the tempo",
+		"Shift+M: mid/side, Shift+D: mono, </>: pan",
+		"Shift+L/Shift+R: solo the left/right channel",
+		"Shift+S: fade out and stop",
+		fmt.Sprintf("Shift+W: switch the waveform (%s)", theWaveform.label()),
+		"Shift+C: master limiter, Shift+Z: snap",
+		fmt.Sprintf("Shift+X: switch the time display (%s)", theTimeDisplay.label()),
+		"F11: present, Shift+F11: the next monitor",
+	)
+	for i, l := range lines {
+		lines[i] = wrapText(l, screenWidth/debugCharWidth)
+	}
+	return lines
+}
+
+// helpLinesPerPage is the number of the lines of the key help on a page, leaving a line for the footer.
+const helpLinesPerPage = screenHeight/debugLineHeight - 1
+
+// helpPages returns the number of the pages of the key help.
+func helpPages() int {
+	return (len(helpLines()) + helpLinesPerPage - 1) / helpLinesPerPage
+}
+
+// helpIfNeeded shows the pages of the key help in turn with F1, and closes it after the last page or with Escape.
+// helpIfNeeded reports whether the help is shown, when the other keys are disabled.
+func (g *Game) helpIfNeeded() bool {
+	if inpututil.IsKeyJustPressed(ebiten.KeyF1) {
+		g.helpPage++
+		if g.helpPage > helpPages() {
+			g.helpPage = 0
+		}
+		return true
+	}
+	if g.helpPage == 0 {
+		return false
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.helpPage = 0
+	}
+	return true
+}
+
+// helpMessage returns the current page of the key help.
+func (g *Game) helpMessage() string {
+	lines := helpLines()
+	from := (g.helpPage - 1) * helpLinesPerPage
+	to := from + helpLinesPerPage
+	if to > len(lines) {
+		to = len(lines)
+	}
+	footer := fmt.Sprintf("(%d/%d) F1: next page, Esc: close", g.helpPage, helpPages())
+	if g.helpPage == helpPages() {
+		footer = fmt.Sprintf("(%d/%d) F1 or Esc: close", g.helpPage, helpPages())
+	}
+	return strings.Join(append(lines[from:to], footer), "\n")
+}
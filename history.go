@@ -0,0 +1,75 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var historyHeader = []string{"opened_at", "file", "played_seconds", "seam_auditioned", "seam_plays"}
+
+// listeningHistory appends a CSV row per played file so that the review status of a soundtrack can be tracked.
+//
+// A nil *listeningHistory is valid and records nothing.
+type listeningHistory struct {
+	path string
+}
+
+func newListeningHistory(path string) *listeningHistory {
+	if path == "" {
+		return nil
+	}
+	return &listeningHistory{
+		path: path,
+	}
+}
+
+// Record appends the listening session of p. Record should be called just before p is closed.
+func (h *listeningHistory) Record(p *Player) error {
+	if h == nil || p == nil {
+		return nil
+	}
+
+	_, err := os.Stat(h.path)
+	newFile := os.IsNotExist(err)
+
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	if newFile {
+		if err := w.Write(historyHeader); err != nil {
+			return err
+		}
+	}
+	if err := w.Write([]string{
+		p.openedAt.Format(time.RFC3339),
+		p.path,
+		fmt.Sprintf("%.1f", p.played.Seconds()),
+		strconv.FormatBool(p.seamPlays > 0),
+		strconv.Itoa(p.seamPlays),
+	}); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
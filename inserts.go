@@ -0,0 +1,237 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// limiterRelease is the time constant in seconds the limiter's gain recovers in.
+const limiterRelease = 0.05
+
+// insertEffect is an effect node inserted in the audio graph, processing a stereo frame in the full scale of 16-bit PCM.
+type insertEffect interface {
+	name() string
+	process(l, r float64) (float64, float64)
+}
+
+// gainEffect amplifies by a gain in dB.
+type gainEffect struct {
+	db   float64
+	gain float64
+}
+
+func (e *gainEffect) name() string {
+	return fmt.Sprintf("Gain %+.1f dB", e.db)
+}
+
+func (e *gainEffect) process(l, r float64) (float64, float64) {
+	return l * e.gain, r * e.gain
+}
+
+// filterEffect is a low-pass or a high-pass filter of 12 dB per octave at a cutoff frequency.
+// The coefficients are from Robert Bristow-Johnson's Audio EQ Cookbook.
+type filterEffect struct {
+	highPass bool
+	cutoff   float64
+	filters  [2]*biquad
+}
+
+func newFilterEffect(highPass bool, cutoff float64) *filterEffect {
+	const q = math.Sqrt2 / 2
+	w := 2 * math.Pi * cutoff / sampleRate
+	cos, alpha := math.Cos(w), math.Sin(w)/(2*q)
+	a0 := 1 + alpha
+	e := &filterEffect{highPass: highPass, cutoff: cutoff}
+	for ch := range e.filters {
+		f := &biquad{a1: -2 * cos / a0, a2: (1 - alpha) / a0}
+		if highPass {
+			f.b0, f.b1, f.b2 = (1+cos)/2/a0, -(1+cos)/a0, (1+cos)/2/a0
+		} else {
+			f.b0, f.b1, f.b2 = (1-cos)/2/a0, (1-cos)/a0, (1-cos)/2/a0
+		}
+		e.filters[ch] = f
+	}
+	return e
+}
+
+func (e *filterEffect) name() string {
+	if e.highPass {
+		return fmt.Sprintf("HP %.0f Hz", e.cutoff)
+	}
+	return fmt.Sprintf("LP %.0f Hz", e.cutoff)
+}
+
+func (e *filterEffect) process(l, r float64) (float64, float64) {
+	return e.filters[0].process(l), e.filters[1].process(r)
+}
+
+// limiterEffect is a brickwall limiter at a ceiling in dBFS. The gain drops at once to keep a frame under the ceiling,
+// and recovers in limiterRelease. Both channels share the gain not to move the stereo image.
+type limiterEffect struct {
+	db      float64
+	ceiling float64
+	gain    float64
+}
+
+func newLimiterEffect(db float64) *limiterEffect {
+	return &limiterEffect{
+		db:      db,
+		ceiling: 32767 * math.Pow(10, db/20),
+		gain:    1,
+	}
+}
+
+func (e *limiterEffect) name() string {
+	return fmt.Sprintf("Limiter %.1f dB", e.db)
+}
+
+func (e *limiterEffect) process(l, r float64) (float64, float64) {
+	e.gain += (1 - e.gain) * (1 - math.Exp(-1/(limiterRelease*sampleRate)))
+	if peak := math.Max(math.Abs(l), math.Abs(r)) * e.gain; peak > e.ceiling {
+		e.gain *= e.ceiling / peak
+	}
+	return l * e.gain, r * e.gain
+}
+
+// parseInsertEffect parses an effect as kind:value: gain:DB, lowpass:HZ, highpass:HZ or limiter:DB.
+func parseInsertEffect(str string) (insertEffect, error) {
+	kind, value, ok := strings.Cut(strings.TrimSpace(str), ":")
+	if !ok {
+		return nil, fmt.Errorf("insert must be kind:value but was %q", str)
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("insert %q: %w", str, err)
+	}
+	switch kind {
+	case "gain":
+		return &gainEffect{db: v, gain: math.Pow(10, v/20)}, nil
+	case "lowpass", "highpass":
+		if v <= 0 || v >= sampleRate/2 {
+			return nil, fmt.Errorf("insert %q: the cutoff must be between 0 and %d Hz", str, sampleRate/2)
+		}
+		return newFilterEffect(kind == "highpass", v), nil
+	case "limiter":
+		if v > 0 {
+			return nil, fmt.Errorf("insert %q: the ceiling must be 0 dB or lower", str)
+		}
+		return newLimiterEffect(v), nil
+	}
+	return nil, fmt.Errorf("insert kind must be gain, lowpass, highpass or limiter but was %q", kind)
+}
+
+// insertChain is the chain of the effects inserted in the playback for the session, e.g. to approximate the game's mixer bus.
+// The effects are given as a comma-separated list like gain:-6,highpass:80,limiter:-1 and processed in order.
+// insertChain is a preview stage, so it is in the badge and bypassed with the others.
+type insertChain struct {
+	spec    string
+	effects []insertEffect
+
+	// given reports whether the effects are given with -inserts, which override the ones of a restored session.
+	given bool
+
+	m sync.Mutex
+}
+
+// parse replaces the effects with the ones in spec. An empty spec removes all of them.
+func (c *insertChain) parse(spec string) error {
+	var effects []insertEffect
+	for _, s := range strings.Split(spec, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		e, err := parseInsertEffect(s)
+		if err != nil {
+			return err
+		}
+		effects = append(effects, e)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.spec = spec
+	c.effects = effects
+	return nil
+}
+
+// String implements flag.Value.
+func (c *insertChain) String() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.spec
+}
+
+// Set implements flag.Value.
+func (c *insertChain) Set(spec string) error {
+	if err := c.parse(spec); err != nil {
+		return err
+	}
+	c.given = true
+	return nil
+}
+
+// restore restores the effects of a session unless they are given with -inserts.
+func (c *insertChain) restore(spec string) {
+	if c.given {
+		return
+	}
+	if err := c.parse(spec); err != nil {
+		log.Printf("inserts error: %v", err)
+	}
+}
+
+func (c *insertChain) Name() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	names := make([]string, len(c.effects))
+	for i, e := range c.effects {
+		names[i] = e.name()
+	}
+	return "Inserts " + strings.Join(names, " > ")
+}
+
+func (c *insertChain) Active() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.effects) > 0
+}
+
+func (c *insertChain) Process(pcm []int16) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for i := 0; i+1 < len(pcm); i += 2 {
+		l, r := float64(pcm[i]), float64(pcm[i+1])
+		for _, e := range c.effects {
+			l, r = e.process(l, r)
+		}
+		pcm[i], pcm[i+1] = clampSample(l), clampSample(r)
+	}
+}
+
+// clampSample converts v to a 16-bit sample, clipping it to the range.
+func clampSample(v float64) int16 {
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	}
+	if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	issueImageWidth  = 600
+	issueImageHeight = 120
+)
+
+var (
+	issueBackgroundColor = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	issueWaveformColor   = color.RGBA{0x60, 0xc0, 0x60, 0xff}
+	issueLoopColor       = color.RGBA{0xff, 0xff, 0x80, 0xff}
+	issueMomentColor     = color.RGBA{0xff, 0x40, 0x40, 0xff}
+	issueSelectionColor  = color.RGBA{0x30, 0x40, 0x70, 0xff}
+)
+
+// formatTimeMillis formats d as mm:ss.mmm, which is precise enough to find the problem in a DAW.
+func formatTimeMillis(d time.Duration) string {
+	return fmt.Sprintf("%s.%03d", formatTime(d), (d/time.Millisecond)%1000)
+}
+
+// writeIssueReport writes a Markdown bug report of the current moment of p to dir, ready to paste into a bug tracker.
+// The waveform PNG is written next to the report when the file is analyzed.
+// writeIssueReport returns the path of the report.
+func writeIssueReport(dir string, p *Player, review trackReview) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	sample := p.currentSample()
+	base := strings.TrimSuffix(filepath.Base(p.path), filepath.Ext(p.path))
+	now := samplesToDuration(sample)
+	name := fmt.Sprintf("%s_%02dm%02ds%03d", base, int(now/time.Minute), int(now/time.Second)%60, int(now/time.Millisecond)%1000)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "### %s at %s\n\n", filepath.Base(p.path), formatTimeMillis(now))
+	fmt.Fprintf(&b, "- File: `%s`\n", filepath.ToSlash(p.path))
+	fmt.Fprintf(&b, "- Time: %s (sample %d)\n", formatTimeMillis(now), p.fileSample(sample))
+	if p.HasSelection() {
+		fmt.Fprintf(&b, "- Selection: %s - %s (samples %d - %d)\n",
+			formatTimeMillis(samplesToDuration(p.selStart)), formatTimeMillis(samplesToDuration(p.selEnd)), p.fileSample(p.selStart), p.fileSample(p.selEnd))
+	}
+	fmt.Fprintf(&b, "- Loop start: %s (sample %d)\n", formatTimeMillis(samplesToDuration(p.introSample)), p.fileSample(p.introSample))
+	fmt.Fprintf(&b, "- Loop end: %s (sample %d)\n", formatTimeMillis(samplesToDuration(p.introSample+p.loopSample)), p.fileSample(p.introSample+p.loopSample))
+	fmt.Fprintf(&b, "- Loop length: %s (%d samples)\n", formatTimeMillis(samplesToDuration(p.loopSample)), p.fileLoopLength())
+	for _, l := range p.loopProvenance() {
+		fmt.Fprintf(&b, "- %s\n", l)
+	}
+	if p.loopSource != "" {
+		fmt.Fprintf(&b, "- Loop source: %s, overriding the tags\n", p.loopSource)
+	}
+	fmt.Fprintf(&b, "- Duration: %s (%d samples)\n", formatTimeMillis(p.total), p.fileSample(p.totalSample))
+	if p.info != nil {
+		format := fmt.Sprintf("%d Hz, %d ch", p.info.sampleRate, p.info.channels)
+		if p.info.resampled() {
+			format += fmt.Sprintf(" (resampled to %d Hz)", sampleRate)
+		}
+		fmt.Fprintf(&b, "- Format: %s\n", format)
+	}
+	if review.Status != reviewNone {
+		fmt.Fprintf(&b, "- Review: %s\n", review.Status)
+	}
+	if review.Notes != "" {
+		fmt.Fprintf(&b, "- Notes: %s\n", review.Notes)
+	}
+	for _, n := range review.Moments {
+		fmt.Fprintf(&b, "- Note at %s\n", n)
+	}
+	var warnings []string
+	warnings = append(warnings, p.tagWarnings...)
+	warnings = append(warnings, p.channelWarnings()...)
+	warnings = append(warnings, p.silenceWarnings()...)
+	warnings = append(warnings, p.clipWarnings()...)
+	warnings = append(warnings, p.profileWarnings...)
+	warnings = append(warnings, p.ruleWarnings...)
+	for _, w := range warnings {
+		fmt.Fprintf(&b, "- Warning: %s\n", w)
+	}
+
+	if p.analysis != nil {
+		imgName := name + ".png"
+		if err := writeIssueImage(filepath.Join(dir, imgName), p, sample); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "\n![Waveform](%s)\n", imgName)
+	}
+
+	path := filepath.Join(dir, name+".md")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeIssueImage renders the waveform of p with the loop, the selection and the moment at sample to a PNG file.
+func writeIssueImage(path string, p *Player, sample int64) error {
+	img := newWaveformImage(issueImageWidth, issueImageHeight, p.totalSample)
+	if p.HasSelection() {
+		img.shade(p.selStart, p.selEnd, issueSelectionColor)
+	}
+	img.drawWaveform(p.analysis.waveform)
+	img.drawLoop(p.introSample, p.loopSample)
+	img.vline(img.col(sample), issueMomentColor)
+	return img.writePNG(path)
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type jobState int
+
+const (
+	jobQueued jobState = iota
+	jobRunning
+)
+
+// job is a heavy operation running in background, e.g. decoding a file or encoding.
+//
+// A nil *job is valid and is never canceled, so that the operations can also be run without the queue.
+type job struct {
+	name   string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	state    jobState
+	progress float64
+	m        sync.Mutex
+}
+
+// SetProgress sets the progress in [0, 1].
+func (j *job) SetProgress(progress float64) {
+	if j == nil {
+		return
+	}
+	j.m.Lock()
+	defer j.m.Unlock()
+	j.progress = progress
+}
+
+// Context returns the context canceled when the job is canceled.
+func (j *job) Context() context.Context {
+	if j == nil {
+		return context.Background()
+	}
+	return j.ctx
+}
+
+// Err returns a non-nil error when the job is canceled. Long operations should check it periodically.
+func (j *job) Err() error {
+	if j == nil {
+		return nil
+	}
+	return j.ctx.Err()
+}
+
+// Cancel cancels the job. A queued job is removed without running.
+func (j *job) Cancel() {
+	if j == nil {
+		return
+	}
+	j.cancel()
+}
+
+func (j *job) String() string {
+	j.m.Lock()
+	defer j.m.Unlock()
+	if j.state == jobQueued {
+		return j.name + " (queued)"
+	}
+	return fmt.Sprintf("%s (%d%%)", j.name, int(j.progress*100))
+}
+
+// jobQueue runs jobs in background in the order they are added with limited concurrency,
+// so that heavy operations don't freeze playback or the UI.
+type jobQueue struct {
+	concurrency int
+	running     int
+	jobs        []*job
+	funcs       map[*job]func(j *job)
+	m           sync.Mutex
+}
+
+func newJobQueue(concurrency int) *jobQueue {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &jobQueue{
+		concurrency: concurrency,
+		funcs:       map[*job]func(j *job){},
+	}
+}
+
+// Go queues f, which runs when a slot is available. f should return early when the job is canceled.
+func (q *jobQueue) Go(name string, f func(j *job)) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		name:   name,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	q.m.Lock()
+	q.jobs = append(q.jobs, j)
+	q.funcs[j] = f
+	q.m.Unlock()
+
+	// Remove the job as soon as it is canceled, even while it is queued.
+	go func() {
+		<-ctx.Done()
+		q.m.Lock()
+		defer q.m.Unlock()
+		q.removeLocked(j)
+	}()
+
+	q.dispatch()
+	return j
+}
+
+// dispatch starts the queued jobs while there are free slots.
+func (q *jobQueue) dispatch() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	for _, j := range q.jobs {
+		if q.running >= q.concurrency {
+			return
+		}
+		f, ok := q.funcs[j]
+		if !ok {
+			continue
+		}
+		delete(q.funcs, j)
+		j.m.Lock()
+		j.state = jobRunning
+		j.m.Unlock()
+		q.running++
+		go func(j *job) {
+			f(j)
+			q.m.Lock()
+			q.running--
+			q.m.Unlock()
+			// Canceling also removes the job from the queue.
+			j.cancel()
+			q.dispatch()
+		}(j)
+	}
+}
+
+func (q *jobQueue) removeLocked(j *job) {
+	delete(q.funcs, j)
+	for i, jj := range q.jobs {
+		if jj == j {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			return
+		}
+	}
+}
+
+// Jobs returns the queued and running jobs in the order they were added.
+func (q *jobQueue) Jobs() []*job {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return append([]*job(nil), q.jobs...)
+}
+
+// CancelLast cancels the last added job, and reports whether there was a job.
+func (q *jobQueue) CancelLast() bool {
+	jobs := q.Jobs()
+	if len(jobs) == 0 {
+		return false
+	}
+	jobs[len(jobs)-1].Cancel()
+	return true
+}
+
+// jobLines returns the lines describing the jobs.
+func (q *jobQueue) jobLines() []string {
+	var lines []string
+	for _, j := range q.Jobs() {
+		lines = append(lines, "Job: "+j.String())
+	}
+	return lines
+}
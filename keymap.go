@@ -0,0 +1,275 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// keyAction is an action whose key can be remapped in the settings file.
+type keyAction string
+
+const (
+	actionPlay       keyAction = "play"
+	actionVolumeDown keyAction = "volumeDown"
+	actionVolumeUp   keyAction = "volumeUp"
+	actionOpen       keyAction = "open"
+	actionNext       keyAction = "next"
+	actionPrev       keyAction = "prev"
+	actionLoopStart  keyAction = "loopStart"
+	actionLoopEnd    keyAction = "loopEnd"
+	actionSeam       keyAction = "seam"
+	actionHalveLoop  keyAction = "halveLoop"
+	actionDoubleLoop keyAction = "doubleLoop"
+	actionShiftBack  keyAction = "shiftLoopBack"
+	actionShiftFwd   keyAction = "shiftLoopForward"
+)
+
+// keyMap maps the actions to the keys. The actions missing in the map use the default keys.
+type keyMap map[keyAction]ebiten.Key
+
+var defaultKeys = keyMap{
+	actionPlay:       ebiten.KeySpace,
+	actionVolumeDown: ebiten.KeyZ,
+	actionVolumeUp:   ebiten.KeyX,
+	actionOpen:       ebiten.KeyF,
+	actionNext:       ebiten.KeyN,
+	actionPrev:       ebiten.KeyP,
+	actionLoopStart:  ebiten.KeyI,
+	actionLoopEnd:    ebiten.KeyO,
+	actionSeam:       ebiten.KeyE,
+	actionHalveLoop:  ebiten.KeyH,
+	actionDoubleLoop: ebiten.KeyD,
+	actionShiftBack:  ebiten.KeyComma,
+	actionShiftFwd:   ebiten.KeyPeriod,
+}
+
+// key returns the key of the action.
+func (m keyMap) key(a keyAction) ebiten.Key {
+	if k, ok := m[a]; ok {
+		return k
+	}
+	return defaultKeys[a]
+}
+
+// unknownActions removes the actions that don't exist, e.g. typos in the settings file, and returns their names.
+func (m keyMap) unknownActions() []string {
+	var names []string
+	for a := range m {
+		if _, ok := defaultKeys[a]; !ok {
+			names = append(names, string(a))
+			delete(m, a)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// guiFixedKeys are the keys that do something without a modifier in the window regardless of the key map.
+// An action mapped to one of them would fire together with it.
+var guiFixedKeys = []ebiten.Key{
+	ebiten.KeyA, ebiten.KeyB, ebiten.KeyC, ebiten.KeyG, ebiten.KeyL, ebiten.KeyM, ebiten.KeyR, ebiten.KeyS,
+	ebiten.KeyT, ebiten.KeyU, ebiten.KeyV, ebiten.KeyW, ebiten.KeyY,
+	ebiten.KeyDigit0, ebiten.KeyDigit1, ebiten.KeyDigit2, ebiten.KeyDigit3, ebiten.KeyDigit4,
+	ebiten.KeyDigit5, ebiten.KeyDigit6, ebiten.KeyDigit7, ebiten.KeyDigit8, ebiten.KeyDigit9,
+	ebiten.KeyTab, ebiten.KeyEnter, ebiten.KeyEscape, ebiten.KeyBackspace, ebiten.KeyDelete,
+	ebiten.KeyEqual, ebiten.KeyMinus, ebiten.KeyBracketLeft, ebiten.KeyBracketRight,
+	ebiten.KeyArrowLeft, ebiten.KeyArrowRight, ebiten.KeyPageUp, ebiten.KeyPageDown,
+	ebiten.KeyF1, ebiten.KeyF3, ebiten.KeyF11,
+}
+
+// terminalFixedKeys are the characters that do something in the terminal UI regardless of the key map.
+// Q quits, as the terminal has no window to close.
+const terminalFixedKeys = "qwcrbyavtusm{}0123456789"
+
+// isFixedKey reports whether k does something regardless of the key map, in the terminal UI if terminal is true.
+func isFixedKey(k ebiten.Key, terminal bool) bool {
+	if theTransport == transportJKL && (k == ebiten.KeyJ || k == ebiten.KeyK || k == ebiten.KeyL) {
+		return true
+	}
+	if terminal {
+		c := terminalKey(k)
+		return c != "" && strings.Contains(terminalFixedKeys, c)
+	}
+	for _, f := range guiFixedKeys {
+		if k == f {
+			return true
+		}
+	}
+	return false
+}
+
+// badMappings removes the mappings that can't work, in the terminal UI if terminal is true, and returns why:
+// a key with a fixed meaning, a key the terminal can't type, and a key mapped to more than one action.
+// The actions whose mappings are removed use the default keys.
+func (m keyMap) badMappings(terminal bool) []string {
+	var problems []string
+	actions := make([]keyAction, 0, len(m))
+	for a := range m {
+		actions = append(actions, a)
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i] < actions[j]
+	})
+	for _, a := range actions {
+		k := m[a]
+		if k == defaultKeys[a] {
+			continue
+		}
+		if isFixedKey(k, terminal) {
+			problems = append(problems, fmt.Sprintf("%s: %s has a fixed meaning", a, keyLabel(k)))
+			delete(m, a)
+			continue
+		}
+		if terminal && terminalKey(k) == "" {
+			problems = append(problems, fmt.Sprintf("%s: %s can't be typed in the terminal", a, keyLabel(k)))
+			delete(m, a)
+		}
+	}
+
+	// Removing a mapping puts the action back on its default key, which can be taken by another action, so check again.
+	for {
+		a, b, ok := m.duplicate()
+		if !ok {
+			break
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s is also mapped to %s", b, keyLabel(m.key(b)), a))
+		if _, ok := m[b]; ok {
+			delete(m, b)
+		} else {
+			delete(m, a)
+		}
+	}
+	return problems
+}
+
+// duplicate returns two actions mapped to the same key, if any. b is remapped if either of them is.
+func (m keyMap) duplicate() (a, b keyAction, ok bool) {
+	actions := make([]keyAction, 0, len(defaultKeys))
+	for a := range defaultKeys {
+		actions = append(actions, a)
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i] < actions[j]
+	})
+	seen := map[ebiten.Key]keyAction{}
+	for _, b := range actions {
+		k := m.key(b)
+		a, ok := seen[k]
+		if !ok {
+			seen[k] = b
+			continue
+		}
+		if _, remapped := m[b]; !remapped {
+			a, b = b, a
+		}
+		return a, b, true
+	}
+	return "", "", false
+}
+
+// label returns the name of the action's key for the help.
+func (m keyMap) label(a keyAction) string {
+	return keyLabel(m.key(a))
+}
+
+// keyLabel returns the name of k for the help.
+func keyLabel(k ebiten.Key) string {
+	switch k {
+	case ebiten.KeyComma:
+		return ","
+	case ebiten.KeyPeriod:
+		return "."
+	default:
+		return k.String()
+	}
+}
+
+// justPressed reports whether the action's key is just pressed.
+func (m keyMap) justPressed(a keyAction) bool {
+	return inpututil.IsKeyJustPressed(m.key(a))
+}
+
+// pressed reports whether the action's key is being pressed.
+func (m keyMap) pressed(a keyAction) bool {
+	return ebiten.IsKeyPressed(m.key(a))
+}
+
+// terminalKey returns the character k types in the terminal in lowercase, or an empty string when k doesn't type one.
+func terminalKey(k ebiten.Key) string {
+	switch {
+	case ebiten.KeyA <= k && k <= ebiten.KeyZ:
+		return string(rune('a' + k - ebiten.KeyA))
+	case ebiten.KeyDigit0 <= k && k <= ebiten.KeyDigit9:
+		return string(rune('0' + k - ebiten.KeyDigit0))
+	}
+	switch k {
+	case ebiten.KeySpace:
+		return " "
+	case ebiten.KeyComma:
+		return ","
+	case ebiten.KeyPeriod:
+		return "."
+	case ebiten.KeyMinus:
+		return "-"
+	case ebiten.KeyEqual:
+		return "="
+	case ebiten.KeySlash:
+		return "/"
+	case ebiten.KeySemicolon:
+		return ";"
+	case ebiten.KeyQuote:
+		return "'"
+	case ebiten.KeyBracketLeft:
+		return "["
+	case ebiten.KeyBracketRight:
+		return "]"
+	case ebiten.KeyBackquote:
+		return "`"
+	case ebiten.KeyBackslash:
+		return "\\"
+	}
+	return ""
+}
+
+// remapTerminalKey translates a key read in the terminal to the default key of the action it is mapped to,
+// so that the terminal handlers can keep switching on the default keys.
+// The default key of a remapped action does nothing unless another action is mapped to it.
+func (m keyMap) remapTerminalKey(key string) string {
+	for a, d := range defaultKeys {
+		if k := m.key(a); k != d && terminalKey(k) == key {
+			return terminalKey(d)
+		}
+	}
+	for a, d := range defaultKeys {
+		if m.key(a) != d && terminalKey(d) == key {
+			return ""
+		}
+	}
+	return key
+}
+
+// terminalLabel returns the name of the action's key for the terminal help.
+func (m keyMap) terminalLabel(a keyAction) string {
+	if k := m.key(a); k == ebiten.KeySpace {
+		return "Space"
+	}
+	return strings.ToUpper(terminalKey(m.key(a)))
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// limiterHold is how long the largest gain reduction of the master limiter stays shown.
+const limiterHold = 500 * time.Millisecond
+
+// masterLimiterStage is a brickwall limiter at the ceiling given by -limiter on the playback master,
+// mimicking the game's final output limiter, so that it can be heard whether the transients at the loop seam pump it.
+// It is the last stage of the chain, after the inserts.
+type masterLimiterStage struct {
+	limiter *limiterEffect
+
+	// reduction is the largest recent gain reduction in dB, and reducedAt is when it was.
+	reduction float64
+	reducedAt time.Time
+
+	m sync.Mutex
+}
+
+func (s *masterLimiterStage) Name() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.limiter == nil {
+		return ""
+	}
+	if s.reduction < 0.1 {
+		return fmt.Sprintf("Limiter %.1f dB", s.limiter.db)
+	}
+	return fmt.Sprintf("Limiter %.1f dB (GR %.1f dB)", s.limiter.db, s.reduction)
+}
+
+func (s *masterLimiterStage) Active() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.limiter != nil
+}
+
+func (s *masterLimiterStage) Process(pcm []int16) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.limiter == nil {
+		return
+	}
+	gain := 1.0
+	for i := 0; i+1 < len(pcm); i += 2 {
+		l, r := s.limiter.process(float64(pcm[i]), float64(pcm[i+1]))
+		pcm[i], pcm[i+1] = clampSample(l), clampSample(r)
+		gain = math.Min(gain, s.limiter.gain)
+	}
+	now := time.Now()
+	if r := -20 * math.Log10(gain); r >= s.reduction || now.Sub(s.reducedAt) > limiterHold {
+		s.reduction = r
+		s.reducedAt = now
+	}
+}
+
+// Toggle turns the limiter on at the ceiling of -limiter, or off.
+func (s *masterLimiterStage) Toggle() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.limiter != nil {
+		s.limiter = nil
+		return
+	}
+	s.limiter = newLimiterEffect(math.Min(*flagLimiter, 0))
+	s.reduction = 0
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// loopEndStop is a stream ending at the loop end, so that the audio player pauses exactly at the loop end sample
+// instead of wrapping to the loop start. Seeking starts another pass to the next loop end.
+//
+// Read is called from the audio goroutine, so the position is guarded by a mutex.
+type loopEndStop struct {
+	src io.ReadSeeker
+
+	// intro and loop are the loop in bytes.
+	intro int64
+	loop  int64
+
+	// pos is the position in the looped stream, which keeps increasing over loops. stopAt is the loop end to stop at.
+	pos    int64
+	stopAt int64
+
+	m sync.Mutex
+}
+
+func newLoopEndStop(src io.ReadSeeker, intro, loop int64) *loopEndStop {
+	return &loopEndStop{
+		src:    src,
+		intro:  intro,
+		loop:   loop,
+		stopAt: intro + loop,
+	}
+}
+
+func (l *loopEndStop) Read(buf []byte) (int, error) {
+	l.m.Lock()
+	rest := l.stopAt - l.pos
+	l.m.Unlock()
+	if rest <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(buf)) > rest {
+		buf = buf[:rest]
+	}
+	n, err := l.src.Read(buf)
+
+	l.m.Lock()
+	l.pos += int64(n)
+	l.m.Unlock()
+	return n, err
+}
+
+func (l *loopEndStop) Seek(offset int64, whence int) (int64, error) {
+	pos, err := l.src.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.pos = pos
+	l.stopAt = l.intro + l.loop
+	if pos >= l.stopAt && l.loop > 0 {
+		l.stopAt = l.intro + ((pos-l.intro)/l.loop+1)*l.loop
+	}
+	return pos, nil
+}
+
+// Parked reports whether the stream has stopped at the loop end.
+func (l *loopEndStop) Parked() bool {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.pos >= l.stopAt
+}
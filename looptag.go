@@ -0,0 +1,357 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The tag names of the loop as RPG Maker uses.
+const (
+	loopStartKey  = "LOOPSTART"
+	loopLengthKey = "LOOPLENGTH"
+)
+
+// tagMode is how strictly malformed loop tags are treated.
+type tagMode int
+
+const (
+	// tagModeLenient ignores malformed values.
+	tagModeLenient tagMode = iota
+
+	// tagModeAutoCorrect uses the closest valid values for malformed values where possible.
+	tagModeAutoCorrect
+
+	// tagModeStrict refuses to play a file with malformed values, as strict game engines do.
+	tagModeStrict
+)
+
+// String implements flag.Value.
+func (m *tagMode) String() string {
+	switch *m {
+	case tagModeLenient:
+		return "lenient"
+	case tagModeAutoCorrect:
+		return "autocorrect"
+	case tagModeStrict:
+		return "strict"
+	}
+	return ""
+}
+
+// Set implements flag.Value.
+func (m *tagMode) Set(str string) error {
+	switch str {
+	case "lenient":
+		*m = tagModeLenient
+	case "autocorrect":
+		*m = tagModeAutoCorrect
+	case "strict":
+		*m = tagModeStrict
+	default:
+		return fmt.Errorf("tag mode must be lenient, autocorrect or strict but was %q", str)
+	}
+	return nil
+}
+
+// loopTags is the loop values read from the tags with the problems found in them.
+type loopTags struct {
+	mode   tagMode
+	start  int64
+	length int64
+
+	// warnings describe the problems of the tags for users.
+	warnings []string
+
+	// badKeys are the keys whose values are malformed.
+	badKeys []string
+}
+
+// parseLoopTags reads LOOPSTART and LOOPLENGTH from c. Malformed values are treated as mode specifies.
+func parseLoopTags(c *vorbisComments, mode tagMode) *loopTags {
+	t := &loopTags{
+		mode: mode,
+	}
+	t.start = t.parseValue(loopStartKey, c.Get(loopStartKey))
+	t.length = t.parseValue(loopLengthKey, c.Get(loopLengthKey))
+
+	hasStart := len(c.Get(loopStartKey)) > 0
+	hasLength := len(c.Get(loopLengthKey)) > 0
+	if hasStart && !hasLength {
+		t.warn(loopStartKey, "%s exists without %s", loopStartKey, loopLengthKey)
+	}
+	if hasLength && t.length == 0 && !t.isBad(loopLengthKey) {
+		t.warn(loopLengthKey, "%s is 0", loopLengthKey)
+	}
+	return t
+}
+
+func (t *loopTags) parseValue(key string, vals []string) int64 {
+	if len(vals) == 0 {
+		return 0
+	}
+
+	corrected := " (ignored)"
+	if t.mode == tagModeAutoCorrect {
+		corrected = " (corrected)"
+	}
+
+	if len(vals) > 1 {
+		if t.mode != tagModeAutoCorrect {
+			t.warn(key, "%s appears %d times (ignored)", key, len(vals))
+			return 0
+		}
+		t.warn(key, "%s appears %d times (the first one is used)", key, len(vals))
+	}
+
+	str := strings.TrimSpace(vals[0])
+	v, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			t.warn(key, "%s is not a number: %q (ignored)", key, vals[0])
+			return 0
+		}
+		if strings.ContainsAny(str, "eE") {
+			t.warn(key, "%s is in scientific notation: %q%s", key, vals[0], corrected)
+		} else {
+			t.warn(key, "%s is not an integer: %q%s", key, vals[0], corrected)
+		}
+		if t.mode != tagModeAutoCorrect {
+			return 0
+		}
+		v = int64(math.Round(f))
+	} else if str != vals[0] {
+		t.warn(key, "%s has spaces: %q%s", key, vals[0], corrected)
+		if t.mode != tagModeAutoCorrect {
+			return 0
+		}
+	}
+	if v < 0 {
+		t.warn(key, "%s is negative: %d (ignored)", key, v)
+		return 0
+	}
+	return v
+}
+
+// validateRange checks the loop against the file length.
+func (t *loopTags) validateRange(totalSample int64) {
+	if t.length == 0 {
+		return
+	}
+	if t.start+t.length <= totalSample {
+		return
+	}
+	if t.start >= totalSample {
+		t.warn(loopStartKey, "%s (%d) is beyond the end of the file (%d) (ignored)", loopStartKey, t.start, totalSample)
+	} else if t.mode == tagModeAutoCorrect {
+		t.warn(loopLengthKey, "the loop end (%d) is beyond the end of the file (%d) (corrected)", t.start+t.length, totalSample)
+		t.length = totalSample - t.start
+		return
+	} else {
+		t.warn(loopLengthKey, "the loop end (%d) is beyond the end of the file (%d) (ignored)", t.start+t.length, totalSample)
+	}
+	t.markBad(loopStartKey)
+	t.markBad(loopLengthKey)
+	t.start = 0
+	t.length = 0
+}
+
+// removePreSkip converts the loop of an Opus file from the granule domain, which includes the pre-skip,
+// to the decoded samples the player plays.
+func (t *loopTags) removePreSkip(preSkip int64) {
+	if t.length == 0 {
+		return
+	}
+	t.start -= preSkip
+	// Some tools write the loop tags of Opus files without the pre-skip. Such a loop from the start still starts at the start.
+	if t.start < 0 {
+		t.start = 0
+	}
+}
+
+// toStreamRate converts the loop from the samples at the file's sample rate, which the tags are written in,
+// to the decoded stream at sampleRate. The end is converted rather than the length so that the loop doesn't drift.
+func (t *loopTags) toStreamRate(info *fileInfo) {
+	if t.length == 0 {
+		return
+	}
+	start := info.toStreamSamples(t.start)
+	t.length = info.toStreamSamples(t.start+t.length) - start
+	t.start = start
+}
+
+// err returns an error when the tags are malformed in the strict mode.
+func (t *loopTags) err() error {
+	if t.mode != tagModeStrict || len(t.warnings) == 0 {
+		return nil
+	}
+	return fmt.Errorf("malformed loop tags: %s", strings.Join(t.warnings, ", "))
+}
+
+func (t *loopTags) warn(key string, format string, args ...interface{}) {
+	t.warnings = append(t.warnings, fmt.Sprintf(format, args...))
+	t.markBad(key)
+}
+
+func (t *loopTags) markBad(key string) {
+	if !t.isBad(key) {
+		t.badKeys = append(t.badKeys, key)
+	}
+}
+
+func (t *loopTags) isBad(key string) bool {
+	for _, k := range t.badKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// clearLoopTags removes the tags of keys from the Ogg/Vorbis file at path.
+func clearLoopTags(path string, keys []string) error {
+	return rewriteVorbisComments(path, func(c *vorbisComments) {
+		for _, k := range keys {
+			c.Delete(k)
+		}
+	})
+}
+
+// writeLoopTags sets LOOPSTART and LOOPLENGTH of the Ogg/Vorbis or Ogg/Opus file at path.
+func writeLoopTags(path string, introSample, loopSample int64) error {
+	return rewriteVorbisComments(path, func(c *vorbisComments) {
+		c.Set(loopStartKey, strconv.FormatInt(introSample, 10))
+		c.Set(loopLengthKey, strconv.FormatInt(loopSample, 10))
+	})
+}
+
+// rewriteVorbisComments rewrites the comments of the Ogg/Vorbis or Ogg/Opus file at path with edit.
+// The loop of an MP3 file is written to its JSON sidecar instead.
+func rewriteVorbisComments(path string, edit func(c *vorbisComments)) error {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(dat, []byte(wavMagic)) {
+		return fmt.Errorf("oggplayer: the loop of a WAV file cannot be written to the tags: %s", path)
+	}
+	if bytes.HasPrefix(dat, []byte(flacMagic)) {
+		return fmt.Errorf("oggplayer: the loop of a FLAC file cannot be written to the tags: %s", path)
+	}
+	if mp3, err := isMP3Stream(bytes.NewReader(dat)); err != nil {
+		return err
+	} else if mp3 {
+		return rewriteLoopSidecar(path, edit)
+	}
+	read, replace, headerCount := readOggVorbisComments, replaceOggVorbisComments, vorbisHeaderCount
+	if isOggOpus(dat) {
+		read, replace, headerCount = readOggOpusComments, replaceOggOpusComments, opusHeaderCount
+	}
+	c, err := read(dat)
+	if err != nil {
+		return err
+	}
+	edit(c)
+	newDat, err := replace(dat, c)
+	if err != nil {
+		return err
+	}
+	return replaceFileSafely(path, dat, newDat, func(tmp string) error {
+		return verifyRewrittenOgg(tmp, dat, headerCount, c, read)
+	})
+}
+
+// verifyRewrittenOgg checks that the rewritten Ogg file at path has the comments c read by read,
+// has the same audio as orig with intact pages, and decodes to the end with the same length as orig. headerCount is the number of the header packets.
+func verifyRewrittenOgg(path string, orig []byte, headerCount int, c *vorbisComments, read func(dat []byte) (*vorbisComments, error)) error {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	got, err := read(dat)
+	if err != nil {
+		return err
+	}
+	if got.vendor != c.vendor || strings.Join(got.comments, "\n") != strings.Join(c.comments, "\n") {
+		return fmt.Errorf("the comments are not written as expected")
+	}
+
+	// Encoding the pages again results in the same bytes only when all the pages and their CRCs are intact.
+	pages, err := readOggPages(dat)
+	if err != nil {
+		return err
+	}
+	var encoded []byte
+	for _, p := range pages {
+		encoded = append(encoded, p.bytes()...)
+	}
+	if !bytes.Equal(encoded, dat) {
+		return fmt.Errorf("the pages are broken")
+	}
+
+	// Only the headers are rewritten, so the audio must be the same as the original.
+	h, err := readOggHeaders(dat, headerCount)
+	if err != nil {
+		return err
+	}
+	origH, err := readOggHeaders(orig, headerCount)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h.audioData(), origH.audioData()) {
+		return fmt.Errorf("the audio data is changed")
+	}
+
+	// Decoding Opus takes opusdec and time. The audio is already checked to be the same as above.
+	if isOggOpus(dat) {
+		return nil
+	}
+	// Decode the whole stream so that broken pages or granule positions later in the file are found,
+	// and compare the length with the original's.
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := decodedLength(f)
+	if err != nil {
+		return err
+	}
+	origN, err := decodedLength(bytes.NewReader(orig))
+	if err != nil {
+		return err
+	}
+	if n != origN {
+		return fmt.Errorf("the length is changed from %d to %d samples", origN/bytesPerSample, n/bytesPerSample)
+	}
+	return nil
+}
+
+// decodedLength decodes the whole stream of r and returns its length in bytes.
+func decodedLength(r io.ReadSeeker) (int64, error) {
+	s, err := decodeStream(r, nil)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(io.Discard, s)
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// loopWrap is the result of checking one wrap from the loop end to the loop start in the rendered playback.
+type loopWrap struct {
+	// sample is the frame of the rendered playback where the wrap lands.
+	sample int64
+
+	// exact reports whether the rendered frames around the wrap are the loop end followed by the loop start sample for sample.
+	exact bool
+
+	// jump is the discontinuity at the wrap in the rendered playback.
+	jump float64
+}
+
+// ok reports whether the wrap is sample-exact and its discontinuity is within tolerance.
+func (l loopWrap) ok(tolerance float64) bool {
+	return l.exact && l.jump <= tolerance
+}
+
+// minLoopTestLength is the length of the shortest loop whose wraps can be checked, as two frames before a wrap are compared.
+const minLoopTestLength = 2
+
+// checkLoopWraps renders the intro and wraps iterations of the loop of pcm offline through the same stream the playback reads,
+// and checks every wrap: the frames must continue from the loop end to the loop start without a dropped or repeated sample,
+// and the rendered discontinuity is measured as seamJump does. The loop must be at least minLoopTestLength samples and within pcm.
+func checkLoopWraps(pcm []int16, introSample, loopSample int64, wraps int) ([]loopWrap, error) {
+	if loopSample < minLoopTestLength {
+		return nil, fmt.Errorf("the loop is shorter than %d samples", minLoopTestLength)
+	}
+	if introSample < 0 || introSample+loopSample > int64(len(pcm)/2) {
+		return nil, fmt.Errorf("the loop ends after the audio")
+	}
+	out, err := renderLoops(pcm, introSample, loopSample, wraps+1, 0)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]loopWrap, wraps)
+	for i := range results {
+		at := introSample + int64(i+1)*loopSample
+		w := loopWrap{sample: at, exact: true}
+		// Compare a few frames on both sides of the wrap with the source.
+		for d := int64(-2); d < 2; d++ {
+			src := introSample + d
+			if d < 0 {
+				src += loopSample
+			}
+			if out[2*(at+d)] != pcm[2*src] || out[2*(at+d)+1] != pcm[2*src+1] {
+				w.exact = false
+			}
+		}
+		predicted := 2*monoAt(out, at-1) - monoAt(out, at-2)
+		w.jump = math.Abs(predicted - monoAt(out, at))
+		results[i] = w
+	}
+	return results, nil
+}
+
+// runLoopTest runs the looptest command, which renders wraps of the loop of each file offline and checks the continuity at each wrap,
+// so that the project and the games using its files can regression-test the loop in their CI:
+//
+//	oggplayer looptest -wraps 8 -tolerance 0.01 file.ogg...
+//
+// runLoopTest reports whether any file failed.
+func runLoopTest(args []string, w io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("looptest", flag.ExitOnError)
+	wraps := fs.Int("wraps", 4, "number of the wraps rendered and checked")
+	tolerance := fs.Float64("tolerance", seamJumpTolerance, "largest discontinuity at a wrap in the full scale")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: oggplayer looptest [-wraps 4] [-tolerance 0.01] file.ogg...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *wraps < 1 || *tolerance < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var failed int
+	for _, path := range fs.Args() {
+		h, err := openHeadless(path)
+		if err != nil {
+			return false, err
+		}
+		if h.tags.length <= 0 {
+			fmt.Fprintf(w, "SKIP %s: no loop\n", path)
+			continue
+		}
+		if h.tags.length < minLoopTestLength {
+			fmt.Fprintf(w, "FAIL %s: the loop is shorter than %d samples\n", path, minLoopTestLength)
+			failed++
+			continue
+		}
+		pcm, err := decodePCM(path, nil)
+		if err != nil {
+			return false, err
+		}
+		if int64(len(pcm)/2) < h.tags.start+h.tags.length {
+			fmt.Fprintf(w, "FAIL %s: the loop ends after the decoded audio\n", path)
+			failed++
+			continue
+		}
+		results, err := checkLoopWraps(pcm, h.tags.start, h.tags.length, *wraps)
+		if err != nil {
+			return false, err
+		}
+		result := "PASS"
+		for _, r := range results {
+			if !r.ok(*tolerance) {
+				result = "FAIL"
+			}
+		}
+		if result == "FAIL" {
+			failed++
+		}
+		fmt.Fprintf(w, "%s %s\n", result, path)
+		for i, r := range results {
+			line := fmt.Sprintf("  wrap %d at %s: jump %.4f", i+1, formatTimeMillis(samplesToDuration(r.sample)), r.jump)
+			if !r.exact {
+				line += ", not sample-exact"
+			}
+			if !r.ok(*tolerance) {
+				line += " FAIL"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	fmt.Fprintf(w, "\n%d files tested, %d failed\n", fs.NArg(), failed)
+	return failed > 0, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// sinePCM returns frames of interleaved stereo PCM of a sine at half the full scale with a period of period frames.
+func sinePCM(frames int, period float64) []int16 {
+	pcm := make([]int16, 2*frames)
+	for i := 0; i < frames; i++ {
+		v := int16(16384 * math.Sin(2*math.Pi*float64(i)/period))
+		pcm[2*i] = v
+		pcm[2*i+1] = v
+	}
+	return pcm
+}
+
+func TestCheckLoopWraps(t *testing.T) {
+	pcm := sinePCM(2000, 100)
+	for _, tc := range []struct {
+		name        string
+		introSample int64
+		loopSample  int64
+		ok          bool
+		err         bool
+	}{
+		{
+			name:        "exact",
+			introSample: 100,
+			loopSample:  500,
+			ok:          true,
+		},
+		{
+			name:        "discontinuous",
+			introSample: 100,
+			loopSample:  525,
+			ok:          false,
+		},
+		{
+			name:        "tiny",
+			introSample: 0,
+			loopSample:  1,
+			err:         true,
+		},
+		{
+			name:        "shortest",
+			introSample: 0,
+			loopSample:  minLoopTestLength,
+			ok:          false,
+		},
+		{
+			name:        "beyond the end",
+			introSample: 1900,
+			loopSample:  200,
+			err:         true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			const wraps = 3
+			results, err := checkLoopWraps(pcm, tc.introSample, tc.loopSample, wraps)
+			if tc.err {
+				if err == nil {
+					t.Errorf("checkLoopWraps returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != wraps {
+				t.Fatalf("got %d wraps, want %d", len(results), wraps)
+			}
+			for i, r := range results {
+				if want := tc.introSample + int64(i+1)*tc.loopSample; r.sample != want {
+					t.Errorf("wrap %d: sample: got %d, want %d", i, r.sample, want)
+				}
+				if !r.exact {
+					t.Errorf("wrap %d: the rendered frames are not sample-exact", i)
+				}
+				if got := r.ok(seamJumpTolerance); got != tc.ok {
+					t.Errorf("wrap %d: ok: got %t (jump %.4f), want %t", i, got, r.jump, tc.ok)
+				}
+			}
+		})
+	}
+}
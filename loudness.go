@@ -0,0 +1,153 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math"
+)
+
+// biquad is a second-order IIR filter.
+type biquad struct {
+	b0, b1, b2 float64
+	a1, a2     float64
+
+	x1, x2 float64
+	y1, y2 float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// newKWeighting returns the K-weighting filters for 48 kHz defined in ITU-R BS.1770.
+func newKWeighting() []*biquad {
+	return []*biquad{
+		// The high shelf of the head.
+		{b0: 1.53512485958697, b1: -2.69169618940638, b2: 1.19839281085285, a1: -1.69065929318241, a2: 0.73248077421585},
+		// The high pass (RLB weighting).
+		{b0: 1, b1: -2, b2: 1, a1: -1.99004745483398, a2: 0.99007225036621},
+	}
+}
+
+const (
+	loudnessBlockSamples = sampleRate * 400 / 1000
+	loudnessStepSamples  = sampleRate * 100 / 1000
+	loudnessAbsoluteGate = -70
+	loudnessRelativeGate = -10
+
+	// shortTermSteps is the number of the steps in the 3 s window of the short-term loudness (EBU Tech 3341).
+	shortTermSteps = 30
+)
+
+// loudnessMeter sums the squared K-weighted samples per step, so that the overlapping blocks can be computed cheaply.
+// The PCM is added chunk by chunk as it is decoded.
+type loudnessMeter struct {
+	filters [2][]*biquad
+
+	// steps are the sums of the finished steps.
+	steps []float64
+
+	sum float64
+	n   int
+}
+
+func newLoudnessMeter() *loudnessMeter {
+	return &loudnessMeter{
+		filters: [2][]*biquad{newKWeighting(), newKWeighting()},
+	}
+}
+
+// add adds interleaved stereo PCM at 48 kHz. The last unfinished step is ignored.
+func (m *loudnessMeter) add(pcm []int16) {
+	for i := 0; i < len(pcm)/2; i++ {
+		for ch := 0; ch < 2; ch++ {
+			v := float64(pcm[2*i+ch]) / 32768
+			for _, f := range m.filters[ch] {
+				v = f.process(v)
+			}
+			m.sum += v * v
+		}
+		m.n++
+		if m.n == loudnessStepSamples {
+			m.steps = append(m.steps, m.sum)
+			m.sum = 0
+			m.n = 0
+		}
+	}
+}
+
+// shortTermLoudness returns the short-term loudness in LUFS of the 3 s window ending at every 100 ms step
+// from the steps of a loudnessMeter. The windows at the beginning are shorter.
+func shortTermLoudness(steps []float64) []float64 {
+	loudness := make([]float64, len(steps))
+	var sum float64
+	for i, s := range steps {
+		sum += s
+		n := i + 1
+		if n > shortTermSteps {
+			sum -= steps[i-shortTermSteps]
+			n = shortTermSteps
+		}
+		// Avoid a tiny negative sum by the rounding errors.
+		if sum < 0 {
+			sum = 0
+		}
+		loudness[i] = blockLoudness(sum / float64(n*loudnessStepSamples))
+	}
+	return loudness
+}
+
+// integratedLoudness returns the integrated loudness in LUFS (ITU-R BS.1770-4) from the steps of a loudnessMeter.
+// integratedLoudness returns -Inf for silence.
+func integratedLoudness(steps []float64) float64 {
+	const stepsPerBlock = loudnessBlockSamples / loudnessStepSamples
+	var blocks []float64
+	for i := 0; i+stepsPerBlock <= len(steps); i++ {
+		var sum float64
+		for _, s := range steps[i : i+stepsPerBlock] {
+			sum += s
+		}
+		blocks = append(blocks, sum/loudnessBlockSamples)
+	}
+
+	gatedMean := func(gate float64) float64 {
+		var sum float64
+		var n int
+		for _, z := range blocks {
+			if blockLoudness(z) <= gate {
+				continue
+			}
+			sum += z
+			n++
+		}
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+
+	relativeGate := blockLoudness(gatedMean(loudnessAbsoluteGate)) + loudnessRelativeGate
+	if relativeGate < loudnessAbsoluteGate {
+		relativeGate = loudnessAbsoluteGate
+	}
+	return blockLoudness(gatedMean(relativeGate))
+}
+
+func blockLoudness(meanSquare float64) float64 {
+	return -0.691 + 10*math.Log10(meanSquare)
+}
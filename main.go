@@ -15,339 +15,341 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"image/color"
+	"flag"
 	"log"
 	"os"
-	"time"
+	"runtime"
 
 	"github.com/hajimehoshi/ebiten/v2"
-	"github.com/hajimehoshi/ebiten/v2/audio"
-	"github.com/hajimehoshi/ebiten/v2/audio/vorbis"
-	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
-	"github.com/hajimehoshi/ebiten/v2/inpututil"
-	"github.com/hajimehoshi/oggloop"
-	"github.com/sqweek/dialog"
 )
 
-const (
-	screenWidth  = 320
-	screenHeight = 240
-
-	sampleRate = 48000
+var (
+	flagTUI      = flag.Bool("tui", false, "use the terminal UI instead of opening a window (files are given as arguments)")
+	flagHistory  = flag.String("history", "", "append a CSV log of the played files to the given path")
+	flagM3U      = flag.String("m3u", "playlist.m3u8", "path the terminal UI exports the playlist to (.oggproj writes a project)")
+	flagLoopBars = flag.Int("loop-bars", 16, "number of bars in a loop, used to shift the loop by a bar")
+	flagBarBeats = flag.Int("bar-beats", 4, "number of beats in a bar of the detected beat grid")
+	flagLoopStop = flag.Bool("pause-at-loop-end", false, "pause exactly at the loop end instead of wrapping to the loop start, leaving the playhead at the seam")
+	flagPreview  = flag.Float64("preview", 3, "seconds before the loop end E seeks to, to audition the loop seam")
+	flagFadeIn   = flag.Float64("play-fade-in", 0, "milliseconds of the fade-in when the playback starts or resumes (0 disables it)")
+	flagFadeOff  = flag.Float64("pause-fade-out", 0, "milliseconds of the fade-out before the playback pauses (0 disables it)")
+	flagXfade    = flag.Float64("crossfade", 0, "seconds of the crossfade from the playing track to the next opened one (0 cuts)")
+	flagLimiter  = flag.Float64("limiter", -1, "ceiling in dBFS of the master limiter toggled with Shift+C, like the game's output limiter")
+	flagFadeStop = flag.Float64("stop-fade", 2, "seconds of the fade-out of the fade out and stop action (Shift+S)")
+	flagProfile  = flag.String("profile", "", "validation profile: rpgmaker, godot, renpy or a JSON file of a custom profile")
+	flagRules    = flag.String("rules", "", "YAML or JSON file of the studio's own rules for the files")
+	flagRefTrack = flag.String("reference", "", "reference track Shift+Tab switches to from the current file at the matched loudness")
+	flagDiscord  = flag.String("discord", "", "publish the playing track to Discord Rich Presence using the given application client ID")
+	flagMonitor  = flag.String("monitor", "", "command writing the input to monitor as 16-bit stereo PCM at 48000 Hz to stdout, e.g. arecord -q -f S16_LE -r 48000 -c 2 -t raw")
+	flagMediaKey = flag.Bool("media-keys", false, "control the playback with the media keys without focus: MPRIS on Linux, hot keys on Windows")
+	flagOggenc   = flag.String("oggenc", "oggenc", "oggenc command used to encode files")
+	flagOpusenc  = flag.String("opusenc", "opusenc", "opusenc command used to encode files to Opus")
+	flagOpusdec  = flag.String("opusdec", "opusdec", "opusdec command used to play Opus files")
+	flagFLAC     = flag.String("flac", "flac", "flac command used to play FLAC files")
+	flagIssues   = flag.String("issues", "issues", "directory bug reports are exported to")
+	flagDAW      = flag.String("daw", "markers", "directory the loop and the markers are exported to as REAPER regions and Audacity labels")
+	flagRender   = flag.String("render", "wav", "format the intro and the loops are rendered to with Ctrl+R: wav or ogg")
+	flagLoops    = flag.Int("loops", 2, "number of the loop iterations rendered after the intro with Ctrl+R")
+	flagFadeOut  = flag.Float64("fade-out", 0, "seconds of the fade-out at the end of the rendered file (0 disables it)")
+	flagTrimPad  = flag.Float64("stinger-pad", 10, "milliseconds of the audio kept around the sound of a stinger trimmed with Ctrl+T")
+	flagTrimFade = flag.Float64("stinger-fade", 5, "milliseconds of the fade-in and the fade-out at the edges of a stinger trimmed with Ctrl+T")
+	flagEditor   = flag.String("editor", "", "external editor command the current file is opened in with Ctrl+E, e.g. the path to Audacity")
+	flagJobs     = flag.Int("jobs", runtime.NumCPU(), "maximum number of background jobs running at once, e.g. decoding and encoding, and of the files checked at once by -check")
+	flagPCMCache = flag.Int("pcm-cache", 512, "megabytes of decoded audio kept in memory not to decode the same files again (0 disables it)")
+	flagReadOnly = flag.Bool("read-only", false, "never change the files or write files next to them (tag edits, trimming, encoding and reviews are disabled)")
+	flagCheck    = flag.String("check", "", "check the loop tags of every Ogg file under the given directory without opening a window, and exit with 1 on failures")
+	flagPNG      = flag.String("png", "", "render the waveforms with the loops of the given files to PNG files in the given directory without opening a window")
+	flagState    = flag.Bool("state", false, "print the state of the given files (format, loop and analysis) as JSON without opening a window")
+	flagPresent  = flag.Int("present-on", 0, "monitor the presentation mode (F11) goes fullscreen on, counted from 1 in the order the system reports them (0: the window's monitor)")
+	flagConfig   = flag.String("config", "", "settings file the volume, the last folder, the window and the preview are kept in (default: config.json in the user's config directory)")
+	flagStartup  = flag.String("startup", "", "what to do at startup without files: files (nothing), resume (the last session), dialog, project:PATH or watch:DIR (saved for the next launches)")
+	flagSnapshot = flag.String("snapshot", "", "restore the named snapshot at startup and save it at exit (a name or a JSON file)")
 )
 
 var (
-	playerBarColor     = color.RGBA{0x80, 0x80, 0x80, 0xff}
-	playerCurrentColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
-	loopCursorColor    = color.RGBA{0xff, 0xff, 0x80, 0xff}
-)
+	// theTagMode is how strictly malformed loop tags are treated.
+	theTagMode tagMode
 
-// Player represents the current audio state.
-type Player struct {
-	audioContext *audio.Context
-	audioPlayer  *audio.Player
-	current      time.Duration
-	total        time.Duration
-	seBytes      []byte
-	seCh         chan []byte
-	volume128    int
-	introSample  int64
-	loopSample   int64
-}
+	// theTransport is the keys controlling the playback.
+	theTransport transportScheme
 
-func playerBarRect() (x, y, w, h int) {
-	w, h = 300, 4
-	x = (screenWidth - w) / 2
-	y = screenHeight - h - 16
-	return
-}
+	// theWaveform is how the channels are shown on the waveform.
+	theWaveform waveformMode
 
-func NewPlayer(audioContext *audio.Context, oggPath string) (*Player, error) {
-	const bytesPerSample = 4 // TODO: This should be defined in audio package
+	// theSnap is where the loop markers snap to.
+	theSnap snapMode
 
-	var s *vorbis.Stream
-	var introSample, loopSample int64
+	// theTimeDisplay is how the current time and the loop points are shown.
+	theTimeDisplay timeDisplay
 
-	var err error
-	var dat []byte
-	dat, err = os.ReadFile(oggPath)
-	if err != nil {
-		return nil, err
-	}
+	// theProfile is the validation profile of the target engine. theProfile is nil when not specified.
+	theProfile *validationProfile
 
-	introSample, loopSample, err = oggloop.Read(bytes.NewReader(dat))
-	if err != nil {
-		// Ignore oggloop's error.
-		log.Printf("oggloop error: %s, %v", oggPath, err)
-	}
-	s, err = vorbis.Decode(audioContext, bytes.NewReader(dat))
-	if err != nil {
-		return nil, err
-	}
+	// theRules is the studio's own rules. theRules is nil when not specified.
+	theRules *checkRules
 
-	s2 := audio.NewInfiniteLoopWithIntro(s, introSample*bytesPerSample, loopSample*bytesPerSample)
+	// theSettings is the preferences kept across launches.
+	theSettings = defaultSettings()
 
-	p, err := audio.NewPlayer(audioContext, s2)
-	if err != nil {
-		return nil, err
-	}
-	player := &Player{
-		audioContext: audioContext,
-		audioPlayer:  p,
-		total:        time.Second * time.Duration(s.Length()) / bytesPerSample / sampleRate,
-		volume128:    128,
-		seCh:         make(chan []byte),
-		introSample:  introSample,
-		loopSample:   loopSample,
-	}
-	if player.total == 0 {
-		player.total = 1
-	}
-	player.audioPlayer.Play()
-	return player, nil
-}
+	// theJobs is the queue of the background jobs.
+	theJobs *jobQueue
 
-func (p *Player) Resume() {
-	if !p.audioPlayer.IsPlaying() {
-		p.audioPlayer.Play()
-	}
-}
+	// thePCMCache is the cache of the decoded PCM. thePCMCache is nil when disabled.
+	thePCMCache *pcmCache
 
-func (p *Player) Pause() {
-	if p.audioPlayer.IsPlaying() {
-		p.audioPlayer.Pause()
-	}
-}
+	// theTranspose is the transpose of the preview.
+	theTranspose = &transposeStage{}
 
-func (p *Player) Close() error {
-	return p.audioPlayer.Close()
-}
+	// theStretch is the time-stretch of the preview.
+	theStretch = newStretchStage()
 
-func (p *Player) loopStartInSecond() float64 {
-	return float64(p.introSample) / sampleRate
-}
+	// theMidSide is the mid/side audition of the preview.
+	theMidSide = &midSideStage{}
 
-func (p *Player) loopLengthInSecond() float64 {
-	return float64(p.loopSample) / sampleRate
-}
+	// theChannels is the channel solo and the mono downmix of the preview.
+	theChannels = &channelStage{}
+
+	// thePan is the pan of the preview.
+	thePan = &panStage{}
+
+	// theInserts is the effects inserted in the playback for the session.
+	theInserts = &insertChain{}
+
+	// theLimiter is the limiter on the playback master.
+	theLimiter = &masterLimiterStage{}
 
-func (p *Player) loopEndInSecond() float64 {
-	return float64(p.introSample+p.loopSample) / sampleRate
+	// theOpusDecodes and theFLACDecodes are the WAV files decoded from the played Opus and FLAC files.
+	theOpusDecodes = newExternalDecodes(decodeOpusToWAV)
+	theFLACDecodes = newExternalDecodes(decodeFLACToWAV)
+
+	// thePreview is the chain of the preview processing applied to the playback.
+	thePreview = &previewChain{}
+
+	// theAnalyses is the cache of the analyses of the opened files.
+	theAnalyses = newAnalysisCache()
+)
+
+func init() {
+	flag.Var(&theTagMode, "tags", "how to treat malformed loop tags: lenient (ignore), autocorrect or strict (refuse to play)")
+	flag.Var(&theWaveform, "waveform", "how the waveform shows the channels: sum, split (L/R) or midside (mid/side), switched with Shift+W")
+	flag.Var(theInserts, "inserts", "effects inserted in the playback for the session, e.g. gain:-6,highpass:80,lowpass:8000,limiter:-1 (saved in snapshots)")
+	flag.Var(&theSnap, "snap", "where the loop markers snap to when dragged or set at the playhead: off, zero (zero crossings of the mix), zero-left, zero-right, beat or bar, switched with Shift+Z")
+	flag.Var(&theTimeDisplay, "time", "how the current time and the loop points are shown: mmss, millis (mm:ss.mmm) or samples, switched with Shift+X")
+	flag.Var(&theTransport, "transport", "keys controlling the playback: space (Space toggles Play/Pause) or jkl (J/K/L as in editors, and Space)")
 }
 
-func (p *Player) update() error {
-	select {
-	case p.seBytes = <-p.seCh:
-		close(p.seCh)
-		p.seCh = nil
-	default:
+func main() {
+	flag.Parse()
+	theJobs = newJobQueue(*flagJobs)
+	thePreview.Add(theTranspose)
+	thePreview.Add(theStretch)
+	thePreview.Add(theMidSide)
+	thePreview.Add(theChannels)
+	thePreview.Add(thePan)
+	thePreview.Add(theInserts)
+	thePreview.Add(theLimiter)
+	thePCMCache = newPCMCache(int64(*flagPCMCache) * 1024 * 1024)
+	defer removeDecodedWAVs()
+
+	if *flagProfile != "" {
+		p, err := loadValidationProfile(*flagProfile)
+		if err != nil {
+			fatal(err)
+		}
+		theProfile = p
 	}
-
-	if p.audioPlayer.IsPlaying() {
-		curentSample := int64(p.audioPlayer.Current() * sampleRate / time.Second)
-		newSample := curentSample
-		if curentSample > p.introSample {
-			newSample = (curentSample-p.introSample)%p.loopSample + p.introSample
+	if *flagRules != "" {
+		r, err := loadCheckRules(*flagRules)
+		if err != nil {
+			fatal(err)
 		}
-		p.current = (time.Duration(newSample) * time.Second) / time.Duration(sampleRate)
+		theRules = r
 	}
-	p.seekBarIfNeeded()
-	p.switchPlayStateIfNeeded()
-	p.updateVolumeIfNeeded()
 
-	return nil
-}
-
-func (p *Player) updateVolumeIfNeeded() {
-	if ebiten.IsKeyPressed(ebiten.KeyZ) {
-		p.volume128--
+	settingsFile, err := settingsPath()
+	if err != nil {
+		fatal(err)
 	}
-	if ebiten.IsKeyPressed(ebiten.KeyX) {
-		p.volume128++
+	if s, err := loadSettings(settingsFile); err != nil {
+		// Broken settings shouldn't prevent the app from starting.
+		log.Printf("settings error: %s, %v", settingsFile, err)
+	} else {
+		theSettings = s
 	}
-	if p.volume128 < 0 {
-		p.volume128 = 0
+	// -preview and -startup on the command line override the saved ones, and are saved.
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	if given["preview"] {
+		theSettings.Preview = *flagPreview
+	} else {
+		*flagPreview = theSettings.Preview
 	}
-	if 128 < p.volume128 {
-		p.volume128 = 128
+	if given["startup"] {
+		theSettings.Startup = *flagStartup
+	}
+	start, err := parseStartup(theSettings.Startup)
+	if err != nil {
+		if given["startup"] {
+			fatal(err)
+		}
+		log.Printf("settings error: %s, %v", settingsFile, err)
 	}
-	p.audioPlayer.SetVolume(float64(p.volume128) / 128)
-}
 
-func (p *Player) switchPlayStateIfNeeded() {
-	if !inpututil.IsKeyJustPressed(ebiten.KeySpace) {
-		return
+	var snapshotFile string
+	var snap *snapshot
+	if *flagSnapshot != "" {
+		path, err := snapshotPath(*flagSnapshot)
+		if err != nil {
+			fatal(err)
+		}
+		s, err := loadSnapshot(path)
+		if err != nil {
+			fatal(err)
+		}
+		snapshotFile = path
+		snap = s
 	}
-	if p.audioPlayer.IsPlaying() {
-		p.audioPlayer.Pause()
+
+	if *flagCheck != "" {
+		failed, err := runCheck(*flagCheck, os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		if failed {
+			exit(1)
+		}
 		return
 	}
-	p.audioPlayer.Play()
-}
 
-func (p *Player) seekBarIfNeeded() {
-	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+	if *flagPNG != "" {
+		if err := runWaveformPNGs(*flagPNG, flag.Args()); err != nil {
+			fatal(err)
+		}
 		return
 	}
 
-	// Calculate the next seeking position from the current cursor position.
-	x, y := ebiten.CursorPosition()
-	bx, by, bw, bh := playerBarRect()
-	const padding = 4
-	if y < by-padding || by+bh+padding <= y {
+	if *flagState {
+		if err := runState(flag.Args(), os.Stdout); err != nil {
+			fatal(err)
+		}
 		return
 	}
-	if x < bx || bx+bw <= x {
+
+	if flag.Arg(0) == "soak" {
+		failed, err := runSoak(flag.Args()[1:], os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		if failed {
+			exit(1)
+		}
 		return
 	}
-	pos := time.Duration(x-bx) * p.total / time.Duration(bw)
-	p.current = pos
-	p.audioPlayer.Seek(pos)
-}
-
-func (p *Player) draw(screen *ebiten.Image) {
-	// Draw the bar.
-	x, y, w, h := playerBarRect()
-	ebitenutil.DrawRect(screen, float64(x), float64(y), float64(w), float64(h), playerBarColor)
-
-	// Draw the cursor on the bar.
-	c := p.current
-	cw, ch := 4, 10
-	cx := int(time.Duration(w)*c/p.total) + x - cw/2
-	cy := y - (ch-h)/2
-	ebitenutil.DrawRect(screen, float64(cx), float64(cy), float64(cw), float64(ch), playerCurrentColor)
-
-	// Compose the curren time text.
-	m := (c / time.Minute) % 100
-	s := (c / time.Second) % 60
-	currentTimeStr := fmt.Sprintf("%02d:%02d", m, s)
-
-	// Draw the loop start on the bar.
-	cx = int((time.Duration(w*int(p.introSample)/sampleRate)*time.Second)/p.total) + x - cw/2
-	ebitenutil.DrawRect(screen, float64(cx), float64(cy), float64(cw), float64(ch), loopCursorColor)
-
-	// Draw the loop end on the bar.
-	cx = int((time.Duration(w*int(p.introSample+p.loopSample)/sampleRate)*time.Second)/p.total) + x - cw/2
-	ebitenutil.DrawRect(screen, float64(cx), float64(cy), float64(cw), float64(ch), loopCursorColor)
-
-	loopStartStr := fmt.Sprintf("%02d:%02d", int(p.loopStartInSecond())/60, int(p.loopStartInSecond())%60)
-	loopEndStr := fmt.Sprintf("%02d:%02d", int(p.loopEndInSecond())/60, int(p.loopEndInSecond())%60)
-	// Draw the debug message.
-	msg := fmt.Sprintf(`Press Space to toggle Play/Pause
-Press Z or X to change volume of the music
-Current Volume: %d/128
-Loop Start: %s (%d)
-Loop End: %s (%d)
-Current Time: %s (%d)
-`, int(p.audioPlayer.Volume()*128), loopStartStr, p.introSample, loopEndStr, p.introSample+p.loopSample, currentTimeStr, (c*sampleRate)/time.Second)
-	ebitenutil.DebugPrint(screen, msg)
-}
-
-type Game struct {
-	audioContext  *audio.Context
-	musicPlayer   *Player
-	musicPlayerCh chan *Player
-	fileCh        chan string
-	errCh         chan error
-}
-
-func NewGame() (*Game, error) {
-	audioContext := audio.NewContext(sampleRate)
 
-	ebiten.SetRunnableOnUnfocused(true)
-
-	return &Game{
-		audioContext:  audioContext,
-		musicPlayer:   nil,
-		musicPlayerCh: make(chan *Player),
-		errCh:         make(chan error),
-	}, nil
-}
-
-func (g *Game) Update() error {
-	select {
-	case p := <-g.musicPlayerCh:
-		g.musicPlayer = p
-	case err := <-g.errCh:
-		return err
-	default:
+	if flag.Arg(0) == "looptest" {
+		failed, err := runLoopTest(flag.Args()[1:], os.Stdout)
+		if err != nil {
+			fatal(err)
+		}
+		if failed {
+			exit(1)
+		}
+		return
 	}
 
-	if g.musicPlayer != nil {
-		if err := g.musicPlayer.update(); err != nil {
-			return err
+	if flag.Arg(0) == "play" {
+		if err := runPlay(flag.Args()[1:]); err != nil {
+			fatal(err)
 		}
+		return
 	}
 
-	if err := g.openFileIfNeeded(); err != nil {
-		return err
+	if *flagTUI {
+		t, err := NewTUI(flag.Args())
+		if err != nil {
+			fatal(err)
+		}
+		t.presence = newDiscordPresence(*flagDiscord)
+		t.mediaKeys = newMediaKeys(*flagMediaKey)
+		t.history = newListeningHistory(*flagHistory)
+		t.m3uPath = *flagM3U
+		t.snapshotPath = snapshotFile
+		t.startup = start
+		if snap != nil {
+			if err := t.restore(snap); err != nil {
+				fatal(err)
+			}
+		}
+		if err := t.Run(); err != nil {
+			fatal(err)
+		}
+		if err := theSettings.save(settingsFile); err != nil {
+			fatal(err)
+		}
+		return
 	}
 
-	return nil
-}
-
-func (g *Game) openFile() {
-	filename, err := dialog.File().Filter("Ogg file", "ogg").Load()
-	if err != dialog.Cancelled {
-		g.fileCh <- filename
+	ebiten.SetWindowSize(screenWidth*2, screenHeight*2)
+	if w := theSettings.Window; w != nil && w.Width > 0 && w.Height > 0 {
+		ebiten.SetWindowSize(w.Width, w.Height)
+		ebiten.SetWindowPosition(w.X, w.Y)
 	}
-	g.fileCh <- ""
-}
-
-func (g *Game) openFileIfNeeded() error {
-	select {
-	case filename := <-g.fileCh:
-		if filename != "" {
-			fmt.Println("open ogg file", filename)
-			if g.musicPlayer != nil {
-				g.musicPlayer.Close()
-			}
-
-			m, err := NewPlayer(g.audioContext, filename)
-			if err != nil {
-				return err
-			}
-
-			g.musicPlayer = m
+	title := "Ogg Loop Checker"
+	if *flagReadOnly {
+		title += " (read-only)"
+	}
+	ebiten.SetWindowTitle(title)
+	g, err := NewGame()
+	if err != nil {
+		fatal(err)
+	}
+	g.presence = newDiscordPresence(*flagDiscord)
+	g.mediaKeys = newMediaKeys(*flagMediaKey)
+	g.history = newListeningHistory(*flagHistory)
+	if snap != nil {
+		if err := g.restore(snap); err != nil {
+			fatal(err)
 		}
-	default:
+	} else if flag.NArg() > 0 {
+		if err := g.openFiles(flag.Args()); err != nil {
+			fatal(err)
+		}
+	} else if err := g.start(start); err != nil {
+		fatal(err)
 	}
-
-	if !inpututil.IsKeyJustPressed(ebiten.KeyF) {
-		return nil
+	if err := ebiten.RunGame(g); err != nil {
+		fatal(err)
 	}
-	if g.musicPlayer != nil {
-		g.musicPlayer.Pause()
+	g.closeComparison()
+	g.crossfade.Close()
+	g.reference.Close()
+	if g.monitor != nil {
+		g.monitor.Close()
 	}
-
-	g.fileCh = make(chan string)
-	go g.openFile()
-
-	return nil
-}
-
-func (g *Game) Draw(screen *ebiten.Image) {
-	if g.musicPlayer == nil {
-		ebitenutil.DebugPrint(screen, `Press F to load an ogg file`)
-		return
+	if err := theSettings.save(settingsFile); err != nil {
+		fatal(err)
+	}
+	if snapshotFile != "" {
+		if err := takeSnapshot(g.playlist, g.musicPlayer).save(snapshotFile); err != nil {
+			fatal(err)
+		}
+	}
+	saveLastSession(g.playlist, g.musicPlayer)
+	if err := g.history.Record(g.musicPlayer); err != nil {
+		fatal(err)
 	}
-	g.musicPlayer.draw(screen)
 }
 
-func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
-	return screenWidth, screenHeight
+// fatal is like log.Fatal, and removes the decoded WAV files before exiting.
+func fatal(v ...interface{}) {
+	log.Print(v...)
+	exit(1)
 }
 
-func main() {
-	ebiten.SetWindowSize(screenWidth*2, screenHeight*2)
-	ebiten.SetWindowTitle("Ogg Loop Checker")
-	g, err := NewGame()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if err := ebiten.RunGame(g); err != nil {
-		log.Fatal(err)
-	}
+// exit is like os.Exit, and removes the decoded WAV files before exiting, as os.Exit skips the deferred calls.
+func exit(code int) {
+	removeDecodedWAVs()
+	os.Exit(code)
 }
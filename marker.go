@@ -0,0 +1,37 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// Marker is a labeled position in a file, e.g. a track boundary from a CUE sheet.
+type Marker struct {
+	Sample int64  `json:"sample"`
+	Label  string `json:"label"`
+}
+
+// markerSegment returns the range from the i-th marker to the next one, or to the end when it is the last.
+func markerSegment(markers []Marker, i int, totalSample int64) (start, end int64, ok bool) {
+	if i < 0 || len(markers) <= i {
+		return 0, 0, false
+	}
+	start = markers[i].Sample
+	end = totalSample
+	if i+1 < len(markers) {
+		end = markers[i+1].Sample
+	}
+	if start >= end || end > totalSample {
+		return 0, 0, false
+	}
+	return start, end, true
+}
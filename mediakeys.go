@@ -0,0 +1,143 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+// mediaAction is an action requested by the OS, e.g. with a media key of the keyboard.
+type mediaAction int
+
+const (
+	mediaPlayPause mediaAction = iota
+	mediaPlay
+	mediaPause
+	mediaStop
+	mediaNext
+	mediaPrev
+)
+
+// mediaStatus is the playback state told to the OS, e.g. for the media controls of the desktop.
+type mediaStatus struct {
+	// status is "Playing", "Paused" or "Stopped" as in MPRIS.
+	status string
+	title  string
+}
+
+// mediaKeys receives the media keys while the window is not focused: with MPRIS on Linux and with hot keys on Windows.
+//
+// A nil *mediaKeys is valid and does nothing, so callers don't have to check whether the feature is enabled.
+type mediaKeys struct {
+	ch chan mediaAction
+
+	m       sync.Mutex
+	status  mediaStatus
+	changed chan struct{}
+}
+
+func newMediaKeys(enabled bool) *mediaKeys {
+	if !enabled {
+		return nil
+	}
+	m := &mediaKeys{
+		ch:      make(chan mediaAction, 8),
+		status:  mediaStatus{status: "Stopped"},
+		changed: make(chan struct{}, 1),
+	}
+	go func() {
+		if err := listenMediaKeys(m); err != nil {
+			log.Printf("media keys: %v", err)
+		}
+	}()
+	return m
+}
+
+// C returns the channel of the requested actions. C returns nil for a nil *mediaKeys, which blocks forever in select.
+func (m *mediaKeys) C() <-chan mediaAction {
+	if m == nil {
+		return nil
+	}
+	return m.ch
+}
+
+// send is called by the listener. An action is dropped when the player is too busy to take it.
+func (m *mediaKeys) send(a mediaAction) {
+	select {
+	case m.ch <- a:
+	default:
+	}
+}
+
+// Update tells the state of p to the OS. p can be nil when no file is open.
+// Update is cheap when nothing changes, so it can be called every frame.
+func (m *mediaKeys) Update(p *Player) {
+	if m == nil {
+		return
+	}
+	s := mediaStatus{status: "Stopped"}
+	if p != nil {
+		s.title = filepath.Base(p.path)
+		s.status = "Paused"
+		if p.IsPlaying() {
+			s.status = "Playing"
+		}
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+	if s == m.status {
+		return
+	}
+	m.status = s
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// currentStatus returns the state last given to Update.
+func (m *mediaKeys) currentStatus() mediaStatus {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.status
+}
+
+// applyMediaAction applies a to p. next and prev move in the playlist.
+func applyMediaAction(a mediaAction, p *Player, next, prev func()) {
+	switch a {
+	case mediaNext:
+		next()
+		return
+	case mediaPrev:
+		prev()
+		return
+	}
+	if p == nil {
+		return
+	}
+	switch a {
+	case mediaPlayPause:
+		p.TogglePlay()
+	case mediaPlay:
+		if !p.IsPlaying() {
+			p.TogglePlay()
+		}
+	case mediaPause, mediaStop:
+		p.Pause()
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+const (
+	mprisPath       = "/org/mpris/MediaPlayer2"
+	mprisRoot       = "org.mpris.MediaPlayer2"
+	mprisPlayer     = "org.mpris.MediaPlayer2.Player"
+	dbusProperties  = "org.freedesktop.DBus.Properties"
+	dbusPeer        = "org.freedesktop.DBus.Peer"
+	mprisTrackID    = "/org/odencat/oggplayer/track"
+	mprisNoTrackID  = "/org/mpris/MediaPlayer2/TrackList/NoTrack"
+	dbusDoNotQueue  = 0x4
+	dbusBusName     = "org.freedesktop.DBus"
+	dbusBusPath     = "/org/freedesktop/DBus"
+	mprisNamePrefix = "org.mpris.MediaPlayer2.oggplayer"
+)
+
+// mprisActions are the methods of MPRIS's Player interface which the media keys of the desktop call.
+var mprisActions = map[string]mediaAction{
+	"PlayPause": mediaPlayPause,
+	"Play":      mediaPlay,
+	"Pause":     mediaPause,
+	"Stop":      mediaStop,
+	"Next":      mediaNext,
+	"Previous":  mediaPrev,
+}
+
+// mprisServer serves MPRIS on the session bus, so that the desktop's media keys and tools like playerctl control oggplayer.
+// See https://specifications.freedesktop.org/mpris-spec/latest/.
+type mprisServer struct {
+	keys *mediaKeys
+	conn net.Conn
+
+	// m guards the writes and serial.
+	m      sync.Mutex
+	serial uint32
+}
+
+// listenMediaKeys serves MPRIS until the connection to the session bus breaks.
+func listenMediaKeys(keys *mediaKeys) error {
+	conn, r, err := dialSessionBus()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s := &mprisServer{keys: keys, conn: conn}
+	if err := s.call("Hello"); err != nil {
+		return err
+	}
+	// The instance suffix lets several oggplayers run at once, as MPRIS allows.
+	name := fmt.Sprintf("%s.instance%d", mprisNamePrefix, os.Getpid())
+	if err := s.call("RequestName", name, uint32(dbusDoNotQueue)); err != nil {
+		return err
+	}
+	go s.notify()
+
+	for {
+		msg, err := readDBusMessage(r)
+		if err != nil {
+			return err
+		}
+		// The replies to Hello and RequestName, and the signals, are not needed.
+		if msg.typ != dbusMethodCall {
+			continue
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *mprisServer) send(msg *dbusMessage) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.serial++
+	msg.serial = s.serial
+	_, err := s.conn.Write(msg.marshal())
+	return err
+}
+
+// call calls a method of the bus without waiting for the reply.
+func (s *mprisServer) call(member string, args ...interface{}) error {
+	return s.send(&dbusMessage{
+		typ:         dbusMethodCall,
+		path:        dbusBusPath,
+		iface:       dbusBusName,
+		member:      member,
+		destination: dbusBusName,
+		body:        args,
+	})
+}
+
+func (s *mprisServer) reply(call *dbusMessage, args ...interface{}) error {
+	if call.flags&dbusNoReplyExpected != 0 {
+		return nil
+	}
+	return s.send(&dbusMessage{
+		typ:         dbusMethodReturn,
+		replySerial: call.serial,
+		destination: call.sender,
+		body:        args,
+	})
+}
+
+func (s *mprisServer) replyError(call *dbusMessage, name, text string) error {
+	if call.flags&dbusNoReplyExpected != 0 {
+		return nil
+	}
+	return s.send(&dbusMessage{
+		typ:         dbusError,
+		errorName:   name,
+		replySerial: call.serial,
+		destination: call.sender,
+		body:        []interface{}{text},
+	})
+}
+
+func (s *mprisServer) handle(call *dbusMessage) error {
+	if call.iface == dbusPeer {
+		// Ping and GetMachineId. The machine ID is not needed by MPRIS clients.
+		return s.reply(call)
+	}
+	if call.path != mprisPath {
+		return s.replyError(call, "org.freedesktop.DBus.Error.UnknownObject", "unknown object "+call.path)
+	}
+	switch call.iface {
+	case mprisPlayer:
+		if a, ok := mprisActions[call.member]; ok {
+			s.keys.send(a)
+			return s.reply(call)
+		}
+		switch call.member {
+		case "Seek", "SetPosition", "OpenUri":
+			// These do nothing, as CanSeek tells.
+			return s.reply(call)
+		}
+	case mprisRoot:
+		switch call.member {
+		case "Raise", "Quit":
+			// These do nothing, as CanRaise and CanQuit tell.
+			return s.reply(call)
+		}
+	case dbusProperties:
+		args := call.stringArgs()
+		switch call.member {
+		case "Get":
+			if len(args) == 2 {
+				for _, p := range s.properties(args[0]) {
+					if p.key == args[1] {
+						return s.reply(call, dbusVariant{p.value})
+					}
+				}
+			}
+			return s.replyError(call, "org.freedesktop.DBus.Error.InvalidArgs", "unknown property")
+		case "GetAll":
+			var iface string
+			if len(args) > 0 {
+				iface = args[0]
+			}
+			return s.reply(call, s.properties(iface))
+		case "Set":
+			// No properties are writable.
+			return s.reply(call)
+		}
+	}
+	return s.replyError(call, "org.freedesktop.DBus.Error.UnknownMethod", "unknown method "+call.iface+"."+call.member)
+}
+
+// properties returns the properties of the MPRIS interface iface.
+func (s *mprisServer) properties(iface string) []dbusEntry {
+	switch iface {
+	case mprisRoot:
+		return []dbusEntry{
+			{"CanQuit", false},
+			{"CanRaise", false},
+			{"HasTrackList", false},
+			{"Identity", "oggplayer"},
+			{"SupportedUriSchemes", []string{}},
+			{"SupportedMimeTypes", []string{}},
+		}
+	case mprisPlayer:
+		st := s.keys.currentStatus()
+		metadata := []dbusEntry{{"mpris:trackid", dbusObjectPath(mprisNoTrackID)}}
+		if st.title != "" {
+			metadata = []dbusEntry{
+				{"mpris:trackid", dbusObjectPath(mprisTrackID)},
+				{"xesam:title", st.title},
+			}
+		}
+		opened := st.status != "Stopped"
+		return []dbusEntry{
+			{"PlaybackStatus", st.status},
+			{"Metadata", metadata},
+			{"Rate", 1.0},
+			{"MinimumRate", 1.0},
+			{"MaximumRate", 1.0},
+			{"CanGoNext", true},
+			{"CanGoPrevious", true},
+			{"CanPlay", opened},
+			{"CanPause", opened},
+			{"CanSeek", false},
+			{"CanControl", true},
+		}
+	}
+	return nil
+}
+
+// notify emits PropertiesChanged when the state changes, so that the desktop's media controls follow it.
+func (s *mprisServer) notify() {
+	for range s.keys.changed {
+		if err := s.send(&dbusMessage{
+			typ:    dbusSignal,
+			path:   mprisPath,
+			iface:  dbusProperties,
+			member: "PropertiesChanged",
+			body:   []interface{}{mprisPlayer, s.properties(mprisPlayer), []string{}},
+		}); err != nil {
+			return
+		}
+	}
+}
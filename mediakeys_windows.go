@@ -0,0 +1,87 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	user32             = syscall.NewLazyDLL("user32.dll")
+	procRegisterHotKey = user32.NewProc("RegisterHotKey")
+	procGetMessageW    = user32.NewProc("GetMessageW")
+)
+
+const wmHotKey = 0x0312
+
+// mediaVirtualKeys are the virtual key codes of the media keys.
+var mediaVirtualKeys = map[mediaAction]uintptr{
+	mediaNext:      0xb0, // VK_MEDIA_NEXT_TRACK
+	mediaPrev:      0xb1, // VK_MEDIA_PREV_TRACK
+	mediaStop:      0xb2, // VK_MEDIA_STOP
+	mediaPlayPause: 0xb3, // VK_MEDIA_PLAY_PAUSE
+}
+
+// msg is MSG of Win32.
+type msg struct {
+	hwnd     uintptr
+	message  uint32
+	wParam   uintptr
+	lParam   uintptr
+	time     uint32
+	ptX      int32
+	ptY      int32
+	lPrivate uint32
+}
+
+// listenMediaKeys registers the media keys as hot keys, which works without focus.
+// The keys are taken from the other applications, e.g. music players, while oggplayer runs.
+// The hot keys are posted to the thread registering them, so this locks the goroutine to the thread and never returns on success.
+func listenMediaKeys(m *mediaKeys) error {
+	runtime.LockOSThread()
+
+	var registered int
+	for a, vk := range mediaVirtualKeys {
+		// The ID of a hot key is the action.
+		if r, _, err := procRegisterHotKey.Call(0, uintptr(a), 0, vk); r == 0 {
+			// Another application may have registered the key already.
+			log.Printf("media keys: registering the hot key 0x%x failed: %v", vk, err)
+			continue
+		}
+		registered++
+	}
+	if registered == 0 {
+		return fmt.Errorf("no media keys are registered")
+	}
+
+	var ms msg
+	for {
+		r, _, err := procGetMessageW.Call(uintptr(unsafe.Pointer(&ms)), 0, 0, 0)
+		switch int32(r) {
+		case -1:
+			return err
+		case 0:
+			// WM_QUIT
+			return nil
+		}
+		if ms.message == wmHotKey {
+			m.send(mediaAction(ms.wParam))
+		}
+	}
+}
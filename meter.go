@@ -0,0 +1,126 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// levelMeter is a stream that records the peak levels of the PCM passing through it.
+//
+// Read is called from the audio goroutine, so the levels are guarded by a mutex.
+type levelMeter struct {
+	src io.ReadSeeker
+
+	left  float64
+	right float64
+
+	// headroom is the lowest headroom of the reads since Headroom was called last.
+	headroom    float64
+	hasHeadroom bool
+
+	// frames is the number of the samples per channel read in total, and lateReads is the number of the reads
+	// that took longer than the PCM they returned lasts, which can starve the audio device.
+	frames    int64
+	lateReads int
+
+	m sync.Mutex
+}
+
+func newLevelMeter(src io.ReadSeeker) *levelMeter {
+	return &levelMeter{
+		src: src,
+	}
+}
+
+func (l *levelMeter) Read(buf []byte) (int, error) {
+	start := time.Now()
+	n, err := l.src.Read(buf)
+	elapsed := time.Since(start)
+
+	var left, right float64
+	for i := 0; i+bytesPerSample <= n; i += bytesPerSample {
+		lv := absSample(int16(buf[i]) | int16(buf[i+1])<<8)
+		rv := absSample(int16(buf[i+2]) | int16(buf[i+3])<<8)
+		if left < lv {
+			left = lv
+		}
+		if right < rv {
+			right = rv
+		}
+	}
+
+	l.m.Lock()
+	if l.left < left {
+		l.left = left
+	}
+	if l.right < right {
+		l.right = right
+	}
+	if n >= bytesPerSample {
+		// The headroom is the part of the time the read PCM lasts that is not spent on decoding it.
+		h := 1 - float64(elapsed)/float64(samplesToDuration(int64(n/bytesPerSample)))
+		if !l.hasHeadroom || h < l.headroom {
+			l.headroom = h
+			l.hasHeadroom = true
+		}
+		if h < 0 {
+			l.lateReads++
+		}
+	}
+	l.frames += int64(n / bytesPerSample)
+	l.m.Unlock()
+
+	return n, err
+}
+
+func (l *levelMeter) Seek(offset int64, whence int) (int64, error) {
+	return l.src.Seek(offset, whence)
+}
+
+// Peaks returns the peak levels in [0, 1] read since the last call, so that no peak is missed between the calls.
+func (l *levelMeter) Peaks() (left, right float64) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	left, right = l.left, l.right
+	l.left, l.right = 0, 0
+	return left, right
+}
+
+// Headroom returns the lowest headroom of the audio callbacks since the last call, e.g. 0.9 when
+// decoding took 10 % of the time the PCM lasts. ok is false when nothing has been read since then.
+func (l *levelMeter) Headroom() (headroom float64, ok bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	headroom, ok = l.headroom, l.hasHeadroom
+	l.hasHeadroom = false
+	return headroom, ok
+}
+
+// Counts returns the number of the samples read in total and the number of the late reads.
+func (l *levelMeter) Counts() (frames int64, lateReads int) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.frames, l.lateReads
+}
+
+func absSample(v int16) float64 {
+	if v < 0 {
+		return -float64(v) / 32768
+	}
+	return float64(v) / 32768
+}
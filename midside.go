@@ -0,0 +1,78 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+)
+
+// midSideMode is which part of the stereo signal the mid/side audition plays.
+type midSideMode int
+
+const (
+	midSideOff midSideMode = iota
+
+	// midSideMid plays only the mid, the sum of the channels.
+	midSideMid
+
+	// midSideSide plays only the side, the difference of the channels, where the stereo width is.
+	midSideSide
+
+	midSideModes
+)
+
+// midSideStage plays only the mid or the side of the playback in both channels,
+// so that a change of the stereo width across the loop seam can be heard in isolation.
+type midSideStage struct {
+	mode midSideMode
+	m    sync.Mutex
+}
+
+func (s *midSideStage) Name() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.mode == midSideSide {
+		return "Side only"
+	}
+	return "Mid only"
+}
+
+func (s *midSideStage) Active() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.mode != midSideOff
+}
+
+func (s *midSideStage) Process(pcm []int16) {
+	s.m.Lock()
+	mode := s.mode
+	s.m.Unlock()
+	for i := 0; i+1 < len(pcm); i += 2 {
+		l, r := int32(pcm[i]), int32(pcm[i+1])
+		v := (l + r) / 2
+		if mode == midSideSide {
+			v = (l - r) / 2
+		}
+		pcm[i] = int16(v)
+		pcm[i+1] = int16(v)
+	}
+}
+
+// Next switches to the mid, the side and back to the stereo in turn.
+func (s *midSideStage) Next() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.mode = (s.mode + 1) % midSideModes
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// miniScreenHeight is the height of the screen in the mini mode, which shows only the transport, the time and the loop.
+const miniScreenHeight = 40
+
+// toggleMiniIfNeeded switches the mini mode with Ctrl+M.
+// The mini window floats on top so that the looping reference stays audible and visible next to a DAW.
+func (g *Game) toggleMiniIfNeeded() {
+	if g.presenting || !isControlPressed() || !inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		return
+	}
+	g.mini = !g.mini
+	ebiten.SetWindowFloating(g.mini)
+	if g.mini {
+		g.windowWidth, g.windowHeight = ebiten.WindowSize()
+		ebiten.SetWindowSize(screenWidth*2, miniScreenHeight*2)
+		return
+	}
+	ebiten.SetWindowSize(g.windowWidth, g.windowHeight)
+}
+
+// rememberWindow keeps the position and the size of the window in the settings.
+// The small window of the mini mode is not kept, but the size to go back to is.
+// Likewise, the position to go back to from the presentation mode is kept.
+func (g *Game) rememberWindow() {
+	x, y := ebiten.WindowPosition()
+	w, h := ebiten.WindowSize()
+	if g.mini {
+		w, h = g.windowWidth, g.windowHeight
+	}
+	if g.presenting {
+		x, y = g.windowX, g.windowY
+	}
+	ws := windowSettings{X: x, Y: y, Width: w, Height: h}
+	if theSettings.Window == nil || *theSettings.Window != ws {
+		theSettings.Window = &ws
+	}
+}
+
+// drawMini draws the mini mode: the playing state, the time, the loop and the bar.
+func (g *Game) drawMini(screen *ebiten.Image) {
+	p := g.musicPlayer
+	if p == nil {
+		ebitenutil.DebugPrint(screen, "No file (Ctrl+M: exit mini mode)")
+		return
+	}
+
+	state := "Paused"
+	if p.IsPlaying() {
+		state = "Playing"
+		if l := p.shuttleLabel(); l != "" {
+			state = l
+		}
+	}
+	loop := "No loop"
+	if p.loopSample > 0 {
+		loop = fmt.Sprintf("Loop %s - %s", formatTime(samplesToDuration(p.introSample)), formatTime(samplesToDuration(p.introSample+p.loopSample)))
+		if p.loopSource != "" {
+			loop += " (" + p.loopSource + ")"
+		}
+	}
+	if names, bypassed := thePreview.ActiveNames(); len(names) > 0 && !bypassed {
+		state += " [Processed]"
+	}
+	ebitenutil.DebugPrint(screen, fmt.Sprintf("%s %s / %s\n%s", state, formatTime(p.current), formatTime(p.total), loop))
+
+	b := &g.shapes
+	b.begin(screen)
+	defer b.Flush()
+
+	const (
+		x = 10
+		w = screenWidth - 2*x
+		y = miniScreenHeight - 6
+		h = 2
+	)
+	b.Rect(x, y, w, h, playerBarColor)
+	if p.loopSample > 0 {
+		for _, s := range []int64{p.introSample, p.introSample + p.loopSample} {
+			lx := x + int(int64(w)*s/p.totalSample)
+			b.Rect(float64(lx), y-2, 1, h+4, loopCursorColor)
+		}
+	}
+	cx := x + int(time.Duration(w)*p.current/p.total)
+	b.Rect(float64(cx-1), y-2, 2, h+4, playerCurrentColor)
+}
@@ -0,0 +1,173 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+const (
+	// monitorBufferSize is the audio player's buffer of the input monitor, smaller than the music's to keep the latency low.
+	monitorBufferSize = 20 * time.Millisecond
+
+	// monitorQueueLimit bounds the queued input in bytes. The queue grows when the capture's clock is faster than the output's,
+	// and the oldest input is dropped then so that the latency doesn't grow.
+	monitorQueueLimit = sampleRate / 10 * bytesPerSample
+)
+
+// monitorQueue passes the captured input to the audio player.
+// Read never blocks and fills the missing input with silence, so that a slow capture doesn't stall the output.
+type monitorQueue struct {
+	m   sync.Mutex
+	buf []byte
+}
+
+func (q *monitorQueue) Write(b []byte) (int, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.buf = append(q.buf, b...)
+	if over := len(q.buf) - monitorQueueLimit; over > 0 {
+		over += (bytesPerSample - over%bytesPerSample) % bytesPerSample
+		q.buf = append(q.buf[:0], q.buf[over:]...)
+	}
+	return len(b), nil
+}
+
+func (q *monitorQueue) Read(b []byte) (int, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	// Keep a partially written sample for the next read not to shift the channels.
+	n := len(q.buf)
+	if n > len(b) {
+		n = len(b)
+	}
+	n -= n % bytesPerSample
+	copy(b, q.buf[:n])
+	q.buf = append(q.buf[:0], q.buf[n:]...)
+	for i := n; i < len(b); i++ {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// queued returns the duration of the input waiting to be played.
+func (q *monitorQueue) queued() time.Duration {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return samplesToDuration(int64(len(q.buf) / bytesPerSample))
+}
+
+// inputMonitor plays the input of a capture command, e.g. a microphone or a line input, mixed with the music,
+// so that a composer can hum or play along across the loop seam.
+type inputMonitor struct {
+	cmd    *exec.Cmd
+	player *audio.Player
+	queue  *monitorQueue
+
+	// m guards err.
+	m   sync.Mutex
+	err error
+}
+
+// startInputMonitor runs command, which writes the input as 16-bit little-endian stereo PCM at 48000 Hz to stdout,
+// and plays it. The arguments in command are separated by spaces.
+func startInputMonitor(audioContext *audio.Context, command string) (*inputMonitor, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("oggplayer: no input monitor command is given with -monitor")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	m := &inputMonitor{
+		cmd:   cmd,
+		queue: &monitorQueue{},
+	}
+	player, err := audio.NewPlayer(audioContext, m.queue)
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+	player.SetBufferSize(monitorBufferSize)
+	player.Play()
+	m.player = player
+
+	go func() {
+		_, err := io.Copy(m.queue, out)
+		if werr := cmd.Wait(); err == nil {
+			err = werr
+		}
+		if err == nil {
+			err = fmt.Errorf("oggplayer: the input monitor command exited")
+		}
+		m.m.Lock()
+		m.err = err
+		m.m.Unlock()
+	}()
+	return m, nil
+}
+
+// Err returns the error when the capture command stopped.
+func (m *inputMonitor) Err() error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.err
+}
+
+// Latency returns the estimated latency between the capture command's output and the speakers.
+// The capture command's own buffering is not included.
+func (m *inputMonitor) Latency() time.Duration {
+	return monitorBufferSize + m.queue.queued()
+}
+
+// Close stops the capture command and the playing.
+func (m *inputMonitor) Close() error {
+	err := m.player.Close()
+	// The copying goroutine waits for the command.
+	if kerr := m.cmd.Process.Kill(); err == nil && m.Err() == nil {
+		err = kerr
+	}
+	return err
+}
+
+// line returns the status of the monitor for the help text.
+func (m *inputMonitor) line() string {
+	if err := m.Err(); err != nil {
+		return fmt.Sprintf("Input monitor: stopped: %v", err)
+	}
+	return fmt.Sprintf("Input monitor: on (latency %d ms)", m.Latency().Milliseconds())
+}
+
+// toggleInputMonitor starts the input monitor when m is nil, or stops m. toggleInputMonitor returns the new monitor or nil.
+func toggleInputMonitor(audioContext *audio.Context, m *inputMonitor) (*inputMonitor, error) {
+	if m != nil {
+		return nil, m.Close()
+	}
+	return startInputMonitor(audioContext, *flagMonitor)
+}
@@ -0,0 +1,415 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const id3Magic = "ID3"
+
+// isMP3Stream reports whether r starts with an ID3v2 tag or an MPEG audio frame. The position of r is restored to the start.
+func isMP3Stream(r io.ReadSeeker) (bool, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	var magic [3]byte
+	_, err := io.ReadFull(r, magic[:])
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(magic[:]) == id3Magic || (magic[0] == 0xff && magic[1]&0xe0 == 0xe0), nil
+}
+
+// readMP3Info reads the format information from the first frame of an MP3 file.
+// The TXXX frames of the ID3v2 tag are given as the comments, so that LOOPSTART and LOOPLENGTH are read as the loop tags.
+// The length is left unknown, as an MP3 file has no exact length.
+func readMP3Info(r io.ReadSeeker) (*fileInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	info := &fileInfo{
+		comments:        &vorbisComments{},
+		granulePosition: -1,
+	}
+
+	if head, err := br.Peek(10); err == nil && string(head[:3]) == id3Magic {
+		size := 10 + int(syncsafe(head[6:10]))
+		// The footer has the same size as the header.
+		if head[5]&0x10 != 0 {
+			size += 10
+		}
+		tag := make([]byte, size)
+		if _, err := io.ReadFull(br, tag); err != nil {
+			return nil, fmt.Errorf("mp3: %w", err)
+		}
+		readID3TXXX(tag, info.comments)
+	}
+
+	// Find the first frame. Some files have garbage before it.
+	const maxSearch = 64 * 1024
+	for i := 0; i < maxSearch; i++ {
+		b, err := br.Peek(4)
+		if err != nil {
+			return nil, fmt.Errorf("mp3: no MPEG audio frame is found")
+		}
+		if ok, err := readMP3FrameHeader(binary.BigEndian.Uint32(b), info); ok {
+			if err != nil {
+				return nil, err
+			}
+			return info, nil
+		}
+		br.Discard(1)
+	}
+	return nil, fmt.Errorf("mp3: no MPEG audio frame is found")
+}
+
+var (
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{},                    // Reserved
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+	mp3Bitrates = [2][15]int{
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},     // MPEG 2 and 2.5
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}, // MPEG 1
+	}
+)
+
+// readMP3FrameHeader reads the MPEG audio frame header h into info, and reports whether h is a valid header.
+// http://www.mp3-tech.org/programmer/frame_header.html
+func readMP3FrameHeader(h uint32, info *fileInfo) (bool, error) {
+	version := (h >> 19) & 3
+	layer := (h >> 17) & 3
+	bitrate := (h >> 12) & 0xf
+	rate := (h >> 10) & 3
+	if h>>21 != 0x7ff || version == 1 || layer == 0 || bitrate == 0xf || rate == 3 {
+		return false, nil
+	}
+	if layer != 1 {
+		return true, fmt.Errorf("mp3: only MPEG Layer III is supported")
+	}
+	info.sampleRate = mp3SampleRates[version][rate]
+	info.channels = 2
+	if (h>>6)&3 == 3 {
+		info.channels = 1
+	}
+	table := 0
+	if version == 3 {
+		table = 1
+	}
+	info.nominalBitrate = mp3Bitrates[table][bitrate] * 1000
+	return true, nil
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// readID3TXXX adds the TXXX frames of the ID3v2.3 or ID3v2.4 tag to c as DESCRIPTION=VALUE.
+// The compressed and encrypted frames are skipped.
+func readID3TXXX(tag []byte, c *vorbisComments) {
+	major := tag[3]
+	if major != 3 && major != 4 {
+		return
+	}
+	pos := 10
+	// Skip the extended header.
+	if tag[5]&0x40 != 0 && len(tag) >= 14 {
+		if major == 4 {
+			pos += int(syncsafe(tag[10:14]))
+		} else {
+			pos += 4 + int(binary.BigEndian.Uint32(tag[10:14]))
+		}
+	}
+	for pos+10 <= len(tag) && tag[pos] != 0 {
+		id := string(tag[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(tag[pos+4 : pos+8]))
+		if major == 4 {
+			size = int(syncsafe(tag[pos+4 : pos+8]))
+		}
+		flags := binary.BigEndian.Uint16(tag[pos+8 : pos+10])
+		pos += 10
+		if size < 0 || pos+size > len(tag) {
+			return
+		}
+		body := tag[pos : pos+size]
+		pos += size
+
+		if id != "TXXX" {
+			continue
+		}
+		if major == 3 && flags&0x00c0 != 0 || major == 4 && flags&0x000c != 0 {
+			continue
+		}
+		// The data length indicator of ID3v2.4.
+		if major == 4 && flags&0x0001 != 0 {
+			if len(body) < 4 {
+				continue
+			}
+			body = body[4:]
+		}
+		if len(body) < 1 {
+			continue
+		}
+		desc, val := splitID3Text(body[0], body[1:])
+		if desc != "" {
+			c.comments = append(c.comments, desc+"="+val)
+		}
+	}
+}
+
+// splitID3Text decodes the description and the value of a TXXX frame in the text encoding enc.
+func splitID3Text(enc byte, b []byte) (desc, val string) {
+	wide := enc == 1 || enc == 2
+	term := 1
+	if wide {
+		term = 2
+	}
+	i := 0
+	for ; i+term <= len(b); i += term {
+		if b[i] == 0 && (!wide || b[i+1] == 0) {
+			break
+		}
+	}
+	if i+term > len(b) {
+		return decodeID3Text(enc, b), ""
+	}
+	return decodeID3Text(enc, b[:i]), decodeID3Text(enc, b[i+term:])
+}
+
+// decodeID3Text decodes b in the ID3 text encoding enc: ISO-8859-1 (0), UTF-16 with BOM (1), UTF-16BE (2) or UTF-8 (3).
+func decodeID3Text(enc byte, b []byte) string {
+	switch enc {
+	case 0:
+		rs := make([]rune, 0, len(b))
+		for _, c := range b {
+			rs = append(rs, rune(c))
+		}
+		return strings.TrimRight(string(rs), "\x00")
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if len(b) >= 2 && b[0] == 0xff && b[1] == 0xfe {
+			order = binary.LittleEndian
+			b = b[2:]
+		} else if len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff {
+			b = b[2:]
+		}
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = order.Uint16(b[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	default:
+		return strings.TrimRight(string(b), "\x00")
+	}
+}
+
+// loopSidecarPaths returns the paths of the sidecar files giving the loop of the MP3 file at path:
+// a text file of KEY=VALUE lines, and a JSON object. The later one overrides the earlier one.
+func loopSidecarPaths(path string) []string {
+	return []string{
+		path + ".loop.txt",
+		path + ".loop.json",
+	}
+}
+
+// readLoopSidecars sets the values of the loop sidecars of path to c, overriding the ID3 tags,
+// and returns the values of the loop tags in the sidecars. A key cleared by a JSON sidecar is removed from c.
+func readLoopSidecars(path string, c *vorbisComments) ([]loopOrigin, error) {
+	var origins []loopOrigin
+	for _, p := range loopSidecarPaths(path) {
+		dat, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		vals, cleared, err := parseLoopSidecar(p, dat)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range cleared {
+			c.Delete(k)
+		}
+		keys := make([]string, 0, len(vals))
+		for k := range vals {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			c.Set(k, vals[k])
+			if k == loopStartKey || k == loopLengthKey {
+				origins = append(origins, loopOrigin{key: k, value: vals[k], source: sidecarSource(p), sidecar: true})
+			}
+		}
+	}
+	return origins, nil
+}
+
+// parseLoopSidecar parses a loop sidecar. The values of a JSON sidecar can be numbers or strings,
+// and the keys whose values are null are returned as cleared, so that the values in the ID3 tags are treated as absent.
+func parseLoopSidecar(path string, dat []byte) (vals map[string]string, cleared []string, err error) {
+	vals = map[string]string{}
+	if strings.HasSuffix(path, ".json") {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(dat, &obj); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for k, raw := range obj {
+			if string(bytes.TrimSpace(raw)) == "null" {
+				cleared = append(cleared, k)
+				continue
+			}
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				vals[k] = s
+				continue
+			}
+			vals[k] = string(bytes.TrimSpace(raw))
+		}
+		sort.Strings(cleared)
+		return vals, cleared, nil
+	}
+	for _, line := range strings.Split(string(dat), "\n") {
+		k, v, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		vals[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return vals, nil, nil
+}
+
+// rewriteLoopSidecar rewrites the loop of the MP3 file at path with edit, and writes it to the JSON sidecar.
+// The MP3 file itself is never changed, so a key deleted by edit is written as null when it is in the ID3 tags,
+// and is removed from the text sidecar.
+func rewriteLoopSidecar(path string, edit func(c *vorbisComments)) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	txtPath, jsonPath := loopSidecarPaths(path)[0], loopSidecarPaths(path)[1]
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	id3, err := readMP3Info(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	var cleared []string
+	if dat, err := os.ReadFile(jsonPath); err == nil {
+		if _, cleared, err = parseLoopSidecar(jsonPath, dat); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// effective is the loop as it is read, to find the keys deleted by edit.
+	effective := &vorbisComments{comments: append([]string(nil), id3.comments.comments...)}
+	if _, err := readLoopSidecars(path, effective); err != nil {
+		return err
+	}
+	before := effective.keys()
+	edit(effective)
+	var deleted []string
+	for _, k := range before {
+		if len(effective.Get(k)) == 0 {
+			deleted = append(deleted, k)
+		}
+	}
+
+	c := &vorbisComments{}
+	if _, err := readLoopSidecars(path, c); err != nil {
+		return err
+	}
+	edit(c)
+
+	obj := map[string]interface{}{}
+	for _, s := range c.comments {
+		k, v, _ := strings.Cut(s, "=")
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			obj[k] = n
+			continue
+		}
+		obj[k] = v
+	}
+	for _, k := range append(cleared, deleted...) {
+		if len(c.Get(k)) == 0 && len(id3.comments.Get(k)) > 0 {
+			obj[k] = nil
+		}
+	}
+	dat, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := removeLoopSidecarKeys(txtPath, deleted); err != nil {
+		return err
+	}
+	return writeFileSync(jsonPath, append(dat, '\n'), 0644)
+}
+
+// removeLoopSidecarKeys removes the lines of keys from the text sidecar at path, if any.
+func removeLoopSidecarKeys(path string, keys []string) error {
+	dat, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var lines []string
+	removed := false
+	for _, line := range strings.Split(string(dat), "\n") {
+		k, _, _ := strings.Cut(line, "=")
+		keep := true
+		for _, key := range keys {
+			if strings.EqualFold(strings.TrimSpace(k), key) {
+				keep = false
+			}
+		}
+		if !keep {
+			removed = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !removed {
+		return nil
+	}
+	return writeFileSync(path, []byte(strings.Join(lines, "\n")), 0644)
+}
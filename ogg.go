@@ -0,0 +1,381 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Header type flags of an Ogg page.
+// https://www.xiph.org/ogg/doc/framing.html
+const (
+	oggContinued = 0x01
+	oggBOS       = 0x02
+	oggEOS       = 0x04
+)
+
+const (
+	oggPageHeaderSize = 27
+
+	// oggMaxPageSize is the size of a page with 255 segments of 255 bytes.
+	oggMaxPageSize = oggPageHeaderSize + 255 + 255*255
+)
+
+// oggPage is a page of an Ogg bitstream.
+type oggPage struct {
+	headerType byte
+	granule    int64
+	serial     uint32
+	seq        uint32
+	segments   []byte
+	data       []byte
+}
+
+var oggCRCTable [256]uint32
+
+func init() {
+	// The CRC of Ogg is non-reflected with the polynomial 0x04c11db7.
+	for i := range oggCRCTable {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		oggCRCTable[i] = r
+	}
+}
+
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, v := range b {
+		crc = (crc << 8) ^ oggCRCTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// readOggPages splits dat into Ogg pages.
+func readOggPages(dat []byte) ([]*oggPage, error) {
+	var pages []*oggPage
+	var offset int
+	for len(dat) > 0 {
+		p, n, err := parseOggPage(dat)
+		if err != nil {
+			return nil, fmt.Errorf("%w at offset %d", err, offset)
+		}
+		pages = append(pages, p)
+		dat = dat[n:]
+		offset += n
+	}
+	return pages, nil
+}
+
+// parseOggPage parses the page at the beginning of dat, and returns the page and its size in bytes.
+func parseOggPage(dat []byte) (*oggPage, int, error) {
+	if len(dat) < oggPageHeaderSize || string(dat[:4]) != "OggS" {
+		return nil, 0, fmt.Errorf("ogg: invalid page")
+	}
+	nseg := int(dat[26])
+	if len(dat) < oggPageHeaderSize+nseg {
+		return nil, 0, fmt.Errorf("ogg: unexpected end of the page header")
+	}
+	segments := dat[oggPageHeaderSize : oggPageHeaderSize+nseg]
+	size := 0
+	for _, s := range segments {
+		size += int(s)
+	}
+	end := oggPageHeaderSize + nseg + size
+	if len(dat) < end {
+		return nil, 0, fmt.Errorf("ogg: unexpected end of the page")
+	}
+	return &oggPage{
+		headerType: dat[5],
+		granule:    int64(binary.LittleEndian.Uint64(dat[6:14])),
+		serial:     binary.LittleEndian.Uint32(dat[14:18]),
+		seq:        binary.LittleEndian.Uint32(dat[18:22]),
+		segments:   append([]byte(nil), segments...),
+		data:       append([]byte(nil), dat[oggPageHeaderSize+nseg:end]...),
+	}, end, nil
+}
+
+// readOggPage reads the next page from r. readOggPage returns io.EOF when r has no more pages.
+func readOggPage(r io.Reader) (*oggPage, error) {
+	buf := make([]byte, oggPageHeaderSize, oggMaxPageSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("ogg: unexpected end of the page header")
+		}
+		return nil, err
+	}
+	nseg := int(buf[26])
+	buf = buf[:oggPageHeaderSize+nseg]
+	if _, err := io.ReadFull(r, buf[oggPageHeaderSize:]); err != nil {
+		return nil, fmt.Errorf("ogg: unexpected end of the page header")
+	}
+	size := 0
+	for _, s := range buf[oggPageHeaderSize:] {
+		size += int(s)
+	}
+	buf = buf[:len(buf)+size]
+	if _, err := io.ReadFull(r, buf[oggPageHeaderSize+nseg:]); err != nil {
+		return nil, fmt.Errorf("ogg: unexpected end of the page")
+	}
+	p, _, err := parseOggPage(buf)
+	return p, err
+}
+
+// bytes encodes the page with its CRC.
+func (p *oggPage) bytes() []byte {
+	b := make([]byte, oggPageHeaderSize, oggPageHeaderSize+len(p.segments)+len(p.data))
+	copy(b, "OggS")
+	b[5] = p.headerType
+	binary.LittleEndian.PutUint64(b[6:14], uint64(p.granule))
+	binary.LittleEndian.PutUint32(b[14:18], p.serial)
+	binary.LittleEndian.PutUint32(b[18:22], p.seq)
+	b[26] = byte(len(p.segments))
+	b = append(b, p.segments...)
+	b = append(b, p.data...)
+	binary.LittleEndian.PutUint32(b[22:26], oggCRC(b))
+	return b
+}
+
+// packetLacing returns the lacing values of a packet with the given size.
+func packetLacing(size int) []byte {
+	lacing := bytes.Repeat([]byte{255}, size/255)
+	return append(lacing, byte(size%255))
+}
+
+// paginate packs packets into pages starting with the sequence number seq.
+// The last page ends at the end of the last packet.
+func paginate(serial uint32, seq uint32, packets [][]byte) []*oggPage {
+	var pages []*oggPage
+	cur := &oggPage{serial: serial, seq: seq}
+	for _, pkt := range packets {
+		all := packetLacing(len(pkt))
+		lacing := all
+		data := pkt
+		for len(lacing) > 0 {
+			if len(cur.segments) == 255 {
+				pages = append(pages, cur)
+				seq++
+				cur = &oggPage{serial: serial, seq: seq}
+				if len(lacing) != len(all) {
+					cur.headerType = oggContinued
+				}
+			}
+			n := 255 - len(cur.segments)
+			if n > len(lacing) {
+				n = len(lacing)
+			}
+			size := 0
+			for _, l := range lacing[:n] {
+				size += int(l)
+			}
+			cur.segments = append(cur.segments, lacing[:n]...)
+			cur.data = append(cur.data, data[:size]...)
+			lacing = lacing[n:]
+			data = data[size:]
+		}
+	}
+	return append(pages, cur)
+}
+
+// oggHeaders is the header packets of the first logical bitstream in an Ogg file.
+type oggHeaders struct {
+	pages   []*oggPage
+	serial  uint32
+	packets [][]byte
+
+	// pageCount is the number of pages the header packets occupy.
+	pageCount int
+}
+
+// errOggHeadersMissing is returned when the pages end before the header packets.
+var errOggHeadersMissing = errors.New("ogg: header packets are missing")
+
+// readOggHeaders reads the first count packets of the first logical bitstream in dat.
+// The header packets must end at a page boundary, as Vorbis and Opus require.
+func readOggHeaders(dat []byte, count int) (*oggHeaders, error) {
+	pages, err := readOggPages(dat)
+	if err != nil {
+		return nil, err
+	}
+	return newOggHeaders(pages, count)
+}
+
+// readOggHeadersFrom is readOggHeaders reading only the pages up to the end of the header packets from r,
+// so that a large file doesn't have to be loaded. The returned headers can't replace packets.
+func readOggHeadersFrom(r io.Reader, count int) (*oggHeaders, error) {
+	var pages []*oggPage
+	for {
+		p, err := readOggPage(r)
+		if err == io.EOF {
+			return nil, errOggHeadersMissing
+		}
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, p)
+		// The header pages are few, so parsing them again for every page is fine.
+		h, err := newOggHeaders(pages, count)
+		if err == errOggHeadersMissing {
+			continue
+		}
+		return h, err
+	}
+}
+
+func newOggHeaders(pages []*oggPage, count int) (*oggHeaders, error) {
+	if len(pages) == 0 || pages[0].headerType&oggBOS == 0 {
+		return nil, fmt.Errorf("ogg: no beginning of stream")
+	}
+
+	h := &oggHeaders{
+		pages:  pages,
+		serial: pages[0].serial,
+	}
+	var pkt []byte
+	for i, p := range pages {
+		if p.serial != h.serial {
+			continue
+		}
+		offset := 0
+		for _, s := range p.segments {
+			pkt = append(pkt, p.data[offset:offset+int(s)]...)
+			offset += int(s)
+			if s < 255 {
+				h.packets = append(h.packets, pkt)
+				pkt = nil
+			}
+		}
+		if len(h.packets) >= count {
+			if len(h.packets) > count || len(pkt) > 0 {
+				return nil, fmt.Errorf("ogg: the header packets don't end at a page boundary")
+			}
+			h.pageCount = i + 1
+			return h, nil
+		}
+	}
+	return nil, errOggHeadersMissing
+}
+
+// replacePacket returns a new file replacing the index-th header packet with pkt.
+// The other packets are copied without re-encoding, and the pages after the headers are renumbered.
+func (h *oggHeaders) replacePacket(index int, pkt []byte) []byte {
+	packets := make([][]byte, len(h.packets))
+	copy(packets, h.packets)
+	packets[index] = pkt
+
+	// The identification header is alone in the first page.
+	newPages := paginate(h.serial, 0, packets[:1])
+	newPages[0].headerType = oggBOS
+	newPages = append(newPages, paginate(h.serial, 1, packets[1:])...)
+
+	var buf bytes.Buffer
+	buf.Write(newPages[0].bytes())
+	// Pages of other logical bitstreams can be interleaved with the headers.
+	for _, p := range h.pages[:h.pageCount] {
+		if p.serial != h.serial {
+			buf.Write(p.bytes())
+		}
+	}
+	for _, p := range newPages[1:] {
+		buf.Write(p.bytes())
+	}
+	delta := int64(len(newPages)) - int64(h.headerPageCount())
+	for _, p := range h.pages[h.pageCount:] {
+		if p.serial == h.serial {
+			renumbered := *p
+			renumbered.seq = uint32(int64(p.seq) + delta)
+			p = &renumbered
+		}
+		buf.Write(p.bytes())
+	}
+	return buf.Bytes()
+}
+
+// audioData returns the data and the granule positions of the pages after the headers of the headers' bitstream,
+// to compare the audio of files with different headers.
+func (h *oggHeaders) audioData() []byte {
+	var b []byte
+	var granule [8]byte
+	for _, p := range h.pages[h.pageCount:] {
+		if p.serial != h.serial {
+			continue
+		}
+		binary.LittleEndian.PutUint64(granule[:], uint64(p.granule))
+		b = append(b, granule[:]...)
+		b = append(b, p.data...)
+	}
+	return b
+}
+
+// headerPageCount returns the number of the pages of the headers' bitstream.
+func (h *oggHeaders) headerPageCount() int {
+	n := 0
+	for _, p := range h.pages[:h.pageCount] {
+		if p.serial == h.serial {
+			n++
+		}
+	}
+	return n
+}
+
+// readLastGranulePosition returns the granule position of the last page of the headers' bitstream
+// that has a finished packet, or -1 when there is no such page.
+// Only the end of r is read, as the last page is within the maximum page size from the end.
+func (h *oggHeaders) readLastGranulePosition(r io.ReadSeeker) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	offset := size - 2*oggMaxPageSize
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	headerPages := uint32(h.headerPageCount())
+	granule := int64(-1)
+	for i := 0; i+oggPageHeaderSize <= len(tail); {
+		if string(tail[i:i+4]) != "OggS" {
+			i++
+			continue
+		}
+		p, n, err := parseOggPage(tail[i:])
+		// The capture pattern can appear in the audio data. Check the CRC to find the real pages.
+		if err != nil || !bytes.Equal(p.bytes(), tail[i:i+n]) {
+			i++
+			continue
+		}
+		if p.serial == h.serial && p.seq >= headerPages && p.granule != -1 {
+			granule = p.granule
+		}
+		i += n
+	}
+	return granule, nil
+}
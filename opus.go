@@ -0,0 +1,158 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+const (
+	opusHeadMagic = "OpusHead"
+	opusTagsMagic = "OpusTags"
+
+	// opusHeaderCount is the number of the header packets of Ogg/Opus.
+	opusHeaderCount = 2
+)
+
+// readOpusPreSkip returns the pre-skip of an Ogg/Opus file, the number of the samples at 48 kHz
+// the decoders discard at the beginning.
+// https://www.rfc-editor.org/rfc/rfc7845#section-5.1
+func readOpusPreSkip(dat []byte) (int64, error) {
+	h, err := readOggHeaders(dat, opusHeaderCount)
+	if err != nil {
+		return 0, err
+	}
+	head := h.packets[0]
+	if !strings.HasPrefix(string(head), opusHeadMagic) || len(head) < 19 {
+		return 0, fmt.Errorf("opus: invalid identification header")
+	}
+	return int64(binary.LittleEndian.Uint16(head[10:12])), nil
+}
+
+// isOggOpus reports whether dat is an Ogg/Opus file.
+func isOggOpus(dat []byte) bool {
+	h, err := readOggHeaders(dat, 1)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(string(h.packets[0]), opusHeadMagic)
+}
+
+// isOggOpusStream reports whether r is an Ogg/Opus file. The position of r is restored to the start.
+func isOggOpusStream(r io.ReadSeeker) (bool, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	h, headErr := readOggHeadersFrom(bufio.NewReader(r), 1)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if headErr != nil {
+		return false, nil
+	}
+	return strings.HasPrefix(string(h.packets[0]), opusHeadMagic), nil
+}
+
+// readOggOpusInfo reads the format information from the header packets of an Ogg/Opus file.
+// Only the headers and the end of r are read.
+func readOggOpusInfo(r io.ReadSeeker) (*fileInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h, err := readOggHeadersFrom(bufio.NewReader(r), opusHeaderCount)
+	if err != nil {
+		return nil, err
+	}
+
+	head := h.packets[0]
+	if !strings.HasPrefix(string(head), opusHeadMagic) || len(head) < 19 {
+		return nil, fmt.Errorf("opus: invalid identification header")
+	}
+	// Opus is always decoded at 48 kHz. The input sample rate in the header is only informational.
+	info := &fileInfo{
+		sampleRate: 48000,
+		channels:   int(head[9]),
+		preSkip:    int64(binary.LittleEndian.Uint16(head[10:12])),
+	}
+
+	pkt := h.packets[1]
+	if !strings.HasPrefix(string(pkt), opusTagsMagic) {
+		return nil, fmt.Errorf("opus: invalid comment header")
+	}
+	if info.comments, err = parseVorbisComments(pkt[len(opusTagsMagic):]); err != nil {
+		return nil, err
+	}
+
+	granule, err := h.readLastGranulePosition(r)
+	if err != nil {
+		return nil, err
+	}
+	info.granulePosition = -1
+	if granule >= info.preSkip {
+		info.granulePosition = granule - info.preSkip
+	}
+	return info, nil
+}
+
+// decodeOpusToWAV decodes the Opus file at path to the WAV file at wav at 48 kHz with opusdec,
+// as there is no Opus decoder in Go. opusdec is killed when ctx is canceled.
+func decodeOpusToWAV(ctx context.Context, path, wav string) error {
+	cmd := exec.CommandContext(ctx, *flagOpusdec, "--quiet", "--rate", "48000", "--force-wav", path, wav)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("oggplayer: %s failed: %w: %s", *flagOpusdec, err, out)
+	}
+	return nil
+}
+
+// readOggOpusComments reads the comments of an Ogg/Opus file, which are in the same format as Vorbis's.
+func readOggOpusComments(dat []byte) (*vorbisComments, error) {
+	h, err := readOggHeaders(dat, opusHeaderCount)
+	if err != nil {
+		return nil, err
+	}
+	pkt := h.packets[1]
+	if !strings.HasPrefix(string(pkt), opusTagsMagic) {
+		return nil, fmt.Errorf("opus: invalid comment header")
+	}
+	return parseVorbisComments(pkt[len(opusTagsMagic):])
+}
+
+// replaceOggOpusComments returns the Ogg/Opus file dat with its comments replaced with c.
+// The audio packets are copied as they are.
+func replaceOggOpusComments(dat []byte, c *vorbisComments) ([]byte, error) {
+	h, err := readOggHeaders(dat, opusHeaderCount)
+	if err != nil {
+		return nil, err
+	}
+	// Unlike Vorbis, OpusTags has no framing bit.
+	return h.replacePacket(1, append([]byte(opusTagsMagic), c.bytes()...)), nil
+}
+
+// opusLoop converts a loop in samples at sourceRate to the granule domain of Opus,
+// which is at 48 kHz and includes the pre-skip.
+func opusLoop(introSample, loopSample int64, sourceRate int, preSkip int64) (int64, int64) {
+	scale := func(v int64) int64 {
+		return (v*48000 + int64(sourceRate)/2) / int64(sourceRate)
+	}
+	start := scale(introSample)
+	end := scale(introSample + loopSample)
+	return start + preSkip, end - start
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// panSteps is the number of the pan steps either way. A step is 10 % of the full pan.
+const panSteps = 10
+
+// panStage pans the playback by turning down the opposite channel, as a balance control does,
+// so that the loop can be checked while the game pans the BGM for positional effects.
+type panStage struct {
+	// pan is the pan in steps, negative to the left.
+	pan int
+	m   sync.Mutex
+}
+
+func (s *panStage) Name() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.pan < 0 {
+		return fmt.Sprintf("Pan L%d", -s.pan*100/panSteps)
+	}
+	return fmt.Sprintf("Pan R%d", s.pan*100/panSteps)
+}
+
+func (s *panStage) Active() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.pan != 0
+}
+
+func (s *panStage) Process(pcm []int16) {
+	s.m.Lock()
+	pan := float64(s.pan) / panSteps
+	s.m.Unlock()
+	// Only the channel on the other side is turned down.
+	ch, gain := 0, 1-pan
+	if pan < 0 {
+		ch, gain = 1, 1+pan
+	}
+	for i := ch; i < len(pcm); i += 2 {
+		pcm[i] = int16(float64(pcm[i]) * gain)
+	}
+}
+
+// Add pans by delta steps within panSteps either way.
+func (s *panStage) Add(delta int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.pan += delta
+	if s.pan > panSteps {
+		s.pan = panSteps
+	}
+	if s.pan < -panSteps {
+		s.pan = -panSteps
+	}
+}
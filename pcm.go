@@ -0,0 +1,216 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/hajimehoshi/ebiten/v2/audio/mp3"
+	"github.com/hajimehoshi/ebiten/v2/audio/vorbis"
+	"github.com/hajimehoshi/ebiten/v2/audio/wav"
+)
+
+// pcmChunkSize is the size in bytes of the chunks the PCM is read in.
+const pcmChunkSize = 64 * 1024
+
+// pcmStream is a seekable stream of interleaved stereo 16-bit samples.
+type pcmStream interface {
+	io.ReadSeeker
+
+	// Length returns the size of the stream in bytes.
+	Length() int64
+}
+
+// decodeStream decodes an Ogg/Vorbis, WAV or MP3 file from r as a stream of interleaved stereo 16-bit samples at sampleRate.
+// The data is decoded as it is read, so r should be a file rather than the whole data in memory.
+// A file at another sample rate, e.g. 44100 or 22050 Hz, is resampled by the decoder so that it plays at the correct pitch
+// in the audio context, which has a single sample rate for all the files.
+// info is used to skip the resampling, and can be nil.
+func decodeStream(r io.ReadSeeker, info *fileInfo) (pcmStream, error) {
+	isWAV, err := isWAVStream(r)
+	if err != nil {
+		return nil, err
+	}
+	isMP3, err := isMP3Stream(r)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil && info.sampleRate == sampleRate {
+		if isWAV {
+			return wav.DecodeWithoutResampling(r)
+		}
+		if isMP3 {
+			return mp3.DecodeWithoutResampling(r)
+		}
+		return vorbis.DecodeWithoutResampling(r)
+	}
+	if isWAV {
+		return wav.DecodeWithSampleRate(sampleRate, r)
+	}
+	if isMP3 {
+		return mp3.DecodeWithSampleRate(sampleRate, r)
+	}
+	return vorbis.DecodeWithSampleRate(sampleRate, r)
+}
+
+// openAudioFile opens the file at path to decode with decodeStream.
+// An Ogg/Opus or FLAC file is decoded to a WAV file with opusdec or flac first, which is opened instead.
+// The command is killed when j is canceled.
+func openAudioFile(path string, j *job) (*os.File, error) {
+	decodes, err := externalDecodesOf(path)
+	if err != nil {
+		return nil, err
+	}
+	if decodes == nil {
+		return openShared(path)
+	}
+	wav, err := decodes.wavPath(j.Context(), path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(wav)
+}
+
+// externalDecodesOf returns the external decodes for the file at path, or nil when the file is decoded in Go.
+func externalDecodesOf(path string) (*externalDecodes, error) {
+	f, err := openShared(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	opus, err := isOggOpusStream(f)
+	if err != nil {
+		return nil, err
+	}
+	if opus {
+		return theOpusDecodes, nil
+	}
+	flac, err := isFLACStream(f)
+	if err != nil {
+		return nil, err
+	}
+	if flac {
+		return theFLACDecodes, nil
+	}
+	return nil, nil
+}
+
+// streamPCM decodes the file at path and passes the samples to f chunk by chunk, so that the whole PCM is never in memory.
+// The progress is reported to j, and streamPCM returns early when j is canceled.
+// f must not keep the chunk.
+func streamPCM(path string, j *job, f func(pcm []int16)) error {
+	file, err := openAudioFile(path, j)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	s, err := decodeStream(file, nil)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, pcmChunkSize)
+	pcm := make([]int16, pcmChunkSize/2)
+	var read int64
+	for {
+		if err := j.Err(); err != nil {
+			return err
+		}
+		n, err := io.ReadFull(s, buf)
+		// A sample can't be split across the chunks as the chunk size is a multiple of bytesPerSample.
+		n -= n % bytesPerSample
+		for i := 0; i < n/2; i++ {
+			pcm[i] = int16(buf[2*i]) | int16(buf[2*i+1])<<8
+		}
+		if n > 0 {
+			f(pcm[:n/2])
+		}
+		read += int64(n)
+		if s.Length() > 0 {
+			j.SetProgress(float64(read) / float64(s.Length()))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodePCM decodes the whole file at path into interleaved stereo 16-bit samples at sampleRate.
+// This is for the operations needing the whole PCM at once like encoding. Playing and the analysis stream the file instead.
+// The PCM is cached, and must not be modified.
+func decodePCM(path string, j *job) ([]int16, error) {
+	key, err := newPCMCacheKey(path)
+	if err != nil {
+		return nil, err
+	}
+	if pcm, ok := thePCMCache.get(key); ok {
+		return pcm, nil
+	}
+	var pcm []int16
+	if err := streamPCM(path, j, func(chunk []int16) {
+		pcm = append(pcm, chunk...)
+	}); err != nil {
+		return nil, err
+	}
+	thePCMCache.put(key, pcm)
+	return pcm, nil
+}
+
+// readPCMRange decodes only the frames [from, to) of the file at path by seeking, unless the file's PCM is cached.
+// The returned PCM is shorter when the file ends before to, and must not be modified.
+func readPCMRange(path string, from, to int64) ([]int16, error) {
+	key, err := newPCMCacheKey(path)
+	if err != nil {
+		return nil, err
+	}
+	if pcm, ok := thePCMCache.get(key); ok {
+		frames := int64(len(pcm) / 2)
+		if from > frames {
+			from = frames
+		}
+		if to > frames {
+			to = frames
+		}
+		return pcm[2*from : 2*to], nil
+	}
+
+	file, err := openAudioFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	s, err := decodeStream(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Seek(from*bytesPerSample, io.SeekStart); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, (to-from)*bytesPerSample)
+	n, err := io.ReadFull(s, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	pcm := make([]int16, n/2)
+	for i := range pcm {
+		pcm[i] = int16(buf[2*i]) | int16(buf[2*i+1])<<8
+	}
+	return pcm, nil
+}
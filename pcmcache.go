@@ -0,0 +1,114 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"container/list"
+	"os"
+	"sync"
+)
+
+// pcmCacheKey identifies a version of a file, so that a rewritten file is decoded again.
+type pcmCacheKey struct {
+	path    string
+	modTime int64
+	size    int64
+}
+
+func newPCMCacheKey(path string) (pcmCacheKey, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return pcmCacheKey{}, err
+	}
+	return pcmCacheKey{
+		path:    path,
+		modTime: fi.ModTime().UnixNano(),
+		size:    fi.Size(),
+	}, nil
+}
+
+type pcmCacheEntry struct {
+	key pcmCacheKey
+	pcm []int16
+}
+
+// pcmCache keeps the decoded PCM of the recently used files up to the capacity in bytes,
+// so that the analysis, encoding and the seam checks of the same file don't decode it again.
+// The least recently used PCM is dropped first.
+//
+// The cached PCM is shared, and must not be modified.
+// A nil *pcmCache is valid and caches nothing.
+type pcmCache struct {
+	capacity int64
+	size     int64
+	entries  map[pcmCacheKey]*list.Element
+	lru      *list.List
+	m        sync.Mutex
+}
+
+func newPCMCache(capacity int64) *pcmCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &pcmCache{
+		capacity: capacity,
+		entries:  map[pcmCacheKey]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// fits reports whether PCM of size bytes can be cached.
+func (c *pcmCache) fits(size int64) bool {
+	if c == nil {
+		return false
+	}
+	return size <= c.capacity
+}
+
+func (c *pcmCache) get(key pcmCacheKey) ([]int16, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*pcmCacheEntry).pcm, true
+}
+
+func (c *pcmCache) put(key pcmCacheKey, pcm []int16) {
+	size := int64(len(pcm)) * 2
+	if !c.fits(size) {
+		return
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&pcmCacheEntry{key: key, pcm: pcm})
+	c.size += size
+	for c.size > c.capacity {
+		e := c.lru.Back()
+		entry := e.Value.(*pcmCacheEntry)
+		c.lru.Remove(e)
+		delete(c.entries, entry.key)
+		c.size -= int64(len(entry.pcm)) * 2
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// perfInterval is the interval the numbers of the performance overlay are updated at, to keep them readable.
+const perfInterval = time.Second
+
+// perfOverlay is the performance overlay toggled with F3, to report a stuttering playhead with numbers.
+type perfOverlay struct {
+	show bool
+
+	// drawTime is the longest time Draw took in the current interval, and lastDrawTime is the one of the previous interval.
+	drawTime     time.Duration
+	lastDrawTime time.Duration
+
+	// headroom is the lowest headroom of the audio callbacks in the previous interval.
+	headroom    float64
+	hasHeadroom bool
+
+	updatedAt time.Time
+}
+
+func (o *perfOverlay) updateIfNeeded(p *Player) {
+	if inpututil.IsKeyJustPressed(ebiten.KeyF3) {
+		o.show = !o.show
+	}
+	if time.Since(o.updatedAt) < perfInterval {
+		return
+	}
+	o.updatedAt = time.Now()
+	o.lastDrawTime = o.drawTime
+	o.drawTime = 0
+	o.hasHeadroom = false
+	if p != nil {
+		o.headroom, o.hasHeadroom = p.AudioHeadroom()
+	}
+}
+
+// recordDraw records the time Draw took since start.
+func (o *perfOverlay) recordDraw(start time.Time) {
+	if d := time.Since(start); o.drawTime < d {
+		o.drawTime = d
+	}
+}
+
+func (o *perfOverlay) lines() []string {
+	headroom := "-"
+	if o.hasHeadroom {
+		headroom = fmt.Sprintf("%.0f%%", o.headroom*100)
+	}
+	return []string{
+		fmt.Sprintf("FPS %.1f", ebiten.ActualFPS()),
+		fmt.Sprintf("TPS %.1f", ebiten.ActualTPS()),
+		fmt.Sprintf("Draw %.2fms", float64(o.lastDrawTime)/float64(time.Millisecond)),
+		"Audio " + headroom,
+	}
+}
+
+// draw draws the overlay at the right below the seam band energy.
+func (o *perfOverlay) draw(screen *ebiten.Image) {
+	if !o.show {
+		return
+	}
+	lines := o.lines()
+	var width int
+	for _, l := range lines {
+		if width < len(l) {
+			width = len(l)
+		}
+	}
+	ebitenutil.DebugPrintAt(screen, strings.Join(lines, "\n"), screenWidth-width*debugCharWidth-4, 44)
+}
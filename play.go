@@ -0,0 +1,119 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"golang.org/x/term"
+)
+
+const playStatusInterval = time.Second / 10
+
+// runPlay runs the play command, which plays a file in the terminal without any window.
+//
+//	oggplayer play file.ogg
+func runPlay(args []string) error {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: oggplayer play file.ogg")
+		fmt.Fprintln(fs.Output(), "Space: Play/Pause, Left/Right: Seek, Z/X: Volume, Q: Quit")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	p, err := NewPlayer(audio.NewContext(sampleRate), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	presence := newDiscordPresence(*flagDiscord)
+	history := newListeningHistory(*flagHistory)
+	defer func() {
+		if err := history.Record(p); err != nil {
+			log.Printf("history error: %v", err)
+		}
+	}()
+
+	// Key strokes are available without Enter only in the raw mode.
+	// When stdin is not a terminal, keys are still read from it line by line.
+	stdin := int(os.Stdin.Fd())
+	if term.IsTerminal(stdin) {
+		state, err := term.MakeRaw(stdin)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(stdin, state)
+	}
+	defer fmt.Print("\r\n")
+
+	keyCh := make(chan string)
+	errCh := make(chan error, 1)
+	go readTerminalKeys(os.Stdin, keyCh, errCh)
+
+	fmt.Printf("%s\r\n", fs.Arg(0))
+
+	ticker := time.NewTicker(playStatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case key := <-keyCh:
+			switch theSettings.Keys.remapTerminalKey(strings.ToLower(key)) {
+			case "q", keyInterrupt:
+				return nil
+			case " ":
+				p.TogglePlay()
+			case keyLeft:
+				p.Seek(p.current - tuiSeekStep)
+			case keyRight:
+				p.Seek(p.current + tuiSeekStep)
+			case "z":
+				p.AddVolume(-tuiVolumeStep)
+			case "x":
+				p.AddVolume(tuiVolumeStep)
+			}
+		case err := <-errCh:
+			// Keep playing without the keyboard controls when stdin is closed.
+			if err != io.EOF {
+				return err
+			}
+		case <-ticker.C:
+		}
+
+		p.updateCurrent()
+		presence.Update(p)
+
+		state := "Paused "
+		if p.IsPlaying() {
+			state = "Playing"
+		}
+		fmt.Printf("\r%s %s / %s  Loop: %s - %s  Volume: %d/128\x1b[K",
+			state, formatTime(p.current), formatTime(p.total),
+			formatTime(samplesToDuration(p.introSample)), formatTime(samplesToDuration(p.introSample+p.loopSample)),
+			p.volume128)
+	}
+}
@@ -0,0 +1,868 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+const (
+	sampleRate = 48000
+
+	bytesPerSample = 4 // TODO: This should be defined in audio package
+)
+
+// Player represents the current audio state.
+//
+// Player is shared by the GUI and the terminal UI. It must not depend on ebiten's input or graphics.
+type Player struct {
+	audioContext *audio.Context
+	audioPlayer  *audio.Player
+	stream       io.ReadSeeker
+
+	// loopEnd stops the stream at the loop end with -pause-at-loop-end or playOnce, or is nil.
+	// playOnce reports whether the loop is played only once, e.g. to play the selection.
+	loopEnd  *loopEndStop
+	playOnce bool
+
+	// rate is the playback speed of the preview, e.g. of a transpose or a time-stretch, which the audio player's position is counted at.
+	// rateFrom and rateOutFrom are the source's and the audio player's positions when the rate changed or seeked last.
+	rate        float64
+	rateFrom    time.Duration
+	rateOutFrom time.Duration
+
+	meter       *levelMeter
+	path        string
+	current     time.Duration
+	total       time.Duration
+	totalSample int64
+	openedAt    time.Time
+	played      time.Duration
+	lastUpdated time.Time
+	seamPlays   int
+	loopIter    int
+	seBytes     []byte
+	seCh        chan []byte
+	volume128   int
+	introSample int64
+	loopSample  int64
+	markers     []Marker
+
+	// fileIntroSample and fileLoopSample are the loop values read from the file.
+	fileIntroSample int64
+	fileLoopSample  int64
+
+	// file is the opened file the stream decodes from, which is a decoded WAV file for an Opus file.
+	// The file is closed by Close.
+	file *os.File
+
+	// info is the format information of the file. info can be nil when the headers are broken.
+	info *fileInfo
+
+	// profileWarnings are the violations of the validation profile.
+	profileWarnings []string
+
+	// ruleWarnings are the violations of the studio's rules.
+	ruleWarnings []string
+
+	// analysis is the analysis of the decoded PCM. analysis is nil until the analysis in background finishes.
+	analysis    *analysis
+	analysisCh  chan *analysis
+	analysisJob *job
+
+	// modTime is the modification time of the file when it was opened.
+	modTime time.Time
+
+	// changedModTime is the modification time seen at the last check when the file has been changed.
+	changedModTime time.Time
+	lastChecked    time.Time
+
+	// trim is the proposed trim of the silence. trim is nil when no trim is proposed.
+	trim *silenceTrim
+
+	// seamBands is the cache of the band energy at the seam.
+	seamBands *seamBands
+
+	// seamQuality is the cache of the analysis of the audio around the seam.
+	seamQuality *seamQuality
+
+	// ghost is the waveform of the file before it was reloaded. ghost is nil when the file is not reloaded.
+	ghost *waveform
+
+	// tagWarnings are the problems found in the loop tags, and badTagKeys are the keys of the ignored tags.
+	tagWarnings []string
+	badTagKeys  []string
+
+	// loopSource describes where the current loop comes from when it is not the file's one.
+	loopSource string
+
+	// loopConflict is the loops of the sources that disagree, until the user chooses one of them.
+	loopConflict []loopChoice
+
+	// selStart and selEnd are the selected range in samples. The selection is empty when selEnd <= selStart.
+	selStart int64
+	selEnd   int64
+
+	// selecting, selAnchor and selAnchorX are the state of dragging a selection in the GUI.
+	selecting  bool
+	selAnchor  int64
+	selAnchorX int
+
+	// pointA and pointB are the points of the A-B loop. hasPointA and hasPointB report whether they are set.
+	pointA    int64
+	pointB    int64
+	hasPointA bool
+	hasPointB bool
+
+	// shuttle is the speed of the JKL transport: negative is backward. 0 and 1 are the normal playback.
+	// shuttleAt is when the shuttle jumped last.
+	shuttle   int
+	shuttleAt time.Time
+
+	// fade is the fade-in or the fade-out in progress, or nil.
+	fade *fade
+
+	// matchDB is the gain in dB matching the loudness to the reference track, which is 0 or negative.
+	matchDB float64
+
+	// draggingLoop is the loop handle being dragged in the GUI, and dragSample is where it is dragged to.
+	// loopDragApplied is when the dragged loop was last applied to the playback.
+	draggingLoop    loopHandle
+	dragSample      int64
+	loopDragApplied time.Time
+
+	// viewStart and viewSpan are the range of the samples shown on the zoomed timeline in the GUI.
+	// viewSpan is 0 when the whole file is shown. viewPCMFrames is the PCM around the view from viewPCMFrom,
+	// and viewPCMFailed reports whether reading it failed.
+	viewStart     int64
+	viewSpan      int64
+	viewPCMFrames []int16
+	viewPCMFrom   int64
+	viewPCMFailed bool
+}
+
+// loopHandle is a draggable end of the loop.
+type loopHandle int
+
+const (
+	loopHandleNone loopHandle = iota
+	loopHandleStart
+	loopHandleEnd
+)
+
+func NewPlayer(audioContext *audio.Context, oggPath string) (*Player, error) {
+	// The file is decoded as it is played instead of loaded at once, so that the memory doesn't grow with the length.
+	f, err := openAudioFile(oggPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	player, err := newPlayer(audioContext, oggPath, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return player, nil
+}
+
+func newPlayer(audioContext *audio.Context, oggPath string, f *os.File) (*Player, error) {
+	tags := &loopTags{}
+	// f is a decoded WAV file for an Opus file, so the information is read from the file itself.
+	info, err := readFileInfoAt(oggPath)
+	if err != nil {
+		// Ignore the tag's error. The file can still be played.
+		log.Printf("loop tag error: %s, %v", oggPath, err)
+	} else {
+		tags = parseLoopTags(info.comments, theTagMode)
+		tags.removePreSkip(info.preSkip)
+		tags.toStreamRate(info)
+	}
+	s, err := decodeStream(f, info)
+	if err != nil {
+		return nil, err
+	}
+	// The granule position is the exact length, while the decoder's length can be an estimate.
+	streamSample := s.Length() / bytesPerSample
+	totalSample := streamSample
+	if n, ok := info.exactLength(); ok && n < totalSample {
+		totalSample = n
+	}
+	tags.validateRange(totalSample)
+	if err := tags.err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", oggPath, err)
+	}
+	for _, w := range tags.warnings {
+		log.Printf("loop tag warning: %s, %s", oggPath, w)
+	}
+	introSample, loopSample := tags.start, tags.length
+
+	player := &Player{
+		audioContext:    audioContext,
+		stream:          s,
+		file:            f,
+		path:            oggPath,
+		openedAt:        time.Now(),
+		total:           samplesToDuration(totalSample),
+		totalSample:     totalSample,
+		volume128:       theSettings.Volume,
+		rate:            1,
+		seCh:            make(chan []byte),
+		introSample:     introSample,
+		loopSample:      loopSample,
+		fileIntroSample: introSample,
+		fileLoopSample:  loopSample,
+		info:            info,
+		tagWarnings:     tags.warnings,
+		profileWarnings: theProfile.check(info),
+		analysisCh:      make(chan *analysis, 1),
+		badTagKeys:      tags.badKeys,
+	}
+	if player.total == 0 {
+		player.total = 1
+	}
+	if fi, err := os.Stat(oggPath); err == nil {
+		player.modTime = fi.ModTime()
+	}
+	// Decoding the whole file takes time. Analyze it in background unless it is analyzed before.
+	key, err := newPCMCacheKey(oggPath)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := theAnalyses.get(key); ok {
+		player.analysis = a
+	} else {
+		player.analysisJob = theJobs.Go("Analyze "+filepath.Base(oggPath), func(j *job) {
+			a, err := analyze(oggPath, streamSample, j)
+			if err != nil {
+				if j.Err() == nil {
+					log.Printf("analysis error: %s, %v", oggPath, err)
+				}
+				return
+			}
+			theAnalyses.put(key, a)
+			player.analysisCh <- a
+		})
+	}
+	player.ruleWarnings = theRules.check(oggPath, info, player.total, player.Loudness())
+	if err := player.resetAudioPlayer(); err != nil {
+		player.analysisJob.Cancel()
+		return nil, err
+	}
+	player.play()
+	return player, nil
+}
+
+// resetAudioPlayer creates a new audio player looping the stream with the current loop values.
+// The previous audio player, if any, is closed.
+func (p *Player) resetAudioPlayer() error {
+	if p.audioPlayer != nil {
+		if err := p.audioPlayer.Close(); err != nil {
+			return err
+		}
+		p.audioPlayer = nil
+	}
+
+	// InfiniteLoop takes the current position of the source as its start position.
+	if _, err := p.stream.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	intro, loop := p.playbackLoop()
+	g := audioGraph{
+		introSample:   intro,
+		loopSample:    loop,
+		stopAtLoopEnd: *flagLoopStop || p.playOnce,
+		chain:         thePreview,
+	}
+	s, loopEnd := g.build(p.stream)
+	p.loopEnd = loopEnd
+	meter := newLevelMeter(s)
+
+	ap, err := audio.NewPlayer(p.audioContext, meter)
+	if err != nil {
+		return err
+	}
+	p.audioPlayer = ap
+	p.applyVolume()
+	p.meter = meter
+	p.rateFrom, p.rateOutFrom = 0, 0
+	return nil
+}
+
+// SetLoop changes the loop to introSample and loopSample without changing the file.
+// The current position and the playing state are kept as far as possible.
+func (p *Player) SetLoop(introSample, loopSample int64) error {
+	if !p.isValidLoop(introSample, loopSample) {
+		return fmt.Errorf("oggplayer: invalid loop: start: %d, length: %d", introSample, loopSample)
+	}
+	p.playOnce = false
+	return p.setLoop(introSample, loopSample)
+}
+
+// setLoop is SetLoop without the validation. An invalid loop means no loop as the file without loop tags.
+func (p *Player) setLoop(introSample, loopSample int64) error {
+	playing := p.IsPlaying()
+	pos := p.current
+	p.introSample = introSample
+	p.loopSample = loopSample
+	if err := p.resetAudioPlayer(); err != nil {
+		return err
+	}
+	if intro, loop := p.playbackLoop(); pos >= samplesToDuration(intro+loop) {
+		pos = samplesToDuration(intro)
+	}
+	if err := p.Seek(pos); err != nil {
+		return err
+	}
+	if playing {
+		p.audioPlayer.Play()
+	}
+	return nil
+}
+
+// playbackLoop returns the loop actually played. Without a valid loop, the whole file is looped.
+func (p *Player) playbackLoop() (introSample, loopSample int64) {
+	if !p.isValidLoop(p.introSample, p.loopSample) {
+		return 0, p.totalSample
+	}
+	return p.introSample, p.loopSample
+}
+
+func (p *Player) isValidLoop(introSample, loopSample int64) bool {
+	return introSample >= 0 && loopSample > 0 && introSample+loopSample <= p.totalSample
+}
+
+// editLoop is SetLoop for the user's edits. An edit making the loop invalid is ignored.
+func (p *Player) editLoop(introSample, loopSample int64) error {
+	if !p.isValidLoop(introSample, loopSample) {
+		return nil
+	}
+	if err := p.SetLoop(introSample, loopSample); err != nil {
+		return err
+	}
+	p.loopSource = "edited"
+	return nil
+}
+
+// ResetLoop restores the loop read from the file. The A-B points are cleared.
+func (p *Player) ResetLoop() error {
+	p.loopSource = ""
+	p.hasPointA = false
+	p.hasPointB = false
+	p.playOnce = false
+	return p.setLoop(p.fileIntroSample, p.fileLoopSample)
+}
+
+// SetLoopStartAt moves the loop start to sample keeping the loop end.
+// If sample is not before the loop end, the loop ends at the end of the file.
+func (p *Player) SetLoopStartAt(sample int64) error {
+	end := p.introSample + p.loopSample
+	if p.loopSample <= 0 || sample >= end {
+		end = p.totalSample
+	}
+	return p.editLoop(sample, end-sample)
+}
+
+// SetLoopEndAt moves the loop end to sample keeping the loop start.
+func (p *Player) SetLoopEndAt(sample int64) error {
+	return p.editLoop(p.introSample, sample-p.introSample)
+}
+
+// ScaleLoopLength multiplies the loop length by num/den keeping the loop start.
+func (p *Player) ScaleLoopLength(num, den int64) error {
+	if p.loopSample <= 0 {
+		return nil
+	}
+	return p.editLoop(p.introSample, p.loopSample*num/den)
+}
+
+// ShiftLoop moves the loop by the 1/bars of the loop length keeping the length.
+// A loop is usually a whole number of bars, so this moves the loop by a bar. delta is the number of bars.
+func (p *Player) ShiftLoop(delta int64, bars int64) error {
+	if p.loopSample <= 0 || bars <= 0 {
+		return nil
+	}
+	return p.editLoop(p.introSample+delta*p.loopSample/bars, p.loopSample)
+}
+
+// MoveLoopHandle moves the loop start or end to sample keeping the other end. sample is snapped by theSnap.
+func (p *Player) MoveLoopHandle(h loopHandle, sample int64) error {
+	sample, err := p.snapSample(sample)
+	if err != nil {
+		return err
+	}
+	switch h {
+	case loopHandleStart:
+		return p.SetLoopStartAt(sample)
+	case loopHandleEnd:
+		return p.SetLoopEndAt(sample)
+	}
+	return nil
+}
+
+// currentSample returns the current position in samples.
+func (p *Player) currentSample() int64 {
+	return durationToSamples(p.current)
+}
+
+// fileSample converts sample of the stream to the file's sample rate, which the loop tags and DAWs count in.
+func (p *Player) fileSample(sample int64) int64 {
+	return p.info.toFileSamples(sample)
+}
+
+// fileLoopLength returns the loop length at the file's sample rate, converted from the end so that it matches the tags.
+func (p *Player) fileLoopLength() int64 {
+	return p.fileSample(p.introSample+p.loopSample) - p.fileSample(p.introSample)
+}
+
+// SetSelection selects the range between the two samples in any order.
+func (p *Player) SetSelection(a, b int64) {
+	if a > b {
+		a, b = b, a
+	}
+	if a < 0 {
+		a = 0
+	}
+	if b > p.totalSample {
+		b = p.totalSample
+	}
+	p.selStart = a
+	p.selEnd = b
+}
+
+func (p *Player) ClearSelection() {
+	p.selStart = 0
+	p.selEnd = 0
+}
+
+func (p *Player) HasSelection() bool {
+	return p.selEnd > p.selStart
+}
+
+// PreviewSelection loops the selection without changing the file's loop values.
+func (p *Player) PreviewSelection() error {
+	if !p.HasSelection() {
+		return nil
+	}
+	if err := p.SetLoop(p.selStart, p.selEnd-p.selStart); err != nil {
+		return err
+	}
+	p.loopSource = "selection"
+	return p.Seek(samplesToDuration(p.selStart))
+}
+
+// PlaySelection plays the selection once from its start and pauses at its end, without changing the file's loop values.
+// Playing again replays the selection.
+func (p *Player) PlaySelection() error {
+	if !p.HasSelection() {
+		return nil
+	}
+	p.playOnce = true
+	if err := p.setLoop(p.selStart, p.selEnd-p.selStart); err != nil {
+		return err
+	}
+	p.loopSource = "selection, once"
+	if err := p.Seek(samplesToDuration(p.selStart)); err != nil {
+		return err
+	}
+	p.play()
+	return nil
+}
+
+// SetPointA sets the point A of the A-B loop at the current position.
+func (p *Player) SetPointA() error {
+	p.pointA = p.currentSample()
+	p.hasPointA = true
+	return p.applyABLoop()
+}
+
+// SetPointB sets the point B of the A-B loop at the current position.
+func (p *Player) SetPointB() error {
+	p.pointB = p.currentSample()
+	p.hasPointB = true
+	return p.applyABLoop()
+}
+
+// abLine returns the points of the A-B loop being set, or an empty string when no point is set.
+func (p *Player) abLine() string {
+	if !p.hasPointA && !p.hasPointB {
+		return ""
+	}
+	point := func(ok bool, sample int64) string {
+		if !ok {
+			return "-"
+		}
+		return formatTimeMillis(samplesToDuration(sample))
+	}
+	return fmt.Sprintf("A-B: %s - %s (0: clear)", point(p.hasPointA, p.pointA), point(p.hasPointB, p.pointB))
+}
+
+// applyABLoop loops between A and B without changing the file's loop values, once both points are set.
+func (p *Player) applyABLoop() error {
+	if !p.hasPointA || !p.hasPointB {
+		return nil
+	}
+	start, end := p.pointA, p.pointB
+	if start > end {
+		start, end = end, start
+	}
+	if !p.isValidLoop(start, end-start) {
+		return nil
+	}
+	if err := p.SetLoop(start, end-start); err != nil {
+		return err
+	}
+	p.loopSource = "A-B"
+	return nil
+}
+
+// Loudness returns the integrated loudness in LUFS, or NaN until the analysis finishes.
+func (p *Player) Loudness() float64 {
+	if p.analysis == nil {
+		return math.NaN()
+	}
+	return p.analysis.loudness
+}
+
+// FileChanged reports whether the file has been rewritten since it was opened, e.g. by a DAW's re-export.
+// FileChanged reports true only after the modification time stays the same over two calls,
+// so that a file being written is not reported.
+func (p *Player) FileChanged() bool {
+	// Checking the file every frame is too much.
+	if time.Since(p.lastChecked) < time.Second {
+		return false
+	}
+	p.lastChecked = time.Now()
+
+	fi, err := os.Stat(p.path)
+	if err != nil {
+		return false
+	}
+	t := fi.ModTime()
+	if t.Equal(p.modTime) {
+		return false
+	}
+	if !t.Equal(p.changedModTime) {
+		p.changedModTime = t
+		return false
+	}
+	return true
+}
+
+// Reload reopens the file and returns the new player, keeping the position, the playing state and the markers.
+// The current waveform is kept as the ghost to compare with the new one.
+// p is closed when Reload succeeds.
+func (p *Player) Reload() (*Player, error) {
+	n, err := p.reopen()
+	if err != nil {
+		// Don't try again until the file is changed again.
+		p.modTime = p.changedModTime
+		return nil, err
+	}
+	if p.analysis != nil {
+		n.ghost = p.analysis.waveform
+	}
+	return n, nil
+}
+
+// reopen opens the file again keeping the position, the playing state and the markers, and closes p.
+func (p *Player) reopen() (*Player, error) {
+	n, err := NewPlayer(p.audioContext, p.path)
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsPlaying() {
+		n.Pause()
+	}
+	n.Seek(p.current)
+	n.loopIter = p.loopIter
+	n.markers = p.markers
+	n.ghost = p.ghost
+	// The reopened file is still the same listening session.
+	n.openedAt = p.openedAt
+	n.played = p.played
+	n.seamPlays = p.seamPlays
+	p.Close()
+	return n, nil
+}
+
+// SaveLoop writes the current loop to LOOPSTART and LOOPLENGTH of the file without re-encoding the audio,
+// and returns the file reopened with the saved loop. p keeps playing when saving fails.
+func (p *Player) SaveLoop() (*Player, error) {
+	if p.loopSample <= 0 {
+		return nil, fmt.Errorf("oggplayer: no loop to save")
+	}
+	// The loop tags are at the file's sample rate, while the player plays the stream resampled to sampleRate.
+	start := p.info.toFileSamples(p.introSample)
+	length := p.info.toFileSamples(p.introSample+p.loopSample) - start
+	// The loop tags of an Opus file include the pre-skip.
+	if p.info != nil {
+		start += p.info.preSkip
+	}
+	if err := writeLoopTags(p.path, start, length); err != nil {
+		return nil, err
+	}
+	return p.reopen()
+}
+
+// ClearBadTags removes the ignored loop tags from the file.
+// The player keeps playing the old data, so the caller should reopen the file.
+func (p *Player) ClearBadTags() error {
+	if len(p.badTagKeys) == 0 {
+		return nil
+	}
+	return clearLoopTags(p.path, p.badTagKeys)
+}
+
+// PreviewSeam seeks to before the loop end by d and plays, so that the seam can be auditioned at once.
+// The position is not before the loop start, so that it doesn't go to the intro for a short loop.
+func (p *Player) PreviewSeam(d time.Duration) error {
+	if p.loopSample <= 0 {
+		return nil
+	}
+	sample := p.introSample + p.loopSample - int64(d.Seconds()*sampleRate)
+	if sample < p.introSample {
+		sample = p.introSample
+	}
+	if err := p.Seek(samplesToDuration(sample)); err != nil {
+		return err
+	}
+	p.audioPlayer.Play()
+	return nil
+}
+
+// PreviewIntroEnd seeks to before the loop start by d and plays, so that the transition from the intro
+// into the loop can be auditioned at once. This transition plays only once, unlike the seam at the loop end.
+func (p *Player) PreviewIntroEnd(d time.Duration) error {
+	if p.loopSample <= 0 {
+		return nil
+	}
+	sample := p.introSample - int64(d.Seconds()*sampleRate)
+	if sample < 0 {
+		sample = 0
+	}
+	if err := p.Seek(samplesToDuration(sample)); err != nil {
+		return err
+	}
+	p.audioPlayer.Play()
+	return nil
+}
+
+// AuditionSegment loops the i-th segment between the markers and moves to its start.
+func (p *Player) AuditionSegment(i int) error {
+	start, end, ok := markerSegment(p.markers, i, p.totalSample)
+	if !ok {
+		return nil
+	}
+	if err := p.SetLoop(start, end-start); err != nil {
+		return err
+	}
+	p.loopSource = fmt.Sprintf("segment %s", p.markers[i].Label)
+	return p.Seek(samplesToDuration(start))
+}
+
+func (p *Player) Resume() {
+	if !p.audioPlayer.IsPlaying() {
+		p.play()
+	}
+}
+
+func (p *Player) Pause() {
+	if p.audioPlayer.IsPlaying() {
+		p.audioPlayer.Pause()
+	}
+	p.cancelFade()
+}
+
+// TogglePlay plays or pauses. Pausing fades out over -pause-fade-out, and toggling during a fade-out goes on playing.
+func (p *Player) TogglePlay() {
+	p.shuttle = 0
+	if p.audioPlayer.IsPlaying() {
+		switch {
+		case p.FadingOut():
+			p.startFade(p.gain(), 1, playFadeIn(), fadeEndNone)
+		case pauseFadeOut() > 0:
+			p.startFade(p.gain(), 0, pauseFadeOut(), fadeEndPause)
+		default:
+			p.Pause()
+		}
+		return
+	}
+	p.play()
+}
+
+// play plays the audio player fading in over -play-fade-in. The playback paused at the loop end goes on to the loop start.
+func (p *Player) play() {
+	// Seeking starts the stream again after it stopped at the loop end, and the loop wraps to the loop start.
+	if p.parkedAtLoopEnd() {
+		intro, loop := p.playbackLoop()
+		if err := p.seekAudio(samplesToDuration(intro + loop)); err != nil {
+			log.Printf("seek error: %s, %v", p.path, err)
+		}
+	}
+	if d := playFadeIn(); d > 0 {
+		p.startFade(0, 1, d, fadeEndNone)
+	}
+	p.audioPlayer.Play()
+}
+
+// parkedAtLoopEnd reports whether the playback has paused at the loop end with -pause-at-loop-end.
+func (p *Player) parkedAtLoopEnd() bool {
+	return p.loopEnd != nil && !p.audioPlayer.IsPlaying() && p.loopEnd.Parked()
+}
+
+func (p *Player) IsPlaying() bool {
+	return p.audioPlayer.IsPlaying()
+}
+
+func (p *Player) Close() error {
+	// The analysis is no longer needed.
+	p.analysisJob.Cancel()
+	// The audio player reads the file until it is closed.
+	err := p.audioPlayer.Close()
+	if ferr := p.file.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Seek moves the playing position to pos. pos is clamped to the file length.
+func (p *Player) Seek(pos time.Duration) error {
+	if pos < 0 {
+		pos = 0
+	}
+	if pos >= p.total {
+		pos = p.total - 1
+	}
+	p.current = pos
+	p.loopIter = 0
+	return p.seekAudio(pos)
+}
+
+// seekAudio seeks the audio player to pos in the source.
+func (p *Player) seekAudio(pos time.Duration) error {
+	p.rateFrom, p.rateOutFrom = pos, pos
+	return p.audioPlayer.Seek(pos)
+}
+
+// sourcePosition returns the position in the looped source, which differs from the audio player's position
+// while the preview changes the playback rate or the tempo.
+func (p *Player) sourcePosition() time.Duration {
+	out := p.audioPlayer.Current()
+	if r := thePreview.speed(); r != p.rate {
+		p.rateFrom += time.Duration(float64(out-p.rateOutFrom) * p.rate)
+		p.rateOutFrom = out
+		p.rate = r
+	}
+	return p.rateFrom + time.Duration(float64(out-p.rateOutFrom)*p.rate)
+}
+
+// AddVolume changes the volume by delta in the 0-128 scale.
+func (p *Player) AddVolume(delta int) {
+	p.volume128 += delta
+	if p.volume128 < 0 {
+		p.volume128 = 0
+	}
+	if 128 < p.volume128 {
+		p.volume128 = 128
+	}
+	theSettings.Volume = p.volume128
+	p.applyVolume()
+}
+
+// Peaks returns the peak levels of the left and right channels in [0, 1] since the last call.
+func (p *Player) Peaks() (left, right float64) {
+	return p.meter.Peaks()
+}
+
+// AudioHeadroom returns the lowest headroom of the audio callbacks since the last call.
+func (p *Player) AudioHeadroom() (float64, bool) {
+	return p.meter.Headroom()
+}
+
+func (p *Player) loopStartInSecond() float64 {
+	return float64(p.introSample) / sampleRate
+}
+
+func (p *Player) loopLengthInSecond() float64 {
+	return float64(p.loopSample) / sampleRate
+}
+
+func (p *Player) loopEndInSecond() float64 {
+	return float64(p.introSample+p.loopSample) / sampleRate
+}
+
+// updateCurrent updates the current position in the file from the audio player's position.
+// The audio player's position keeps increasing over loops, so this wraps it into the loop range.
+func (p *Player) updateCurrent() {
+	select {
+	case p.seBytes = <-p.seCh:
+		close(p.seCh)
+		p.seCh = nil
+	default:
+	}
+
+	select {
+	case a := <-p.analysisCh:
+		p.analysis = a
+		p.analysisCh = nil
+		p.ruleWarnings = theRules.check(p.path, p.info, p.total, p.Loudness())
+	default:
+	}
+
+	now := time.Now()
+	if p.audioPlayer.IsPlaying() {
+		if !p.lastUpdated.IsZero() {
+			p.played += now.Sub(p.lastUpdated)
+		}
+
+		intro, loop := p.playbackLoop()
+		curentSample := int64(p.sourcePosition() * sampleRate / time.Second)
+		newSample := curentSample
+		if curentSample > intro && loop > 0 {
+			newSample = (curentSample-intro)%loop + intro
+		}
+		prev := p.current
+		p.current = (time.Duration(newSample) * time.Second) / time.Duration(sampleRate)
+
+		// Seek updates current directly, so going back here means that the playback passed the loop seam.
+		if p.loopSample > 0 && p.current < prev && prev >= samplesToDuration(p.introSample) {
+			p.seamPlays++
+			p.loopIter++
+		}
+	}
+	// The wrapped position is the loop start, but the playhead is parked at the loop end.
+	if p.parkedAtLoopEnd() {
+		intro, loop := p.playbackLoop()
+		p.current = samplesToDuration(intro + loop)
+	}
+	p.lastUpdated = now
+	p.updateShuttle(now)
+	p.updateFade(now)
+}
+
+func samplesToDuration(samples int64) time.Duration {
+	return time.Duration(samples) * time.Second / sampleRate
+}
+
+func durationToSamples(d time.Duration) int64 {
+	return int64(d * sampleRate / time.Second)
+}
@@ -0,0 +1,311 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Playlist is an ordered list of files to review.
+type Playlist struct {
+	paths   []string
+	markers map[string][]Marker
+	index   int
+
+	// loops are the loops given by a project file, overriding the files' tags.
+	loops map[string]projectLoop
+
+	// reviews are the reviews of the files from their sidecars or a project file.
+	reviews map[string]trackReview
+
+	// projectName is the name of the last opened project.
+	projectName string
+
+	// projects are the paths of the opened projects, which are updated when a file is renamed.
+	projects []string
+
+	// profile is the validation profile given by the last opened project.
+	profile string
+}
+
+func NewPlaylist(paths []string) *Playlist {
+	return &Playlist{
+		paths:   paths,
+		markers: map[string][]Marker{},
+		loops:   map[string]projectLoop{},
+		reviews: map[string]trackReview{},
+	}
+}
+
+func (p *Playlist) Len() int {
+	return len(p.paths)
+}
+
+func (p *Playlist) Index() int {
+	return p.index
+}
+
+// Current returns the current path, or an empty string when the playlist is empty.
+func (p *Playlist) Current() string {
+	if len(p.paths) == 0 {
+		return ""
+	}
+	return p.paths[p.index]
+}
+
+// SetIndex sets the current index and reports whether index is in the range.
+func (p *Playlist) SetIndex(index int) bool {
+	if index < 0 || len(p.paths) <= index {
+		return false
+	}
+	p.index = index
+	return true
+}
+
+// Markers returns the markers of path given by a CUE sheet.
+func (p *Playlist) Markers(path string) []Marker {
+	return p.markers[path]
+}
+
+// clone returns a copy of the playlist, e.g. to write it on another goroutine.
+func (p *Playlist) clone() *Playlist {
+	c := NewPlaylist(append([]string(nil), p.paths...))
+	for k, v := range p.markers {
+		c.markers[k] = v
+	}
+	for k, v := range p.loops {
+		c.loops[k] = v
+	}
+	for k, v := range p.reviews {
+		c.reviews[k] = v
+	}
+	c.index = p.index
+	c.projectName = p.projectName
+	c.projects = append([]string(nil), p.projects...)
+	c.profile = p.profile
+	return c
+}
+
+// prepare applies the playlist's data of the current file, e.g. the markers and the loop given by a project, to player.
+func (p *Playlist) prepare(player *Player) error {
+	path := p.Current()
+	player.markers = p.Markers(path)
+	var project *projectLoop
+	if l, ok := p.loops[path]; ok && player.isValidLoop(l.Start, l.Length) {
+		if err := player.SetLoop(l.Start, l.Length); err != nil {
+			return err
+		}
+		player.loopSource = "project"
+		project = &l
+	}
+	player.checkLoopConflict(project)
+	return nil
+}
+
+// loopSaved updates the loop given by a project for path to the loop saved to the file's tags,
+// as the project's loop would override the tags otherwise.
+func (p *Playlist) loopSaved(path string, introSample, loopSample int64) {
+	if _, ok := p.loops[path]; !ok {
+		return
+	}
+	p.loops[path] = projectLoop{Start: introSample, Length: loopSample}
+}
+
+// Review returns the review of path.
+func (p *Playlist) Review(path string) trackReview {
+	return p.reviews[path]
+}
+
+// SetReview sets the review of path and saves it to the sidecar.
+func (p *Playlist) SetReview(path string, r trackReview) error {
+	if err := saveTrackReview(path, r); err != nil {
+		return err
+	}
+	p.reviews[path] = r
+	return nil
+}
+
+// TakeNote adds an empty note at sample to the review of path and saves it, so that the moment is kept even if no text is written.
+// TakeNote returns the index of the note to write its text with SetNoteText.
+func (p *Playlist) TakeNote(path string, sample int64) (int, error) {
+	r, i := p.reviews[path].withMoment(sample)
+	if err := p.SetReview(path, r); err != nil {
+		return 0, err
+	}
+	return i, nil
+}
+
+// SetNoteText sets the text of the i-th note taken in path.
+func (p *Playlist) SetNoteText(path string, i int, text string) error {
+	return p.SetReview(path, p.reviews[path].withMomentText(i, text))
+}
+
+// appendEntry appends path with its review in the sidecar if exists.
+func (p *Playlist) appendEntry(path string) {
+	p.paths = append(p.paths, path)
+	r, ok, err := loadTrackReview(path)
+	if err != nil {
+		log.Printf("review error: %s, %v", path, err)
+		return
+	}
+	if ok {
+		p.reviews[path] = r
+	}
+}
+
+// AddFile appends path to the playlist. If path is an M3U playlist, a CUE sheet or a project, its entries are appended instead.
+// AddFile returns the index of the first appended entry.
+func (p *Playlist) AddFile(path string) (int, error) {
+	first := len(p.paths)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		if err := p.addM3U(path); err != nil {
+			return 0, err
+		}
+	case ".cue":
+		if err := p.addCUE(path); err != nil {
+			return 0, err
+		}
+	case projectExt:
+		if err := p.addProject(path); err != nil {
+			return 0, err
+		}
+	default:
+		p.appendEntry(path)
+	}
+	if first == len(p.paths) {
+		return 0, fmt.Errorf("oggplayer: no entries in %s", path)
+	}
+	return first, nil
+}
+
+// findOrAdd returns the index of path in the playlist, adding it when it is not in the playlist yet.
+func (p *Playlist) findOrAdd(path string) (int, error) {
+	abs := absPath(path)
+	for i, entry := range p.paths {
+		if absPath(entry) == abs {
+			return i, nil
+		}
+	}
+	return p.AddFile(path)
+}
+
+func (p *Playlist) addM3U(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(s.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Streams are not supported.
+		if strings.Contains(line, "://") {
+			continue
+		}
+		p.appendEntry(resolvePlaylistEntry(path, line))
+	}
+	return s.Err()
+}
+
+func (p *Playlist) addCUE(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	files, err := parseCUE(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, cf := range files {
+		entry := resolvePlaylistEntry(path, cf.path)
+		p.appendEntry(entry)
+		p.markers[entry] = append(p.markers[entry], cf.markers...)
+	}
+	return nil
+}
+
+// resolvePlaylistEntry resolves entry in the playlist file playlistPath.
+// A relative entry is relative to the playlist file's directory.
+func resolvePlaylistEntry(playlistPath string, entry string) string {
+	entry = filepath.FromSlash(entry)
+	if filepath.IsAbs(entry) {
+		return entry
+	}
+	return filepath.Join(filepath.Dir(playlistPath), entry)
+}
+
+// relativePlaylistEntry returns entry relative to dir with slashes, or the absolute path when entry is on another volume.
+func relativePlaylistEntry(dir string, entry string) (string, error) {
+	abs, err := filepath.Abs(entry)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return abs, nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Write writes the playlist to path as a project file or an M3U file depending on the extension.
+func (p *Playlist) Write(path string) error {
+	if strings.ToLower(filepath.Ext(path)) == projectExt {
+		return p.WriteProject(path)
+	}
+	return p.WriteM3U(path)
+}
+
+// WriteM3U writes the playlist to path as an extended M3U file.
+// The entries are written relative to the directory of path so that the playlist can be shared with the files.
+func (p *Playlist) WriteM3U(path string) error {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#EXTM3U")
+	for _, entry := range p.paths {
+		rel, err := relativePlaylistEntry(dir, entry)
+		if err != nil {
+			return err
+		}
+		title := strings.TrimSuffix(filepath.Base(entry), filepath.Ext(entry))
+		fmt.Fprintf(w, "#EXTINF:-1,%s\n", title)
+		fmt.Fprintln(w, rel)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
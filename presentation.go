@@ -0,0 +1,163 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+const (
+	// presentationScale is how many times the timecode is scaled up in the presentation mode.
+	presentationScale = 4
+
+	// presentationMeterWidth and presentationMeterHeight are the size of a channel's meter in the presentation mode.
+	presentationMeterWidth  = 8
+	presentationMeterHeight = 140
+)
+
+// togglePresentationIfNeeded switches the presentation mode with F11, and Shift+F11 moves it to the next monitor.
+// The presentation mode shows the track fullscreen with a large timecode, the meters and the waveform for playing it to a room,
+// and the playback is still controlled with the usual keys.
+func (g *Game) togglePresentationIfNeeded() {
+	if g.mini || !inpututil.IsKeyJustPressed(ebiten.KeyF11) {
+		return
+	}
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		if g.presenting {
+			ebiten.SetMonitor(nextMonitor(ebiten.Monitor()))
+		}
+		return
+	}
+	g.presenting = !g.presenting
+	if g.presenting {
+		g.windowX, g.windowY = ebiten.WindowPosition()
+		if m, err := presentationMonitor(*flagPresent); err != nil {
+			log.Printf("presentation error: %v", err)
+		} else if m != nil {
+			ebiten.SetMonitor(m)
+		}
+		ebiten.SetFullscreen(true)
+		return
+	}
+	ebiten.SetFullscreen(false)
+	ebiten.SetWindowPosition(g.windowX, g.windowY)
+}
+
+// presentationMonitor returns the n-th monitor counted from 1 in the order the system reports them,
+// or nil for the monitor the window is on when n is 0.
+func presentationMonitor(n int) (*ebiten.MonitorType, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	ms := ebiten.AppendMonitors(nil)
+	if n < 0 || n > len(ms) {
+		return nil, fmt.Errorf("no monitor %d in %d monitors", n, len(ms))
+	}
+	return ms[n-1], nil
+}
+
+// nextMonitor returns the monitor after m in the order the system reports them, wrapping to the first one.
+func nextMonitor(m *ebiten.MonitorType) *ebiten.MonitorType {
+	ms := ebiten.AppendMonitors(nil)
+	for i := range ms {
+		if ms[i] == m {
+			return ms[(i+1)%len(ms)]
+		}
+	}
+	return ms[0]
+}
+
+// formatTimecode formats d as minutes, seconds and tenths, readable from the back of a room.
+func formatTimecode(d time.Duration) string {
+	return fmt.Sprintf("%s.%d", formatTime(d), (d/(100*time.Millisecond))%10)
+}
+
+// drawPresentation draws the presentation mode: the title, the large timecode, the meters and the waveform.
+// The waveform and the bar are where they are in the normal screen, so that clicking on them seeks as usual.
+func (g *Game) drawPresentation(screen *ebiten.Image) {
+	p := g.musicPlayer
+	if p == nil {
+		ebitenutil.DebugPrint(screen, "No file (F11: exit presentation mode)")
+		return
+	}
+
+	b := &g.shapes
+	b.begin(screen)
+	if p.analysis != nil {
+		if pcm, from := p.viewPCM(); pcm != nil {
+			p.drawSamples(b, pcm, from, waveformColor)
+		} else {
+			p.drawWaveform(b, p.analysis.waveform, waveformColor)
+		}
+		p.drawClips(b)
+	}
+	x, y, w, h := playerBarRect()
+	b.Rect(float64(x), float64(y), float64(w), float64(h), playerBarColor)
+	_, wy, _, _ := waveformRect()
+	if p.loopSample > 0 {
+		for _, s := range []int64{p.introSample, p.introSample + p.loopSample} {
+			if lx, ok := p.timelineX(s); ok {
+				b.Rect(float64(lx), float64(wy), 1, float64(y+h-wy), loopCursorColor)
+			}
+		}
+	}
+	if cx, ok := p.timelineX(p.currentSample()); ok {
+		b.Rect(float64(cx-1), float64(wy), 2, float64(y+h+2-wy), playerCurrentColor)
+	}
+	g.meters.draw(b, screenWidth-2*presentationMeterWidth-9, wy-12, presentationMeterWidth, presentationMeterHeight)
+	b.Flush()
+
+	name := []rune(filepath.Base(p.path))
+	if n := screenWidth/debugCharWidth - 1; len(name) > n {
+		name = name[:n]
+	}
+	ebitenutil.DebugPrintAt(screen, string(name), 4, 4)
+
+	// The timecode is drawn small to an offscreen image and scaled up without smoothing.
+	tc := formatTimecode(p.current)
+	if g.timecode == nil {
+		g.timecode = ebiten.NewImage(len(tc)*debugCharWidth+1, debugLineHeight+1)
+	}
+	g.timecode.Clear()
+	ebitenutil.DebugPrint(g.timecode, tc)
+	op := &ebiten.DrawImageOptions{}
+	op.GeoM.Scale(presentationScale, presentationScale)
+	op.GeoM.Translate(4, 24)
+	screen.DrawImage(g.timecode, op)
+
+	state := "Paused"
+	if p.IsPlaying() {
+		state = "Playing"
+		if l := p.shuttleLabel(); l != "" {
+			state = l
+		}
+	}
+	lines := fmt.Sprintf("%s / %s", state, formatTime(p.total))
+	if p.loopSample > 0 {
+		lines += fmt.Sprintf("\nLoop %s - %s", formatTime(samplesToDuration(p.introSample)), formatTime(samplesToDuration(p.introSample+p.loopSample)))
+	}
+	// The badge is always shown not to judge the sound through forgotten preview processing.
+	if badge := thePreview.badge(); badge != "" {
+		lines += "\n" + badge
+	}
+	ebitenutil.DebugPrintAt(screen, lines, 4, 24+presentationScale*debugLineHeight+8)
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// previewStage is a processing of the playback for the audition, e.g. a transpose or a mono downmix.
+// The files are never changed by a stage.
+type previewStage interface {
+	// Name returns the short description of the stage shown in the badge, e.g. "Mono".
+	Name() string
+
+	// Active reports whether the stage changes the sound.
+	Active() bool
+
+	// Process processes interleaved stereo PCM in place. Process is called on the audio goroutine.
+	Process(pcm []int16)
+}
+
+// previewChain is the chain of the preview stages applied to the playback of every file.
+// All the stages can be bypassed at once so that the sound can be judged without them.
+type previewChain struct {
+	stages []previewStage
+	bypass bool
+	m      sync.Mutex
+}
+
+// Add appends s to the chain.
+func (c *previewChain) Add(s previewStage) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.stages = append(c.stages, s)
+}
+
+// ToggleBypass bypasses all the stages, or stops bypassing them.
+func (c *previewChain) ToggleBypass() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.bypass = !c.bypass
+}
+
+// ActiveNames returns the names of the active stages, and whether they are bypassed.
+func (c *previewChain) ActiveNames() (names []string, bypassed bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for _, s := range c.stages {
+		if s.Active() {
+			names = append(names, s.Name())
+		}
+	}
+	return names, c.bypass
+}
+
+// process applies the active stages to pcm unless bypassed.
+func (c *previewChain) process(pcm []int16) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.bypass {
+		return
+	}
+	for _, s := range c.stages {
+		if s.Active() {
+			s.Process(pcm)
+		}
+	}
+}
+
+// badge returns the badge shown while the playback is processed, or an empty string when it is not.
+func (c *previewChain) badge() string {
+	names, bypassed := c.ActiveNames()
+	if len(names) == 0 {
+		return ""
+	}
+	if bypassed {
+		return "BYPASSED: " + strings.Join(names, ", ") + " (Ctrl+B: restore)"
+	}
+	return "PROCESSED AUDITION: " + strings.Join(names, ", ") + " (Ctrl+B: bypass)"
+}
+
+// previewStream is a stream processed by a previewChain.
+type previewStream struct {
+	src   io.ReadSeeker
+	chain *previewChain
+	pcm   []int16
+}
+
+func newPreviewStream(src io.ReadSeeker, chain *previewChain) *previewStream {
+	return &previewStream{
+		src:   src,
+		chain: chain,
+	}
+}
+
+func (s *previewStream) Read(buf []byte) (int, error) {
+	n, err := s.src.Read(buf)
+	frames := n / bytesPerSample
+	if frames == 0 {
+		return n, err
+	}
+	if cap(s.pcm) < frames*2 {
+		s.pcm = make([]int16, frames*2)
+	}
+	pcm := s.pcm[:frames*2]
+	for i := range pcm {
+		pcm[i] = int16(buf[2*i]) | int16(buf[2*i+1])<<8
+	}
+	s.chain.process(pcm)
+	for i, v := range pcm {
+		buf[2*i] = byte(v)
+		buf[2*i+1] = byte(v >> 8)
+	}
+	return n, err
+}
+
+func (s *previewStream) Seek(offset int64, whence int) (int64, error) {
+	return s.src.Seek(offset, whence)
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// validationProfile is the format requirements of a game engine.
+type validationProfile struct {
+	Name string `json:"name"`
+
+	// SampleRates are the allowed sample rates. Empty means any.
+	SampleRates []int `json:"sampleRates"`
+
+	// MaxChannels is the maximum number of channels. 0 means any.
+	MaxChannels int `json:"maxChannels"`
+
+	// RequiredTags are the tags that must exist, e.g. the loop tags the engine reads.
+	RequiredTags []string `json:"requiredTags"`
+
+	// MaxBitrate is the maximum nominal bitrate in kbps. 0 means any.
+	MaxBitrate int `json:"maxBitrate"`
+}
+
+var builtinProfiles = map[string]*validationProfile{
+	"rpgmaker": {
+		Name:         "RPG Maker",
+		SampleRates:  []int{44100, 48000},
+		MaxChannels:  2,
+		RequiredTags: []string{loopStartKey, loopLengthKey},
+	},
+	"godot": {
+		// Godot sets loops in the import settings instead of the tags.
+		Name:        "Godot",
+		SampleRates: []int{44100, 48000},
+		MaxChannels: 2,
+	},
+	"renpy": {
+		Name:        "Ren'Py",
+		SampleRates: []int{44100, 48000},
+		MaxChannels: 2,
+		MaxBitrate:  320,
+	},
+}
+
+// loadValidationProfile returns the built-in profile of the name, or loads a custom profile from a JSON file.
+func loadValidationProfile(nameOrPath string) (*validationProfile, error) {
+	if p, ok := builtinProfiles[strings.ToLower(nameOrPath)]; ok {
+		return p, nil
+	}
+	if !strings.HasSuffix(strings.ToLower(nameOrPath), ".json") {
+		return nil, fmt.Errorf("profile must be rpgmaker, godot, renpy or a JSON file but was %q", nameOrPath)
+	}
+
+	dat, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return nil, err
+	}
+	p := &validationProfile{}
+	if err := json.Unmarshal(dat, p); err != nil {
+		return nil, fmt.Errorf("%s: %w", nameOrPath, err)
+	}
+	if p.Name == "" {
+		p.Name = "custom"
+	}
+	return p, nil
+}
+
+// check returns the violations of the profile. A nil profile checks nothing.
+func (p *validationProfile) check(info *fileInfo) []string {
+	if p == nil || info == nil {
+		return nil
+	}
+
+	var warnings []string
+	if len(p.SampleRates) > 0 {
+		ok := false
+		for _, r := range p.SampleRates {
+			if info.sampleRate == r {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("sample rate %d Hz is not supported", info.sampleRate))
+		}
+	}
+	if p.MaxChannels > 0 && info.channels > p.MaxChannels {
+		warnings = append(warnings, fmt.Sprintf("%d channels exceed %d", info.channels, p.MaxChannels))
+	}
+	for _, tag := range p.RequiredTags {
+		if info.comments == nil || len(info.comments.Get(tag)) == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s is missing", tag))
+		}
+	}
+	if p.MaxBitrate > 0 && info.nominalBitrate > p.MaxBitrate*1000 {
+		warnings = append(warnings, fmt.Sprintf("bitrate %d kbps exceeds %d kbps", info.nominalBitrate/1000, p.MaxBitrate))
+	}
+	return warnings
+}
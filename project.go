@@ -0,0 +1,147 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// projectExt is the extension of a project file.
+const projectExt = ".oggproj"
+
+// project groups the files of a soundtrack with their review data, so that a game's soundtrack can be opened as a unit.
+// A project file is JSON.
+type project struct {
+	Name string `json:"name"`
+
+	// Profile is the validation profile of the game: a builtin profile name or a JSON file relative to the project.
+	Profile string `json:"profile,omitempty"`
+
+	Tracks []projectTrack `json:"tracks"`
+}
+
+type projectTrack struct {
+	// File is relative to the project file.
+	File string `json:"file"`
+
+	// Loop overrides the loop tags of the file. Loop is nil when the tags are used.
+	Loop *projectLoop `json:"loop,omitempty"`
+
+	Markers []Marker     `json:"markers,omitempty"`
+	Status  reviewStatus `json:"status,omitempty"`
+	Notes   string       `json:"notes,omitempty"`
+	Moments []timedNote  `json:"moments,omitempty"`
+}
+
+// projectLoop is a loop in samples.
+type projectLoop struct {
+	Start  int64 `json:"start"`
+	Length int64 `json:"length"`
+}
+
+func (p *Playlist) addProject(path string) error {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var proj project
+	if err := json.Unmarshal(dat, &proj); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// The profile given by -profile takes precedence.
+	if proj.Profile != "" && theProfile == nil {
+		name := proj.Profile
+		if strings.EqualFold(filepath.Ext(name), ".json") {
+			name = resolvePlaylistEntry(path, name)
+		}
+		prof, err := loadValidationProfile(name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		theProfile = prof
+		p.profile = name
+	}
+
+	for _, t := range proj.Tracks {
+		entry := resolvePlaylistEntry(path, t.File)
+		// The sidecar is newer than the project as it is saved whenever the review is changed.
+		p.reviews[entry] = trackReview{Status: t.Status, Notes: t.Notes, Moments: t.Moments}
+		p.appendEntry(entry)
+		p.markers[entry] = append(p.markers[entry], t.Markers...)
+		if t.Loop != nil {
+			p.loops[entry] = *t.Loop
+		}
+	}
+	p.projectName = proj.Name
+	p.projects = append(p.projects, path)
+	return nil
+}
+
+// WriteProject writes the playlist to path as a project file.
+// The files are written relative to the directory of path so that the project can be shared with the files.
+func (p *Playlist) WriteProject(path string) error {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	proj := project{
+		Name: p.projectName,
+	}
+	if proj.Name == "" {
+		proj.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	profile := p.profile
+	if *flagProfile != "" {
+		profile = *flagProfile
+	}
+	if strings.EqualFold(filepath.Ext(profile), ".json") {
+		rel, err := relativePlaylistEntry(dir, profile)
+		if err != nil {
+			return err
+		}
+		profile = rel
+	}
+	proj.Profile = profile
+	for _, entry := range p.paths {
+		rel, err := relativePlaylistEntry(dir, entry)
+		if err != nil {
+			return err
+		}
+		r := p.reviews[entry]
+		t := projectTrack{
+			File:    rel,
+			Markers: p.markers[entry],
+			Status:  r.Status,
+			Notes:   r.Notes,
+			Moments: r.Moments,
+		}
+		if l, ok := p.loops[entry]; ok {
+			t.Loop = &l
+		}
+		proj.Tracks = append(proj.Tracks, t)
+	}
+
+	dat, err := json.MarshalIndent(&proj, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, dat, 0644)
+}
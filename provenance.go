@@ -0,0 +1,115 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// loopOrigin is a value of a loop tag and where it was read from.
+type loopOrigin struct {
+	key    string
+	value  string
+	source string
+
+	// sidecar reports whether the value is from a sidecar rather than embedded in the file.
+	sidecar bool
+}
+
+// tagSourceName returns the name of where the loop tags are embedded in the file in r.
+func tagSourceName(r io.ReadSeeker) (string, error) {
+	for _, f := range []struct {
+		is   func(io.ReadSeeker) (bool, error)
+		name string
+	}{
+		{isWAVStream, "WAV smpl chunk"},
+		{isOggOpusStream, "Opus comment"},
+		{isMP3Stream, "ID3 TXXX frame"},
+		{isFLACStream, "FLAC comment"},
+	} {
+		ok, err := f.is(r)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return f.name, nil
+		}
+	}
+	return "Vorbis comment", nil
+}
+
+// loopOriginsOf returns the values of the loop tags in c read from source.
+func loopOriginsOf(c *vorbisComments, source string) []loopOrigin {
+	var origins []loopOrigin
+	for _, key := range []string{loopStartKey, loopLengthKey} {
+		for _, v := range c.Get(key) {
+			origins = append(origins, loopOrigin{key: key, value: v, source: source})
+		}
+	}
+	return origins
+}
+
+// loopWinners returns the index of the value of each loop tag in origins that is used.
+// A later value overrides an earlier one.
+func loopWinners(origins []loopOrigin) map[string]int {
+	winners := map[string]int{}
+	for i, o := range origins {
+		winners[o.key] = i
+	}
+	return winners
+}
+
+// loopProvenance returns lines telling where the values of the loop tags came from and which of them are used,
+// so that a loop that differs between the sources can be debugged.
+func (p *Player) loopProvenance() []string {
+	if p.info == nil || len(p.info.loopOrigins) == 0 {
+		return nil
+	}
+	origins := p.info.loopOrigins
+	winners := loopWinners(origins)
+	start, okStart := winners[loopStartKey]
+	length, okLength := winners[loopLengthKey]
+	if okStart && okLength && len(origins) == 2 && origins[start].source == origins[length].source {
+		return []string{fmt.Sprintf("Loop tags: from the %s", origins[start].source)}
+	}
+
+	var lines []string
+	for _, key := range []string{loopStartKey, loopLengthKey} {
+		w, ok := winners[key]
+		if !ok {
+			continue
+		}
+		l := fmt.Sprintf("%s: %s from the %s", key, origins[w].value, origins[w].source)
+		var overridden []string
+		for i, o := range origins {
+			if o.key == key && i != w {
+				overridden = append(overridden, fmt.Sprintf("%s in the %s", o.value, o.source))
+			}
+		}
+		if len(overridden) > 0 {
+			l += ", overriding " + strings.Join(overridden, ", ")
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// sidecarSource returns the name of the loop sidecar at path as a source of loop tags.
+func sidecarSource(path string) string {
+	return "sidecar " + filepath.Base(path)
+}
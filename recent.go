@@ -0,0 +1,146 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// recentFilesLimit is the number of the recent files kept, which can be opened with 1-9.
+const recentFilesLimit = 9
+
+// absPath returns the absolute path of path, or path itself when it cannot be resolved,
+// so that the recent files work from any working directory.
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
+
+// addRecent moves path to the top of the recent files.
+func (s *settings) addRecent(path string) {
+	path = absPath(path)
+	recent := []string{path}
+	for _, r := range s.Recent {
+		if r != path && len(recent) < recentFilesLimit {
+			recent = append(recent, r)
+		}
+	}
+	s.Recent = recent
+}
+
+// renameRecent replaces oldPath in the recent files with newPath, e.g. after the file is renamed or moved.
+func (s *settings) renameRecent(oldPath, newPath string) {
+	oldPath = absPath(oldPath)
+	for i, r := range s.Recent {
+		if r == oldPath {
+			s.Recent[i] = absPath(newPath)
+		}
+	}
+}
+
+// removeRecent removes path from the recent files, e.g. when it no longer exists.
+func (s *settings) removeRecent(path string) {
+	path = absPath(path)
+	recent := s.Recent[:0]
+	for _, r := range s.Recent {
+		if r != path {
+			recent = append(recent, r)
+		}
+	}
+	s.Recent = recent
+}
+
+// recentLines returns the lines of the recent files pane.
+func recentLines(closeKey string) []string {
+	if len(theSettings.Recent) == 0 {
+		return []string{fmt.Sprintf("No recent files (%s: close)", closeKey)}
+	}
+	lines := []string{fmt.Sprintf("Recent files (1-%d: open, %s: close)", len(theSettings.Recent), closeKey)}
+	for i, path := range theSettings.Recent {
+		lines = append(lines, fmt.Sprintf("%d. %s (%s)", i+1, filepath.Base(path), filepath.Dir(path)))
+	}
+	return lines
+}
+
+// recentPath returns the i-th recent file. A file which no longer exists is removed from the list,
+// and recentPath returns an error.
+func recentPath(i int) (string, error) {
+	if i < 0 || i >= len(theSettings.Recent) {
+		return "", nil
+	}
+	path := theSettings.Recent[i]
+	if _, err := os.Stat(path); err != nil {
+		theSettings.removeRecent(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// recentIfNeeded toggles the recent files pane with Ctrl+O. While the pane is shown, 1-9 open the file,
+// and Escape closes the pane. recentIfNeeded reports whether the key input is taken by the pane.
+func (g *Game) recentIfNeeded() (bool, error) {
+	if isControlPressed() && inpututil.IsKeyJustPressed(ebiten.KeyO) {
+		g.showRecent = !g.showRecent
+		return true, nil
+	}
+	if !g.showRecent {
+		return false, nil
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		g.showRecent = false
+		return true, nil
+	}
+	for i := 0; i < recentFilesLimit; i++ {
+		if !inpututil.IsKeyJustPressed(ebiten.KeyDigit1 + ebiten.Key(i)) {
+			continue
+		}
+		path, err := recentPath(i)
+		if err != nil {
+			log.Printf("open error: %v", err)
+			return true, nil
+		}
+		if path == "" {
+			return true, nil
+		}
+		g.showRecent = false
+		index, err := g.playlist.findOrAdd(path)
+		if err != nil {
+			return true, err
+		}
+		return true, g.load(index)
+	}
+	return true, nil
+}
+
+// recentMessage returns the recent files pane.
+func (g *Game) recentMessage() string {
+	lines := recentLines("Ctrl+O")
+	for i := range lines {
+		if runes := []rune(lines[i]); len(runes) > screenWidth/debugCharWidth {
+			lines[i] = string(runes[:screenWidth/debugCharWidth])
+		}
+	}
+	return strings.Join(lines, "\n")
+}
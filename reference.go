@@ -0,0 +1,149 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+// referenceAB auditions the current file and the reference track given by -reference alternately,
+// with the louder one turned down to the loudness of the other, so that the level and the tone are judged
+// on equal footing across the soundtrack.
+//
+// Unlike abComparison, the reference keeps its own position, as it is another piece of music.
+type referenceAB struct {
+	ref *Player
+
+	// source is the current file's player while the reference is auditioned, or nil.
+	source *Player
+}
+
+func newReferenceAB(audioContext *audio.Context, path string) (*referenceAB, error) {
+	ref, err := NewPlayer(audioContext, path)
+	if err != nil {
+		return nil, err
+	}
+	ref.Pause()
+	return &referenceAB{
+		ref: ref,
+	}, nil
+}
+
+// Active reports whether the reference is being auditioned. Active is false for nil.
+func (r *referenceAB) Active() bool {
+	return r != nil && r.source != nil
+}
+
+// Toggle switches between current, the current file's player, and the reference keeping the playing state,
+// and returns the player to audition.
+func (r *referenceAB) Toggle(current *Player) *Player {
+	from, to := current, r.ref
+	if r.Active() {
+		from, to = r.ref, r.source
+	}
+	playing := from.IsPlaying()
+	from.Pause()
+	if r.Active() {
+		r.source = nil
+	} else {
+		r.source = current
+	}
+	r.match(to)
+	if playing {
+		to.Resume()
+	}
+	return to
+}
+
+// Restore switches back to the current file's player if the reference is being auditioned, and returns it.
+// r can be nil.
+func (r *referenceAB) Restore(current *Player) *Player {
+	if !r.Active() {
+		return current
+	}
+	return r.Toggle(current)
+}
+
+// match turns down the louder of source and the reference to the loudness of the other.
+// Nothing is matched until both are analyzed.
+func (r *referenceAB) match(source *Player) {
+	if source == r.ref {
+		source = r.source
+	}
+	if source == nil {
+		return
+	}
+	ls, lr := source.Loudness(), r.ref.Loudness()
+	if math.IsNaN(ls) || math.IsNaN(lr) || math.IsInf(ls, 0) || math.IsInf(lr, 0) {
+		return
+	}
+	target := math.Min(ls, lr)
+	source.setMatchDB(target - ls)
+	r.ref.setMatchDB(target - lr)
+}
+
+// Update matches the loudness of current, as the analysis of either file can finish later and the file can change.
+// The reference's player is updated here while it is not current.
+func (r *referenceAB) Update(current *Player) {
+	if r == nil {
+		return
+	}
+	if !r.Active() {
+		r.ref.updateCurrent()
+	}
+	if current != nil {
+		r.match(current)
+	}
+}
+
+// Close closes the reference's player.
+func (r *referenceAB) Close() {
+	if r == nil {
+		return
+	}
+	r.ref.Close()
+}
+
+// line returns which one is auditioned and how much it is turned down.
+func (r *referenceAB) line(current *Player) string {
+	if r.Active() {
+		return fmt.Sprintf("Reference: playing %s%s (Shift+Tab: back to the file)", filepath.Base(r.ref.path), matchLine(r.ref))
+	}
+	if current == nil {
+		return ""
+	}
+	return fmt.Sprintf("Reference: %s, playing the file%s (Shift+Tab: switch)", filepath.Base(r.ref.path), matchLine(current))
+}
+
+// setMatchDB sets the gain of the loudness matching in dB.
+func (p *Player) setMatchDB(db float64) {
+	if p.matchDB == db {
+		return
+	}
+	p.matchDB = db
+	p.applyVolume()
+}
+
+// matchLine describes how much p is turned down by the loudness matching.
+func matchLine(p *Player) string {
+	if p.matchDB == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" turned down by %.1f dB", -p.matchDB)
+}
@@ -0,0 +1,180 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// sidecarPaths returns the paths of the files kept next to path, which must follow path when it is renamed.
+func sidecarPaths(path string) []string {
+	return append([]string{
+		reviewSidecarPath(path),
+		path + backupExt,
+	}, loopSidecarPaths(path)...)
+}
+
+// renameWithSidecars renames or moves the file at oldPath to newPath with its sidecars.
+// If newPath is a directory, the file is moved into it with the same name.
+// renameWithSidecars returns the new path.
+func renameWithSidecars(oldPath, newPath string) (string, error) {
+	if err := checkWritable(); err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(newPath); err == nil && fi.IsDir() {
+		newPath = filepath.Join(newPath, filepath.Base(oldPath))
+	}
+	if filepath.Clean(oldPath) == filepath.Clean(newPath) {
+		return oldPath, nil
+	}
+
+	// Check all the destinations first so that a sidecar is not left behind halfway.
+	olds := append([]string{oldPath}, sidecarPaths(oldPath)...)
+	news := append([]string{newPath}, sidecarPaths(newPath)...)
+	for i := range olds {
+		if i > 0 {
+			if _, err := os.Stat(olds[i]); os.IsNotExist(err) {
+				continue
+			}
+		}
+		if _, err := os.Stat(news[i]); err == nil {
+			return "", fmt.Errorf("oggplayer: %s already exists", news[i])
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return "", err
+	}
+	for i := 1; i < len(olds); i++ {
+		if err := os.Rename(olds[i], news[i]); err != nil && !os.IsNotExist(err) {
+			return newPath, fmt.Errorf("oggplayer: renamed %s but not its sidecar: %w", oldPath, err)
+		}
+	}
+	return newPath, nil
+}
+
+// renameProjectTrack rewrites the tracks referring to oldPath in the project file at projectPath to refer to newPath.
+func renameProjectTrack(projectPath, oldPath, newPath string) error {
+	dat, err := os.ReadFile(projectPath)
+	if err != nil {
+		return err
+	}
+	var proj project
+	if err := json.Unmarshal(dat, &proj); err != nil {
+		return fmt.Errorf("%s: %w", projectPath, err)
+	}
+	dir, err := filepath.Abs(filepath.Dir(projectPath))
+	if err != nil {
+		return err
+	}
+	var changed bool
+	for i, t := range proj.Tracks {
+		if filepath.Clean(resolvePlaylistEntry(projectPath, t.File)) != filepath.Clean(oldPath) {
+			continue
+		}
+		rel, err := relativePlaylistEntry(dir, newPath)
+		if err != nil {
+			return err
+		}
+		proj.Tracks[i].File = rel
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	dat, err = json.MarshalIndent(&proj, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(projectPath, dat, 0644)
+}
+
+// Rename renames or moves the file at oldPath with its sidecars, and updates the playlist and the opened projects referring to it.
+// The file must not be opened by a player, as an opened file cannot be renamed on Windows.
+// Rename returns the new path.
+func (p *Playlist) Rename(oldPath, newPath string) (string, error) {
+	newPath, err := renameWithSidecars(oldPath, newPath)
+	if newPath == "" {
+		return "", err
+	}
+	if newPath == oldPath {
+		return oldPath, nil
+	}
+
+	for i, path := range p.paths {
+		if path == oldPath {
+			p.paths[i] = newPath
+		}
+	}
+	if m, ok := p.markers[oldPath]; ok {
+		p.markers[newPath] = m
+		delete(p.markers, oldPath)
+	}
+	if l, ok := p.loops[oldPath]; ok {
+		p.loops[newPath] = l
+		delete(p.loops, oldPath)
+	}
+	if r, ok := p.reviews[oldPath]; ok {
+		p.reviews[newPath] = r
+		delete(p.reviews, oldPath)
+	}
+	theSettings.renameRecent(oldPath, newPath)
+
+	// Keep the first error, as the file is already renamed.
+	for _, proj := range p.projects {
+		if perr := renameProjectTrack(proj, oldPath, newPath); perr != nil && err == nil {
+			err = perr
+		}
+	}
+	return newPath, err
+}
+
+// renamePlayer renames or moves the file played by player, and reopens it at the same position.
+// When renaming fails, the file is reopened at the old path.
+// renamePlayer returns the new player, which can be nil when the file cannot be reopened.
+func renamePlayer(playlist *Playlist, player *Player, newPath string) (*Player, error) {
+	s := takeSnapshot(playlist, player)
+	audioContext := player.audioContext
+	player.Close()
+
+	path, err := playlist.Rename(player.path, newPath)
+	if path == "" {
+		path = player.path
+	}
+	n, oerr := NewPlayer(audioContext, path)
+	if oerr != nil {
+		if err == nil {
+			err = oerr
+		}
+		return nil, err
+	}
+	if perr := playlist.prepare(n); perr != nil && err == nil {
+		err = perr
+	}
+	if aerr := s.apply(n); aerr != nil && err == nil {
+		err = aerr
+	}
+	// The renamed file is still the same listening session.
+	n.openedAt = player.openedAt
+	n.played = player.played
+	n.seamPlays = player.seamPlays
+	n.loopConflict = player.loopConflict
+	return n, err
+}
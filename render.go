@@ -0,0 +1,102 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// renderedPath returns the path the intro and loops loop iterations of path are rendered to with the format ext.
+func renderedPath(path string, loops int, ext string) string {
+	return fmt.Sprintf("%s_x%d.%s", strings.TrimSuffix(path, filepath.Ext(path)), loops, ext)
+}
+
+// renderLoops renders the intro and loops iterations of the loop of pcm offline through the audio graph the playback reads,
+// without the preview stages, so that the result is what the player actually plays.
+// The last fade frames are faded out linearly.
+func renderLoops(pcm []int16, introSample, loopSample int64, loops int, fade int64) ([]int16, error) {
+	g := audioGraph{
+		introSample: introSample,
+		loopSample:  loopSample,
+	}
+	frames := introSample + int64(loops)*loopSample
+	out, err := g.render(pcm, frames)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)/2) < frames {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if fade > frames {
+		fade = frames
+	}
+	for i := int64(0); i < fade; i++ {
+		f := frames - fade + i
+		g := float64(fade-i) / float64(fade)
+		out[2*f] = int16(float64(out[2*f]) * g)
+		out[2*f+1] = int16(float64(out[2*f+1]) * g)
+	}
+	return out, nil
+}
+
+// RenderJob returns the function writing the intro and *flagLoops iterations of the current loop next to the file,
+// faded out in the last *flagFadeOut seconds, as WAV or Ogg/Vorbis by *flagRender.
+// The function can be called on another goroutine.
+func (p *Player) RenderJob() func(j *job) (string, error) {
+	src := p.path
+	intro, loop := p.playbackLoop()
+	loops := *flagLoops
+	fade := int64(*flagFadeOut * sampleRate)
+	format := *flagRender
+	path := renderedPath(p.path, loops, format)
+	return func(j *job) (string, error) {
+		if err := checkWritable(); err != nil {
+			return "", err
+		}
+		if loops < 1 {
+			return "", fmt.Errorf("oggplayer: the number of loops must be positive: %d", loops)
+		}
+		pcm, err := decodePCM(src, j)
+		if err != nil {
+			return "", err
+		}
+		out, err := renderLoops(pcm, intro, loop, loops, fade)
+		if err != nil {
+			return "", err
+		}
+		switch format {
+		case "wav":
+			var buf bytes.Buffer
+			if err := writeWAV(&buf, out); err != nil {
+				return "", err
+			}
+			if err := writeFileSync(path, buf.Bytes(), 0644); err != nil {
+				return "", err
+			}
+		case "ogg":
+			if err := encodeVorbis(path, out, defaultVorbisQuality, nil, j); err != nil {
+				return "", err
+			}
+		default:
+			return "", fmt.Errorf("oggplayer: unknown render format: %s", format)
+		}
+		return path, nil
+	}
+}
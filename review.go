@@ -0,0 +1,148 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+)
+
+// reviewStatus is a reviewer's verdict of a track.
+type reviewStatus string
+
+const (
+	reviewNone     reviewStatus = ""
+	reviewOK       reviewStatus = "ok"
+	reviewNeedsFix reviewStatus = "needs-fix"
+	reviewBlocked  reviewStatus = "blocked"
+)
+
+var reviewStatuses = []reviewStatus{reviewNone, reviewOK, reviewNeedsFix, reviewBlocked}
+
+// next returns the next status in the cycle.
+func (s reviewStatus) next() reviewStatus {
+	for i, v := range reviewStatuses {
+		if v == s {
+			return reviewStatuses[(i+1)%len(reviewStatuses)]
+		}
+	}
+	return reviewNone
+}
+
+func (s reviewStatus) String() string {
+	switch s {
+	case reviewNone:
+		return "Not reviewed"
+	case reviewOK:
+		return "OK"
+	case reviewNeedsFix:
+		return "Needs fix"
+	case reviewBlocked:
+		return "Blocked"
+	}
+	return string(s)
+}
+
+// trackReview is the review of a track: the status, the reviewer's freeform notes and the notes taken at moments of the track.
+type trackReview struct {
+	Status  reviewStatus `json:"status,omitempty"`
+	Notes   string       `json:"notes,omitempty"`
+	Moments []timedNote  `json:"moments,omitempty"`
+}
+
+func (r trackReview) isEmpty() bool {
+	return r.Status == reviewNone && r.Notes == "" && len(r.Moments) == 0
+}
+
+// timedNote is a note taken at a moment of the track while listening, e.g. "click here". Text can be empty.
+type timedNote struct {
+	Sample int64  `json:"sample"`
+	Text   string `json:"text,omitempty"`
+}
+
+// String returns the note with its time.
+func (n timedNote) String() string {
+	str := formatTimeMillis(samplesToDuration(n.Sample))
+	if n.Text != "" {
+		str += " " + n.Text
+	}
+	return str
+}
+
+// withMoment returns a copy of r with a note at sample, and the index of the note. The notes are sorted by the time.
+func (r trackReview) withMoment(sample int64) (trackReview, int) {
+	i := sort.Search(len(r.Moments), func(i int) bool {
+		return r.Moments[i].Sample > sample
+	})
+	moments := make([]timedNote, 0, len(r.Moments)+1)
+	moments = append(moments, r.Moments[:i]...)
+	moments = append(moments, timedNote{Sample: sample})
+	moments = append(moments, r.Moments[i:]...)
+	r.Moments = moments
+	return r, i
+}
+
+// withMomentText returns a copy of r with the text of the i-th note replaced.
+func (r trackReview) withMomentText(i int, text string) trackReview {
+	if i < 0 || len(r.Moments) <= i {
+		return r
+	}
+	r.Moments = append([]timedNote(nil), r.Moments...)
+	r.Moments[i].Text = text
+	return r
+}
+
+// reviewSidecarPath returns the path of the sidecar file keeping the review of path.
+// The review is kept next to the file so that it survives without a project.
+func reviewSidecarPath(path string) string {
+	return path + ".review.json"
+}
+
+// loadTrackReview reads the review of path from its sidecar.
+// loadTrackReview reports false when the sidecar doesn't exist.
+func loadTrackReview(path string) (trackReview, bool, error) {
+	var r trackReview
+	dat, err := os.ReadFile(reviewSidecarPath(path))
+	if os.IsNotExist(err) {
+		return r, false, nil
+	}
+	if err != nil {
+		return r, false, err
+	}
+	if err := json.Unmarshal(dat, &r); err != nil {
+		return r, false, err
+	}
+	return r, true, nil
+}
+
+// saveTrackReview writes the review of path to its sidecar. An empty review removes the sidecar.
+func saveTrackReview(path string, r trackReview) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	sidecar := reviewSidecarPath(path)
+	if r.isEmpty() {
+		if err := os.Remove(sidecar); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	dat, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(sidecar, dat, 0644)
+}
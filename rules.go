@@ -0,0 +1,105 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkRules is a studio's own rules for the files, loaded from a YAML or JSON file.
+//
+//	maxDuration: 300
+//	minLoudness: -18
+//	maxLoudness: -14
+//	namePattern: '^bgm_[a-z0-9_]+\.ogg$'
+//	requiredTags: [LOOPSTART, LOOPLENGTH, TITLE]
+type checkRules struct {
+	// MaxDuration is the maximum duration in seconds. 0 means no limit.
+	MaxDuration float64 `yaml:"maxDuration"`
+
+	// MinLoudness and MaxLoudness are the range of the integrated loudness in LUFS.
+	MinLoudness *float64 `yaml:"minLoudness"`
+	MaxLoudness *float64 `yaml:"maxLoudness"`
+
+	// NamePattern is a regular expression the file name must match.
+	NamePattern string `yaml:"namePattern"`
+
+	// RequiredTags are the tags that must exist.
+	RequiredTags []string `yaml:"requiredTags"`
+
+	nameRe *regexp.Regexp
+}
+
+func loadCheckRules(path string) (*checkRules, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &checkRules{}
+	// JSON is also valid YAML.
+	if err := yaml.Unmarshal(dat, r); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if r.NamePattern != "" {
+		re, err := regexp.Compile(r.NamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		r.nameRe = re
+	}
+	return r, nil
+}
+
+// limitsLoudness reports whether the rules have a range of the loudness, which requires decoding the files.
+func (r *checkRules) limitsLoudness() bool {
+	return r != nil && (r.MinLoudness != nil || r.MaxLoudness != nil)
+}
+
+// check returns the violations of the rules. A nil rules checks nothing.
+// loudness is the integrated loudness in LUFS, or NaN if it is not measured yet.
+func (r *checkRules) check(path string, info *fileInfo, duration time.Duration, loudness float64) []string {
+	if r == nil {
+		return nil
+	}
+
+	var warnings []string
+	if r.MaxDuration > 0 && duration.Seconds() > r.MaxDuration {
+		warnings = append(warnings, fmt.Sprintf("duration %.1fs exceeds %.1fs", duration.Seconds(), r.MaxDuration))
+	}
+	if !math.IsNaN(loudness) {
+		if r.MinLoudness != nil && loudness < *r.MinLoudness {
+			warnings = append(warnings, fmt.Sprintf("loudness %.1f LUFS is below %.1f LUFS", loudness, *r.MinLoudness))
+		}
+		if r.MaxLoudness != nil && loudness > *r.MaxLoudness {
+			warnings = append(warnings, fmt.Sprintf("loudness %.1f LUFS is above %.1f LUFS", loudness, *r.MaxLoudness))
+		}
+	}
+	if r.nameRe != nil && !r.nameRe.MatchString(filepath.Base(path)) {
+		warnings = append(warnings, fmt.Sprintf("file name doesn't match %s", r.NamePattern))
+	}
+	for _, tag := range r.RequiredTags {
+		if info == nil || info.comments == nil || len(info.comments.Get(tag)) == 0 {
+			warnings = append(warnings, fmt.Sprintf("%s is missing", tag))
+		}
+	}
+	return warnings
+}
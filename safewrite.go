@@ -0,0 +1,88 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// backupExt is the extension of the backup of a rewritten file.
+const backupExt = ".bak"
+
+// errReadOnly is returned by the operations writing next to the files in the read-only mode.
+var errReadOnly = errors.New("oggplayer: the files can't be changed in the read-only mode")
+
+// checkWritable returns errReadOnly in the read-only mode.
+// Every operation changing the files or writing new files next to them must call this first.
+func checkWritable() error {
+	if *flagReadOnly {
+		return errReadOnly
+	}
+	return nil
+}
+
+// writeFileSync writes dat to path and flushes it to the disk, so that a crash right after doesn't leave a broken file.
+func writeFileSync(path string, dat []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(dat); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// replaceFileSafely replaces the file at path, whose current content is orig, with dat.
+//
+// dat is written to a temporary file next to path first, and verify checks the temporary file.
+// Only when it passes, orig is kept as path + backupExt and the temporary file is renamed to path,
+// so that the original file is never left broken by a failure or a crash.
+func replaceFileSafely(path string, orig, dat []byte, verify func(tmp string) error) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	perm := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := writeFileSync(tmp, dat, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := verify(tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("oggplayer: verifying the new %s failed, the file is not changed: %w", path, err)
+	}
+	if err := writeFileSync(path+backupExt, orig, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// scanDir returns the files under dir with one of the extensions exts, in lowercase with the dot, in the order of the walk.
+func scanDir(dir string, exts ...string) ([]string, error) {
+	var paths []string
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		for _, e := range exts {
+			if ext == e {
+				paths = append(paths, path)
+				break
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// scanPool calls work for each of the n items on -jobs workers at once, and done for the items in order
+// as soon as the item and the ones before it are finished, so that the results stream out in a stable order.
+// done is called on the caller's goroutine, and scanPool returns after all of them are called.
+func scanPool(n int, work func(i int), done func(i int)) {
+	finished := make([]chan struct{}, n)
+	for i := range finished {
+		finished[i] = make(chan struct{})
+	}
+	indices := make(chan int)
+	go func() {
+		for i := 0; i < n; i++ {
+			indices <- i
+		}
+		close(indices)
+	}()
+	workers := *flagJobs
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				work(i)
+				close(finished[i])
+			}
+		}()
+	}
+	defer wg.Wait()
+
+	for i, ch := range finished {
+		<-ch
+		done(i)
+	}
+}
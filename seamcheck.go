@@ -0,0 +1,140 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// seamCheckWindow is the number of the samples compared to find a shift by the encoder.
+	seamCheckWindow = 4096
+
+	// seamCheckMaxShift is the maximum shift in samples searched for.
+	seamCheckMaxShift = 1024
+
+	// seamJumpTolerance is the increase of the jump at the seam regarded as a degradation, about -40 dBFS.
+	seamJumpTolerance = 0.01
+
+	// seamBandTolerance is the increase of the band mismatch in dB regarded as a degradation.
+	seamBandTolerance = 1
+)
+
+// seamIntegrity is the result of comparing the seam of an encoded file with its source.
+type seamIntegrity struct {
+	// shift is the number of the samples the encoded audio is delayed from the source.
+	shift int64
+
+	// sourceJump and encodedJump are the discontinuities at the seam.
+	sourceJump  float64
+	encodedJump float64
+
+	// sourceBand and encodedBand are the largest band mismatches in dB at the seam.
+	sourceBand  float64
+	encodedBand float64
+}
+
+// monoAt returns the mono mix of the i-th frame of interleaved stereo PCM in [-1, 1].
+func monoAt(pcm []int16, i int64) float64 {
+	return (float64(pcm[2*i]) + float64(pcm[2*i+1])) / 2 / 32768
+}
+
+// seamJump returns the discontinuity when the loop end jumps to the loop start,
+// as the error of extrapolating the last two samples of the loop.
+func seamJump(pcm []int16, introSample, loopSample int64) float64 {
+	end := introSample + loopSample
+	if end < 2 || int64(len(pcm)/2) < end {
+		return 0
+	}
+	predicted := 2*monoAt(pcm, end-1) - monoAt(pcm, end-2)
+	return math.Abs(predicted - monoAt(pcm, introSample))
+}
+
+// findShift returns the lag of b against a around the frame at, maximizing the cross-correlation.
+func findShift(a, b []int16, at int64) int64 {
+	frames := int64(len(a) / 2)
+	if n := int64(len(b) / 2); n < frames {
+		frames = n
+	}
+	if at < seamCheckMaxShift {
+		at = seamCheckMaxShift
+	}
+	if at+seamCheckWindow+seamCheckMaxShift > frames {
+		at = frames - seamCheckWindow - seamCheckMaxShift
+	}
+	if at < seamCheckMaxShift {
+		return 0
+	}
+
+	var best int64
+	bestCorr := math.Inf(-1)
+	for lag := int64(-seamCheckMaxShift); lag <= seamCheckMaxShift; lag++ {
+		// Normalize the correlation by the energy of b, which changes with the lag.
+		var corr, energy float64
+		for i := at; i < at+seamCheckWindow; i++ {
+			v := monoAt(b, i+lag)
+			corr += monoAt(a, i) * v
+			energy += v * v
+		}
+		if energy == 0 {
+			continue
+		}
+		corr /= math.Sqrt(energy)
+		if corr > bestCorr {
+			best, bestCorr = lag, corr
+		}
+	}
+	return best
+}
+
+// checkSeamIntegrity compares the seam of the encoded PCM with the source's.
+func checkSeamIntegrity(source, encoded []int16, introSample, loopSample int64) seamIntegrity {
+	s := seamIntegrity{
+		shift:       findShift(source, encoded, introSample),
+		sourceJump:  seamJump(source, introSample, loopSample),
+		encodedJump: seamJump(encoded, introSample, loopSample),
+	}
+	band := func(pcm []int16) float64 {
+		end := introSample + loopSample
+		if loopSample < seamBandSamples || int64(len(pcm)/2) < end {
+			return 0
+		}
+		b := &seamBands{
+			before: measureBandEnergy(pcm, end-seamBandSamples, end),
+			after:  measureBandEnergy(pcm, introSample, introSample+seamBandSamples),
+		}
+		_, diff := b.mismatch()
+		return math.Abs(diff)
+	}
+	s.sourceBand = band(source)
+	s.encodedBand = band(encoded)
+	return s
+}
+
+// warnings returns the problems the encoder introduced.
+func (s seamIntegrity) warnings() []string {
+	var ws []string
+	if s.shift != 0 {
+		ws = append(ws, fmt.Sprintf("the encode shifted the audio by %d samples", s.shift))
+	}
+	if s.encodedJump-s.sourceJump > seamJumpTolerance {
+		ws = append(ws, fmt.Sprintf("the jump at the seam grew from %.3f to %.3f", s.sourceJump, s.encodedJump))
+	}
+	if s.encodedBand-s.sourceBand > seamBandTolerance {
+		ws = append(ws, fmt.Sprintf("the band mismatch at the seam grew from %.1f dB to %.1f dB", s.sourceBand, s.encodedBand))
+	}
+	return ws
+}
@@ -0,0 +1,240 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+const (
+	// seamQualityWindow is the length of the audio rendered at each side of the loop seam.
+	seamQualityWindow = sampleRate * 200 / 1000
+
+	// seamClickSamples is the length at each side of the seam where a click is searched for.
+	seamClickSamples = sampleRate * 2 / 1000
+
+	// seamQualityWarning is the score below which the seam is likely heard.
+	seamQualityWarning = 70
+)
+
+// seamQuality is the result of analyzing the audio around the loop seam, where the loop end is spliced to the loop start.
+type seamQuality struct {
+	introSample int64
+	loopSample  int64
+
+	// jump is how much the extrapolation error at the seam exceeds the typical one around it.
+	// A smooth seam is about 1, and an amplitude jump makes it large.
+	jump float64
+
+	// click is how much the high frequency energy around the seam exceeds the rest in dB.
+	click float64
+
+	// phase is the correlation between the audio after the seam and the audio the loop end would continue to.
+	// A loop cut at a different phase of the music has a low correlation. phase is NaN when it cannot be measured.
+	phase float64
+
+	// score is the seam quality from 0 (bad) to 100 (seamless).
+	score int
+
+	// err is the error reading the PCM at the seam, which is cached not to read the file again every frame.
+	err error
+}
+
+// splicedChannel returns the channel ch of the interleaved stereo PCM a followed by b.
+func splicedChannel(a, b []int16, ch int) []float64 {
+	x := make([]float64, 0, (len(a)+len(b))/2)
+	for _, pcm := range [][]int16{a, b} {
+		for i := ch; i < len(pcm); i += 2 {
+			x = append(x, float64(pcm[i])/32768)
+		}
+	}
+	return x
+}
+
+// measureSeamJump returns the extrapolation error at the seam at the index seam of x, relative to the RMS of the errors elsewhere.
+func measureSeamJump(x []float64, seam int) float64 {
+	residual := func(i int) float64 {
+		return math.Abs(x[i] - (2*x[i-1] - x[i-2]))
+	}
+	var atSeam, sum float64
+	var n int
+	for i := 2; i < len(x); i++ {
+		if i == seam || i == seam+1 {
+			atSeam = math.Max(atSeam, residual(i))
+			continue
+		}
+		r := residual(i)
+		sum += r * r
+		n++
+	}
+	rms := math.Sqrt(sum / float64(n))
+	// Avoid dividing by zero in silence, where only a jump out of nothing matters.
+	const floor = 1.0 / 32768
+	return atSeam / math.Max(rms, floor)
+}
+
+// measureSeamClick returns the energy of the first difference around the seam at the index seam of x, relative to the rest in dB.
+func measureSeamClick(x []float64, seam int) float64 {
+	var near, far float64
+	var nn, nf int
+	for i := 1; i < len(x); i++ {
+		d := x[i] - x[i-1]
+		if seam-seamClickSamples <= i && i < seam+seamClickSamples {
+			near += d * d
+			nn++
+			continue
+		}
+		far += d * d
+		nf++
+	}
+	const floor = 1e-12
+	return 10 * math.Log10((near/float64(nn)+floor)/(far/float64(nf)+floor))
+}
+
+// correlation returns the normalized cross-correlation of the mono mixes of the interleaved stereo PCM a and b.
+// correlation returns NaN when either is silent.
+func correlation(a, b []int16) float64 {
+	n := int64(len(a) / 2)
+	if m := int64(len(b) / 2); m < n {
+		n = m
+	}
+	var ab, aa, bb float64
+	for i := int64(0); i < n; i++ {
+		x, y := monoAt(a, i), monoAt(b, i)
+		ab += x * y
+		aa += x * x
+		bb += y * y
+	}
+	if aa == 0 || bb == 0 {
+		return math.NaN()
+	}
+	return ab / math.Sqrt(aa*bb)
+}
+
+// seamQualityScore returns the score from 0 to 100 combining the penalties of the jump, the click and the phase.
+func seamQualityScore(jump, click, phase float64) int {
+	clamp := func(v float64) float64 {
+		return math.Max(0, math.Min(1, v))
+	}
+	// A jump up to twice the typical error and a click up to 3 dB are usual in music.
+	penalties := []float64{clamp((jump - 2) / 8), clamp((click - 3) / 12)}
+	weights := []float64{0.5, 0.5}
+	if !math.IsNaN(phase) {
+		penalties = append(penalties, clamp((0.9-phase)/0.9))
+		weights = []float64{0.4, 0.4, 0.2}
+	}
+	var penalty float64
+	for i, p := range penalties {
+		penalty += weights[i] * p
+	}
+	return int(math.Round(100 * (1 - penalty)))
+}
+
+// SeamQuality returns the analysis of the seam of the current loop.
+// SeamQuality returns nil until the file is analyzed, or when the seam cannot be read.
+func (p *Player) SeamQuality() *seamQuality {
+	if p.analysis == nil || !p.isValidLoop(p.introSample, p.loopSample) || p.loopSample < 3 {
+		return nil
+	}
+	if q := p.seamQuality; q != nil && q.introSample == p.introSample && q.loopSample == p.loopSample {
+		if q.err != nil {
+			return nil
+		}
+		return q
+	}
+	p.seamQuality = &seamQuality{
+		introSample: p.introSample,
+		loopSample:  p.loopSample,
+	}
+	if err := p.measureSeamQuality(p.seamQuality); err != nil {
+		p.seamQuality.err = err
+		log.Printf("seam quality error: %s, %v", p.path, err)
+		return nil
+	}
+	return p.seamQuality
+}
+
+// measureSeamQuality renders the audio around the seam of q's loop and measures it.
+func (p *Player) measureSeamQuality(q *seamQuality) error {
+	w := int64(seamQualityWindow)
+	if q.loopSample < w {
+		w = q.loopSample
+	}
+	end := q.introSample + q.loopSample
+	read := func(from, to int64) ([]int16, error) {
+		pcm, err := readPCMRange(p.path, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(pcm)/2) < to-from {
+			return nil, fmt.Errorf("oggplayer: the file ends before the seam")
+		}
+		return pcm, nil
+	}
+	before, err := read(end-w, end)
+	if err != nil {
+		return err
+	}
+	after, err := read(q.introSample, q.introSample+w)
+	if err != nil {
+		return err
+	}
+
+	for ch := 0; ch < 2; ch++ {
+		x := splicedChannel(before, after, ch)
+		q.jump = math.Max(q.jump, measureSeamJump(x, int(w)))
+		if c := measureSeamClick(x, int(w)); ch == 0 || c > q.click {
+			q.click = c
+		}
+	}
+
+	// The audio after the loop start should be what the loop end would continue to. Without the audio after the loop end,
+	// the audio before the loop start is compared with the one before the loop end instead.
+	q.phase = math.NaN()
+	switch {
+	case end+w <= p.totalSample:
+		tail, err := read(end, end+w)
+		if err != nil {
+			return err
+		}
+		q.phase = correlation(after, tail)
+	case q.introSample >= w:
+		head, err := read(q.introSample-w, q.introSample)
+		if err != nil {
+			return err
+		}
+		q.phase = correlation(before, head)
+	}
+	q.score = seamQualityScore(q.jump, q.click, q.phase)
+	return nil
+}
+
+// seamQualityLine returns the line describing the seam quality, or an empty string when it is not analyzed.
+func seamQualityLine(q *seamQuality) string {
+	if q == nil {
+		return ""
+	}
+	line := fmt.Sprintf("Seam quality: %d/100 (jump %.1fx, click %+.1f dB", q.score, q.jump, q.click)
+	if !math.IsNaN(q.phase) {
+		line += fmt.Sprintf(", phase %.2f", q.phase)
+	}
+	line += ")"
+	if q.score < seamQualityWarning {
+		line += " Likely audible"
+	}
+	return line
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// settings are the preferences kept across launches, so that the volume and the folder don't have to be set every time.
+type settings struct {
+	// Volume is the volume in the 0-128 scale.
+	Volume int `json:"volume"`
+
+	// LastDir is the directory of the file last opened in the dialog.
+	LastDir string `json:"lastDir,omitempty"`
+
+	// Window is the position and the size of the window. Window is nil until the window is shown once.
+	Window *windowSettings `json:"window,omitempty"`
+
+	// Preview is the seconds before the loop end E seeks to.
+	Preview float64 `json:"preview"`
+
+	// Recent is the absolute paths of the recently opened files, the latest first.
+	Recent []string `json:"recent,omitempty"`
+
+	// Startup is what the app does at startup when no files are given: files, resume, dialog, project:PATH or watch:DIR.
+	Startup string `json:"startup,omitempty"`
+
+	// Keys remaps the actions' keys, e.g. {"play": "K"}. The actions not listed keep the default keys.
+	Keys keyMap `json:"keys,omitempty"`
+}
+
+type windowSettings struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func defaultSettings() *settings {
+	return &settings{
+		Volume:  128,
+		Preview: 3,
+	}
+}
+
+// settingsPath returns the path of the settings file: -config, or config.json in the user's config directory.
+func settingsPath() (string, error) {
+	if *flagConfig != "" {
+		return *flagConfig, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "oggplayer", "config.json"), nil
+}
+
+// loadSettings reads the settings at path. The missing values are the defaults, e.g. when the file doesn't exist yet.
+func loadSettings(path string) (*settings, error) {
+	s := defaultSettings()
+	dat, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(dat, s); err != nil {
+		return nil, err
+	}
+	if s.Volume < 0 || 128 < s.Volume {
+		s.Volume = 128
+	}
+	if names := s.Keys.unknownActions(); len(names) > 0 {
+		log.Printf("settings error: %s, unknown key actions: %s", path, strings.Join(names, ", "))
+	}
+	if problems := s.Keys.badMappings(*flagTUI); len(problems) > 0 {
+		log.Printf("settings error: %s, key mappings ignored: %s", path, strings.Join(problems, "; "))
+	}
+	return s, nil
+}
+
+func (s *settings) save(path string) error {
+	dat, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, dat, 0644)
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// whiteSubImage is a white pixel to fill the shapes with. The inner pixel is used to avoid the bleeding at the edges.
+var whiteSubImage = func() *ebiten.Image {
+	img := ebiten.NewImage(3, 3)
+	img.Fill(color.White)
+	return img.SubImage(image.Rect(1, 1, 2, 2)).(*ebiten.Image)
+}()
+
+// shapeBatch collects filled rectangles and draws them with one DrawTriangles call,
+// instead of a draw call per rectangle, which doesn't scale to the waveform's hundreds of columns on integrated GPUs.
+// The vertices are kept to be reused in the next frame.
+// The texts are still drawn by ebitenutil.DebugPrint after Flush, as text/v2 requires Ebiten 2.7.
+type shapeBatch struct {
+	dst      *ebiten.Image
+	vertices []ebiten.Vertex
+	indices  []uint16
+}
+
+// maxBatchVertices is the number of the vertices a batch can draw at once with uint16 indices.
+const maxBatchVertices = 1 << 16
+
+// begin starts collecting the shapes drawn to dst.
+func (b *shapeBatch) begin(dst *ebiten.Image) {
+	b.dst = dst
+	b.vertices = b.vertices[:0]
+	b.indices = b.indices[:0]
+}
+
+// Rect adds the filled rectangle at (x, y) with the size (w, h).
+func (b *shapeBatch) Rect(x, y, w, h float64, clr color.Color) {
+	if w <= 0 || h <= 0 {
+		return
+	}
+	if len(b.vertices)+4 > maxBatchVertices {
+		b.Flush()
+	}
+
+	// The vertex colors are in the straight alpha.
+	cr, cg, cb, ca := clr.RGBA()
+	var r, g, bl, a float32
+	if ca > 0 {
+		r = float32(cr) / float32(ca)
+		g = float32(cg) / float32(ca)
+		bl = float32(cb) / float32(ca)
+		a = float32(ca) / 0xffff
+	}
+	i := uint16(len(b.vertices))
+	for _, v := range [4][2]float64{{x, y}, {x + w, y}, {x, y + h}, {x + w, y + h}} {
+		b.vertices = append(b.vertices, ebiten.Vertex{
+			DstX:   float32(v[0]),
+			DstY:   float32(v[1]),
+			SrcX:   1,
+			SrcY:   1,
+			ColorR: r,
+			ColorG: g,
+			ColorB: bl,
+			ColorA: a,
+		})
+	}
+	b.indices = append(b.indices, i, i+1, i+2, i+1, i+3, i+2)
+}
+
+// Flush draws the collected shapes, and starts collecting again.
+func (b *shapeBatch) Flush() {
+	if len(b.indices) > 0 {
+		b.dst.DrawTriangles(b.vertices, b.indices, whiteSubImage, nil)
+	}
+	b.vertices = b.vertices[:0]
+	b.indices = b.indices[:0]
+}
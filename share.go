@@ -0,0 +1,110 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// shareToken is a playback moment as text, e.g. "track.ogg@sample=1234567(loop-iter=3)", so that people on a call
+// can point at the same moment by pasting it.
+type shareToken struct {
+	// name is the base name of the file.
+	name string
+
+	// sample is the position at the file's sample rate, which the loop tags count in.
+	sample int64
+
+	// loopIter is the number of the times the loop wrapped before the position.
+	loopIter int
+}
+
+func (s shareToken) String() string {
+	return fmt.Sprintf("%s@sample=%d(loop-iter=%d)", s.name, s.sample, s.loopIter)
+}
+
+var shareTokenRe = regexp.MustCompile(`^(.+)@sample=(\d+)(?:\(loop-iter=(\d+)\))?$`)
+
+// parseShareToken parses a share token. The loop iteration can be omitted.
+func parseShareToken(text string) (shareToken, error) {
+	m := shareTokenRe.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return shareToken{}, fmt.Errorf("oggplayer: not a share token: %q", text)
+	}
+	sample, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return shareToken{}, err
+	}
+	s := shareToken{name: m[1], sample: sample}
+	if m[3] != "" {
+		if s.loopIter, err = strconv.Atoi(m[3]); err != nil {
+			return shareToken{}, err
+		}
+	}
+	return s, nil
+}
+
+// ShareToken returns the token of the current position.
+func (p *Player) ShareToken() shareToken {
+	return shareToken{
+		name:     filepath.Base(p.path),
+		sample:   p.fileSample(p.currentSample()),
+		loopIter: p.loopIter,
+	}
+}
+
+// JumpTo seeks to the position of s, and counts the loop iterations from the ones in s.
+func (p *Player) JumpTo(s shareToken) error {
+	if err := p.Seek(samplesToDuration(p.info.toStreamSamples(s.sample))); err != nil {
+		return err
+	}
+	p.loopIter = s.loopIter
+	return nil
+}
+
+// findName returns the index of the first file named name in the playlist, or -1.
+func (p *Playlist) findName(name string) int {
+	for i, path := range p.paths {
+		if filepath.Base(path) == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// readShareToken reads a share token from the clipboard, and returns it with the index of its file in the playlist.
+// The current file is preferred when the playlist has files of the same name.
+func readShareToken(playlist *Playlist) (shareToken, int, error) {
+	text, err := readClipboard()
+	if err != nil {
+		return shareToken{}, 0, err
+	}
+	s, err := parseShareToken(text)
+	if err != nil {
+		return shareToken{}, 0, err
+	}
+	if c := playlist.Current(); c != "" && filepath.Base(c) == s.name {
+		return s, playlist.Index(), nil
+	}
+	i := playlist.findName(s.name)
+	if i < 0 {
+		return shareToken{}, 0, fmt.Errorf("oggplayer: %s is not in the playlist", s.name)
+	}
+	return s, i, nil
+}
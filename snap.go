@@ -0,0 +1,202 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+)
+
+// zeroSnapWindow is how far in frames a loop marker is moved at most to a zero crossing.
+const zeroSnapWindow = sampleRate / 100
+
+// snapMode is where the loop markers snap to when they are dragged or set at the playhead.
+type snapMode int
+
+const (
+	snapOff snapMode = iota
+
+	// snapZero snaps to the zero crossings of the mix of the channels.
+	snapZero
+
+	// snapZeroLeft and snapZeroRight snap to the zero crossings of the left or the right channel.
+	snapZeroLeft
+	snapZeroRight
+
+	// snapBeat and snapBar snap to the beats or the bars of the detected beat grid.
+	snapBeat
+	snapBar
+
+	snapModes
+)
+
+// String implements flag.Value.
+func (m *snapMode) String() string {
+	switch *m {
+	case snapOff:
+		return "off"
+	case snapZero:
+		return "zero"
+	case snapZeroLeft:
+		return "zero-left"
+	case snapZeroRight:
+		return "zero-right"
+	case snapBeat:
+		return "beat"
+	case snapBar:
+		return "bar"
+	}
+	return ""
+}
+
+// Set implements flag.Value.
+func (m *snapMode) Set(str string) error {
+	switch str {
+	case "off":
+		*m = snapOff
+	case "zero":
+		*m = snapZero
+	case "zero-left":
+		*m = snapZeroLeft
+	case "zero-right":
+		*m = snapZeroRight
+	case "beat":
+		*m = snapBeat
+	case "bar":
+		*m = snapBar
+	default:
+		return fmt.Errorf("snap mode must be off, zero, zero-left, zero-right, beat or bar but was %q", str)
+	}
+	return nil
+}
+
+// label returns the name of the mode shown to the user.
+func (m snapMode) label() string {
+	switch m {
+	case snapZero:
+		return "Zero crossings"
+	case snapZeroLeft:
+		return "Zero crossings (L)"
+	case snapZeroRight:
+		return "Zero crossings (R)"
+	case snapBeat:
+		return "Beats"
+	case snapBar:
+		return "Bars"
+	}
+	return "Off"
+}
+
+// next returns the mode following m, wrapping around.
+func (m snapMode) next() snapMode {
+	return (m + 1) % snapModes
+}
+
+// zeroSnapValue returns the value of the i-th frame of interleaved stereo PCM the zero crossings of the mode are found in.
+func (m snapMode) zeroSnapValue(pcm []int16, i int) int {
+	switch m {
+	case snapZeroLeft:
+		return int(pcm[2*i])
+	case snapZeroRight:
+		return int(pcm[2*i+1])
+	}
+	return int(pcm[2*i]) + int(pcm[2*i+1])
+}
+
+// nearestZeroCrossing returns the index of the frame at the zero crossing nearest to the frame at in interleaved stereo PCM,
+// which is the quieter frame of the two around the crossing. nearestZeroCrossing reports false when there is no crossing.
+func (m snapMode) nearestZeroCrossing(pcm []int16, at int) (int, bool) {
+	best, found := 0, false
+	for i := 1; i < len(pcm)/2; i++ {
+		a, b := m.zeroSnapValue(pcm, i-1), m.zeroSnapValue(pcm, i)
+		if (a < 0) == (b < 0) && b != 0 {
+			continue
+		}
+		c := i
+		if absInt(a) < absInt(b) {
+			c = i - 1
+		}
+		if !found || absInt(c-at) < absInt(best-at) {
+			best, found = c, true
+		}
+	}
+	return best, found
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// snapSample returns sample moved by theSnap, or sample as it is when the snap is off or finds nothing around it.
+func (p *Player) snapSample(sample int64) (int64, error) {
+	switch theSnap {
+	case snapOff:
+		return sample, nil
+	case snapBeat, snapBar:
+		return p.snapToGrid(sample), nil
+	}
+	from := sample - zeroSnapWindow
+	if from < 0 {
+		from = 0
+	}
+	to := sample + zeroSnapWindow + 1
+	if to > p.totalSample {
+		to = p.totalSample
+	}
+	if from >= to {
+		return sample, nil
+	}
+	pcm, err := readPCMRange(p.path, from, to)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := theSnap.nearestZeroCrossing(pcm, int(sample-from))
+	if !ok {
+		return sample, nil
+	}
+	return from + int64(i), nil
+}
+
+// snapToGrid returns sample moved to the nearest beat or bar of the beat grid,
+// or sample as it is when there is no beat grid or the beat is out of the file.
+func (p *Player) snapToGrid(sample int64) int64 {
+	g := p.beatGrid()
+	if g == nil {
+		return sample
+	}
+	every := int64(1)
+	if theSnap == snapBar {
+		every = int64(beatsPerBar())
+	}
+	s := g.nearestBeat(sample, every)
+	if s < 0 || s > p.totalSample {
+		return sample
+	}
+	return s
+}
+
+// snapLine returns the line of the snap mode, or an empty string when the snap is off.
+func (p *Player) snapLine() string {
+	if theSnap == snapOff {
+		return ""
+	}
+	l := "Snap: " + theSnap.label()
+	if (theSnap == snapBeat || theSnap == snapBar) && p.beatGrid() == nil {
+		l += " (no beat detected)"
+	}
+	return l
+}
@@ -0,0 +1,133 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// snapshot is the saved state of the app, so that a review can be resumed after switching to another project.
+type snapshot struct {
+	Files   []string            `json:"files"`
+	Markers map[string][]Marker `json:"markers,omitempty"`
+	Index   int                 `json:"index"`
+
+	// Position is the playback position of the current file in samples.
+	Position int64 `json:"position"`
+	Playing  bool  `json:"playing"`
+
+	// Loop is the edited loop of the current file. Loop is nil when the file's loop is used.
+	Loop *snapshotLoop `json:"loop,omitempty"`
+
+	// Inserts is the effects inserted in the playback, as given with -inserts.
+	Inserts string `json:"inserts,omitempty"`
+}
+
+type snapshotLoop struct {
+	Start  int64  `json:"start"`
+	Length int64  `json:"length"`
+	Source string `json:"source"`
+}
+
+// snapshotPath returns the path of the snapshot name.
+// A name with a directory or the .json extension is a path. Otherwise, the snapshot is in the user's config directory.
+func snapshotPath(name string) (string, error) {
+	if strings.ContainsAny(name, `/\`) || filepath.Ext(name) == ".json" {
+		return name, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "oggplayer", "snapshots", name+".json"), nil
+}
+
+// takeSnapshot returns the current state. p can be nil when no file is opened.
+func takeSnapshot(playlist *Playlist, p *Player) *snapshot {
+	s := &snapshot{
+		Files:   playlist.paths,
+		Markers: playlist.markers,
+		Index:   playlist.Index(),
+		Inserts: theInserts.String(),
+	}
+	if p == nil {
+		return s
+	}
+	s.Position = p.currentSample()
+	s.Playing = p.IsPlaying()
+	if p.loopSource != "" {
+		s.Loop = &snapshotLoop{
+			Start:  p.introSample,
+			Length: p.loopSample,
+			Source: p.loopSource,
+		}
+	}
+	return s
+}
+
+// loadSnapshot reads the snapshot at path. loadSnapshot returns nil without an error when the snapshot doesn't exist yet.
+func loadSnapshot(path string) (*snapshot, error) {
+	dat, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	if err := json.Unmarshal(dat, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *snapshot) save(path string) error {
+	dat, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, dat, 0644)
+}
+
+func (s *snapshot) playlist() *Playlist {
+	p := NewPlaylist(nil)
+	for _, path := range s.Files {
+		p.appendEntry(path)
+	}
+	for path, markers := range s.Markers {
+		p.markers[path] = markers
+	}
+	return p
+}
+
+// apply restores the position, the playing state and the loop of the current file to p.
+func (s *snapshot) apply(p *Player) error {
+	if s.Loop != nil && p.isValidLoop(s.Loop.Start, s.Loop.Length) {
+		if err := p.SetLoop(s.Loop.Start, s.Loop.Length); err != nil {
+			return err
+		}
+		p.loopSource = s.Loop.Source
+	}
+	if !s.Playing {
+		p.Pause()
+	}
+	return p.Seek(samplesToDuration(s.Position))
+}
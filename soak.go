@@ -0,0 +1,144 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+)
+
+// soakDriftLimit is the change of the drift during a soak test above which the playback is considered unstable.
+const soakDriftLimit = 50 * time.Millisecond
+
+// soakSample is a measurement taken during a soak test.
+type soakSample struct {
+	elapsed    time.Duration
+	heap       uint64
+	sys        uint64
+	goroutines int
+
+	// drift is how far the audio read so far is ahead of the wall clock. It is about the size of the buffer
+	// and stays constant while the playback is stable.
+	drift time.Duration
+
+	lateReads int
+	headroom  float64
+	loops     int64
+}
+
+// runSoak runs the soak command, which plays the loop of a file for hours without any window while
+// logging the memory, the drift of the audio against the wall clock and the late reads, so that
+// the file and the playback stack are validated for games leaving one BGM playing all session.
+//
+//	oggplayer soak -hours 8 file.ogg
+func runSoak(args []string, w io.Writer) (bool, error) {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	hours := fs.Float64("hours", 1, "hours to play")
+	interval := fs.Duration("interval", time.Minute, "interval of the log lines")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: oggplayer soak [-hours 1] [-interval 1m] file.ogg")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *hours <= 0 || *interval <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	p, err := NewPlayer(audio.NewContext(sampleRate), fs.Arg(0))
+	if err != nil {
+		return false, err
+	}
+	defer p.Close()
+	if p.loopSample <= 0 {
+		fmt.Fprintf(w, "%s has no loop; the file is played to the end\n", p.path)
+	}
+
+	duration := time.Duration(*hours * float64(time.Hour))
+	fmt.Fprintf(w, "%s: soaking for %s\n", p.path, duration)
+	fmt.Fprintln(w, "elapsed,heap_mb,sys_mb,goroutines,drift_ms,late_reads,min_headroom,loops")
+
+	start := time.Now()
+	p.audioPlayer.Play()
+	measure := func() soakSample {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		elapsed := time.Since(start)
+		frames, late := p.meter.Counts()
+		headroom, ok := p.meter.Headroom()
+		if !ok {
+			headroom = 1
+		}
+		var loops int64
+		if p.loopSample > 0 && frames > p.introSample+p.loopSample {
+			loops = (frames - p.introSample) / p.loopSample
+		}
+		return soakSample{
+			elapsed:    elapsed,
+			heap:       m.HeapAlloc,
+			sys:        m.Sys,
+			goroutines: runtime.NumGoroutine(),
+			drift:      samplesToDuration(frames) - elapsed,
+			lateReads:  late,
+			headroom:   headroom,
+			loops:      loops,
+		}
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	var first, last soakSample
+	minHeadroom := 1.0
+	for n := 0; ; n++ {
+		<-ticker.C
+		s := measure()
+		if n == 0 {
+			// The first measurement is the baseline after the buffer is filled.
+			first = s
+		}
+		if s.headroom < minHeadroom {
+			minHeadroom = s.headroom
+		}
+		last = s
+		fmt.Fprintf(w, "%s,%.1f,%.1f,%d,%d,%d,%.2f,%d\n",
+			formatTime(s.elapsed), float64(s.heap)/(1<<20), float64(s.sys)/(1<<20), s.goroutines,
+			s.drift.Milliseconds(), s.lateReads, s.headroom, s.loops)
+		if !p.IsPlaying() {
+			fmt.Fprintln(w, "the playback stopped")
+			break
+		}
+		if s.elapsed >= duration {
+			break
+		}
+	}
+
+	driftChange := last.drift - first.drift
+	fmt.Fprintf(w, "heap: %.1f MB -> %.1f MB, goroutines: %d -> %d, drift change: %d ms, late reads: %d, lowest headroom: %.2f, loops: %d\n",
+		float64(first.heap)/(1<<20), float64(last.heap)/(1<<20), first.goroutines, last.goroutines,
+		driftChange.Milliseconds(), last.lateReads, minHeadroom, last.loops)
+	failed := last.lateReads > 0 || driftChange > soakDriftLimit || driftChange < -soakDriftLimit || (p.loopSample > 0 && !p.IsPlaying())
+	if failed {
+		fmt.Fprintln(w, "FAIL")
+	} else {
+		fmt.Fprintln(w, "PASS")
+	}
+	return failed, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// startupMode is what the app does at startup when neither files nor -snapshot are given.
+type startupMode int
+
+const (
+	// startupFiles opens nothing but the files given as arguments.
+	startupFiles startupMode = iota
+
+	// startupResume restores the last session, which is saved at every exit.
+	startupResume
+
+	// startupProject opens the given project.
+	startupProject
+
+	// startupWatch watches the given folder and opens the audio files exported to it.
+	startupWatch
+
+	// startupDialog shows the file dialog, or the recent files in the terminal UI.
+	startupDialog
+)
+
+// startup is the startup behavior given by -startup or the settings.
+type startup struct {
+	mode startupMode
+
+	// path is the project of startupProject or the folder of startupWatch.
+	path string
+}
+
+// parseStartup parses the startup behavior: files, resume, dialog, project:PATH or watch:DIR.
+// An empty string is files.
+func parseStartup(str string) (startup, error) {
+	name, path, _ := strings.Cut(str, ":")
+	switch name {
+	case "", "files":
+		return startup{mode: startupFiles}, nil
+	case "resume":
+		return startup{mode: startupResume}, nil
+	case "dialog":
+		return startup{mode: startupDialog}, nil
+	case "project", "watch":
+		if name == "project" {
+			if path == "" {
+				return startup{}, fmt.Errorf("startup project needs the project as project:PATH")
+			}
+			return startup{mode: startupProject, path: path}, nil
+		}
+		if path == "" {
+			return startup{}, fmt.Errorf("startup watch needs the folder as watch:DIR")
+		}
+		return startup{mode: startupWatch, path: path}, nil
+	}
+	return startup{}, fmt.Errorf("startup must be files, resume, dialog, project:PATH or watch:DIR but was %q", str)
+}
+
+// lastSessionPath returns the path of the snapshot of the last session.
+func lastSessionPath() (string, error) {
+	return snapshotPath("last-session")
+}
+
+// saveLastSession saves the current state as the last session, which -startup resume restores.
+// p can be nil when no file is opened.
+func saveLastSession(playlist *Playlist, p *Player) {
+	path, err := lastSessionPath()
+	if err != nil {
+		log.Printf("session error: %v", err)
+		return
+	}
+	if err := takeSnapshot(playlist, p).save(path); err != nil {
+		log.Printf("session error: %s, %v", path, err)
+	}
+}
+
+// loadLastSession reads the snapshot of the last session. loadLastSession returns nil without an error
+// when no session has been saved yet.
+func loadLastSession() (*snapshot, error) {
+	path, err := lastSessionPath()
+	if err != nil {
+		return nil, err
+	}
+	return loadSnapshot(path)
+}
+
+// watchInterval is the interval of polling the watched folder.
+const watchInterval = time.Second
+
+// folderWatcher polls a folder for the audio files exported to it, e.g. by a DAW.
+type folderWatcher struct {
+	dir string
+
+	// sizes are the sizes of the files not added yet at the last poll.
+	sizes map[string]int64
+	added map[string]bool
+
+	polled time.Time
+}
+
+func newFolderWatcher(dir string) *folderWatcher {
+	return &folderWatcher{
+		dir:   dir,
+		sizes: map[string]int64{},
+		added: map[string]bool{},
+	}
+}
+
+// isWatchedFile reports whether the file at path is an audio file the watcher adds.
+func isWatchedFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ogg", ".opus", ".wav", ".mp3", ".flac":
+		return true
+	}
+	return false
+}
+
+// Poll returns the audio files which have appeared in the folder since the last call, the latest modified last.
+// A file is returned once its size stays the same over two polls, so that a file being written is not opened.
+// The files already in the folder are returned by the second poll. Poll returns nothing until watchInterval passes
+// since the last poll, and w can be nil.
+func (w *folderWatcher) Poll(now time.Time) ([]string, error) {
+	if w == nil || now.Sub(w.polled) < watchInterval {
+		return nil, nil
+	}
+	w.polled = now
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		path    string
+		modTime time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		if e.IsDir() || !isWatchedFile(e.Name()) {
+			continue
+		}
+		path := filepath.Join(w.dir, e.Name())
+		if w.added[path] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		size, ok := w.sizes[path]
+		w.sizes[path] = info.Size()
+		if !ok || size != info.Size() || info.Size() == 0 {
+			continue
+		}
+		delete(w.sizes, path)
+		w.added[path] = true
+		files = append(files, file{path: path, modTime: info.ModTime()})
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
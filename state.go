@@ -0,0 +1,228 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+// playerState is the state of a file dumped as JSON for bug reports and external tools.
+// The samples are at the file's sample rate, as the loop tags are.
+type playerState struct {
+	File   string       `json:"file"`
+	Format *stateFormat `json:"format,omitempty"`
+
+	Length        int64   `json:"length"`
+	LengthSeconds float64 `json:"lengthSeconds"`
+
+	Loop      *stateLoop  `json:"loop,omitempty"`
+	Selection *stateRange `json:"selection,omitempty"`
+	Markers   []Marker    `json:"markers,omitempty"`
+
+	// Position, Playing and Volume are only of an opened player.
+	Position        int64   `json:"position"`
+	PositionSeconds float64 `json:"positionSeconds"`
+	Playing         bool    `json:"playing"`
+	Volume          int     `json:"volume"`
+
+	Analysis *stateAnalysis `json:"analysis,omitempty"`
+	Warnings []string       `json:"warnings,omitempty"`
+}
+
+type stateFormat struct {
+	SampleRate int  `json:"sampleRate"`
+	Channels   int  `json:"channels"`
+	Bitrate    int  `json:"bitrate,omitempty"`
+	Resampled  bool `json:"resampled"`
+}
+
+type stateLoop struct {
+	Start        int64   `json:"start"`
+	Length       int64   `json:"length"`
+	StartSeconds float64 `json:"startSeconds"`
+	EndSeconds   float64 `json:"endSeconds"`
+	Source       string  `json:"source,omitempty"`
+
+	// Origins are the values of the loop tags in the order they were read. Used tells which of them are used.
+	Origins []stateLoopOrigin `json:"origins,omitempty"`
+}
+
+type stateLoopOrigin struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Used   bool   `json:"used"`
+}
+
+type stateRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// stateAnalysis is the result of the analysis. The values which can't be measured are omitted, as JSON has no NaN.
+type stateAnalysis struct {
+	Loudness   *float64 `json:"loudness,omitempty"`
+	SoundStart int64    `json:"soundStart"`
+	SoundEnd   int64    `json:"soundEnd"`
+
+	ClippedSamples int64    `json:"clippedSamples"`
+	TruePeak       *float64 `json:"truePeak,omitempty"`
+	Tempo          *float64 `json:"tempo,omitempty"`
+
+	SeamScore *int     `json:"seamScore,omitempty"`
+	SeamJump  *float64 `json:"seamJump,omitempty"`
+	SeamClick *float64 `json:"seamClick,omitempty"`
+	SeamPhase *float64 `json:"seamPhase,omitempty"`
+}
+
+// stateFloat returns a pointer to v, or nil when v is NaN or infinite.
+func stateFloat(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// newPlayerState returns the state of a file without the playback.
+// The loop, the samples and a are of the decoded stream. a can be nil.
+func newPlayerState(path string, info *fileInfo, totalSample, introSample, loopSample int64, a *analysis) *playerState {
+	s := &playerState{
+		File:          filepath.ToSlash(path),
+		Length:        info.toFileSamples(totalSample),
+		LengthSeconds: samplesToDuration(totalSample).Seconds(),
+	}
+	if info != nil {
+		s.Format = &stateFormat{
+			SampleRate: info.sampleRate,
+			Channels:   info.channels,
+			Bitrate:    info.nominalBitrate,
+			Resampled:  info.resampled(),
+		}
+	}
+	if loopSample > 0 {
+		start := info.toFileSamples(introSample)
+		s.Loop = &stateLoop{
+			Start:        start,
+			Length:       info.toFileSamples(introSample+loopSample) - start,
+			StartSeconds: samplesToDuration(introSample).Seconds(),
+			EndSeconds:   samplesToDuration(introSample + loopSample).Seconds(),
+		}
+		if info != nil {
+			winners := loopWinners(info.loopOrigins)
+			for i, o := range info.loopOrigins {
+				s.Loop.Origins = append(s.Loop.Origins, stateLoopOrigin{
+					Key:    o.key,
+					Value:  o.value,
+					Source: o.source,
+					Used:   winners[o.key] == i,
+				})
+			}
+		}
+	}
+	if a != nil {
+		s.Analysis = &stateAnalysis{
+			Loudness:   stateFloat(a.loudness),
+			SoundStart: info.toFileSamples(a.soundStart),
+			SoundEnd:   info.toFileSamples(a.soundEnd),
+
+			ClippedSamples: a.clips.count,
+			TruePeak:       stateFloat(a.clips.truePeakDB()),
+		}
+		if a.tempo != nil {
+			s.Analysis.Tempo = stateFloat(a.tempo.bpm())
+		}
+		var channels int
+		if info != nil {
+			channels = info.channels
+		}
+		s.Warnings = append(s.Warnings, a.channels.warnings(channels)...)
+		validLoop := introSample >= 0 && loopSample > 0 && introSample+loopSample <= totalSample
+		s.Warnings = append(s.Warnings, silenceWarnings(a.soundStart, a.soundEnd, totalSample, introSample, loopSample, validLoop)...)
+		s.Warnings = append(s.Warnings, a.clips.warnings()...)
+	}
+	return s
+}
+
+// State returns the current state of p.
+func (p *Player) State() *playerState {
+	s := newPlayerState(p.path, p.info, p.totalSample, p.introSample, p.loopSample, p.analysis)
+	if s.Loop != nil {
+		s.Loop.Source = p.loopSource
+	}
+	if p.HasSelection() {
+		s.Selection = &stateRange{
+			Start: p.fileSample(p.selStart),
+			End:   p.fileSample(p.selEnd),
+		}
+	}
+	for _, m := range p.markers {
+		s.Markers = append(s.Markers, Marker{Sample: p.fileSample(m.Sample), Label: m.Label})
+	}
+	s.Position = p.fileSample(p.currentSample())
+	s.PositionSeconds = p.current.Seconds()
+	s.Playing = p.IsPlaying()
+	s.Volume = p.volume128
+	if q := p.SeamQuality(); q != nil && q.err == nil && s.Analysis != nil {
+		s.Analysis.SeamScore = &q.score
+		s.Analysis.SeamJump = stateFloat(q.jump)
+		s.Analysis.SeamClick = stateFloat(q.click)
+		s.Analysis.SeamPhase = stateFloat(q.phase)
+	}
+	s.Warnings = append(s.Warnings, p.tagWarnings...)
+	s.Warnings = append(s.Warnings, p.profileWarnings...)
+	s.Warnings = append(s.Warnings, p.ruleWarnings...)
+	return s
+}
+
+// writeState writes v as indented JSON to w.
+func writeState(w io.Writer, v interface{}) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+// stateJSON returns the state of p as indented JSON.
+func (p *Player) stateJSON() (string, error) {
+	var b strings.Builder
+	if err := writeState(&b, p.State()); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// runState writes the states of the files at paths as a JSON array to w without opening a window.
+// The files are analyzed, but there is no playback position.
+func runState(paths []string, w io.Writer) error {
+	states := []*playerState{}
+	for _, path := range paths {
+		h, err := openHeadless(path)
+		if err != nil {
+			return err
+		}
+		a, err := h.analyze()
+		if err != nil {
+			return err
+		}
+		s := newPlayerState(path, h.info, h.totalSample, h.tags.start, h.tags.length, a)
+		s.Warnings = append(s.Warnings, h.tags.warnings...)
+		s.Warnings = append(s.Warnings, theProfile.check(h.info)...)
+		states = append(states, s)
+	}
+	return writeState(w, states)
+}
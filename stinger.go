@@ -0,0 +1,105 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// stingerPath returns the path the trimmed stinger of path is written to.
+func stingerPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_stinger.ogg"
+}
+
+// stingerRange returns the range of a stinger tightly around the sound [soundStart, soundEnd),
+// with pad frames kept at each side for the attack and the tail, within the file of frames samples per channel.
+func stingerRange(soundStart, soundEnd, frames, pad int64) (start, end int64) {
+	start = soundStart - pad
+	if start < 0 {
+		start = 0
+	}
+	end = soundEnd + pad
+	if end > frames {
+		end = frames
+	}
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+// fadeEdges fades in the first fade frames and fades out the last fade frames of pcm linearly,
+// so that a tight cut doesn't click.
+func fadeEdges(pcm []int16, fade int64) {
+	frames := int64(len(pcm) / 2)
+	if fade > frames/2 {
+		fade = frames / 2
+	}
+	for i := int64(0); i < fade; i++ {
+		g := float64(i) / float64(fade)
+		for _, f := range []int64{i, frames - 1 - i} {
+			pcm[2*f] = int16(float64(pcm[2*f]) * g)
+			pcm[2*f+1] = int16(float64(pcm[2*f+1]) * g)
+		}
+	}
+}
+
+// StingerJob returns the function writing the file trimmed tightly to its sound with short fades at the edges,
+// for a stinger or a jingle which doesn't loop. The loop tags are not kept.
+// StingerJob returns an error when the file is not analyzed yet or loops.
+// The function can be called on another goroutine.
+func (p *Player) StingerJob() (func(j *job) (string, error), error) {
+	if p.analysis == nil {
+		return nil, fmt.Errorf("oggplayer: %s is not analyzed yet", filepath.Base(p.path))
+	}
+	if p.isValidLoop(p.fileIntroSample, p.fileLoopSample) {
+		return nil, fmt.Errorf("oggplayer: %s loops; press A to trim the silence keeping the loop", filepath.Base(p.path))
+	}
+	if p.analysis.soundEnd <= p.analysis.soundStart {
+		return nil, fmt.Errorf("oggplayer: %s is silent", filepath.Base(p.path))
+	}
+	start, end := stingerRange(p.analysis.soundStart, p.analysis.soundEnd, p.totalSample, int64(*flagTrimPad*sampleRate/1000))
+	fade := int64(*flagTrimFade * sampleRate / 1000)
+
+	src := p.path
+	var comments []string
+	if p.info != nil {
+		c := &vorbisComments{comments: append([]string(nil), p.info.comments.comments...)}
+		c.Delete(loopStartKey)
+		c.Delete(loopLengthKey)
+		comments = c.comments
+	}
+	path := stingerPath(p.path)
+	return func(j *job) (string, error) {
+		if err := checkWritable(); err != nil {
+			return "", err
+		}
+		pcm, err := decodePCM(src, j)
+		if err != nil {
+			return "", err
+		}
+		if n := int64(len(pcm) / 2); end > n {
+			end = n
+		}
+		out := append([]int16(nil), pcm[2*start:2*end]...)
+		fadeEdges(out, fade)
+		if err := encodeVorbis(path, out, defaultVorbisQuality, comments, j); err != nil {
+			return "", err
+		}
+		return path, nil
+	}, nil
+}
@@ -0,0 +1,335 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// stretchSpeeds are the playback speeds of the time-stretch.
+var stretchSpeeds = []float64{0.5, 0.6, 0.7, 0.8, 0.9, 1, 1.25, 1.5, 1.75, 2}
+
+// tempoStage is a preview stage changing the tempo without changing the pitch.
+// Like rateStage, it is applied by a stream reading the source, a stretchStream, and Process does nothing.
+type tempoStage interface {
+	previewStage
+
+	// Tempo returns the source frames played per output frame, e.g. 0.5 for the half speed.
+	Tempo() float64
+}
+
+// stretchStage slows down or speeds up the playback keeping the pitch, e.g. to listen closely around the loop seam.
+type stretchStage struct {
+	// index is the index in stretchSpeeds.
+	index int
+	m     sync.Mutex
+}
+
+func newStretchStage() *stretchStage {
+	s := &stretchStage{}
+	for i, v := range stretchSpeeds {
+		if v == 1 {
+			s.index = i
+		}
+	}
+	return s
+}
+
+func (s *stretchStage) Name() string {
+	return fmt.Sprintf("Speed %gx", s.Tempo())
+}
+
+func (s *stretchStage) Active() bool {
+	return s.Tempo() != 1
+}
+
+func (s *stretchStage) Process(pcm []int16) {
+}
+
+func (s *stretchStage) Tempo() float64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return stretchSpeeds[s.index]
+}
+
+// Add changes the speed by delta steps of stretchSpeeds.
+func (s *stretchStage) Add(delta int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.index += delta
+	if s.index < 0 {
+		s.index = 0
+	}
+	if s.index >= len(stretchSpeeds) {
+		s.index = len(stretchSpeeds) - 1
+	}
+}
+
+// tempo returns the tempo of the active tempo stages, or 1 when they are bypassed.
+func (c *previewChain) tempo() float64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.bypass {
+		return 1
+	}
+	t := 1.0
+	for _, s := range c.stages {
+		if ts, ok := s.(tempoStage); ok && s.Active() {
+			t *= ts.Tempo()
+		}
+	}
+	return t
+}
+
+// speed returns how fast the source is played: the source frames per output frame with the rate and the tempo.
+func (c *previewChain) speed() float64 {
+	return c.rate() * c.tempo()
+}
+
+const (
+	// stretchFrameSize is the length of a grain in frames.
+	stretchFrameSize = 2048
+
+	// stretchHop is the distance between the grains in the output. The Hann windows overlapping by half sum to 1.
+	stretchHop = stretchFrameSize / 2
+
+	// stretchSearch is the farthest a grain is moved from its nominal position to match the previous one.
+	stretchSearch = 256
+
+	// stretchMatch is the length in frames compared to match the grains.
+	stretchMatch = 512
+)
+
+// stretchWindow is the periodic Hann window of a grain.
+var stretchWindow = func() []float64 {
+	w := make([]float64, stretchFrameSize)
+	for i := range w {
+		w[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/stretchFrameSize)
+	}
+	return w
+}()
+
+// stretchStream is a stream time-stretched at the tempo of a previewChain with WSOLA (waveform similarity overlap-add):
+// the grains are read at the tempo and overlapped at the original pace, each moved slightly to continue the previous one's waveform.
+// The source is read as is while the tempo is 1.
+type stretchStream struct {
+	frameReader
+	chain *previewChain
+
+	// nominal is the position in the frames read ahead the next grain is read around.
+	// prev is the position of the previous grain, which can be negative after the frames are dropped.
+	// stretching reports whether a grain is made since the tempo changed from 1 or the stream is seeked.
+	nominal    float64
+	prev       int
+	stretching bool
+
+	// acc is the interleaved sum of the overlapping grains, and out is the output ready to be read.
+	acc []float64
+	out []byte
+
+	m sync.Mutex
+}
+
+func newStretchStream(src io.ReadSeeker, chain *previewChain) *stretchStream {
+	return &stretchStream{
+		frameReader: frameReader{src: src},
+		chain:       chain,
+		acc:         make([]float64, 2*stretchFrameSize),
+	}
+}
+
+// sample returns the sample of the channel ch at the frame i, or 0 out of the frames read ahead.
+func (s *stretchStream) sample(i, ch int) float64 {
+	if i < 0 || i >= len(s.frames)/2 {
+		return 0
+	}
+	return float64(s.frames[2*i+ch])
+}
+
+// mono returns the mix of the channels at the frame i for matching the waveforms.
+func (s *stretchStream) mono(i int) float64 {
+	return s.sample(i, 0) + s.sample(i, 1)
+}
+
+// match returns the position around pos whose waveform continues the previous grain best,
+// by the normalized cross-correlation with what follows the previous grain in the source.
+func (s *stretchStream) match(pos int) int {
+	next := s.prev + stretchHop
+	best, bestScore := pos, math.Inf(-1)
+	for c := pos - stretchSearch; c <= pos+stretchSearch; c++ {
+		if c < 0 {
+			continue
+		}
+		var corr, energy float64
+		// Every other frame is enough to compare the waveforms.
+		for j := 0; j < stretchMatch; j += 2 {
+			v := s.mono(c + j)
+			corr += v * s.mono(next+j)
+			energy += v * v
+		}
+		if energy == 0 {
+			continue
+		}
+		if score := corr / math.Sqrt(energy); score > bestScore {
+			best, bestScore = c, score
+		}
+	}
+	return best
+}
+
+// grain adds the next grain and makes stretchHop frames ready. grain returns io.EOF when the source ends.
+func (s *stretchStream) grain(tempo float64) error {
+	pos := int(s.nominal)
+	need := pos + stretchSearch
+	if s.stretching && s.prev+stretchHop > need {
+		need = s.prev + stretchHop
+	}
+	if err := s.fill(need + stretchFrameSize); err != nil {
+		return err
+	}
+	if s.eof && pos >= len(s.frames)/2 {
+		return io.EOF
+	}
+
+	if s.stretching {
+		pos = s.match(pos)
+	}
+	for j, w := range stretchWindow {
+		for ch := 0; ch < 2; ch++ {
+			s.acc[2*j+ch] += w * s.sample(pos+j, ch)
+		}
+	}
+
+	// The first hop has all of its overlapping grains now.
+	for j := 0; j < 2*stretchHop; j++ {
+		v := s.acc[j]
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+		}
+		if v < math.MinInt16 {
+			v = math.MinInt16
+		}
+		iv := int16(v)
+		s.out = append(s.out, byte(iv), byte(iv>>8))
+	}
+	copy(s.acc, s.acc[2*stretchHop:])
+	for j := 2 * stretchHop; j < len(s.acc); j++ {
+		s.acc[j] = 0
+	}
+
+	s.prev = pos
+	s.stretching = true
+	s.nominal += tempo * stretchHop
+	s.drop()
+	return nil
+}
+
+// drop discards the frames read ahead which are no longer needed for the next grain.
+func (s *stretchStream) drop() {
+	d := int(s.nominal) - stretchSearch
+	if next := s.prev + stretchHop; next < d {
+		d = next
+	}
+	if n := len(s.frames) / 2; d > n {
+		d = n
+	}
+	if d <= 0 {
+		return
+	}
+	s.frames = append(s.frames[:0], s.frames[2*d:]...)
+	s.nominal -= float64(d)
+	s.prev -= d
+}
+
+// stop goes back to reading the source as is, from the frame following the output.
+func (s *stretchStream) stop() {
+	d := s.prev + stretchHop
+	if n := len(s.frames) / 2; d > n {
+		d = n
+	}
+	if d < 0 {
+		d = 0
+	}
+	s.frames = append(s.frames[:0], s.frames[2*d:]...)
+	s.nominal = 0
+	s.stretching = false
+	for i := range s.acc {
+		s.acc[i] = 0
+	}
+}
+
+func (s *stretchStream) Read(buf []byte) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	t := s.chain.tempo()
+	if t == 1 && len(s.out) == 0 {
+		if s.stretching {
+			s.stop()
+		}
+		// Read out what is read ahead first.
+		if len(s.frames) > 0 {
+			n := len(s.frames)
+			if n > len(buf)/2 {
+				n = len(buf) / 2
+			}
+			n -= n % 2
+			for j, v := range s.frames[:n] {
+				buf[2*j] = byte(v)
+				buf[2*j+1] = byte(v >> 8)
+			}
+			s.frames = append(s.frames[:0], s.frames[n:]...)
+			return 2 * n, nil
+		}
+		if s.eof {
+			return 0, io.EOF
+		}
+		return s.src.Read(buf)
+	}
+
+	// The output made at the previous tempo is read out first when the tempo became 1.
+	for t != 1 && len(s.out) < len(buf) {
+		if err := s.grain(t); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if len(s.out) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(buf, s.out)
+	n -= n % bytesPerSample
+	s.out = append(s.out[:0], s.out[n:]...)
+	return n, nil
+}
+
+func (s *stretchStream) Seek(offset int64, whence int) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.frames = s.frames[:0]
+	s.eof = false
+	s.out = s.out[:0]
+	s.nominal = 0
+	s.stretching = false
+	for i := range s.acc {
+		s.acc[i] = 0
+	}
+	return s.src.Seek(offset, whence)
+}
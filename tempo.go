@@ -0,0 +1,260 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+const (
+	// onsetHop is the number of the frames per value of the onset envelope.
+	onsetHop = 512
+
+	// tempoMin and tempoMax are the range of the detected tempo in BPM.
+	tempoMin = 60
+	tempoMax = 200
+
+	// tempoCenter is the tempo in BPM the detection prefers, to choose between the tempo and its double or half.
+	tempoCenter = 120
+
+	// tempoMinConfidence is the lowest normalized autocorrelation of the onset envelope at the beat for a grid to be shown.
+	tempoMinConfidence = 0.05
+)
+
+var (
+	beatColor = color.RGBA{0x60, 0x60, 0x80, 0x60}
+	barColor  = color.RGBA{0xa0, 0xa0, 0xff, 0xa0}
+)
+
+// theGridShown reports whether the beat grid is drawn over the waveform. Shift+G switches it.
+var theGridShown = true
+
+// onsetDetector makes the onset envelope of the PCM: the rises of the energy in the low, mid and high bands per onsetHop frames.
+// The PCM is added chunk by chunk as it is decoded.
+type onsetDetector struct {
+	filters [3]*biquad
+	energy  [3]float64
+	prev    [3]float64
+	n       int
+	env     []float64
+}
+
+func newOnsetDetector() *onsetDetector {
+	return &onsetDetector{filters: newBandFilters()}
+}
+
+func (d *onsetDetector) add(pcm []int16) {
+	for i := 0; i < len(pcm)/2; i++ {
+		x := (float64(pcm[2*i]) + float64(pcm[2*i+1])) / 2 / 32768
+		for b, f := range d.filters {
+			y := f.process(x)
+			d.energy[b] += y * y
+		}
+		d.n++
+		if d.n < onsetHop {
+			continue
+		}
+		var onset float64
+		for b, e := range d.energy {
+			l := math.Log(e/onsetHop + 1e-10)
+			if len(d.env) > 0 && l > d.prev[b] {
+				onset += l - d.prev[b]
+			}
+			d.prev[b] = l
+			d.energy[b] = 0
+		}
+		d.env = append(d.env, onset)
+		d.n = 0
+	}
+}
+
+// beatGrid is the detected tempo and the positions of the beats and the bars.
+type beatGrid struct {
+	// period is the length of a beat in frames, and offset is the frame of a downbeat, the first beat of a bar.
+	period float64
+	offset float64
+}
+
+// bpm returns the tempo in beats per minute.
+func (g *beatGrid) bpm() float64 {
+	return 60 * sampleRate / g.period
+}
+
+// beatsPerBar returns the number of the beats in a bar.
+func beatsPerBar() int {
+	if *flagBarBeats < 1 {
+		return 4
+	}
+	return *flagBarBeats
+}
+
+// beat returns the frame of the i-th beat counted from the downbeat at offset.
+func (g *beatGrid) beat(i int64) int64 {
+	return int64(math.Round(g.offset + float64(i)*g.period))
+}
+
+// nearestBeat returns the frame of the beat nearest to sample among every every-th beat from the downbeat,
+// so that every 1 is any beat and every beatsPerBar() is a bar.
+func (g *beatGrid) nearestBeat(sample int64, every int64) int64 {
+	span := g.period * float64(every)
+	return g.beat(int64(math.Round((float64(sample)-g.offset)/span)) * every)
+}
+
+// bars returns the length of frames frames in bars.
+func (g *beatGrid) bars(frames int64) float64 {
+	return float64(frames) / g.period / float64(beatsPerBar())
+}
+
+// detectTempo detects the tempo and the phase of the beats in the onset envelope env, or returns nil when there is no clear beat.
+// The beat period is the lag of the highest autocorrelation weighted toward tempoCenter, refined by fitting a comb over the whole envelope.
+func detectTempo(env []float64) *beatGrid {
+	const rate = float64(sampleRate) / onsetHop
+	minLag := int(math.Floor(rate * 60 / tempoMax))
+	maxLag := int(math.Ceil(rate * 60 / tempoMin))
+	if len(env) < 4*maxLag {
+		return nil
+	}
+
+	var mean float64
+	for _, v := range env {
+		mean += v
+	}
+	mean /= float64(len(env))
+	x := make([]float64, len(env))
+	for i, v := range env {
+		x[i] = v - mean
+	}
+	acf := func(lag int) float64 {
+		var s float64
+		for i := lag; i < len(x); i++ {
+			s += x[i] * x[i-lag]
+		}
+		return s
+	}
+	r0 := acf(0)
+	if r0 <= 0 {
+		return nil
+	}
+	r := make([]float64, maxLag+2)
+	best, bestScore := 0, math.Inf(-1)
+	for lag := minLag - 1; lag <= maxLag+1; lag++ {
+		r[lag] = acf(lag)
+	}
+	for lag := minLag; lag <= maxLag; lag++ {
+		// The log-Gaussian weight of an octave's width around the center.
+		o := math.Log2(rate * 60 / float64(lag) / tempoCenter)
+		if score := r[lag] * math.Exp(-o*o/2); score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	if r[best]/r0 < tempoMinConfidence {
+		return nil
+	}
+
+	// The comb sums the envelope at the beats of a period and a phase, linearly interpolated.
+	at := func(pos float64) float64 {
+		i := int(pos)
+		if i < 0 || i+1 >= len(env) {
+			return 0
+		}
+		f := pos - float64(i)
+		return env[i]*(1-f) + env[i+1]*f
+	}
+	comb := func(period, phase float64) float64 {
+		var s float64
+		for pos := phase; pos < float64(len(env)); pos += period {
+			s += at(pos)
+		}
+		return s / math.Floor((float64(len(env))-phase)/period+1)
+	}
+	period, phase, score := float64(best), 0.0, math.Inf(-1)
+	for p := float64(best) - 1; p <= float64(best)+1; p += 0.01 {
+		for ph := 0.0; ph < p; ph += 0.5 {
+			if s := comb(p, ph); s > score {
+				period, phase, score = p, ph, s
+			}
+		}
+	}
+
+	// The downbeat is the beat of the bar with the strongest onsets.
+	n := beatsPerBar()
+	down, downScore := 0, math.Inf(-1)
+	for i := 0; i < n; i++ {
+		if s := comb(period*float64(n), phase+period*float64(i)); s > downScore {
+			down, downScore = i, s
+		}
+	}
+	// An onset is somewhere in its hop, at the middle on average.
+	return &beatGrid{
+		period: period * onsetHop,
+		offset: (phase+period*float64(down))*onsetHop + onsetHop/2,
+	}
+}
+
+// beatGrid returns the detected beat grid, or nil when the file is not analyzed yet or has no clear beat.
+func (p *Player) beatGrid() *beatGrid {
+	if p.analysis == nil {
+		return nil
+	}
+	return p.analysis.tempo
+}
+
+// tempoLine returns the line of the tempo and the loop length in bars, or an empty string without a beat grid.
+// A loop off by a beat shows as a fraction of a bar.
+func (p *Player) tempoLine() string {
+	g := p.beatGrid()
+	if g == nil {
+		return ""
+	}
+	l := fmt.Sprintf("Tempo: %.1f BPM", g.bpm())
+	if p.isValidLoop(p.introSample, p.loopSample) {
+		l += fmt.Sprintf(", loop %.2f bars of %d", g.bars(p.loopSample), beatsPerBar())
+	}
+	return l
+}
+
+// drawBeatGrid draws the beats and the bars over the waveform in the view of the timeline.
+// The beats are left out when they are too dense to see, and so are the bars.
+func (p *Player) drawBeatGrid(b *shapeBatch) {
+	g := p.beatGrid()
+	if g == nil || !theGridShown {
+		return
+	}
+	_, wy, w, h := waveformRect()
+	start, span := p.viewRange()
+	pixelsPerBeat := float64(w) * g.period / float64(span)
+	n := int64(beatsPerBar())
+	if pixelsPerBeat*float64(n) < 4 {
+		return
+	}
+	from := int64(math.Ceil((float64(start) - g.offset) / g.period))
+	for i := from; ; i++ {
+		s := g.beat(i)
+		if s > start+span {
+			break
+		}
+		x, ok := p.timelineX(s)
+		if !ok {
+			continue
+		}
+		if i%n == 0 {
+			b.Rect(float64(x), float64(wy), 1, float64(h), barColor)
+		} else if pixelsPerBeat >= 4 {
+			b.Rect(float64(x), float64(wy), 1, float64(h), beatColor)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Keys read from the terminal. Other keys are represented as the bytes themselves.
+const (
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyUp        = "up"
+	keyDown      = "down"
+	keyInterrupt = "\x03"
+	keyCtrlB     = "\x02"
+	keyCtrlD     = "\x04"
+	keyCtrlE     = "\x05"
+	keyCtrlO     = "\x0f"
+	keyCtrlR     = "\x12"
+	keyCtrlS     = "\x13"
+	keyCtrlT     = "\x14"
+	keyCtrlV     = "\x16"
+	keyCtrlY     = "\x19"
+	keyEnter     = "\r"
+	keyBackspace = "\x7f"
+	keyDelete    = "delete"
+	keyPageUp    = "pageup"
+	keyPageDown  = "pagedown"
+	keyBackTab   = "backtab"
+	keyEscape    = "escape"
+)
+
+// readTerminalKeys reads key strokes from r in the raw mode and sends them to keyCh.
+// The first read error is sent to errCh.
+func readTerminalKeys(r io.Reader, keyCh chan<- string, errCh chan<- error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		if b != 0x1b {
+			keyCh <- string(b)
+			continue
+		}
+
+		// An escape sequence arrives at once, so ESC with nothing after it is the Esc key.
+		if br.Buffered() == 0 {
+			keyCh <- keyEscape
+			continue
+		}
+		// Parse the escape sequences of the arrow keys, Shift+Tab, Delete, Page Up and Page Down.
+		if b, err = br.ReadByte(); err != nil || b != '[' {
+			continue
+		}
+		if b, err = br.ReadByte(); err != nil {
+			continue
+		}
+		switch b {
+		case 'A':
+			keyCh <- keyUp
+		case 'B':
+			keyCh <- keyDown
+		case 'C':
+			keyCh <- keyRight
+		case 'D':
+			keyCh <- keyLeft
+		case 'Z':
+			keyCh <- keyBackTab
+		case '3':
+			// Delete is ESC [ 3 ~.
+			if b, err = br.ReadByte(); err == nil && b == '~' {
+				keyCh <- keyDelete
+			}
+		case '5', '6':
+			// Page Up and Page Down are ESC [ 5 ~ and ESC [ 6 ~.
+			key := keyPageUp
+			if b == '6' {
+				key = keyPageDown
+			}
+			if b, err = br.ReadByte(); err == nil && b == '~' {
+				keyCh <- key
+			}
+		}
+	}
+}
+
+func meterBar(level float64, width int) string {
+	n := int(level * float64(width))
+	if n > width {
+		n = width
+	}
+	return "[" + strings.Repeat("#", n) + strings.Repeat(".", width-n) + "]"
+}
+
+func formatTime(d time.Duration) string {
+	m := (d / time.Minute) % 100
+	s := (d / time.Second) % 60
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// thumbnailWidth and thumbnailHeight are the size of a waveform thumbnail in the playlist pane.
+	thumbnailWidth  = 64
+	thumbnailHeight = 10
+)
+
+var thumbnailLoopColor = color.RGBA{0x40, 0x40, 0x20, 0x80}
+
+// thumbnail is the waveform and the loop of a file shown in the playlist pane.
+type thumbnail struct {
+	waveform *waveform
+
+	// introSample and loopSample are the loop of the file's tags in the stream's samples.
+	introSample int64
+	loopSample  int64
+}
+
+// thumbnails generates the thumbnails of the playlist's entries lazily in background.
+// The analyses are shared with the players, so a file whose thumbnail is shown opens with its waveform at once, and vice versa.
+type thumbnails struct {
+	thumbs map[string]*thumbnail
+
+	// pending is the files whose thumbnails are being generated, or failed to be generated.
+	pending map[string]bool
+
+	m sync.Mutex
+}
+
+func newThumbnails() *thumbnails {
+	return &thumbnails{
+		thumbs:  map[string]*thumbnail{},
+		pending: map[string]bool{},
+	}
+}
+
+// get returns the thumbnail of the file at path, or nil while it is being generated.
+// The first call starts generating it.
+func (t *thumbnails) get(path string) *thumbnail {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if th, ok := t.thumbs[path]; ok {
+		return th
+	}
+	if t.pending[path] {
+		return nil
+	}
+	t.pending[path] = true
+	theJobs.Go(fmt.Sprintf("Thumbnail %s", filepath.Base(path)), func(j *job) {
+		th, err := newThumbnail(path, j)
+		if err != nil {
+			if j.Err() == nil {
+				log.Printf("thumbnail error: %s, %v", path, err)
+			}
+			return
+		}
+		t.m.Lock()
+		defer t.m.Unlock()
+		t.thumbs[path] = th
+		delete(t.pending, path)
+	})
+	return nil
+}
+
+// newThumbnail reads the loop tags of the file at path and analyzes it unless the analysis is cached.
+func newThumbnail(path string, j *job) (*thumbnail, error) {
+	info, err := readFileInfoAt(path)
+	if err != nil {
+		return nil, err
+	}
+	tags := parseLoopTags(info.comments, theTagMode)
+	tags.removePreSkip(info.preSkip)
+	tags.toStreamRate(info)
+
+	key, err := newPCMCacheKey(path)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := theAnalyses.get(key)
+	if !ok {
+		f, err := openAudioFile(path, j)
+		if err != nil {
+			return nil, err
+		}
+		s, err := decodeStream(f, info)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		frames := s.Length() / bytesPerSample
+		f.Close()
+
+		a, err = analyze(path, frames, j)
+		if err != nil {
+			return nil, err
+		}
+		theAnalyses.put(key, a)
+	}
+	th := &thumbnail{
+		waveform: a.waveform,
+	}
+	if tags.err() == nil {
+		th.introSample, th.loopSample = tags.start, tags.length
+	}
+	return th, nil
+}
+
+// draw draws the thumbnail at x and y. introSample and loopSample override the loop when loopSample is positive,
+// e.g. for the loop given by a project.
+func (th *thumbnail) draw(b *shapeBatch, x, y int, introSample, loopSample int64) {
+	wf := th.waveform
+	if wf.frames == 0 {
+		return
+	}
+	if loopSample <= 0 {
+		introSample, loopSample = th.introSample, th.loopSample
+	}
+	if loopSample > 0 {
+		lx := int64(thumbnailWidth) * introSample / wf.frames
+		lw := int64(thumbnailWidth) * loopSample / wf.frames
+		if lw < 1 {
+			lw = 1
+		}
+		b.Rect(float64(int64(x)+lx), float64(y), float64(lw), thumbnailHeight, thumbnailLoopColor)
+	}
+	cy := float64(y) + thumbnailHeight/2
+	for i := 0; i < thumbnailWidth; i++ {
+		min, max := wf.rangePeaks(laneMid, int64(i)*wf.frames/thumbnailWidth, int64(i+1)*wf.frames/thumbnailWidth)
+		top := cy - float64(max)*thumbnailHeight/2
+		bottom := cy - float64(min)*thumbnailHeight/2
+		b.Rect(float64(x+i), top, 1, bottom-top+1, waveformColor)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+)
+
+// timeDisplay is how the current time and the loop points are shown.
+type timeDisplay int
+
+const (
+	// timeDisplayMinutes shows minutes and seconds with the sample in parentheses.
+	timeDisplayMinutes timeDisplay = iota
+
+	// timeDisplayMillis shows minutes, seconds and milliseconds with the sample in parentheses.
+	timeDisplayMillis
+
+	// timeDisplaySamples shows the sample only, as written in LOOPSTART and LOOPLENGTH.
+	timeDisplaySamples
+
+	timeDisplays
+)
+
+// String implements flag.Value.
+func (d *timeDisplay) String() string {
+	switch *d {
+	case timeDisplayMinutes:
+		return "mmss"
+	case timeDisplayMillis:
+		return "millis"
+	case timeDisplaySamples:
+		return "samples"
+	}
+	return ""
+}
+
+// Set implements flag.Value.
+func (d *timeDisplay) Set(str string) error {
+	switch str {
+	case "mmss":
+		*d = timeDisplayMinutes
+	case "millis":
+		*d = timeDisplayMillis
+	case "samples":
+		*d = timeDisplaySamples
+	default:
+		return fmt.Errorf("time display must be mmss, millis or samples but was %q", str)
+	}
+	return nil
+}
+
+// label returns the name of the display shown to the user.
+func (d timeDisplay) label() string {
+	switch d {
+	case timeDisplayMillis:
+		return "mm:ss.mmm"
+	case timeDisplaySamples:
+		return "samples"
+	}
+	return "mm:ss"
+}
+
+// next returns the display following d, wrapping around.
+func (d timeDisplay) next() timeDisplay {
+	return (d + 1) % timeDisplays
+}
+
+// timeReadout returns sample of the decoded stream as theTimeDisplay shows it. The sample is at the file's sample rate.
+func (p *Player) timeReadout(sample int64) string {
+	switch theTimeDisplay {
+	case timeDisplayMillis:
+		return fmt.Sprintf("%s (%d)", formatTimeMillis(samplesToDuration(sample)), p.fileSample(sample))
+	case timeDisplaySamples:
+		return fmt.Sprintf("%d", p.fileSample(sample))
+	}
+	return fmt.Sprintf("%s (%d)", formatTime(samplesToDuration(sample)), p.fileSample(sample))
+}
@@ -0,0 +1,195 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+const (
+	// minViewSpan is the number of the samples shown on the timeline at the maximum zoom, where a sample is 4 px wide.
+	minViewSpan = 75
+
+	// maxPCMViewSpan is the longest view drawn from the PCM itself instead of the waveform overview.
+	maxPCMViewSpan = 10 * sampleRate
+)
+
+// viewRange returns the range of the samples shown on the timeline, the bar and the waveform.
+// The whole file is shown unless zoomed.
+func (p *Player) viewRange() (start, span int64) {
+	if p.viewSpan <= 0 || p.viewSpan >= p.totalSample {
+		return 0, p.totalSample
+	}
+	return p.viewStart, p.viewSpan
+}
+
+// timelineX returns the x position of sample on the timeline, and whether it is in the view.
+func (p *Player) timelineX(sample int64) (int, bool) {
+	bx, _, bw, _ := playerBarRect()
+	start, span := p.viewRange()
+	if span <= 0 || sample < start || sample > start+span {
+		return 0, false
+	}
+	return bx + int((sample-start)*int64(bw)/span), true
+}
+
+// timelineXClamped returns the x position of sample on the timeline, clamped to the timeline.
+func (p *Player) timelineXClamped(sample int64) int {
+	start, span := p.viewRange()
+	if sample < start {
+		sample = start
+	}
+	if sample > start+span {
+		sample = start + span
+	}
+	x, _ := p.timelineX(sample)
+	return x
+}
+
+// timelineSample returns the sample at the x position on the timeline. x is clamped to the timeline.
+func (p *Player) timelineSample(x int) int64 {
+	bx, _, bw, _ := playerBarRect()
+	if x < bx {
+		x = bx
+	}
+	if x > bx+bw {
+		x = bx + bw
+	}
+	start, span := p.viewRange()
+	return start + int64(x-bx)*span/int64(bw)
+}
+
+// setView shows span samples from start on the timeline, clamped to the file.
+func (p *Player) setView(start, span int64) {
+	if span < minViewSpan {
+		span = minViewSpan
+	}
+	if span >= p.totalSample {
+		p.viewStart, p.viewSpan = 0, 0
+		return
+	}
+	if start > p.totalSample-span {
+		start = p.totalSample - span
+	}
+	if start < 0 {
+		start = 0
+	}
+	p.viewStart, p.viewSpan = start, span
+}
+
+// ZoomView zooms the timeline by factor (2 to zoom in, 0.5 to zoom out) keeping anchor at the same position.
+func (p *Player) ZoomView(anchor int64, factor float64) {
+	start, span := p.viewRange()
+	p.setView(anchor-int64(float64(anchor-start)/factor), int64(float64(span)/factor))
+}
+
+// ScrollView scrolls the timeline by the fraction of the view.
+func (p *Player) ScrollView(fraction float64) {
+	start, span := p.viewRange()
+	p.setView(start+int64(float64(span)*fraction), span)
+}
+
+// CenterView scrolls the timeline so that sample is at the center.
+func (p *Player) CenterView(sample int64) {
+	_, span := p.viewRange()
+	p.setView(sample-span/2, span)
+}
+
+// viewPCM returns the PCM of the view and the frame it starts at, or nil when the view is too long to draw the samples
+// or the PCM can't be read.
+// The PCM is read with a margin around the view so that scrolling doesn't read it again.
+func (p *Player) viewPCM() ([]int16, int64) {
+	start, span := p.viewRange()
+	if span > maxPCMViewSpan || p.viewPCMFailed {
+		return nil, 0
+	}
+	if p.viewPCMFrames != nil && p.viewPCMFrom <= start && start+span <= p.viewPCMFrom+int64(len(p.viewPCMFrames)/2) {
+		return p.viewPCMFrames, p.viewPCMFrom
+	}
+	from := start - span
+	if from < 0 {
+		from = 0
+	}
+	pcm, err := readPCMRange(p.path, from, start+2*span)
+	if err != nil {
+		log.Printf("reading PCM error: %s, %v", p.path, err)
+		p.viewPCMFailed = true
+		return nil, 0
+	}
+	p.viewPCMFrames, p.viewPCMFrom = pcm, from
+	return pcm, from
+}
+
+// zoomLine returns the line describing the zoom of the timeline, or an empty string when not zoomed.
+func (p *Player) zoomLine() string {
+	start, span := p.viewRange()
+	if span == p.totalSample {
+		return ""
+	}
+	_, _, bw, _ := playerBarRect()
+	return fmt.Sprintf("Zoom: %s - %s (%.1f samples/px)", formatTimeMillis(samplesToDuration(start)), formatTimeMillis(samplesToDuration(start+span)), float64(span)/float64(bw))
+}
+
+// zoomIfNeeded zooms the timeline with the mouse wheel around the cursor, or with +/- around the playhead,
+// and scrolls it with Shift+wheel, a horizontal wheel or the left/right arrows. [ and ] show the loop start and end.
+func (p *Player) zoomIfNeeded() {
+	_, wheel := ebiten.Wheel()
+	hwheel, _ := ebiten.Wheel()
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		hwheel += wheel
+		wheel = 0
+	}
+	x, y := ebiten.CursorPosition()
+	// The loudness lane is also a part of the timeline.
+	_, wy, _, _ := waveformRect()
+	_, by, _, bh := playerBarRect()
+	if wheel != 0 && wy-loudnessLaneHeight-2 <= y && y < by+bh+4 {
+		factor := 2.0
+		if wheel < 0 {
+			factor = 0.5
+		}
+		p.ZoomView(p.timelineSample(x), factor)
+	}
+	if hwheel != 0 {
+		p.ScrollView(-hwheel / 4)
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyEqual) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadAdd) {
+		p.ZoomView(p.currentSample(), 2)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyMinus) || inpututil.IsKeyJustPressed(ebiten.KeyNumpadSubtract) {
+		p.ZoomView(p.currentSample(), 0.5)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowLeft) {
+		p.ScrollView(-0.25)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowRight) {
+		p.ScrollView(0.25)
+	}
+	// Shift+[ and Shift+] change the speed.
+	if ebiten.IsKeyPressed(ebiten.KeyShift) {
+		return
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketLeft) {
+		p.CenterView(p.introSample)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketRight) {
+		p.CenterView(p.introSample + p.loopSample)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// transportScheme is the keys controlling the playback.
+type transportScheme int
+
+const (
+	// transportSpace toggles Play/Pause with Space.
+	transportSpace transportScheme = iota
+
+	// transportJKL adds J (reverse), K (stop) and L (forward) as in video and audio editors.
+	// Tapping J or L again doubles the speed.
+	transportJKL
+)
+
+// String implements flag.Value.
+func (s *transportScheme) String() string {
+	switch *s {
+	case transportSpace:
+		return "space"
+	case transportJKL:
+		return "jkl"
+	}
+	return ""
+}
+
+// Set implements flag.Value.
+func (s *transportScheme) Set(str string) error {
+	switch str {
+	case "space":
+		*s = transportSpace
+	case "jkl":
+		*s = transportJKL
+	default:
+		return fmt.Errorf("transport scheme must be space or jkl but was %q", str)
+	}
+	return nil
+}
+
+const (
+	// maxShuttleSpeed is the maximum speed of the shuttle in both directions.
+	maxShuttleSpeed = 8
+
+	// shuttleInterval is the interval of the jumps of the shuttle.
+	// The audio can't be played faster or reversed, so the shuttle plays a short piece and jumps, as a CD player does.
+	shuttleInterval = 100 * time.Millisecond
+)
+
+// ShuttleForward plays forward, and doubles the speed when already playing forward (L).
+func (p *Player) ShuttleForward() {
+	switch {
+	case !p.IsPlaying() || p.shuttle <= 0:
+		p.shuttle = 1
+	case p.shuttle < maxShuttleSpeed:
+		p.shuttle *= 2
+	}
+	p.shuttleAt = time.Now()
+	p.play()
+}
+
+// ShuttleReverse plays backward, and doubles the speed when already playing backward (J).
+func (p *Player) ShuttleReverse() {
+	switch {
+	case !p.IsPlaying() || p.shuttle >= 0:
+		p.shuttle = -1
+	case p.shuttle > -maxShuttleSpeed:
+		p.shuttle *= 2
+	}
+	p.shuttleAt = time.Now()
+	p.play()
+}
+
+// StopShuttle pauses and goes back to the normal speed (K).
+func (p *Player) StopShuttle() {
+	p.shuttle = 0
+	p.Pause()
+}
+
+// shuttleLabel returns the direction and the speed of the shuttle, or an empty string at the normal speed.
+func (p *Player) shuttleLabel() string {
+	switch {
+	case p.shuttle < 0:
+		return fmt.Sprintf("Reverse x%d", -p.shuttle)
+	case p.shuttle > 1:
+		return fmt.Sprintf("Forward x%d", p.shuttle)
+	}
+	return ""
+}
+
+// updateShuttle jumps to where the shuttle should be. The playback itself moves forward at the normal speed.
+func (p *Player) updateShuttle(now time.Time) {
+	if (p.shuttle == 0 || p.shuttle == 1) || !p.IsPlaying() {
+		return
+	}
+	elapsed := now.Sub(p.shuttleAt)
+	if elapsed < shuttleInterval {
+		return
+	}
+	p.shuttleAt = now
+
+	pos := p.current + time.Duration(p.shuttle-1)*elapsed
+	if pos <= 0 {
+		// Stop at the beginning as an editor does.
+		p.StopShuttle()
+		p.Seek(0)
+		return
+	}
+	if intro, loop := p.playbackLoop(); pos >= samplesToDuration(intro+loop) {
+		pos = samplesToDuration(intro) + (pos-samplesToDuration(intro+loop))%samplesToDuration(loop)
+	}
+	p.Seek(pos)
+}
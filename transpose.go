@@ -0,0 +1,279 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// maxTranspose is the largest transpose in semitones either way.
+const maxTranspose = 12
+
+// rateStage is a preview stage changing the playback rate. Unlike the other stages, it changes how fast
+// the source is read rather than the samples, so it is applied by a rateStream and Process does nothing.
+type rateStage interface {
+	previewStage
+
+	// Rate returns the playback rate, e.g. 2 for an octave up.
+	Rate() float64
+}
+
+// transposeStage transposes the playback by resampling, as a game engine changing the pitch of a sound does:
+// the tempo changes with the pitch, like a tape played faster or slower.
+// With keepTempo, the playback is also time-stretched by the inverse of the rate, which shifts the pitch keeping the tempo.
+type transposeStage struct {
+	semitones int
+	keepTempo bool
+	m         sync.Mutex
+}
+
+func (t *transposeStage) Name() string {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.keepTempo {
+		return fmt.Sprintf("Pitch shift %+d st", t.semitones)
+	}
+	return fmt.Sprintf("Transpose %+d st", t.semitones)
+}
+
+func (t *transposeStage) Active() bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.semitones != 0
+}
+
+func (t *transposeStage) Process(pcm []int16) {
+}
+
+func (t *transposeStage) Rate() float64 {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return math.Pow(2, float64(t.semitones)/12)
+}
+
+// Tempo implements tempoStage. The tempo cancels the rate's change of the tempo with keepTempo.
+func (t *transposeStage) Tempo() float64 {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if !t.keepTempo {
+		return 1
+	}
+	return math.Pow(2, -float64(t.semitones)/12)
+}
+
+// ToggleKeepTempo switches between the transpose changing the tempo and the pitch shift keeping it.
+func (t *transposeStage) ToggleKeepTempo() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.keepTempo = !t.keepTempo
+}
+
+// Add transposes by delta semitones within maxTranspose.
+func (t *transposeStage) Add(delta int) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.semitones += delta
+	if t.semitones > maxTranspose {
+		t.semitones = maxTranspose
+	}
+	if t.semitones < -maxTranspose {
+		t.semitones = -maxTranspose
+	}
+}
+
+// rate returns the playback rate of the active rate stages, or 1 when they are bypassed.
+func (c *previewChain) rate() float64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.bypass {
+		return 1
+	}
+	r := 1.0
+	for _, s := range c.stages {
+		if rs, ok := s.(rateStage); ok && s.Active() {
+			r *= rs.Rate()
+		}
+	}
+	return r
+}
+
+// rateStream is a stream resampled at the rate of a previewChain with cubic interpolation.
+// The source is read as is while the rate is 1.
+//
+// The audio player counts the bytes of this stream, so its position is not the source's one while resampled.
+// Player converts the position with the rate.
+type rateStream struct {
+	frameReader
+	chain *previewChain
+
+	// pos is the position in the frames read ahead to read next.
+	pos float64
+
+	m sync.Mutex
+}
+
+func newRateStream(src io.ReadSeeker, chain *previewChain) *rateStream {
+	return &rateStream{
+		frameReader: frameReader{src: src},
+		chain:       chain,
+	}
+}
+
+// frameReader reads the interleaved stereo PCM of a source ahead.
+type frameReader struct {
+	src    io.ReadSeeker
+	frames []int16
+	raw    []byte
+	eof    bool
+}
+
+// fill reads the source until n frames are read ahead or the source ends.
+func (s *frameReader) fill(n int) error {
+	for len(s.frames)/2 < n && !s.eof {
+		size := (n - len(s.frames)/2) * bytesPerSample
+		if cap(s.raw) < size {
+			s.raw = make([]byte, size)
+		}
+		raw := s.raw[:size]
+		m, err := io.ReadFull(s.src, raw)
+		m -= m % bytesPerSample
+		for i := 0; i < m/2; i++ {
+			s.frames = append(s.frames, int16(raw[2*i])|int16(raw[2*i+1])<<8)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			s.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sample returns the sample of the channel ch at the frame i, clamped to the frames read ahead.
+func (s *rateStream) sample(i, ch int) float64 {
+	if i < 0 {
+		i = 0
+	}
+	if n := len(s.frames) / 2; i >= n {
+		i = n - 1
+	}
+	return float64(s.frames[2*i+ch])
+}
+
+func (s *rateStream) Read(buf []byte) (int, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	r := s.chain.rate()
+	if r == 1 && len(s.frames) == 0 {
+		return s.src.Read(buf)
+	}
+	out := len(buf) / bytesPerSample
+	if out == 0 {
+		return 0, nil
+	}
+
+	if r == 1 {
+		// Go back to the source's samples: read out what is read ahead first.
+		s.pos = math.Round(s.pos)
+		if err := s.fill(int(s.pos) + 1); err != nil {
+			return 0, err
+		}
+		i := int(s.pos)
+		n := len(s.frames)/2 - i
+		if n > out {
+			n = out
+		}
+		if n <= 0 {
+			s.frames = s.frames[:0]
+			s.pos = 0
+			if s.eof {
+				return 0, io.EOF
+			}
+			return s.src.Read(buf)
+		}
+		for j := 0; j < 2*n; j++ {
+			v := s.frames[2*i+j]
+			buf[2*j] = byte(v)
+			buf[2*j+1] = byte(v >> 8)
+		}
+		s.pos += float64(n)
+		s.drop()
+		return n * bytesPerSample, nil
+	}
+
+	if err := s.fill(int(s.pos+r*float64(out-1)) + 3); err != nil {
+		return 0, err
+	}
+	n := 0
+	for ; n < out; n++ {
+		i := int(s.pos)
+		if i >= len(s.frames)/2 {
+			break
+		}
+		t := s.pos - float64(i)
+		for ch := 0; ch < 2; ch++ {
+			v := cubic(s.sample(i-1, ch), s.sample(i, ch), s.sample(i+1, ch), s.sample(i+2, ch), t)
+			if v > math.MaxInt16 {
+				v = math.MaxInt16
+			}
+			if v < math.MinInt16 {
+				v = math.MinInt16
+			}
+			iv := int16(v)
+			buf[4*n+2*ch] = byte(iv)
+			buf[4*n+2*ch+1] = byte(iv >> 8)
+		}
+		s.pos += r
+	}
+	s.drop()
+	if n == 0 && s.eof {
+		return 0, io.EOF
+	}
+	return n * bytesPerSample, nil
+}
+
+// drop discards the frames read ahead which are no longer needed for the interpolation.
+func (s *rateStream) drop() {
+	d := int(s.pos) - 1
+	if d <= 0 {
+		return
+	}
+	if n := len(s.frames) / 2; d > n {
+		d = n
+	}
+	s.frames = append(s.frames[:0], s.frames[2*d:]...)
+	s.pos -= float64(d)
+}
+
+func (s *rateStream) Seek(offset int64, whence int) (int64, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.frames = s.frames[:0]
+	s.pos = 0
+	s.eof = false
+	return s.src.Seek(offset, whence)
+}
+
+// cubic interpolates between y1 and y2 at t in [0, 1) with the Catmull-Rom spline through y0 to y3.
+func cubic(y0, y1, y2, y3, t float64) float64 {
+	return y1 + 0.5*t*(y2-y0+t*(2*y0-5*y1+4*y2-y3+t*(3*(y1-y2)+y3-y0)))
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// silenceThreshold is the amplitude regarded as silence, about -60 dBFS.
+	silenceThreshold = 32
+
+	// silenceReportLength is the shortest silence at an edge of the file or the loop that is reported.
+	silenceReportLength = sampleRate / 100
+)
+
+// silenceTrim is a proposal to trim the leading and trailing silence of a file.
+type silenceTrim struct {
+	// start and end are the range kept in samples.
+	start int64
+	end   int64
+}
+
+// isSilent reports whether a stereo frame is regarded as silence.
+func isSilent(l, r int16) bool {
+	return -silenceThreshold < l && l < silenceThreshold && -silenceThreshold < r && r < silenceThreshold
+}
+
+// proposeSilenceTrim returns the range without the leading and trailing silence of a file of frames samples per channel,
+// where [soundStart, soundEnd) is the range found by the analysis.
+// The range never cuts the loop [introSample, introSample+loopSample) when the loop is valid.
+// proposeSilenceTrim reports false when there is nothing to trim.
+func proposeSilenceTrim(soundStart, soundEnd, frames int64, introSample, loopSample int64, validLoop bool) (silenceTrim, bool) {
+	t := silenceTrim{start: soundStart, end: soundEnd}
+	if t.end > frames {
+		t.end = frames
+	}
+	if t.end < t.start {
+		t.end = t.start
+	}
+	if validLoop {
+		if t.start > introSample {
+			t.start = introSample
+		}
+		if t.end < introSample+loopSample {
+			t.end = introSample + loopSample
+		}
+	}
+	if t.start >= t.end || (t.start == 0 && t.end == frames) {
+		return silenceTrim{}, false
+	}
+	return t, true
+}
+
+// silenceWarnings returns the leading and the trailing silence of a file of frames samples per channel,
+// where [soundStart, soundEnd) is the range found by the analysis. When the loop is valid, the silence at its start or its end
+// is also reported, which is heard as a gap at every wrap, e.g. after LOOPSTART is set before the sound of a padded export.
+func silenceWarnings(soundStart, soundEnd, frames int64, introSample, loopSample int64, validLoop bool) []string {
+	if soundEnd <= soundStart {
+		return nil
+	}
+	var warnings []string
+	if soundStart >= silenceReportLength {
+		warnings = append(warnings, fmt.Sprintf("the file starts with %.3f s of silence", samplesToDuration(soundStart).Seconds()))
+	}
+	if frames-soundEnd >= silenceReportLength {
+		warnings = append(warnings, fmt.Sprintf("the file ends with %.3f s of silence", samplesToDuration(frames-soundEnd).Seconds()))
+	}
+	if !validLoop {
+		return warnings
+	}
+	loopEnd := introSample + loopSample
+	if gap := soundStart - introSample; gap >= silenceReportLength {
+		if soundStart > loopEnd {
+			gap = loopSample
+		}
+		warnings = append(warnings, fmt.Sprintf("the loop starts with %.3f s of silence, heard at every wrap", samplesToDuration(gap).Seconds()))
+	}
+	if gap := loopEnd - soundEnd; gap >= silenceReportLength && soundEnd > introSample {
+		warnings = append(warnings, fmt.Sprintf("the loop ends with %.3f s of silence, heard at every wrap", samplesToDuration(gap).Seconds()))
+	}
+	return warnings
+}
+
+// silenceWarnings returns the silence at the edges of the file and the loop, or nil until the analysis finishes.
+func (p *Player) silenceWarnings() []string {
+	if p.analysis == nil {
+		return nil
+	}
+	return silenceWarnings(p.analysis.soundStart, p.analysis.soundEnd, p.totalSample, p.introSample, p.loopSample, p.isValidLoop(p.introSample, p.loopSample))
+}
+
+// ProposeTrim proposes trimming the silence of the file. ProposeTrim reports false when there is nothing to trim,
+// or the file is not analyzed yet.
+func (p *Player) ProposeTrim() bool {
+	if p.analysis == nil {
+		return false
+	}
+	t, ok := proposeSilenceTrim(p.analysis.soundStart, p.analysis.soundEnd, p.totalSample, p.fileIntroSample, p.fileLoopSample, p.isValidLoop(p.fileIntroSample, p.fileLoopSample))
+	if !ok {
+		return false
+	}
+	p.trim = &t
+	return true
+}
+
+// trimLine returns the line describing the proposed trim, or an empty string when no trim is proposed.
+func (p *Player) trimLine() string {
+	if p.trim == nil {
+		return ""
+	}
+	return fmt.Sprintf("Trim %s of silence at the start and %s at the end?",
+		formatTimeMillis(samplesToDuration(p.trim.start)), formatTimeMillis(samplesToDuration(p.totalSample-p.trim.end)))
+}
+
+// trimmedPath returns the path the trimmed file of path is written to.
+// The trimmed file is always encoded to Ogg/Vorbis, even from a WAV file.
+func trimmedPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_trimmed.ogg"
+}
+
+// TrimJob returns the function writing the file with the proposed trim, with the loop tags adjusted to the trim.
+// The function can be called on another goroutine.
+func (p *Player) TrimJob() func(j *job) (string, error) {
+	t := *p.trim
+	p.trim = nil
+
+	src := p.path
+	var comments []string
+	if p.info != nil {
+		c := &vorbisComments{comments: append([]string(nil), p.info.comments.comments...)}
+		if p.isValidLoop(p.fileIntroSample, p.fileLoopSample) {
+			c.Set(loopStartKey, strconv.FormatInt(p.fileIntroSample-t.start, 10))
+			c.Set(loopLengthKey, strconv.FormatInt(p.fileLoopSample, 10))
+		}
+		comments = c.comments
+	}
+	path := trimmedPath(p.path)
+	return func(j *job) (string, error) {
+		if err := checkWritable(); err != nil {
+			return "", err
+		}
+		pcm, err := decodePCM(src, j)
+		if err != nil {
+			return "", err
+		}
+		if end := int64(len(pcm) / 2); t.end > end {
+			t.end = end
+		}
+		if err := encodeVorbis(path, pcm[2*t.start:2*t.end], defaultVorbisQuality, comments, j); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+}
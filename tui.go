@@ -0,0 +1,1154 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"golang.org/x/term"
+)
+
+const (
+	tuiFPS         = 15
+	tuiSeekStep    = 5 * time.Second
+	tuiVolumeStep  = 8
+	tuiMeterWidth  = 40
+	tuiMinBarWidth = 10
+)
+
+// TUI is a curses-style terminal interface for machines without a display.
+// It drives the same Player as the GUI.
+type TUI struct {
+	audioContext *audio.Context
+	musicPlayer  *Player
+	playlist     *Playlist
+	m3uPath      string
+	snapshotPath string
+	status       string
+	statusM      sync.Mutex
+	keyCh        chan string
+	errCh        chan error
+	out          *bufio.Writer
+	presence     *discordPresence
+	mediaKeys    *mediaKeys
+	monitor      *inputMonitor
+	history      *listeningHistory
+
+	// crossfade keeps the previous tracks fading out after the next track is opened.
+	crossfade crossfader
+
+	// reference is the reference track compared with the current file. reference is nil until it is opened.
+	reference *referenceAB
+
+	// meters is the peak meters of the playback.
+	meters *peakMeters
+
+	// startup is what to do at startup when no files are given, and watcher is the folder watched by it, or nil.
+	startup startup
+	watcher *folderWatcher
+
+	// comparison is the audition of the current file and its encoded version. comparison is nil when not comparing.
+	comparison  *abComparison
+	presetIndex int
+	encodedCh   chan encodeResult
+
+	// editingNote reports whether the notes of the current file are being edited.
+	// momentIndex is the index of the note taken at a moment being edited, or -1 for the review's notes.
+	editingNote bool
+	note        string
+	momentIndex int
+
+	// renaming reports whether the new path of the current file is being edited.
+	renaming bool
+	newPath  string
+
+	// showRecent reports whether the recent files are listed. The next key opens one of them or closes the list.
+	showRecent bool
+
+	// loading is the file being decoded before it is opened, or nil.
+	loading *pendingLoad
+}
+
+func NewTUI(paths []string) (*TUI, error) {
+	playlist := NewPlaylist(nil)
+	for _, path := range paths {
+		if _, err := playlist.AddFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return &TUI{
+		audioContext: audio.NewContext(sampleRate),
+		playlist:     playlist,
+		keyCh:        make(chan string),
+		encodedCh:    make(chan encodeResult),
+		errCh:        make(chan error, 1),
+		out:          bufio.NewWriter(os.Stdout),
+		meters:       newPeakMeters(),
+	}, nil
+}
+
+// Write implements io.Writer so that log messages are shown in the status line
+// instead of breaking the screen.
+func (t *TUI) Write(p []byte) (int, error) {
+	t.statusM.Lock()
+	defer t.statusM.Unlock()
+	t.status = strings.TrimSpace(string(p))
+	return len(p), nil
+}
+
+func (t *TUI) setStatus(format string, args ...interface{}) {
+	t.statusM.Lock()
+	defer t.statusM.Unlock()
+	t.status = fmt.Sprintf(format, args...)
+}
+
+func (t *TUI) Run() error {
+	stdin := int(os.Stdin.Fd())
+	if !term.IsTerminal(stdin) {
+		return fmt.Errorf("oggplayer: the terminal UI requires a terminal")
+	}
+	state, err := term.MakeRaw(stdin)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(stdin, state)
+
+	// Hide the cursor while running, and restore it at the end.
+	fmt.Fprint(os.Stdout, "\x1b[?25l\x1b[2J")
+	defer fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[2J\x1b[H")
+
+	log.SetOutput(t)
+	defer log.SetOutput(os.Stderr)
+
+	go readTerminalKeys(os.Stdin, t.keyCh, t.errCh)
+
+	// The current file is already opened or being decoded when the state is restored from a snapshot.
+	if t.musicPlayer == nil && t.loading == nil {
+		if t.playlist.Len() > 0 {
+			t.load(0)
+		} else {
+			t.start()
+		}
+	}
+
+	ticker := time.NewTicker(time.Second / tuiFPS)
+	defer ticker.Stop()
+	for {
+		select {
+		case key := <-t.keyCh:
+			if t.editingNote && key != keyInterrupt {
+				t.editNote(key)
+				break
+			}
+			if t.renaming && key != keyInterrupt {
+				t.editNewPath(key)
+				break
+			}
+			if key == keyCtrlO || (t.showRecent && key != keyInterrupt) {
+				t.recent(key)
+				break
+			}
+			if t.musicPlayer != nil && t.musicPlayer.loopConflict != nil && key != keyInterrupt {
+				t.resolveLoopConflict(key)
+				break
+			}
+			// Shift+T takes a note at the current moment.
+			if key == "T" {
+				t.takeNote()
+				break
+			}
+			// Shift+E auditions the end of the intro.
+			if key == strings.ToUpper(terminalKey(theSettings.Keys.key(actionSeam))) && t.musicPlayer != nil {
+				if err := t.musicPlayer.PreviewIntroEnd(time.Duration(*flagPreview * float64(time.Second))); err != nil {
+					t.setStatus("Failed to seek: %v", err)
+				}
+				break
+			}
+			// Shift+M plays the mid only, the side only and the stereo in turn.
+			if key == "M" {
+				theMidSide.Next()
+				break
+			}
+			// Shift+L and Shift+R solo the left and the right channel, and Shift+D downmixes to mono.
+			if key == "L" || key == "R" || key == "D" {
+				mode := channelMono
+				switch key {
+				case "L":
+					mode = channelLeft
+				case "R":
+					mode = channelRight
+				}
+				theChannels.Toggle(mode)
+				break
+			}
+			// Shift+, and Shift+. (< and >) pan the playback.
+			if key == "<" || key == ">" {
+				if key == "<" {
+					thePan.Add(-1)
+				} else {
+					thePan.Add(1)
+				}
+				break
+			}
+			// Shift+Z switches where the loop markers snap to.
+			if key == "Z" {
+				theSnap = theSnap.next()
+				break
+			}
+			// Shift+X switches how the times are shown.
+			if key == "X" {
+				theTimeDisplay = theTimeDisplay.next()
+				break
+			}
+			// Shift+C toggles the master limiter.
+			if key == "C" {
+				theLimiter.Toggle()
+				break
+			}
+			// Shift+S fades out and stops the playback.
+			if key == "S" {
+				if t.musicPlayer != nil {
+					t.musicPlayer.FadeOutAndStop()
+				}
+				break
+			}
+			// Shift+I toggles the input monitor.
+			if key == "I" {
+				m, err := toggleInputMonitor(t.audioContext, t.monitor)
+				t.monitor = m
+				if err != nil {
+					t.setStatus("Failed to monitor the input: %v", err)
+				}
+				break
+			}
+			// Shift+A and Shift+B set the points of the A-B loop.
+			if (key == "A" || key == "B") && t.musicPlayer != nil {
+				set := t.musicPlayer.SetPointA
+				if key == "B" {
+					set = t.musicPlayer.SetPointB
+				}
+				if err := set(); err != nil {
+					t.setStatus("Failed to loop A-B: %v", err)
+				}
+				break
+			}
+			key = theSettings.Keys.remapTerminalKey(strings.ToLower(key))
+			if key == "q" || key == keyInterrupt {
+				t.closeComparison()
+				t.crossfade.Close()
+				t.reference.Close()
+				if t.monitor != nil {
+					t.monitor.Close()
+				}
+				if t.snapshotPath != "" {
+					if err := takeSnapshot(t.playlist, t.musicPlayer).save(t.snapshotPath); err != nil {
+						return err
+					}
+				}
+				saveLastSession(t.playlist, t.musicPlayer)
+				if t.musicPlayer != nil {
+					if err := t.history.Record(t.musicPlayer); err != nil {
+						return err
+					}
+					t.musicPlayer.Close()
+				}
+				return nil
+			}
+			t.handleKey(key)
+		case a := <-t.mediaKeys.C():
+			applyMediaAction(a, t.musicPlayer, func() {
+				t.load(t.playlist.Index() + 1)
+			}, func() {
+				t.load(t.playlist.Index() - 1)
+			})
+		case err := <-t.errCh:
+			return err
+		case r := <-t.encodedCh:
+			t.compareEncoded(r)
+		case <-ticker.C:
+		}
+		t.crossfade.Update()
+		t.loadingIfNeeded()
+		t.watch()
+		t.reference.Update(t.musicPlayer)
+		if t.musicPlayer != nil {
+			t.musicPlayer.updateCurrent()
+			t.reloadIfNeeded()
+			left, right := t.musicPlayer.Peaks()
+			t.meters.Update(time.Now(), left, right)
+		}
+		t.presence.Update(t.musicPlayer)
+		t.mediaKeys.Update(t.musicPlayer)
+		t.draw()
+	}
+}
+
+// start starts as t.startup tells when no files are given.
+// The recent files are listed instead of the file dialog.
+func (t *TUI) start() {
+	switch t.startup.mode {
+	case startupResume:
+		snap, err := loadLastSession()
+		if err != nil {
+			t.setStatus("Failed to resume the last session: %v", err)
+			return
+		}
+		if snap != nil {
+			if err := t.restore(snap); err != nil {
+				t.setStatus("Failed to resume the last session: %v", err)
+			}
+			return
+		}
+	case startupProject:
+		if _, err := t.playlist.AddFile(t.startup.path); err != nil {
+			t.setStatus("Failed to open %s: %v", t.startup.path, err)
+			return
+		}
+		t.load(0)
+		return
+	case startupWatch:
+		t.watcher = newFolderWatcher(t.startup.path)
+		t.setStatus("Watching %s for exported files", t.startup.path)
+		return
+	case startupDialog:
+		t.showRecent = true
+		return
+	}
+	t.setStatus("No files are given. Run with: oggplayer --tui file.ogg...")
+}
+
+// watch adds the audio files exported to the watched folder to the playlist, and opens the latest one.
+func (t *TUI) watch() {
+	paths, err := t.watcher.Poll(time.Now())
+	if err != nil {
+		t.setStatus("Failed to watch %s: %v", t.watcher.dir, err)
+		return
+	}
+	index := -1
+	for _, path := range paths {
+		i, err := t.playlist.AddFile(path)
+		if err != nil {
+			t.setStatus("Failed to open %s: %v", path, err)
+			continue
+		}
+		index = i
+	}
+	if index >= 0 {
+		t.load(index)
+	}
+}
+
+func (t *TUI) load(index int) {
+	t.loadThen(index, nil)
+}
+
+// loadThen is like load, and calls then with the opened player unless then is nil.
+// A file needing an external decoder, e.g. Opus, is decoded in a job first, and is opened by loadingIfNeeded when the decode finishes.
+func (t *TUI) loadThen(index int, then func(p *Player) error) {
+	if !t.playlist.SetIndex(index) {
+		return
+	}
+	t.closeComparison()
+	if t.loading != nil {
+		t.loading.decode.Cancel()
+		t.loading = nil
+	}
+	crossfading := false
+	if t.musicPlayer != nil {
+		if err := t.history.Record(t.musicPlayer); err != nil {
+			log.Printf("history error: %v", err)
+		}
+		crossfading = t.crossfade.Release(t.musicPlayer)
+		if !crossfading {
+			t.musicPlayer.Close()
+		}
+		t.musicPlayer = nil
+	}
+
+	if d := startDecode(t.playlist.Current()); d != nil {
+		t.setStatus("Decoding %s...", filepath.Base(d.path))
+		t.loading = &pendingLoad{
+			decode:      d,
+			crossfading: crossfading,
+			then:        then,
+		}
+		return
+	}
+	t.open(crossfading, then)
+}
+
+// open opens the current file in the playlist, and calls then with the opened player unless then is nil.
+func (t *TUI) open(crossfading bool, then func(p *Player) error) {
+	p, err := NewPlayer(t.audioContext, t.playlist.Current())
+	if err != nil {
+		t.setStatus("Failed to open %s: %v", t.playlist.Current(), err)
+		return
+	}
+	t.musicPlayer = p
+	if crossfading {
+		t.crossfade.FadeIn(p)
+	}
+	theSettings.addRecent(p.path)
+	t.setStatus("")
+	if err := t.playlist.prepare(p); err != nil {
+		t.setStatus("Failed to open %s: %v", t.playlist.Current(), err)
+		return
+	}
+	if then == nil {
+		return
+	}
+	if err := then(p); err != nil {
+		t.setStatus("Failed to open %s: %v", t.playlist.Current(), err)
+	}
+}
+
+// loadingIfNeeded opens the file being loaded when its decode finishes.
+func (t *TUI) loadingIfNeeded() {
+	if t.loading == nil {
+		return
+	}
+	over, err := t.loading.decode.result()
+	if !over {
+		return
+	}
+	l := t.loading
+	t.loading = nil
+	if err != nil {
+		t.setStatus("Failed to open %s: %v", l.decode.path, err)
+		return
+	}
+	t.open(l.crossfading, l.then)
+}
+
+// resolveLoopConflict uses the loop chosen by 1-4 when the sources of the loop disagree. Any other key keeps the current loop.
+func (t *TUI) resolveLoopConflict(key string) {
+	p := t.musicPlayer
+	if len(key) != 1 || key[0] < '1' || int(key[0]-'1') >= len(p.loopConflict) {
+		p.DismissLoopConflict()
+		return
+	}
+	if err := p.ResolveLoopConflict(int(key[0] - '1')); err != nil {
+		t.setStatus("Failed to set the loop: %v", err)
+	}
+}
+
+// recent lists the recent files with Ctrl+O, and opens the one chosen by 1-9. Any other key closes the list.
+func (t *TUI) recent(key string) {
+	if !t.showRecent {
+		t.showRecent = true
+		return
+	}
+	t.showRecent = false
+	if len(key) != 1 || key[0] < '1' || key[0] > '9' {
+		return
+	}
+	path, err := recentPath(int(key[0] - '1'))
+	if err != nil {
+		t.setStatus("Failed to open the recent file: %v", err)
+		return
+	}
+	if path == "" {
+		return
+	}
+	index, err := t.playlist.findOrAdd(path)
+	if err != nil {
+		t.setStatus("Failed to open %s: %v", path, err)
+		return
+	}
+	t.load(index)
+}
+
+// reloadIfNeeded reopens the file when it is rewritten, e.g. re-exported by a DAW.
+func (t *TUI) reloadIfNeeded() {
+	// The comparison would be broken by replacing the player.
+	if t.comparison != nil || t.reference.Active() || !t.musicPlayer.FileChanged() {
+		return
+	}
+	p, err := t.musicPlayer.Reload()
+	if err != nil {
+		t.setStatus("Failed to reload %s: %v", t.musicPlayer.path, err)
+		return
+	}
+	t.musicPlayer = p
+	t.setStatus("Reloaded %s", filepath.Base(p.path))
+}
+
+// restore restores the playlist and the current file's state from s.
+func (t *TUI) restore(s *snapshot) error {
+	theInserts.restore(s.Inserts)
+	t.playlist = s.playlist()
+	// The snapshot is applied when the file is opened, which can be after it is decoded.
+	t.loadThen(s.Index, s.apply)
+	return nil
+}
+
+// jump jumps to the position of the share token in the clipboard, opening its file in the playlist.
+func (t *TUI) jump() {
+	s, index, err := readShareToken(t.playlist)
+	if err != nil {
+		t.setStatus("Failed to paste the share token: %v", err)
+		return
+	}
+	if t.musicPlayer == nil || index != t.playlist.Index() {
+		t.load(index)
+	}
+	if t.musicPlayer == nil {
+		return
+	}
+	if err := t.musicPlayer.JumpTo(s); err != nil {
+		t.setStatus("Failed to seek: %v", err)
+		return
+	}
+	t.setStatus("Jumped to %s", s)
+}
+
+func (t *TUI) handleKey(key string) {
+	switch key {
+	case "n", keyDown:
+		t.load(t.playlist.Index() + 1)
+		return
+	case "p", keyUp:
+		t.load(t.playlist.Index() - 1)
+		return
+	case keyDelete:
+		theJobs.CancelLast()
+		return
+	case keyCtrlV:
+		t.jump()
+		return
+	case "w":
+		if err := t.playlist.Write(t.m3uPath); err != nil {
+			t.setStatus("Failed to export the playlist: %v", err)
+			return
+		}
+		t.setStatus("Exported the playlist to %s", t.m3uPath)
+		return
+	}
+
+	p := t.musicPlayer
+	if p == nil {
+		return
+	}
+	path := t.playlist.Current()
+	switch key {
+	case " ":
+		p.TogglePlay()
+	case keyLeft:
+		p.Seek(p.current - tuiSeekStep)
+	case keyRight:
+		p.Seek(p.current + tuiSeekStep)
+	case "z":
+		p.AddVolume(-tuiVolumeStep)
+	case "x":
+		p.AddVolume(tuiVolumeStep)
+	case "i":
+		if err := p.MoveLoopHandle(loopHandleStart, p.currentSample()); err != nil {
+			t.setStatus("Failed to set the loop start: %v", err)
+		}
+	case "o":
+		if err := p.MoveLoopHandle(loopHandleEnd, p.currentSample()); err != nil {
+			t.setStatus("Failed to set the loop end: %v", err)
+		}
+	case "j", "k", "l":
+		if theTransport != transportJKL {
+			return
+		}
+		switch key {
+		case "j":
+			p.ShuttleReverse()
+		case "k":
+			p.StopShuttle()
+		case "l":
+			p.ShuttleForward()
+		}
+	case "e":
+		if err := p.PreviewSeam(time.Duration(*flagPreview * float64(time.Second))); err != nil {
+			t.setStatus("Failed to seek: %v", err)
+		}
+	case "h":
+		if err := p.ScaleLoopLength(1, 2); err != nil {
+			t.setStatus("Failed to halve the loop: %v", err)
+		}
+	case "d":
+		if err := p.ScaleLoopLength(2, 1); err != nil {
+			t.setStatus("Failed to double the loop: %v", err)
+		}
+	case ",", ".":
+		delta := int64(1)
+		if key == "," {
+			delta = -1
+		}
+		if err := p.ShiftLoop(delta, int64(*flagLoopBars)); err != nil {
+			t.setStatus("Failed to shift the loop: %v", err)
+		}
+	case "c":
+		if len(p.badTagKeys) == 0 {
+			return
+		}
+		t.closeComparison()
+		p = t.musicPlayer
+		if err := p.ClearBadTags(); err != nil {
+			t.setStatus("Failed to clear the tags: %v", err)
+			return
+		}
+		t.load(t.playlist.Index())
+	case "r":
+		r := t.playlist.Review(path)
+		r.Status = r.Status.next()
+		if err := t.playlist.SetReview(path, r); err != nil {
+			t.setStatus("Failed to save the review: %v", err)
+		}
+	case "b":
+		path, err := writeIssueReport(*flagIssues, p, t.playlist.Review(path))
+		if err != nil {
+			t.setStatus("Failed to export the bug report: %v", err)
+			return
+		}
+		t.setStatus("Exported the bug report to %s", path)
+	case "y":
+		state, err := p.stateJSON()
+		if err != nil {
+			t.setStatus("Failed to dump the state: %v", err)
+			return
+		}
+		if err := copyToClipboard(state); err != nil {
+			t.setStatus("Failed to copy the state: %v", err)
+			return
+		}
+		t.setStatus("Copied the state as JSON to the clipboard")
+	case keyCtrlY:
+		s := p.ShareToken().String()
+		if err := copyToClipboard(s); err != nil {
+			t.setStatus("Failed to copy %s: %v", s, err)
+			return
+		}
+		t.setStatus("Copied %s to the clipboard", s)
+	case "a":
+		if p.trim == nil {
+			if !p.ProposeTrim() {
+				t.setStatus("No silence to trim")
+			}
+			return
+		}
+		trim := p.TrimJob()
+		theJobs.Go("Trim "+filepath.Base(p.path), func(j *job) {
+			path, err := trim(j)
+			if j.Err() != nil {
+				t.setStatus("Canceled trimming")
+				return
+			}
+			if err != nil {
+				t.setStatus("Failed to trim: %v", err)
+				return
+			}
+			t.setStatus("Wrote the trimmed file to %s", path)
+		})
+	case "v":
+		t.closeComparison()
+		p = t.musicPlayer
+		preset := encoderPresets[t.presetIndex]
+		t.presetIndex = (t.presetIndex + 1) % len(encoderPresets)
+		encode := p.EncodeJob(preset)
+		theJobs.Go(fmt.Sprintf("Encode %s with %s", filepath.Base(p.path), preset.name), func(j *job) {
+			r := encode(j)
+			if j.Err() != nil {
+				t.setStatus("Canceled encoding")
+				return
+			}
+			t.encodedCh <- r
+		})
+	case "\t":
+		if t.comparison != nil {
+			t.comparison.Toggle()
+			t.musicPlayer = t.comparison.active()
+		}
+	case keyBackTab:
+		t.toggleReference()
+	case "u":
+		if t.comparison != nil {
+			if err := t.comparison.CompensateDelay(); err != nil {
+				t.setStatus("Failed to shift the loop tags: %v", err)
+			}
+			t.musicPlayer = t.comparison.active()
+		}
+	case "t":
+		t.editingNote = true
+		t.note = t.playlist.Review(path).Notes
+		t.momentIndex = -1
+	case keyCtrlD:
+		paths, err := writeDAWMarkers(*flagDAW, p, t.playlist.Review(path).Moments)
+		if err != nil {
+			t.setStatus("Failed to export the markers: %v", err)
+			return
+		}
+		t.setStatus("Exported the markers to %s", strings.Join(paths, ", "))
+	case keyCtrlS:
+		t.closeComparison()
+		p = t.musicPlayer
+		n, err := p.SaveLoop()
+		if err != nil {
+			t.setStatus("Failed to save the loop: %v", err)
+			return
+		}
+		t.playlist.loopSaved(p.path, p.introSample, p.loopSample)
+		t.musicPlayer = n
+		t.setStatus("Saved the loop to %s", filepath.Base(p.path))
+	case keyCtrlB:
+		thePreview.ToggleBypass()
+	case keyPageUp:
+		theTranspose.Add(1)
+	case keyPageDown:
+		theTranspose.Add(-1)
+	case "s":
+		theTranspose.ToggleKeepTempo()
+	case "{":
+		theStretch.Add(-1)
+	case "}":
+		theStretch.Add(1)
+	case keyCtrlR:
+		render := p.RenderJob()
+		theJobs.Go("Render "+filepath.Base(p.path), func(j *job) {
+			path, err := render(j)
+			if j.Err() != nil {
+				t.setStatus("Canceled rendering")
+				return
+			}
+			if err != nil {
+				t.setStatus("Failed to render: %v", err)
+				return
+			}
+			t.setStatus("Rendered the loops to %s", path)
+		})
+	case keyCtrlT:
+		export, err := p.StingerJob()
+		if err != nil {
+			t.setStatus("Failed to export the stinger: %v", err)
+			return
+		}
+		theJobs.Go("Stinger "+filepath.Base(p.path), func(j *job) {
+			path, err := export(j)
+			if j.Err() != nil {
+				t.setStatus("Canceled exporting the stinger")
+				return
+			}
+			if err != nil {
+				t.setStatus("Failed to export the stinger: %v", err)
+				return
+			}
+			t.setStatus("Exported the stinger to %s", path)
+		})
+	case keyCtrlE:
+		if err := openInEditor(p.path); err != nil {
+			t.setStatus("Failed to open the editor: %v", err)
+			return
+		}
+		t.setStatus("Opened %s in the editor. It is reloaded when saved", filepath.Base(p.path))
+	case "m":
+		if err := checkWritable(); err != nil {
+			t.setStatus("Failed to rename: %v", err)
+			return
+		}
+		t.renaming = true
+		t.newPath = p.path
+	case "0":
+		if err := p.ResetLoop(); err != nil {
+			t.setStatus("Failed to restore the loop: %v", err)
+		}
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		if err := p.AuditionSegment(int(key[0] - '1')); err != nil {
+			t.setStatus("Failed to loop the segment: %v", err)
+		}
+	}
+}
+
+// compareEncoded starts comparing the current file with the encoded one.
+func (t *TUI) compareEncoded(r encodeResult) {
+	if r.err != nil {
+		t.setStatus("Failed to encode: %v", r.err)
+		return
+	}
+	t.setStatus("Wrote the encoded file to %s", r.path)
+	if len(r.warnings) > 0 {
+		t.setStatus("Wrote the encoded file to %s, but %s", r.path, strings.Join(r.warnings, ", "))
+	}
+	if t.musicPlayer == nil || t.musicPlayer.path != r.source {
+		return
+	}
+	t.closeComparison()
+	c, err := newABComparison(t.musicPlayer, r)
+	if err != nil {
+		t.setStatus("Failed to open %s: %v", r.path, err)
+		return
+	}
+	t.comparison = c
+}
+
+// closeComparison stops comparing, and makes the source the current player.
+// The auditioned reference track is switched back to the file as well.
+func (t *TUI) closeComparison() {
+	t.musicPlayer = t.reference.Restore(t.musicPlayer)
+	if t.comparison == nil {
+		return
+	}
+	t.musicPlayer = t.comparison.Close()
+	t.comparison = nil
+}
+
+// toggleReference switches between the current file and the reference track given by -reference.
+func (t *TUI) toggleReference() {
+	if *flagRefTrack == "" {
+		t.setStatus("No reference track is given with -reference")
+		return
+	}
+	if t.reference == nil {
+		r, err := newReferenceAB(t.audioContext, *flagRefTrack)
+		if err != nil {
+			t.setStatus("Failed to open the reference track: %v", err)
+			return
+		}
+		t.reference = r
+	}
+	if t.reference.Active() {
+		t.musicPlayer = t.reference.Restore(t.musicPlayer)
+		return
+	}
+	// The encoded file is not compared with the reference.
+	t.closeComparison()
+	t.musicPlayer = t.reference.Toggle(t.musicPlayer)
+}
+
+// takeNote takes a note at the current moment at once, and starts editing its text.
+func (t *TUI) takeNote() {
+	if t.musicPlayer == nil {
+		return
+	}
+	path := t.playlist.Current()
+	i, err := t.playlist.TakeNote(path, t.musicPlayer.currentSample())
+	if err != nil {
+		t.setStatus("Failed to save the review: %v", err)
+		return
+	}
+	t.editingNote = true
+	t.note = ""
+	t.momentIndex = i
+}
+
+// editNote edits the notes with the typed key. Enter saves the notes and Esc cancels the edit.
+func (t *TUI) editNote(key string) {
+	switch key {
+	case keyEscape:
+		t.editingNote = false
+	case keyEnter:
+		t.editingNote = false
+		path := t.playlist.Current()
+		if t.momentIndex >= 0 {
+			if err := t.playlist.SetNoteText(path, t.momentIndex, strings.TrimSpace(t.note)); err != nil {
+				t.setStatus("Failed to save the review: %v", err)
+			}
+			return
+		}
+		r := t.playlist.Review(path)
+		r.Notes = strings.TrimSpace(t.note)
+		if err := t.playlist.SetReview(path, r); err != nil {
+			t.setStatus("Failed to save the review: %v", err)
+		}
+	case keyBackspace:
+		if _, size := utf8.DecodeLastRuneInString(t.note); size > 0 {
+			t.note = t.note[:len(t.note)-size]
+		}
+	case keyLeft, keyRight, keyUp, keyDown, keyDelete, keyPageUp, keyPageDown, keyBackTab:
+	default:
+		// Ignore the control characters.
+		if key[0] >= 0x20 {
+			t.note += key
+		}
+	}
+}
+
+// reviewLine returns the line of the review of the current file, or of the note being edited.
+func (t *TUI) reviewLine() string {
+	r := t.playlist.Review(t.playlist.Current())
+	if t.editingNote && t.momentIndex >= 0 && t.momentIndex < len(r.Moments) {
+		at := formatTimeMillis(samplesToDuration(r.Moments[t.momentIndex].Sample))
+		return fmt.Sprintf("Note at %s (Enter: save, Esc: no text): %s_", at, t.note)
+	}
+	if t.editingNote {
+		return "Note (Enter: save, Esc: cancel): " + t.note + "_"
+	}
+	line := fmt.Sprintf("Review: %s", r.Status)
+	if r.Notes != "" {
+		line += "  Notes: " + r.Notes
+	}
+	return line
+}
+
+// tuiTransportHelp returns the help of the transport scheme's keys.
+func tuiTransportHelp() string {
+	if theTransport == transportJKL {
+		return "J/K/L: Reverse/Stop/Forward  "
+	}
+	return ""
+}
+
+// editNewPath edits the new path of the current file with the typed key. Enter renames the file, or cancels it when the path is empty.
+// Esc cancels it as well.
+func (t *TUI) editNewPath(key string) {
+	switch key {
+	case keyEscape:
+		t.renaming = false
+	case keyEnter:
+		t.renaming = false
+		path := strings.TrimSpace(t.newPath)
+		if path == "" || t.musicPlayer == nil {
+			return
+		}
+		t.closeComparison()
+		old := t.musicPlayer.path
+		p, err := renamePlayer(t.playlist, t.musicPlayer, path)
+		t.musicPlayer = p
+		if err != nil {
+			t.setStatus("Failed to rename %s: %v", old, err)
+			return
+		}
+		if p != nil {
+			t.setStatus("Renamed %s to %s", old, p.path)
+		}
+	case keyBackspace:
+		if _, size := utf8.DecodeLastRuneInString(t.newPath); size > 0 {
+			t.newPath = t.newPath[:len(t.newPath)-size]
+		}
+	case keyLeft, keyRight, keyUp, keyDown, keyDelete, keyPageUp, keyPageDown, keyBackTab:
+	default:
+		// Ignore the control characters.
+		if key[0] >= 0x20 {
+			t.newPath += key
+		}
+	}
+}
+
+func (t *TUI) draw() {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width = 80
+	}
+
+	var lines []string
+	title := "Ogg Loop Checker"
+	if *flagReadOnly {
+		title += " (read-only)"
+	}
+	lines = append(lines, title, "")
+	if b := thePreview.badge(); b != "" {
+		lines = append(lines, b, "")
+	}
+	if t.monitor != nil {
+		lines = append(lines, t.monitor.line(), "")
+	}
+	if t.reference != nil {
+		lines = append(lines, t.reference.line(t.musicPlayer), "")
+	}
+	if t.showRecent {
+		lines = append(lines, recentLines("any other key")...)
+		lines = append(lines, "")
+	}
+	if p := t.musicPlayer; p != nil && p.loopConflict != nil {
+		lines = append(lines, p.loopConflictLines()...)
+		lines = append(lines, "Any other key: keep the current loop", "")
+	}
+
+	if p := t.musicPlayer; p != nil {
+		state := "Paused"
+		if p.IsPlaying() {
+			state = "Playing"
+			if l := p.shuttleLabel(); l != "" {
+				state = l
+			}
+			if l := p.fadeLine(); l != "" {
+				state = l
+			}
+		} else if p.parkedAtLoopEnd() {
+			state = "Paused at the loop end"
+		}
+		lines = append(lines,
+			fmt.Sprintf("%s  %s / %s  Volume: %d/128", state, p.timeReadout(p.currentSample()), formatTime(p.total), p.volume128),
+			"",
+			t.transportBar(width-4),
+			"",
+			fmt.Sprintf("Loop Start:  %s", p.timeReadout(p.introSample)),
+			fmt.Sprintf("Loop End:    %s", p.timeReadout(p.introSample+p.loopSample)),
+			fmt.Sprintf("Loop Length: %s (%d)", formatTime(samplesToDuration(p.loopSample)), p.fileLoopLength()),
+			fmt.Sprintf("File Length: %s (%d at %d Hz)", formatTime(p.total), p.fileSample(p.totalSample), p.info.rate()))
+		lines = append(lines, p.loopProvenance()...)
+		if p.loopSource != "" {
+			lines = append(lines, fmt.Sprintf("Loop: %s, overriding the tags (0: restore, Ctrl+S: save)", p.loopSource))
+		}
+		if l := p.abLine(); l != "" {
+			lines = append(lines, l)
+		}
+		for _, w := range p.tagWarnings {
+			lines = append(lines, "Warning: "+w)
+		}
+		for _, w := range p.channelWarnings() {
+			lines = append(lines, "Warning: "+w)
+		}
+		for _, w := range p.silenceWarnings() {
+			lines = append(lines, "Warning: "+w)
+		}
+		for _, w := range p.clipWarnings() {
+			lines = append(lines, "Warning: "+w)
+		}
+		if l := loudnessLine(p.Loudness()); l != "" {
+			lines = append(lines, strings.TrimSpace(l))
+		}
+		if l := p.tempoLine(); l != "" {
+			lines = append(lines, l)
+		}
+		if l := p.snapLine(); l != "" {
+			lines = append(lines, l)
+		}
+		if l := seamQualityLine(p.SeamQuality()); l != "" {
+			lines = append(lines, l)
+		}
+		for _, w := range p.profileWarnings {
+			lines = append(lines, fmt.Sprintf("%s: %s", theProfile.Name, w))
+		}
+		for _, w := range p.ruleWarnings {
+			lines = append(lines, "Rule: "+w)
+		}
+		if len(p.badTagKeys) > 0 && !*flagReadOnly {
+			lines = append(lines, "Press C to clear the bad loop tags")
+		}
+		for _, n := range t.playlist.Review(t.playlist.Current()).Moments {
+			lines = append(lines, "Note at "+n.String())
+		}
+		lines = append(lines, "")
+		lines = append(lines, t.meters.lines(tuiMeterWidth)...)
+		lines = append(lines, "")
+	}
+
+	if jobs := theJobs.jobLines(); len(jobs) > 0 {
+		lines = append(lines, jobs...)
+		lines = append(lines, "Press Delete to cancel the last job", "")
+	}
+
+	lines = append(lines, "Playlist:")
+	for i, path := range t.playlist.paths {
+		mark := "  "
+		if i == t.playlist.Index() {
+			mark = "> "
+		}
+		lines = append(lines, fmt.Sprintf("%s%d. %s", mark, i+1, filepath.Base(path)))
+	}
+	keys := theSettings.Keys
+	help := []string{
+		fmt.Sprintf("%s: Play/Pause  %sLeft/Right: Seek  %s/%s: Volume  %s/%s: Next/Prev  W: Export  Q: Quit",
+			keys.terminalLabel(actionPlay), tuiTransportHelp(), keys.terminalLabel(actionVolumeDown), keys.terminalLabel(actionVolumeUp), keys.terminalLabel(actionNext), keys.terminalLabel(actionPrev)),
+		fmt.Sprintf("%s/%s: Loop start/end here  %s/Shift+%s: Hear the seam/intro end  %s/%s: Halve/Double loop  %s/%s: Shift loop a bar  1-9: Loop segment  0: Restore loop",
+			keys.terminalLabel(actionLoopStart), keys.terminalLabel(actionLoopEnd), keys.terminalLabel(actionSeam), keys.terminalLabel(actionSeam), keys.terminalLabel(actionHalveLoop), keys.terminalLabel(actionDoubleLoop), keys.terminalLabel(actionShiftBack), keys.terminalLabel(actionShiftFwd)),
+		"R: Review status  T: Note  Shift+T: Note here  B: Report a bug here  A: Trim silence  Shift+A/B: A-B loop  M: Rename/Move  Y: Copy the state",
+		"V: Encode with the next preset and compare  Ctrl+E: Open in the editor  Ctrl+D: Export markers for DAWs  Ctrl+R: Render the loops  Ctrl+T: Export a stinger  Ctrl+O: Recent files  PgUp/PgDn: Transpose  S: Keep the tempo  Shift+M: Mid/Side  Shift+Tab: Reference  Shift+L/R/D: Left/Right/Mono  </>: Pan  Shift+C: Limiter  Shift+Z: Snap  Shift+X: Time display  Shift+S: Fade out and stop  Ctrl+Y/V: Copy/Paste the position  Shift+I: Monitor the input  {/}: Speed",
+	}
+	lines = append(lines, "")
+	for _, h := range help {
+		lines = append(lines, wrapHelp(h, width)...)
+	}
+	if t.musicPlayer != nil {
+		lines = append(lines, "", t.reviewLine())
+	}
+	if t.renaming {
+		lines = append(lines, "", "New path (Enter: rename, Esc or empty: cancel): "+t.newPath+"_")
+	}
+
+	t.statusM.Lock()
+	status := t.status
+	t.statusM.Unlock()
+	if status != "" {
+		lines = append(lines, "", status)
+	}
+
+	// Overwrite the previous screen instead of clearing it to avoid flickering.
+	t.out.WriteString("\x1b[H")
+	for _, l := range lines {
+		if width > 0 && len(l) > width {
+			l = l[:width]
+		}
+		t.out.WriteString(l)
+		t.out.WriteString("\x1b[K\r\n")
+	}
+	t.out.WriteString("\x1b[J")
+	t.out.Flush()
+}
+
+// wrapHelp wraps a line of the key help, whose entries are separated by two spaces, into lines within width
+// so that no key is cut off. An entry wider than width is left as it is.
+func wrapHelp(help string, width int) []string {
+	var lines []string
+	var line string
+	for _, e := range strings.Split(help, "  ") {
+		if e == "" {
+			continue
+		}
+		if line != "" && len(line)+2+len(e) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += "  "
+		}
+		line += e
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// transportBar renders the seek bar with the current position (|), the loop start/end (S/E) and the markers (+).
+func (t *TUI) transportBar(width int) string {
+	if width < tuiMinBarWidth {
+		width = tuiMinBarWidth
+	}
+	p := t.musicPlayer
+	bar := []byte(strings.Repeat("-", width))
+	col := func(d time.Duration) int {
+		c := int(time.Duration(width) * d / p.total)
+		if c < 0 {
+			c = 0
+		}
+		if c >= width {
+			c = width - 1
+		}
+		return c
+	}
+	for i := 0; i < col(p.current); i++ {
+		bar[i] = '='
+	}
+	for _, m := range p.markers {
+		bar[col(samplesToDuration(m.Sample))] = '+'
+	}
+	bar[col(samplesToDuration(p.introSample))] = 'S'
+	bar[col(samplesToDuration(p.introSample+p.loopSample))] = 'E'
+	bar[col(p.current)] = '|'
+	return "[" + string(bar) + "]"
+}
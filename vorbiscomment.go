@@ -0,0 +1,182 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	vorbisCommentMagic = "\x03vorbis"
+
+	// vorbisHeaderCount is the number of the header packets of Ogg/Vorbis.
+	vorbisHeaderCount = 3
+)
+
+// vorbisComments is a Vorbis comment block, which holds tags like LOOPSTART=123.
+// https://xiph.org/vorbis/doc/v-comment.html
+type vorbisComments struct {
+	vendor   string
+	comments []string
+}
+
+// parseVorbisComments parses a comment body without the packet magic or the framing bit.
+func parseVorbisComments(b []byte) (*vorbisComments, error) {
+	readString := func() (string, error) {
+		if len(b) < 4 {
+			return "", fmt.Errorf("vorbiscomment: unexpected end of the comments")
+		}
+		n := binary.LittleEndian.Uint32(b)
+		b = b[4:]
+		if uint32(len(b)) < n {
+			return "", fmt.Errorf("vorbiscomment: unexpected end of the comments")
+		}
+		s := string(b[:n])
+		b = b[n:]
+		return s, nil
+	}
+
+	vendor, err := readString()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 4 {
+		return nil, fmt.Errorf("vorbiscomment: unexpected end of the comments")
+	}
+	n := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	c := &vorbisComments{
+		vendor: vendor,
+	}
+	for i := uint32(0); i < n; i++ {
+		s, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		c.comments = append(c.comments, s)
+	}
+	return c, nil
+}
+
+// parseVorbisCommentPacket parses the comment header packet of Ogg/Vorbis.
+func parseVorbisCommentPacket(pkt []byte) (*vorbisComments, error) {
+	if !strings.HasPrefix(string(pkt), vorbisCommentMagic) {
+		return nil, fmt.Errorf("vorbiscomment: not a Vorbis comment header")
+	}
+	return parseVorbisComments(pkt[len(vorbisCommentMagic):])
+}
+
+// bytes encodes the comment body without the packet magic or the framing bit.
+func (c *vorbisComments) bytes() []byte {
+	var b []byte
+	appendUint32 := func(v uint32) {
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], v)
+		b = append(b, buf[:]...)
+	}
+	appendString := func(s string) {
+		appendUint32(uint32(len(s)))
+		b = append(b, s...)
+	}
+	appendString(c.vendor)
+	appendUint32(uint32(len(c.comments)))
+	for _, s := range c.comments {
+		appendString(s)
+	}
+	return b
+}
+
+// vorbisPacket encodes the comments as the comment header packet of Ogg/Vorbis.
+func (c *vorbisComments) vorbisPacket() []byte {
+	b := []byte(vorbisCommentMagic)
+	b = append(b, c.bytes()...)
+	// The framing bit.
+	return append(b, 1)
+}
+
+// Get returns the values of key. The key is case-insensitive.
+func (c *vorbisComments) Get(key string) []string {
+	var vals []string
+	for _, s := range c.comments {
+		k, v, ok := strings.Cut(s, "=")
+		if !ok || !strings.EqualFold(k, key) {
+			continue
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+// keys returns the keys of the comments in the order they first appear.
+func (c *vorbisComments) keys() []string {
+	var keys []string
+	for _, s := range c.comments {
+		k, _, ok := strings.Cut(s, "=")
+		if !ok {
+			continue
+		}
+		dup := false
+		for _, kk := range keys {
+			if strings.EqualFold(k, kk) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Delete removes all the values of key.
+func (c *vorbisComments) Delete(key string) {
+	var comments []string
+	for _, s := range c.comments {
+		k, _, _ := strings.Cut(s, "=")
+		if strings.EqualFold(k, key) {
+			continue
+		}
+		comments = append(comments, s)
+	}
+	c.comments = comments
+}
+
+// Set replaces the values of key with value.
+func (c *vorbisComments) Set(key, value string) {
+	c.Delete(key)
+	c.comments = append(c.comments, key+"="+value)
+}
+
+// readOggVorbisComments reads the comments of an Ogg/Vorbis file.
+func readOggVorbisComments(dat []byte) (*vorbisComments, error) {
+	h, err := readOggHeaders(dat, vorbisHeaderCount)
+	if err != nil {
+		return nil, err
+	}
+	return parseVorbisCommentPacket(h.packets[1])
+}
+
+// replaceOggVorbisComments returns the Ogg/Vorbis file dat with its comments replaced with c.
+// The audio packets are copied as they are.
+func replaceOggVorbisComments(dat []byte, c *vorbisComments) ([]byte, error) {
+	h, err := readOggHeaders(dat, vorbisHeaderCount)
+	if err != nil {
+		return nil, err
+	}
+	return h.replacePacket(1, c.vorbisPacket()), nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"image/color"
+	"math"
+	"time"
+)
+
+const (
+	// peakHoldTime is how long the peak-hold mark stays before following the meter down.
+	peakHoldTime = 1500 * time.Millisecond
+
+	// peakFallRate is how fast the meters fall in dB per second.
+	peakFallRate = 20.0
+
+	// clipHoldTime is how long the clip indicator stays lit after the last clip.
+	clipHoldTime = 3 * time.Second
+
+	// meterFloor is the level in dBFS at the bottom of the meters.
+	meterFloor = -48.0
+
+	// clipLevel is the peak level counted as a clip, the full scale of 16-bit PCM.
+	clipLevel = 32767.0 / 32768
+
+	// meterWidth and meterHeight are the size of a channel's meter in the main screen of the GUI.
+	meterWidth  = 3
+	meterHeight = 64
+)
+
+var (
+	meterColor     = color.RGBA{0x40, 0xc0, 0x40, 0xff}
+	meterHotColor  = color.RGBA{0xe0, 0xc0, 0x40, 0xff}
+	meterHoldColor = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	meterClipColor = color.RGBA{0xff, 0x40, 0x40, 0xff}
+)
+
+// peakMeters is the state of the stereo peak meters fed from the PCM passing through the player,
+// with the peak-hold marks and the clip indicators. The levels are in dBFS.
+type peakMeters struct {
+	level   [2]float64
+	hold    [2]float64
+	heldAt  [2]time.Time
+	clipAt  [2]time.Time
+	updated time.Time
+}
+
+func newPeakMeters() *peakMeters {
+	return &peakMeters{
+		level: [2]float64{meterFloor, meterFloor},
+		hold:  [2]float64{meterFloor, meterFloor},
+	}
+}
+
+// Update updates the meters with the peak levels in [0, 1] of the left and the right channels since the last update.
+// The meters jump up to a peak and fall at peakFallRate.
+func (m *peakMeters) Update(now time.Time, left, right float64) {
+	dt := 0.0
+	if !m.updated.IsZero() {
+		dt = now.Sub(m.updated).Seconds()
+	}
+	m.updated = now
+	for ch, peak := range [2]float64{left, right} {
+		if peak >= clipLevel {
+			m.clipAt[ch] = now
+		}
+		db := meterFloor
+		if peak > 0 {
+			db = math.Max(20*math.Log10(peak), meterFloor)
+		}
+		m.level[ch] = math.Max(db, m.level[ch]-peakFallRate*dt)
+		switch {
+		case m.level[ch] >= m.hold[ch]:
+			m.hold[ch] = m.level[ch]
+			m.heldAt[ch] = now
+		case now.Sub(m.heldAt[ch]) > peakHoldTime:
+			m.hold[ch] = m.level[ch]
+		}
+	}
+}
+
+// clipped reports whether the channel ch has clipped within clipHoldTime.
+func (m *peakMeters) clipped(ch int) bool {
+	return !m.clipAt[ch].IsZero() && m.updated.Sub(m.clipAt[ch]) < clipHoldTime
+}
+
+// meterFraction returns the part of a meter filled at the level db.
+func meterFraction(db float64) float64 {
+	return (db - meterFloor) / -meterFloor
+}
+
+// draw draws the meters of the left and the right channels side by side with their bottom left at (x, bottom).
+// Each meter is w wide and h high, and the clip indicators are above the meters.
+func (m *peakMeters) draw(b *shapeBatch, x, bottom, w, h int) {
+	fw, fh := float64(w), float64(h)
+	for ch := 0; ch < 2; ch++ {
+		mx := float64(x + ch*(w+1))
+		b.Rect(mx, float64(bottom-h), fw, fh, playerBarColor)
+		// The part above -6 dBFS is drawn in the hot color.
+		lh := meterFraction(m.level[ch]) * fh
+		hot := meterFraction(-6) * fh
+		b.Rect(mx, float64(bottom)-math.Min(lh, hot), fw, math.Min(lh, hot), meterColor)
+		if lh > hot {
+			b.Rect(mx, float64(bottom)-lh, fw, lh-hot, meterHotColor)
+		}
+		if m.hold[ch] > meterFloor {
+			b.Rect(mx, float64(bottom)-meterFraction(m.hold[ch])*fh, fw, 1, meterHoldColor)
+		}
+		if m.clipped(ch) {
+			b.Rect(mx, float64(bottom-h-4), fw, 3, meterClipColor)
+		}
+	}
+}
+
+// lines returns the meters as text for the terminal UI, with the peak-hold marks and the clip indicators.
+func (m *peakMeters) lines(width int) []string {
+	lines := make([]string, 2)
+	for ch, name := range []string{"L ", "R "} {
+		bar := []byte(meterBar(meterFraction(m.level[ch]), width))
+		if m.hold[ch] > meterFloor {
+			// The bar has a bracket at each end.
+			i := 1 + int(meterFraction(m.hold[ch])*float64(width))
+			if i > width {
+				i = width
+			}
+			bar[i] = '|'
+		}
+		lines[ch] = name + string(bar)
+		if m.clipped(ch) {
+			lines[ch] += " CLIP"
+		}
+	}
+	return lines
+}
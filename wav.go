@@ -0,0 +1,178 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const wavMagic = "RIFF"
+
+// isWAVStream reports whether r starts with a RIFF header. The position of r is restored to the start.
+func isWAVStream(r io.ReadSeeker) (bool, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	var magic [4]byte
+	_, err := io.ReadFull(r, magic[:])
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(magic[:]) == wavMagic, nil
+}
+
+// readWAVInfo reads the format information from the chunks of a WAV file.
+// The first loop in the smpl chunk is given as LOOPSTART and LOOPLENGTH in the comments,
+// so that the loop is treated as the loop tags of an Ogg file, and written to the files encoded from the WAV.
+func readWAVInfo(r io.ReadSeeker) (*fileInfo, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	var header [12]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("wav: %w", err)
+	}
+	if string(header[:4]) != wavMagic || string(header[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("wav: invalid RIFF header")
+	}
+
+	// The bitrate is left unknown, as the bitrate limits of the profiles are for the compressed files.
+	info := &fileInfo{
+		comments:        &vorbisComments{},
+		granulePosition: -1,
+	}
+	var blockAlign int64
+	var dataSize int64 = -1
+	for {
+		var chunk [8]byte
+		// Some files have garbage after the last chunk, which is ignored.
+		if _, err := io.ReadFull(br, chunk[:]); err != nil {
+			break
+		}
+		id := string(chunk[:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+		switch id {
+		case "fmt ", "smpl":
+			if size > 1<<16 {
+				return nil, fmt.Errorf("wav: too large %q chunk", id)
+			}
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return nil, fmt.Errorf("wav: %w", err)
+			}
+			if id == "fmt " {
+				if len(buf) < 16 {
+					return nil, fmt.Errorf("wav: invalid fmt chunk")
+				}
+				info.channels = int(binary.LittleEndian.Uint16(buf[2:4]))
+				info.sampleRate = int(binary.LittleEndian.Uint32(buf[4:8]))
+				blockAlign = int64(binary.LittleEndian.Uint16(buf[12:14]))
+			} else {
+				readSmplLoop(buf, info.comments)
+			}
+		case "data":
+			dataSize = size
+			if _, err := br.Discard(int(size)); err != nil {
+				// The data chunk of a file being written can be shorter than its size.
+				dataSize = -1
+			}
+		default:
+			// A short chunk is found by the next read.
+			br.Discard(int(size))
+		}
+		// Chunks are aligned to 2 bytes.
+		if size%2 == 1 {
+			br.Discard(1)
+		}
+	}
+	if info.sampleRate == 0 {
+		return nil, fmt.Errorf("wav: fmt chunk is missing")
+	}
+	if blockAlign > 0 && dataSize >= 0 {
+		info.granulePosition = dataSize / blockAlign
+	}
+	return info, nil
+}
+
+// readSmplLoop reads the first loop of the smpl chunk into LOOPSTART and LOOPLENGTH of c.
+// https://www.recordingblogs.com/wiki/sample-chunk-of-a-wave-file
+func readSmplLoop(buf []byte, c *vorbisComments) {
+	const (
+		headerSize = 36
+		loopSize   = 24
+	)
+	if len(buf) < headerSize+loopSize || binary.LittleEndian.Uint32(buf[28:32]) == 0 {
+		return
+	}
+	loop := buf[headerSize : headerSize+loopSize]
+	start := int64(binary.LittleEndian.Uint32(loop[8:12]))
+	// The end is the last sample played in the loop.
+	end := int64(binary.LittleEndian.Uint32(loop[12:16]))
+	c.Set(loopStartKey, strconv.FormatInt(start, 10))
+	c.Set(loopLengthKey, strconv.FormatInt(end-start+1, 10))
+}
+
+// writeWAV writes interleaved stereo PCM at sampleRate as a 16-bit WAV file.
+func writeWAV(w io.Writer, pcm []int16) error {
+	bw := bufio.NewWriter(w)
+	if err := writeWAVHeader(bw, sampleRate, int64(len(pcm)/2)); err != nil {
+		return err
+	}
+	// bufio.Writer keeps the first error, which is returned by Flush.
+	binary.Write(bw, binary.LittleEndian, pcm)
+	return bw.Flush()
+}
+
+// writeWAVHeader writes the header of a stereo 16-bit WAV file of frames samples per channel at rate.
+// The samples are to be written after the header.
+func writeWAVHeader(w *bufio.Writer, rate int, frames int64) error {
+	const (
+		channels      = 2
+		bitsPerSample = 16
+	)
+	dataSize := uint32(frames * channels * bitsPerSample / 8)
+
+	write := func(v interface{}) {
+		// bufio.Writer keeps the first error, which is returned by Flush.
+		binary.Write(w, binary.LittleEndian, v)
+	}
+	w.WriteString("RIFF")
+	write(uint32(36 + dataSize))
+	w.WriteString("WAVE")
+
+	w.WriteString("fmt ")
+	write(uint32(16))
+	write(uint16(1)) // PCM
+	write(uint16(channels))
+	write(uint32(rate))
+	write(uint32(rate * channels * bitsPerSample / 8))
+	write(uint16(channels * bitsPerSample / 8))
+	write(uint16(bitsPerSample))
+
+	w.WriteString("data")
+	write(dataSize)
+	return nil
+}
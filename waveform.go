@@ -0,0 +1,212 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+)
+
+// waveformBuckets is the resolution of the precomputed waveform overview.
+const waveformBuckets = 2048
+
+// waveformLane is a signal derived from the stereo PCM which a waveform keeps.
+type waveformLane int
+
+const (
+	// laneMid is the mono mix (L+R)/2, which is also the summed waveform.
+	laneMid waveformLane = iota
+
+	// laneSide is the difference (L-R)/2, where the artifacts cancelled in the mix show up.
+	laneSide
+
+	laneLeft
+	laneRight
+
+	waveformLanes
+)
+
+// value returns the value of the lane at the stereo sample of left and right in [-1, 1].
+func (l waveformLane) value(left, right int16) float32 {
+	switch l {
+	case laneMid:
+		return (float32(left) + float32(right)) / 2 / 32768
+	case laneSide:
+		return (float32(left) - float32(right)) / 2 / 32768
+	case laneLeft:
+		return float32(left) / 32768
+	case laneRight:
+		return float32(right) / 32768
+	}
+	return 0
+}
+
+// waveformMode is how the channels are shown on the waveform.
+type waveformMode int
+
+const (
+	// waveformSum shows the mono mix of the channels.
+	waveformSum waveformMode = iota
+
+	// waveformSplit shows the left channel above the right channel.
+	waveformSplit
+
+	// waveformMidSide shows the mid above the side.
+	waveformMidSide
+
+	waveformModes
+)
+
+// String implements flag.Value.
+func (m *waveformMode) String() string {
+	switch *m {
+	case waveformSum:
+		return "sum"
+	case waveformSplit:
+		return "split"
+	case waveformMidSide:
+		return "midside"
+	}
+	return ""
+}
+
+// Set implements flag.Value.
+func (m *waveformMode) Set(str string) error {
+	switch str {
+	case "sum":
+		*m = waveformSum
+	case "split":
+		*m = waveformSplit
+	case "midside":
+		*m = waveformMidSide
+	default:
+		return fmt.Errorf("waveform mode must be sum, split or midside but was %q", str)
+	}
+	return nil
+}
+
+// label returns the name of the mode shown in the help.
+func (m waveformMode) label() string {
+	switch m {
+	case waveformSplit:
+		return "L/R"
+	case waveformMidSide:
+		return "Mid/Side"
+	}
+	return "Sum"
+}
+
+// next returns the mode following m, wrapping around.
+func (m waveformMode) next() waveformMode {
+	return (m + 1) % waveformModes
+}
+
+// lanes returns the lanes shown in the mode from the top.
+func (m waveformMode) lanes() []waveformLane {
+	switch m {
+	case waveformSplit:
+		return []waveformLane{laneLeft, laneRight}
+	case waveformMidSide:
+		return []waveformLane{laneMid, laneSide}
+	}
+	return []waveformLane{laneMid}
+}
+
+// waveform is the overview of the PCM as the minimum and maximum values of each lane per bucket in [-1, 1].
+type waveform struct {
+	mins [waveformLanes][]float32
+	maxs [waveformLanes][]float32
+
+	// frames is the number of the samples per channel of the PCM.
+	frames int64
+}
+
+// newWaveform returns an empty waveform of the PCM of frames samples per channel. The PCM is added by add.
+func newWaveform(frames int64, buckets int) *waveform {
+	w := &waveform{
+		frames: frames,
+	}
+	for l := range w.mins {
+		w.mins[l] = make([]float32, buckets)
+		w.maxs[l] = make([]float32, buckets)
+	}
+	return w
+}
+
+// add adds the interleaved stereo PCM starting at the frame pos.
+// The frames beyond w.frames are added to the last bucket, as the length of a stream can be an estimate.
+func (w *waveform) add(pos int64, pcm []int16) {
+	if w.frames == 0 {
+		return
+	}
+	buckets := int64(len(w.mins[laneMid]))
+	for i := 0; i < len(pcm)/2; i++ {
+		b := (pos + int64(i)) * buckets / w.frames
+		if b >= buckets {
+			b = buckets - 1
+		}
+		for l := waveformLane(0); l < waveformLanes; l++ {
+			v := l.value(pcm[2*i], pcm[2*i+1])
+			if v < w.mins[l][b] {
+				w.mins[l][b] = v
+			}
+			if v > w.maxs[l][b] {
+				w.maxs[l][b] = v
+			}
+		}
+	}
+}
+
+// peaks returns the minimum and maximum values of the mono mix in the x-th column when the waveform is drawn in width columns.
+func (w *waveform) peaks(x, width int) (min, max float32) {
+	mins, maxs := w.mins[laneMid], w.maxs[laneMid]
+	n := len(mins)
+	from := x * n / width
+	to := (x + 1) * n / width
+	if to <= from {
+		to = from + 1
+	}
+	for i := from; i < to && i < n; i++ {
+		if mins[i] < min {
+			min = mins[i]
+		}
+		if maxs[i] > max {
+			max = maxs[i]
+		}
+	}
+	return
+}
+
+// rangePeaks returns the minimum and maximum values of the lane in the frames [from, to).
+func (w *waveform) rangePeaks(lane waveformLane, from, to int64) (min, max float32) {
+	if w.frames == 0 {
+		return
+	}
+	mins, maxs := w.mins[lane], w.maxs[lane]
+	n := int64(len(mins))
+	bf := from * n / w.frames
+	bt := to * n / w.frames
+	if bt <= bf {
+		bt = bf + 1
+	}
+	for i := bf; i < bt && i < n; i++ {
+		if mins[i] < min {
+			min = mins[i]
+		}
+		if maxs[i] > max {
+			max = maxs[i]
+		}
+	}
+	return
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// waveformImage is a waveform of a file rendered to an image without Ebiten, so that it can be drawn without a window.
+type waveformImage struct {
+	img         *image.RGBA
+	totalSample int64
+}
+
+func newWaveformImage(width, height int, totalSample int64) *waveformImage {
+	w := &waveformImage{
+		img:         image.NewRGBA(image.Rect(0, 0, width, height)),
+		totalSample: totalSample,
+	}
+	w.fill(0, width-1, issueBackgroundColor)
+	return w
+}
+
+// col returns the column of sample.
+func (w *waveformImage) col(sample int64) int {
+	width := w.img.Bounds().Dx()
+	if w.totalSample <= 0 {
+		return 0
+	}
+	x := int(int64(width) * sample / w.totalSample)
+	if x >= width {
+		x = width - 1
+	}
+	if x < 0 {
+		x = 0
+	}
+	return x
+}
+
+func (w *waveformImage) vline(x int, clr color.Color) {
+	for y := 0; y < w.img.Bounds().Dy(); y++ {
+		w.img.Set(x, y, clr)
+	}
+}
+
+// fill fills the columns from x0 to x1 inclusive.
+func (w *waveformImage) fill(x0, x1 int, clr color.Color) {
+	for x := x0; x <= x1; x++ {
+		w.vline(x, clr)
+	}
+}
+
+// shade fills the background of the range from start to end. shade must be called before drawWaveform.
+func (w *waveformImage) shade(start, end int64, clr color.Color) {
+	w.fill(w.col(start), w.col(end), clr)
+}
+
+func (w *waveformImage) drawWaveform(wf *waveform) {
+	width, height := w.img.Bounds().Dx(), w.img.Bounds().Dy()
+	cy := height / 2
+	for x := 0; x < width; x++ {
+		min, max := wf.peaks(x, width)
+		for y := cy - int(max*float32(cy)); y <= cy-int(min*float32(cy)); y++ {
+			w.img.Set(x, y, issueWaveformColor)
+		}
+	}
+}
+
+// drawLoop draws the loop start and the loop end.
+func (w *waveformImage) drawLoop(introSample, loopSample int64) {
+	w.vline(w.col(introSample), issueLoopColor)
+	w.vline(w.col(introSample+loopSample), issueLoopColor)
+}
+
+func (w *waveformImage) writePNG(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := png.Encode(f, w.img); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// waveformPNGPath returns the path of the PNG for the file at path in dir.
+func waveformPNGPath(dir, path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return filepath.Join(dir, base+".png")
+}
+
+// writeWaveformPNG decodes the file at path and renders its waveform with the loop to a PNG file at out.
+// Neither an audio context nor a window is needed.
+func writeWaveformPNG(path, out string) error {
+	h, err := openHeadless(path)
+	if err != nil {
+		return err
+	}
+	a, err := h.analyze()
+	if err != nil {
+		return err
+	}
+
+	tags := h.tags
+	img := newWaveformImage(issueImageWidth, issueImageHeight, h.totalSample)
+	if tags.length > 0 {
+		img.shade(tags.start, tags.start+tags.length, issueSelectionColor)
+	}
+	img.drawWaveform(a.waveform)
+	if tags.length > 0 {
+		img.drawLoop(tags.start, tags.length)
+	}
+	return img.writePNG(out)
+}
+
+// runWaveformPNGs renders the waveforms of the files at paths to PNG files in dir, and returns the first error.
+// Every file is tried even when one fails, so that a broken file doesn't stop the rest of the documentation.
+func runWaveformPNGs(dir string, paths []string) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("oggplayer: no files to render to %s", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	var firstErr error
+	for _, path := range paths {
+		out := waveformPNGPath(dir, path)
+		if err := writeWaveformPNG(path, out); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		fmt.Println(out)
+	}
+	return firstErr
+}
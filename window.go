@@ -0,0 +1,55 @@
+// Copyright 2021 Odencat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// openNewWindow opens path in a new window, e.g. on another monitor to compare with the current file.
+// Ebiten has only one window per process, so another instance of the app is started with the same flags,
+// except the ones saving the state, which the instances would overwrite with each other's.
+func openNewWindow(path string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "snapshot", "tui", "check":
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	args = append(args, "--", path)
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("window error: %s, %v", path, err)
+		}
+	}()
+	return nil
+}